@@ -0,0 +1,94 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+const (
+	// ImageCacheGuestAddress is where the image cache is reachable inside
+	// every vm with docker or kubernetes, it is reverse forwarded to the host
+	ImageCacheGuestAddress = "127.0.0.1:5050"
+
+	// DefaultImageCacheSize is the default MaxSize of the image cache
+	DefaultImageCacheSize = "20GiB"
+)
+
+// ImageCache configures the pull-through image cache of meridiand shared by
+// the vms. containerd and docker in a vm use it as the first mirror and
+// fall back to the next one when it fails.
+type ImageCache struct {
+	// Enabled serves the cache to new docker and kubernetes. It is off by
+	// default, the cache pulls docker hub from registry-1.docker.io unless
+	// Upstreams points it at a mirror
+	Enabled bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// MaxSize of the cached blobs, the least recently used ones are pruned
+	// beyond it. go-units.RAMInBytes, default: 20GiB
+	MaxSize string `yaml:"maxSize,omitempty" json:"maxSize,omitempty"`
+	// Address reuses a registry cache listening on the host, eg.
+	// 127.0.0.1:5000, instead of running one in meridiand
+	Address string `yaml:"address,omitempty" json:"address,omitempty"`
+	// Upstreams replaces the endpoint a registry is pulled from, eg.
+	// docker.io: https://docker.m.daocloud.io
+	Upstreams map[string]string `yaml:"upstreams,omitempty" json:"upstreams,omitempty"`
+}
+
+func (c *ImageCache) GetMaxSize() int64 {
+	size, err := units.RAMInBytes(c.MaxSize)
+	if c.MaxSize == "" || err != nil {
+		size, _ = units.RAMInBytes(DefaultImageCacheSize)
+	}
+	return size
+}
+
+func (c *ImageCache) Validate() error {
+	if c.MaxSize != "" {
+		if _, err := units.RAMInBytes(c.MaxSize); err != nil {
+			return fmt.Errorf("invalid image cache max size %s: %s", c.MaxSize, err.Error())
+		}
+	}
+	if c.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return fmt.Errorf("invalid image cache address %s: expect host:port", c.Address)
+		}
+	}
+	for registry, endpoint := range c.Upstreams {
+		if err := validRegistryHost(registry); err != nil {
+			return fmt.Errorf("image cache upstream %q: %v", registry, err)
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("image cache upstream of %q: %q must be an http or https url", registry, endpoint)
+		}
+	}
+	return nil
+}
+
+// CacheEntry is a blob or manifest in the image cache.
+type CacheEntry struct {
+	Digest     string    `json:"digest"`
+	Registry   string    `json:"registry"`
+	Repository string    `json:"repository"`
+	MediaType  string    `json:"mediaType,omitempty"`
+	Size       int64     `json:"size"`
+	LastAccess time.Time `json:"lastAccess"`
+}
+
+// CacheStatus reports the content of the image cache.
+type CacheStatus struct {
+	Address string       `json:"address"`
+	Size    int64        `json:"size"`
+	MaxSize int64        `json:"maxSize"`
+	Entries []CacheEntry `json:"entries,omitempty"`
+}
+
+// CachePrune removes the least recently used entries until the cache is
+// below MaxSize, or every entry with All.
+type CachePrune struct {
+	All     bool   `json:"all,omitempty"`
+	MaxSize string `json:"maxSize,omitempty"`
+}
@@ -0,0 +1,102 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// ConditionDockerReady is true when the docker api of the vm answers
+	// through the docker.sock forwarded to the host
+	ConditionDockerReady = "DockerReady"
+)
+
+// Docker is the docker engine of a vm, containerd and dockerd are installed
+// by meridian-node from the package of Version.
+type Docker struct {
+	// Version of the containerd package, one of DockerVersions, default:
+	// the first of them
+	Version string        `yaml:"version,omitempty" json:"version,omitempty"`
+	Options DockerOptions `yaml:"options,omitempty" json:"options,omitempty"`
+}
+
+// DockerOptions are rendered into daemon.json.
+type DockerOptions struct {
+	// StorageDriver default: overlay2
+	StorageDriver string `yaml:"storageDriver,omitempty" json:"storageDriver,omitempty"`
+	// LogDriver default: json-file
+	LogDriver string `yaml:"logDriver,omitempty" json:"logDriver,omitempty"`
+	// LogOpts replace the builtin max-size and max-file of json-file
+	LogOpts map[string]string `yaml:"logOpts,omitempty" json:"logOpts,omitempty"`
+	// CgroupDriver systemd|cgroupfs, default: systemd
+	CgroupDriver string `yaml:"cgroupDriver,omitempty" json:"cgroupDriver,omitempty"`
+}
+
+var (
+	storageDrivers = []string{"overlay2", "fuse-overlayfs", "btrfs", "zfs", "vfs"}
+	logDrivers     = []string{"json-file", "local", "journald", "syslog", "fluentd", "gelf", "none"}
+)
+
+// DockerVersions are the containerd package versions docker is installed
+// from, the first is the default.
+func DockerVersions() []string {
+	base := &BaseLine{}
+	err := yaml.Unmarshal(baseLine, base)
+	if err != nil {
+		return nil
+	}
+	var versions []string
+	for _, f := range base.Containerd {
+		versions = append(versions, f.Version)
+	}
+	return versions
+}
+
+// GetVersion returns Version or the default of the catalog.
+func (d *Docker) GetVersion() string {
+	if d.Version != "" {
+		return d.Version
+	}
+	if versions := DockerVersions(); len(versions) > 0 {
+		return versions[0]
+	}
+	return ""
+}
+
+func (d *Docker) Validate() error {
+	if d.Version != "" && !contains(DockerVersions(), d.Version) {
+		return fmt.Errorf("docker version %s is not in the catalog %v", d.Version, DockerVersions())
+	}
+	return d.Options.Validate()
+}
+
+func (o *DockerOptions) Validate() error {
+	if o.StorageDriver != "" && !contains(storageDrivers, o.StorageDriver) {
+		return fmt.Errorf("unsupported storage driver %s, expect one of %v", o.StorageDriver, storageDrivers)
+	}
+	if o.LogDriver != "" && !contains(logDrivers, o.LogDriver) {
+		return fmt.Errorf("unsupported log driver %s, expect one of %v", o.LogDriver, logDrivers)
+	}
+	for k, v := range o.LogOpts {
+		if k == "" || strings.ContainsAny(k+v, "'\n") {
+			return fmt.Errorf("invalid log option %q=%q", k, v)
+		}
+	}
+	switch o.CgroupDriver {
+	case "", "systemd", "cgroupfs":
+	default:
+		return fmt.Errorf("unsupported cgroup driver %s, expect systemd|cgroupfs", o.CgroupDriver)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, i := range list {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
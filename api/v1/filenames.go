@@ -18,6 +18,14 @@ const (
 	NetworksConfig = "networks.yaml"
 	DefaultYAML    = "default.yaml"
 	Override       = "override.yaml"
+	// SSHConfigAll has a Host entry for every vm, to be included by ~/.ssh/config
+	SSHConfigAll = "ssh_config"
+	// SSHKeysDir has a key pair for each vm rotated on its own
+	SSHKeysDir = "keys"
+	// ProxyConfig is the HTTPProxy default of the vms created afterwards
+	ProxyConfig = "proxy.yaml"
+	// ImageCacheConfig is the ImageCache of meridiand
+	ImageCacheConfig = "image-cache.yaml"
 )
 
 // Filenames that may appear under an instance directory
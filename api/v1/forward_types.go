@@ -0,0 +1,149 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AutoForward forwards ports the guest starts listening on to the host.
+// Rules are evaluated in order and the first match wins, ports without a
+// matching rule are forwarded when they are not privileged.
+type AutoForward struct {
+	Enabled bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// HostIP is the address forwarded ports bind to on host, default: 127.0.0.1
+	HostIP string `yaml:"hostIP,omitempty" json:"hostIP,omitempty"`
+	// UDP also forwards udp sockets, only tcp is forwarded by default
+	UDP   bool              `yaml:"udp,omitempty" json:"udp,omitempty"`
+	Rules []AutoForwardRule `yaml:"rules,omitempty" json:"rules,omitempty"`
+}
+
+type AutoForwardRule struct {
+	// Ports is a single port or a range, eg. 3000 or 8000-8010, empty
+	// matches all ports
+	Ports string `yaml:"ports,omitempty" json:"ports,omitempty"`
+	// GuestIP matches the listening address in guest, empty matches any
+	GuestIP string `yaml:"guestIP,omitempty" json:"guestIP,omitempty"`
+	// Process matches the name of the listening process, empty matches any
+	Process string `yaml:"process,omitempty" json:"process,omitempty"`
+	// Ignore excludes the matched ports from forwarding
+	Ignore bool `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+}
+
+// NetworkProxy is a SOCKS5 and HTTP proxy served on the host which dials
+// from inside the guest, so that guest addresses, pod and service ips and
+// names resolved by the guest are reachable without a forward for each.
+type NetworkProxy struct {
+	Enabled bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// Address on the host serving both protocols, default: the first port
+	// from 127.0.0.1:1080 not used by the proxy of another vm
+	Address string `yaml:"address,omitempty" json:"address,omitempty"`
+}
+
+func (p *NetworkProxy) GetAddress() string {
+	if p.Address == "" {
+		return "127.0.0.1:1080"
+	}
+	return p.Address
+}
+
+func (p *NetworkProxy) Validate() error {
+	_, port, err := net.SplitHostPort(p.GetAddress())
+	if err != nil {
+		return fmt.Errorf("invalid network proxy address %q: %v", p.Address, err)
+	}
+	_, err = strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("invalid network proxy port %q", port)
+	}
+	return nil
+}
+
+// GuestListener is a listening socket reported by the guest agent.
+type GuestListener struct {
+	Proto   string `json:"proto"`
+	IP      string `json:"ip"`
+	Port    int    `json:"port"`
+	Process string `json:"process,omitempty"`
+}
+
+func (l *GuestListener) String() string {
+	return fmt.Sprintf("%s://%s:%d(%s)", l.Proto, l.IP, l.Port, l.Process)
+}
+
+func (a *AutoForward) Validate() error {
+	for _, r := range a.Rules {
+		if r.Ports == "" {
+			continue
+		}
+		_, _, err := ParsePortRange(r.Ports)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Forward reports whether listener l should be forwarded.
+func (a *AutoForward) Forward(l *GuestListener) bool {
+	for _, r := range a.Rules {
+		if r.Match(l) {
+			return !r.Ignore
+		}
+	}
+	return l.Port >= 1024
+}
+
+func (r *AutoForwardRule) Match(l *GuestListener) bool {
+	if r.Ports != "" {
+		low, high, err := ParsePortRange(r.Ports)
+		if err != nil || l.Port < low || l.Port > high {
+			return false
+		}
+	}
+	if r.GuestIP != "" && r.GuestIP != l.IP {
+		return false
+	}
+	if r.Process != "" && r.Process != l.Process {
+		return false
+	}
+	return true
+}
+
+// ParsePortRange parses a single port or a range like 8000-8010.
+func ParsePortRange(s string) (int, int, error) {
+	low, high, found := strings.Cut(s, "-")
+	lo, err := strconv.Atoi(strings.TrimSpace(low))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	hi := lo
+	if found {
+		hi, err = strconv.Atoi(strings.TrimSpace(high))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+		}
+	}
+	if lo <= 0 || hi > 65535 || lo > hi {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return lo, hi, nil
+}
+
+// ForwardStatus is the runtime state of a forward rule in the host agent.
+type ForwardStatus struct {
+	VM       string `json:"vm,omitempty"`
+	Rule     string `json:"rule"`
+	BindAddr string `json:"bindAddr"`
+	// State is one of Listening, Degraded, Failed and Stopped
+	State       string `json:"state"`
+	Connections int    `json:"connections"`
+	// BytesIn is received from clients, BytesOut is sent back to them
+	BytesIn    int64        `json:"bytesIn"`
+	BytesOut   int64        `json:"bytesOut"`
+	LastError  string       `json:"lastError,omitempty"`
+	LastActive *metav1.Time `json:"lastActive,omitempty"`
+}
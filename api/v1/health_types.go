@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionReady is true when the vm is running and all its health
+	// checks pass
+	ConditionReady = "Ready"
+)
+
+// HealthCheck probes the guest periodically. Exactly one of Exec, TCP and
+// HTTP is set, all of them are run by the guest agent inside the guest.
+type HealthCheck struct {
+	Name string     `yaml:"name" json:"name"`
+	Exec *ExecCheck `yaml:"exec,omitempty" json:"exec,omitempty"`
+	TCP  *TCPCheck  `yaml:"tcp,omitempty" json:"tcp,omitempty"`
+	HTTP *HTTPCheck `yaml:"http,omitempty" json:"http,omitempty"`
+	// Interval between two probes, default: 10s
+	Interval *metav1.Duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+	// Timeout of a single probe, default: 3s
+	Timeout *metav1.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// FailureThreshold is the number of consecutive failures after which
+	// the check is considered failing, default: 3
+	FailureThreshold int `yaml:"failureThreshold,omitempty" json:"failureThreshold,omitempty"`
+	// RestartOnFailure restarts the vm once the check is failing
+	RestartOnFailure bool `yaml:"restartOnFailure,omitempty" json:"restartOnFailure,omitempty"`
+}
+
+// ExecCheck passes when the command exits with 0.
+type ExecCheck struct {
+	Command []string `yaml:"command" json:"command"`
+}
+
+// TCPCheck passes when a connection to Host:Port can be established.
+type TCPCheck struct {
+	// Host default: 127.0.0.1
+	Host string `yaml:"host,omitempty" json:"host,omitempty"`
+	Port int    `yaml:"port" json:"port"`
+}
+
+// HTTPCheck passes when GET returns a 2xx or 3xx status code.
+type HTTPCheck struct {
+	// Scheme http|https, default: http
+	Scheme string `yaml:"scheme,omitempty" json:"scheme,omitempty"`
+	// Host default: 127.0.0.1
+	Host string `yaml:"host,omitempty" json:"host,omitempty"`
+	Port int    `yaml:"port" json:"port"`
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+}
+
+// HealthCheckResult is returned by the guest agent for a single probe.
+type HealthCheckResult struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+func (h *HealthCheck) Validate() error {
+	if h.Name == "" {
+		return fmt.Errorf("health check name must be specified")
+	}
+	cnt := 0
+	if h.Exec != nil {
+		cnt++
+		if len(h.Exec.Command) == 0 {
+			return fmt.Errorf("health check %s: exec command must be specified", h.Name)
+		}
+	}
+	if h.TCP != nil {
+		cnt++
+		if h.TCP.Port <= 0 {
+			return fmt.Errorf("health check %s: tcp port must be specified", h.Name)
+		}
+	}
+	if h.HTTP != nil {
+		cnt++
+		if h.HTTP.Port <= 0 {
+			return fmt.Errorf("health check %s: http port must be specified", h.Name)
+		}
+		switch h.HTTP.Scheme {
+		case "", "http", "https":
+		default:
+			return fmt.Errorf("health check %s: unknown http scheme %s", h.Name, h.HTTP.Scheme)
+		}
+	}
+	if cnt != 1 {
+		return fmt.Errorf("health check %s: exactly one of exec, tcp and http must be set", h.Name)
+	}
+	if h.FailureThreshold < 0 {
+		return fmt.Errorf("health check %s: negative failure threshold", h.Name)
+	}
+	return nil
+}
+
+func (h *HealthCheck) GetInterval() time.Duration {
+	if h.Interval == nil || h.Interval.Duration <= 0 {
+		return 10 * time.Second
+	}
+	return h.Interval.Duration
+}
+
+func (h *HealthCheck) GetTimeout() time.Duration {
+	if h.Timeout == nil || h.Timeout.Duration <= 0 {
+		return 3 * time.Second
+	}
+	return h.Timeout.Duration
+}
+
+func (h *HealthCheck) GetFailureThreshold() int {
+	if h.FailureThreshold <= 0 {
+		return 3
+	}
+	return h.FailureThreshold
+}
@@ -0,0 +1,58 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// KubernetesVersions is the catalog of kubernetes versions of local
+// clusters, the first is the default.
+func KubernetesVersions() []string {
+	base := &BaseLine{}
+	err := yaml.Unmarshal(baseLine, base)
+	if err != nil {
+		return nil
+	}
+	var versions []string
+	for _, f := range base.Kubernetes {
+		versions = append(versions, f.Version)
+	}
+	return versions
+}
+
+// NormalizeKubernetesVersion drops the leading v of version, the packages
+// and the request spec carry none.
+func NormalizeKubernetesVersion(version string) string {
+	return strings.TrimPrefix(version, "v")
+}
+
+// ValidateKubernetesUpgrade checks the upgrade from one version to another
+// against the version skew policy of kubeadm: no downgrade, and one minor
+// version at a time.
+func ValidateKubernetesUpgrade(from, to string) error {
+	from, to = NormalizeKubernetesVersion(from), NormalizeKubernetesVersion(to)
+	if !contains(KubernetesVersions(), to) {
+		return fmt.Errorf("kubernetes version %s is not in the catalog %v", to, KubernetesVersions())
+	}
+	cur, err := version.ParseSemantic(from)
+	if err != nil {
+		return fmt.Errorf("parse current kubernetes version %s: %s", from, err.Error())
+	}
+	next, err := version.ParseSemantic(to)
+	if err != nil {
+		return fmt.Errorf("parse kubernetes version %s: %s", to, err.Error())
+	}
+	switch {
+	case from == to:
+		return fmt.Errorf("kubernetes is already at %s", from)
+	case next.LessThan(cur):
+		return fmt.Errorf("downgrade kubernetes from %s to %s is not supported", from, to)
+	case next.Major() != cur.Major() || next.Minor() > cur.Minor()+1:
+		return fmt.Errorf("upgrade kubernetes from %s to %s skips a minor version, "+
+			"upgrade to %d.%d first", from, to, cur.Major(), cur.Minor()+1)
+	}
+	return nil
+}
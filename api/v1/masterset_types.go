@@ -91,6 +91,10 @@ type ClusterConfig struct {
 	Addons      []*Addon            `json:"addons,omitempty"`
 	Namespace   string              `json:"namespace,omitempty" protobuf:"bytes,3,opt,name=namespace"`
 	CloudType   string              `json:"cloudType,omitempty" protobuf:"bytes,4,opt,name=cloudType"`
+	// Proxy is set up for the runtime and the kubelet, taken from the vm
+	Proxy *HTTPProxy `json:"proxy,omitempty"`
+	// Registries of containerd, taken from the vm
+	Registries *Registries `json:"registries,omitempty"`
 }
 
 func (cfg *ClusterConfig) HasFeature(feature string) bool {
@@ -144,6 +148,12 @@ type AccessPoint struct {
 	Backends   []Endpoint `json:"backends,omitempty"`
 }
 
+// HighlyAvailable reports whether the apiservers of the Backends are served
+// behind the virtual ip Internet.
+func (a *AccessPoint) HighlyAvailable() bool {
+	return len(a.Backends) > 1
+}
+
 type Endpoint struct {
 	Id string `json:"id,omitempty"`
 	Ip string `json:"ip,omitempty"`
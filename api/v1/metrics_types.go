@@ -0,0 +1,63 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GuestMetrics is collected by the guest agent from /proc and /sys. Cpu,
+// disk io and network values are cumulative counters, consumers compute
+// rates from two samples.
+type GuestMetrics struct {
+	Timestamp metav1.Time `json:"timestamp"`
+	// CPUBusy and CPUTotal are cumulative jiffies of all cpus
+	CPUBusy  uint64  `json:"cpuBusy"`
+	CPUTotal uint64  `json:"cpuTotal"`
+	CPUs     int     `json:"cpus"`
+	Load1    float64 `json:"load1"`
+	Load5    float64 `json:"load5"`
+	Load15   float64 `json:"load15"`
+	// MemTotal and MemAvailable in bytes
+	MemTotal     uint64      `json:"memTotal"`
+	MemAvailable uint64      `json:"memAvailable"`
+	Filesystems  []FsMetrics `json:"filesystems,omitempty"`
+	Disks        []IOMetrics `json:"disks,omitempty"`
+	Networks     []IOMetrics `json:"networks,omitempty"`
+}
+
+// FsMetrics is the usage of a mounted filesystem in bytes.
+type FsMetrics struct {
+	Mount string `json:"mount"`
+	Total uint64 `json:"total"`
+	Used  uint64 `json:"used"`
+}
+
+// IOMetrics are cumulative byte counters of a block device or a network
+// interface.
+type IOMetrics struct {
+	Name    string `json:"name"`
+	RxBytes uint64 `json:"rxBytes"`
+	TxBytes uint64 `json:"txBytes"`
+}
+
+// ProcessMetrics describes the meridian-vm sandbox process on host.
+type ProcessMetrics struct {
+	PID        int32   `json:"pid"`
+	CPUPercent float64 `json:"cpuPercent"`
+	RSS        uint64  `json:"rss"`
+}
+
+// VirtualMachineMetrics aggregates guest and host side metrics of a vm. The
+// rates are computed by the daemon against its previous sample.
+type VirtualMachineMetrics struct {
+	Name  string          `json:"name"`
+	Guest *GuestMetrics   `json:"guest,omitempty"`
+	Host  *ProcessMetrics `json:"host,omitempty"`
+	// CPUPercent is the guest cpu usage over all cpus, 0-100
+	CPUPercent float64 `json:"cpuPercent"`
+	// DiskRead, DiskWrite, NetRx and NetTx in bytes per second
+	DiskRead  float64 `json:"diskRead"`
+	DiskWrite float64 `json:"diskWrite"`
+	NetRx     float64 `json:"netRx"`
+	NetTx     float64 `json:"netTx"`
+	Message   string  `json:"message,omitempty"`
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/opencontainers/go-digest"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"net"
 )
 
 type (
@@ -43,6 +44,12 @@ type BaseLine struct {
 	Kubectl  []File `yaml:"kubectl,omitempty" json:"kubectl,omitempty"`
 	Images   []File `yaml:"images,omitempty" json:"images,omitempty"`
 	GuestBin []File `yaml:"guestBin,omitempty" json:"guestBin,omitempty"`
+	// Containerd is the catalog of the containerd package versions
+	// meridian-node installs containerd and dockerd from
+	Containerd []File `yaml:"containerd,omitempty" json:"containerd,omitempty"`
+	// Kubernetes is the catalog of kubernetes versions local clusters are
+	// created with or upgraded to
+	Kubernetes []File `yaml:"kubernetes,omitempty" json:"kubernetes,omitempty"`
 }
 
 type File struct {
@@ -94,6 +101,9 @@ type SSH struct {
 	ForwardAgent      bool `yaml:"forwardAgent,omitempty" json:"forwardAgent,omitempty"`           // default: false
 	ForwardX11        bool `yaml:"forwardX11,omitempty" json:"forwardX11,omitempty"`               // default: false
 	ForwardX11Trusted bool `yaml:"forwardX11Trusted,omitempty" json:"forwardX11Trusted,omitempty"` // default: false
+	// AuthorizedKeys are public keys allowed to log in the vm besides the
+	// meridian key, e.g. those of teammates
+	AuthorizedKeys []string `yaml:"authorizedKeys,omitempty" json:"authorizedKeys,omitempty"`
 }
 
 type Firmware struct {
@@ -132,10 +142,35 @@ type PortForward struct {
 	SrcAddr  intstr.IntOrString `yaml:"srcAddr" json:"srcAddr"`
 	DstProto string             `yaml:"dstProto" json:"dstProto"`
 	DstAddr  intstr.IntOrString `yaml:"dstAddr,omitempty" json:"dstAddr,omitempty"`
+	// Reverse listens on SrcAddr inside the guest and forwards to DstAddr
+	// on the host, nothing is exposed on the host.
+	Reverse bool `yaml:"reverse,omitempty" json:"reverse,omitempty"`
 }
 
+// ReversePrefix marks the source protocol of reverse forwarding rules
+const ReversePrefix = "reverse+"
+
 func (p *PortForward) Rule() string {
-	return fmt.Sprintf("%s://%s->%s://%v", p.SrcProto, p.SrcAddr.String(), p.DstProto, p.DstAddr.String())
+	src := p.SrcProto
+	if p.Reverse {
+		src = ReversePrefix + src
+	}
+	return fmt.Sprintf("%s://%s->%s://%v", src, p.SrcAddr.String(), p.DstProto, p.DstAddr.String())
+}
+
+func (p *PortForward) Validate() error {
+	if !p.Reverse {
+		return nil
+	}
+	if p.SrcProto != "tcp" {
+		return fmt.Errorf("reverse forward %s: guest protocol must be tcp", p.Rule())
+	}
+	switch p.DstProto {
+	case "tcp", "unix":
+	default:
+		return fmt.Errorf("reverse forward %s: host protocol must be tcp or unix", p.Rule())
+	}
+	return nil
 }
 
 type Network struct {
@@ -150,12 +185,174 @@ type Network struct {
 	Interface  string `yaml:"interface,omitempty" json:"interface,omitempty"`
 	Address    string `yaml:"address,omitempty" json:"address,omitempty"`
 	IpGateway  string `yaml:"ipGateway,omitempty" json:"ipGateway,omitempty"`
+
+	// The fields below follow netplan v2 and are rendered into the
+	// network-config of the vm.
+
+	// Addresses are static addresses in CIDR notation besides Address
+	Addresses []string `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+	// DHCP4 default: true
+	DHCP4       *bool        `yaml:"dhcp4,omitempty" json:"dhcp4,omitempty"`
+	DHCP6       bool         `yaml:"dhcp6,omitempty" json:"dhcp6,omitempty"`
+	MTU         int          `yaml:"mtu,omitempty" json:"mtu,omitempty"`
+	Routes      []Route      `yaml:"routes,omitempty" json:"routes,omitempty"`
+	Nameservers *Nameservers `yaml:"nameservers,omitempty" json:"nameservers,omitempty"`
+	VLANs       []VLAN       `yaml:"vlans,omitempty" json:"vlans,omitempty"`
+}
+
+type Route struct {
+	// To is a CIDR or default
+	To     string `yaml:"to" json:"to"`
+	Via    string `yaml:"via" json:"via"`
+	Metric int    `yaml:"metric,omitempty" json:"metric,omitempty"`
+	OnLink bool   `yaml:"onLink,omitempty" json:"onLink,omitempty"`
+}
+
+type Nameservers struct {
+	Addresses []string `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty" json:"search,omitempty"`
+}
+
+// VLAN is a tagged interface on top of a network.
+type VLAN struct {
+	ID int `yaml:"id" json:"id"`
+	// Name default: <interface>.<id>
+	Name        string       `yaml:"name,omitempty" json:"name,omitempty"`
+	Addresses   []string     `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+	DHCP4       bool         `yaml:"dhcp4,omitempty" json:"dhcp4,omitempty"`
+	DHCP6       bool         `yaml:"dhcp6,omitempty" json:"dhcp6,omitempty"`
+	MTU         int          `yaml:"mtu,omitempty" json:"mtu,omitempty"`
+	Routes      []Route      `yaml:"routes,omitempty" json:"routes,omitempty"`
+	Nameservers *Nameservers `yaml:"nameservers,omitempty" json:"nameservers,omitempty"`
+}
+
+func (n *Network) GetDHCP4() bool {
+	return n.DHCP4 == nil || *n.DHCP4
+}
+
+// ValidateNetworks checks the addressing of every network and that the
+// interface names do not clash.
+func ValidateNetworks(networks []Network) error {
+	names := map[string]bool{}
+	unique := func(name string) error {
+		if name == "" {
+			return nil
+		}
+		if names[name] {
+			return fmt.Errorf("duplicated network interface: %s", name)
+		}
+		names[name] = true
+		return nil
+	}
+	for i := range networks {
+		n := &networks[i]
+		err := n.Validate()
+		if err != nil {
+			return err
+		}
+		if err = unique(n.Interface); err != nil {
+			return err
+		}
+		for _, v := range n.VLANs {
+			if err = unique(v.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Network) Validate() error {
+	name := n.Interface
+	if name == "" {
+		name = n.MACAddress
+	}
+	// network-config matches the interfaces by mac address
+	if n.MACAddress == "" {
+		return fmt.Errorf("network %s: empty mac address", name)
+	}
+	if _, err := net.ParseMAC(n.MACAddress); err != nil {
+		return fmt.Errorf("network %s: %v", name, err)
+	}
+	if n.Address != "" {
+		if err := validateCIDR(n.Address); err != nil {
+			return fmt.Errorf("network %s: address %v", name, err)
+		}
+	}
+	if n.IpGateway != "" && net.ParseIP(n.IpGateway) == nil {
+		return fmt.Errorf("network %s: invalid gateway %s", name, n.IpGateway)
+	}
+	err := validateAddressing(n.Addresses, n.MTU, n.Routes, n.Nameservers)
+	if err != nil {
+		return fmt.Errorf("network %s: %v", name, err)
+	}
+	ids := map[int]bool{}
+	for _, v := range n.VLANs {
+		if v.ID < 1 || v.ID > 4094 {
+			return fmt.Errorf("network %s: vlan id %d out of range 1-4094", name, v.ID)
+		}
+		if ids[v.ID] {
+			return fmt.Errorf("network %s: duplicated vlan %d", name, v.ID)
+		}
+		ids[v.ID] = true
+		err = validateAddressing(v.Addresses, v.MTU, v.Routes, v.Nameservers)
+		if err != nil {
+			return fmt.Errorf("network %s: vlan %d: %v", name, v.ID, err)
+		}
+	}
+	return nil
+}
+
+func validateAddressing(addrs []string, mtu int, routes []Route, ns *Nameservers) error {
+	for _, a := range addrs {
+		if err := validateCIDR(a); err != nil {
+			return fmt.Errorf("address %v", err)
+		}
+	}
+	if mtu != 0 && (mtu < 576 || mtu > 65535) {
+		return fmt.Errorf("mtu %d out of range 576-65535", mtu)
+	}
+	for _, r := range routes {
+		if r.To != "default" {
+			if _, _, err := net.ParseCIDR(r.To); err != nil {
+				return fmt.Errorf("route to %s: must be a CIDR or default", r.To)
+			}
+		}
+		if net.ParseIP(r.Via) == nil {
+			return fmt.Errorf("route to %s: invalid via %s", r.To, r.Via)
+		}
+		if r.Metric < 0 {
+			return fmt.Errorf("route to %s: negative metric", r.To)
+		}
+	}
+	if ns != nil {
+		for _, a := range ns.Addresses {
+			if net.ParseIP(a) == nil {
+				return fmt.Errorf("invalid nameserver %s", a)
+			}
+		}
+	}
+	return nil
+}
+
+func validateCIDR(addr string) error {
+	if _, _, err := net.ParseCIDR(addr); err != nil {
+		return fmt.Errorf("%s must be in CIDR notation", addr)
+	}
+	return nil
 }
 
 type HostResolver struct {
 	Enabled bool              `yaml:"enabled,omitempty" json:"enabled,omitempty"`
 	IPv6    bool              `yaml:"ipv6,omitempty" json:"ipv6,omitempty"`
 	Hosts   map[string]string `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	// Upstreams are ip[:port], udp://, tcp://, tls:// (DNS over TLS) or
+	// https:// (DNS over HTTPS) resolvers, default: resolv.conf of the host
+	Upstreams []string `yaml:"upstreams,omitempty" json:"upstreams,omitempty"`
+	// DisableCache sends every query upstream
+	DisableCache bool `yaml:"disableCache,omitempty" json:"disableCache,omitempty"`
+	// QueryLog records every query of the vm to dns-query.log in its dir
+	QueryLog bool `yaml:"queryLog,omitempty" json:"queryLog,omitempty"`
 }
 
 type CACertificates struct {
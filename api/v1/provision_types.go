@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionProvisioned is true when every provision step of the current
+	// boot succeeded
+	ConditionProvisioned = "Provisioned"
+
+	ProvisionSystem = "system"
+	ProvisionUser   = "user"
+
+	ProvisionPending   = "Pending"
+	ProvisionRunning   = "Running"
+	ProvisionSucceeded = "Succeeded"
+	ProvisionFailed    = "Failed"
+)
+
+var provisionName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Provision is a step run on every boot after the guest is set up. Exactly
+// one of Script, File and Packages is set. System steps run first, in
+// order, then the user steps.
+type Provision struct {
+	Name string `yaml:"name" json:"name"`
+	// Mode system runs the step as root, user as the vm user. Files and
+	// packages are always provisioned by root. default: system
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Script is run by the interpreter of its shebang, default /bin/sh
+	Script   string         `yaml:"script,omitempty" json:"script,omitempty"`
+	File     *ProvisionFile `yaml:"file,omitempty" json:"file,omitempty"`
+	Packages []string       `yaml:"packages,omitempty" json:"packages,omitempty"`
+}
+
+// ProvisionFile is written to Path in the guest.
+type ProvisionFile struct {
+	Path    string `yaml:"path" json:"path"`
+	Content string `yaml:"content" json:"content"`
+	// Permissions in octal, default: 0644
+	Permissions string `yaml:"permissions,omitempty" json:"permissions,omitempty"`
+	// Owner as user[:group], default: root
+	Owner string `yaml:"owner,omitempty" json:"owner,omitempty"`
+}
+
+func (p *Provision) GetMode() string {
+	if p.Mode == "" || p.Script == "" {
+		return ProvisionSystem
+	}
+	return p.Mode
+}
+
+func (p *Provision) Validate() error {
+	if !provisionName.MatchString(p.Name) {
+		return fmt.Errorf("provision name %q must match %s", p.Name, provisionName)
+	}
+	switch p.Mode {
+	case "", ProvisionSystem, ProvisionUser:
+	default:
+		return fmt.Errorf("provision %s: unknown mode %s", p.Name, p.Mode)
+	}
+	cnt := 0
+	if p.Script != "" {
+		cnt++
+	}
+	if p.File != nil {
+		cnt++
+		if !path.IsAbs(p.File.Path) {
+			return fmt.Errorf("provision %s: file path must be absolute", p.Name)
+		}
+	}
+	if len(p.Packages) > 0 {
+		cnt++
+	}
+	if cnt != 1 {
+		return fmt.Errorf("provision %s: exactly one of script, file and packages must be set", p.Name)
+	}
+	return nil
+}
+
+func ValidateProvision(steps []Provision) error {
+	names := map[string]bool{}
+	for i := range steps {
+		err := steps[i].Validate()
+		if err != nil {
+			return err
+		}
+		if names[steps[i].Name] {
+			return fmt.Errorf("duplicated provision name: %s", steps[i].Name)
+		}
+		names[steps[i].Name] = true
+	}
+	return nil
+}
+
+// ProvisionStatus is reported by the guest agent for the current boot.
+type ProvisionStatus struct {
+	// Done is set once every step has run
+	Done  bool                  `json:"done"`
+	Steps []ProvisionStepStatus `json:"steps,omitempty"`
+}
+
+type ProvisionStepStatus struct {
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+	// Phase is one of Pending, Running, Succeeded and Failed
+	Phase      string       `json:"phase"`
+	ExitCode   int          `json:"exitCode,omitempty"`
+	StartedAt  *metav1.Time `json:"startedAt,omitempty"`
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+	// LogTail is the end of the output of the step
+	LogTail string `json:"logTail,omitempty"`
+}
@@ -0,0 +1,165 @@
+package v1
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultNoProxy is always part of no_proxy
+var DefaultNoProxy = []string{"localhost", "127.0.0.1", "::1"}
+
+// HTTPProxy is used by the guest, docker, containerd, the kubelet and the
+// package managers to reach the outside network. The proxy in the daemon
+// config dir, see ProxyConfig, is the default of every vm.
+type HTTPProxy struct {
+	HTTPProxy  string `yaml:"httpProxy,omitempty" json:"httpProxy,omitempty"`
+	HTTPSProxy string `yaml:"httpsProxy,omitempty" json:"httpsProxy,omitempty"`
+	// NoProxy is extended with localhost, the vm subnet and the service and
+	// pod cidr of kubernetes
+	NoProxy []string `yaml:"noProxy,omitempty" json:"noProxy,omitempty"`
+	// CA is a PEM certificate trusted by the guest, for proxies that
+	// intercept tls
+	CA string `yaml:"ca,omitempty" json:"ca,omitempty"`
+}
+
+func (p *HTTPProxy) Validate() error {
+	if p.HTTPProxy == "" && p.HTTPSProxy == "" {
+		return fmt.Errorf("proxy: one of httpProxy and httpsProxy is needed")
+	}
+	for _, u := range []string{p.HTTPProxy, p.HTTPSProxy} {
+		if u == "" {
+			continue
+		}
+		pu, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("proxy: invalid url %q: %v", u, err)
+		}
+		switch pu.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("proxy: unsupported scheme of %q, expect http|https|socks5", u)
+		}
+		if pu.Host == "" {
+			return fmt.Errorf("proxy: no host in %q", u)
+		}
+	}
+	if p.CA != "" {
+		block, _ := pem.Decode([]byte(p.CA))
+		if block == nil {
+			return fmt.Errorf("proxy: ca is not a PEM certificate")
+		}
+	}
+	return nil
+}
+
+// Merge returns p with the fields it leaves empty taken from d, NoProxy of
+// both is kept. Either may be nil.
+func (p *HTTPProxy) Merge(d *HTTPProxy) *HTTPProxy {
+	if p == nil {
+		return d.DeepCopy()
+	}
+	out := p.DeepCopy()
+	if d == nil {
+		return out
+	}
+	if out.HTTPProxy == "" {
+		out.HTTPProxy = d.HTTPProxy
+	}
+	if out.HTTPSProxy == "" {
+		out.HTTPSProxy = d.HTTPSProxy
+	}
+	if out.CA == "" {
+		out.CA = d.CA
+	}
+	out.NoProxy = uniqueAppend(d.NoProxy, out.NoProxy...)
+	return out
+}
+
+// NoProxyList joins DefaultNoProxy, NoProxy and extra without duplicates.
+func (p *HTTPProxy) NoProxyList(extra ...string) string {
+	list := uniqueAppend(DefaultNoProxy, p.NoProxy...)
+	return strings.Join(uniqueAppend(list, extra...), ",")
+}
+
+// Env is the proxy environment in both upper and lower case, tools read
+// either of them.
+func (p *HTTPProxy) Env(extra ...string) map[string]string {
+	env := map[string]string{}
+	set := func(k, v string) {
+		if v != "" {
+			env[strings.ToUpper(k)] = v
+			env[k] = v
+		}
+	}
+	set("http_proxy", p.HTTPProxy)
+	set("https_proxy", p.HTTPSProxy)
+	set("no_proxy", p.NoProxyList(extra...))
+	return env
+}
+
+// SystemdDropIn renders a [Service] drop-in setting the proxy environment.
+func (p *HTTPProxy) SystemdDropIn(extra ...string) string {
+	env := p.Env(extra...)
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := []string{"[Service]"}
+	for _, k := range keys {
+		lines = append(lines, "Environment="+systemdQuote(k+"="+env[k]))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// systemdEscaper escapes the value of a double quoted unit file setting, %
+// starts a specifier and is doubled, eg. a percent-encoded proxy password.
+var systemdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%")
+
+func systemdQuote(s string) string {
+	return `"` + systemdEscaper.Replace(s) + `"`
+}
+
+// Subnets of the addresses of nets, they are not proxied.
+func Subnets(nets []Network) []string {
+	var subnets []string
+	for _, n := range nets {
+		addrs := n.Addresses
+		if n.Address != "" {
+			addrs = append([]string{n.Address}, addrs...)
+		}
+		for _, a := range addrs {
+			_, ipnet, err := net.ParseCIDR(a)
+			if err != nil {
+				continue
+			}
+			subnets = uniqueAppend(subnets, ipnet.String())
+		}
+	}
+	return subnets
+}
+
+func uniqueAppend(list []string, items ...string) []string {
+	out := append([]string{}, list...)
+	for _, i := range items {
+		i = strings.TrimSpace(i)
+		if i == "" {
+			continue
+		}
+		found := false
+		for _, o := range out {
+			if o == i {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, i)
+		}
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DockerHub is the upstream key of the mirrors of docker hub, the only
+	// upstream docker itself mirrors.
+	DockerHub = "docker.io"
+	// DefaultDockerHubMirror is the builtin mirror of docker hub in the vm
+	DefaultDockerHubMirror = "https://docker.m.daocloud.io"
+)
+
+// Registries configures where docker and containerd in the vm pull images
+// from. Without it the builtin mirrors are used.
+type Registries struct {
+	// Mirrors are tried in order before the upstream they mirror, keyed by
+	// the upstream host, eg. docker.io or ghcr.io
+	Mirrors map[string][]string `yaml:"mirrors,omitempty" json:"mirrors,omitempty"`
+	// Insecure registries are pulled without verifying their certificate,
+	// host[:port]
+	Insecure []string `yaml:"insecure,omitempty" json:"insecure,omitempty"`
+	// Auths reference the credentials of registries and mirrors
+	Auths []RegistryAuth `yaml:"auths,omitempty" json:"auths,omitempty"`
+}
+
+// RegistryAuth references the credentials of a registry. The file is read
+// on the host when the config is applied, the credentials are not kept in
+// the vm spec.
+type RegistryAuth struct {
+	// Registry is the host[:port] the credentials are sent to
+	Registry string `yaml:"registry" json:"registry"`
+	// File on the host holding username:password
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// Auth is the base64 of username:password read from File
+	Auth string `yaml:"auth,omitempty" json:"auth,omitempty"`
+}
+
+func (r *Registries) Validate() error {
+	for upstream, mirrors := range r.Mirrors {
+		if err := validRegistryHost(upstream); err != nil {
+			return fmt.Errorf("registries: mirrors of %q: %v", upstream, err)
+		}
+		if len(mirrors) == 0 {
+			return fmt.Errorf("registries: no mirror for %q", upstream)
+		}
+		for _, m := range mirrors {
+			u, err := url.Parse(m)
+			if err != nil {
+				return fmt.Errorf("registries: invalid mirror %q: %v", m, err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("registries: mirror %q must be an http or https url", m)
+			}
+		}
+	}
+	for _, i := range r.Insecure {
+		if err := validRegistryHost(i); err != nil {
+			return fmt.Errorf("registries: insecure %q: %v", i, err)
+		}
+	}
+	for _, a := range r.Auths {
+		if err := validRegistryHost(a.Registry); err != nil {
+			return fmt.Errorf("registries: auth of %q: %v", a.Registry, err)
+		}
+		if a.File == "" && a.Auth == "" {
+			return fmt.Errorf("registries: auth of %q references no file", a.Registry)
+		}
+	}
+	return nil
+}
+
+// IsInsecure tells whether the certificate of host is not verified.
+func (r *Registries) IsInsecure(host string) bool {
+	for _, i := range r.Insecure {
+		if i == host {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthOf returns the resolved credentials of host.
+func (r *Registries) AuthOf(host string) string {
+	for _, a := range r.Auths {
+		if a.Registry == host {
+			return a.Auth
+		}
+	}
+	return ""
+}
+
+func validRegistryHost(h string) error {
+	if h == "" || strings.Contains(h, "/") {
+		return fmt.Errorf("expect host[:port]")
+	}
+	if _, _, err := net.SplitHostPort(h); err != nil && strings.Contains(h, ":") {
+		return fmt.Errorf("expect host[:port]")
+	}
+	return nil
+}
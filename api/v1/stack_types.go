@@ -0,0 +1,120 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Stack describes a group of sandboxes applied together by `m apply -f`.
+// Each machine carries its own vm spec and, optionally, the docker and
+// kubernetes deployed in it.
+type Stack struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec StackSpec `yaml:"spec" json:"spec"`
+}
+
+// StackSpec defines the desired state of Stack
+type StackSpec struct {
+	Machines []StackMachine `yaml:"machines" json:"machines"`
+}
+
+type StackMachine struct {
+	Name       string             `yaml:"name" json:"name"`
+	Spec       VirtualMachineSpec `yaml:"spec,omitempty" json:"spec,omitempty"`
+	Docker     *StackDocker       `yaml:"docker,omitempty" json:"docker,omitempty"`
+	Kubernetes *StackKubernetes   `yaml:"kubernetes,omitempty" json:"kubernetes,omitempty"`
+}
+
+type StackDocker struct {
+	// Version is one of DockerVersions, default: the first of them
+	Version string        `yaml:"version,omitempty" json:"version,omitempty"`
+	Options DockerOptions `yaml:"options,omitempty" json:"options,omitempty"`
+}
+
+type StackKubernetes struct {
+	// Version of the kubernetes release, default: DftRequest's version
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+}
+
+const (
+	StackResourceVM     = "vm"
+	StackResourceDocker = "docker"
+	StackResourceK8s    = "k8s"
+
+	ChangeCreate    = "Create"
+	ChangeUpdate    = "Update"
+	ChangeDelete    = "Delete"
+	ChangeUnchanged = "Unchanged"
+)
+
+// StackChange is a single step of a StackPlan.
+type StackChange struct {
+	Resource string `json:"resource"`
+	Name     string `json:"name"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// StackPlan is the ordered list of changes needed to converge a Stack.
+// Deletions come first in reverse dependency order (k8s, docker, vm),
+// followed by creations and updates in dependency order (vm, docker, k8s).
+type StackPlan struct {
+	Stack   string        `json:"stack"`
+	Changes []StackChange `json:"changes,omitempty"`
+}
+
+// Pending reports whether the plan contains anything other than no-ops.
+func (p *StackPlan) Pending() bool {
+	for _, c := range p.Changes {
+		if c.Action != ChangeUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Stack) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("stack name must be specified: metadata.name")
+	}
+	names := sets.New[string]()
+	for i, m := range s.Spec.Machines {
+		if m.Name == "" {
+			return fmt.Errorf("spec.machines[%d].name must be specified", i)
+		}
+		if names.Has(m.Name) {
+			return fmt.Errorf("duplicated machine name: %s", m.Name)
+		}
+		names.Insert(m.Name)
+	}
+	return nil
+}
+
+func (s *Stack) Machine(name string) *StackMachine {
+	for i := range s.Spec.Machines {
+		if s.Spec.Machines[i].Name == name {
+			return &s.Spec.Machines[i]
+		}
+	}
+	return nil
+}
@@ -179,12 +179,13 @@ func validateNetwork(y *VirtualMachine) error {
 		}
 		y.Spec.Networks = network
 	}
-	if y.Spec.Networks[0].VZNAT {
-		if y.Spec.Networks[0].MACAddress == "" {
-			y.Spec.Networks[0].MACAddress = GenMAC()
+	for i := range y.Spec.Networks {
+		n := &y.Spec.Networks[i]
+		if n.MACAddress == "" {
+			n.MACAddress = GenMAC()
 		}
+		klog.Infof("[%-10s]new network address: %s", y.Name, n.MACAddress)
 	}
-	klog.Infof("[%-10s]new network address: %s", y.Name, y.Spec.Networks[0].MACAddress)
 	return nil
 }
 
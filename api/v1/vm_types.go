@@ -55,6 +55,99 @@ type VirtualMachineSpec struct {
 	HostResolver    HostResolver      `yaml:"hostResolver,omitempty" json:"hostResolver,omitempty"`
 	CACertificates  CACertificates    `yaml:"caCerts,omitempty" json:"caCerts,omitempty"`
 	TimeZone        string            `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	Lifecycle       *Lifecycle        `yaml:"lifecycle,omitempty" json:"lifecycle,omitempty"`
+	// RestartPolicy is applied when the sandbox process exits, default: Never
+	RestartPolicy RestartPolicy `yaml:"restartPolicy,omitempty" json:"restartPolicy,omitempty"`
+	// Autostart starts the vm when meridiand starts
+	Autostart bool `yaml:"autostart,omitempty" json:"autostart,omitempty"`
+	// HealthChecks run inside the guest and drive the Ready condition
+	HealthChecks []HealthCheck `yaml:"healthChecks,omitempty" json:"healthChecks,omitempty"`
+	// AutoForward forwards ports the guest starts listening on
+	AutoForward *AutoForward `yaml:"autoForward,omitempty" json:"autoForward,omitempty"`
+	// NetworkProxy serves a SOCKS5 and HTTP proxy into the guest network
+	NetworkProxy *NetworkProxy `yaml:"networkProxy,omitempty" json:"networkProxy,omitempty"`
+	// DNSWildcard resolves every subdomain of the vm hostname to the vm
+	DNSWildcard bool `yaml:"dnsWildcard,omitempty" json:"dnsWildcard,omitempty"`
+	// Provision steps run on every boot, see Status.Provision
+	Provision []Provision `yaml:"provision,omitempty" json:"provision,omitempty"`
+	// Proxy is the http proxy of the guest, its container runtimes and
+	// package managers, default: the proxy of the daemon when the vm is
+	// created, later changes of the daemon proxy do not apply to it
+	Proxy *HTTPProxy `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	// Registries are the mirrors, insecure registries and credentials of
+	// docker and containerd, `m update registries` changes them later
+	Registries *Registries `yaml:"registries,omitempty" json:"registries,omitempty"`
+}
+
+type RestartPolicy string
+
+const (
+	// RestartNever never restarts the vm
+	RestartNever RestartPolicy = "Never"
+	// RestartOnFailure restarts the vm when its sandbox process dies unexpectedly
+	RestartOnFailure RestartPolicy = "OnFailure"
+	// RestartAlways restarts the vm whenever it exits, unless stopped by user
+	RestartAlways RestartPolicy = "Always"
+)
+
+func (p RestartPolicy) Validate() error {
+	switch p {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return nil
+	}
+	return fmt.Errorf("unknown restart policy: %s, expect Never|OnFailure|Always", p)
+}
+
+const (
+	LifecycleStop   = "Stop"
+	LifecycleDelete = "Delete"
+)
+
+// Lifecycle stops or deletes a vm automatically once it expires or stays
+// idle for too long.
+type Lifecycle struct {
+	// TTL is the lifetime of the vm counted from its creation, eg. 24h
+	TTL *metav1.Duration `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+	// ExpireAt is derived from TTL on creation, `m extend vm` moves it forward
+	ExpireAt *metav1.Time `yaml:"expireAt,omitempty" json:"expireAt,omitempty"`
+	// IdleTimeout is the window without ssh sessions, forwarded traffic or
+	// guest cpu activity after which the vm is considered idle
+	IdleTimeout *metav1.Duration `yaml:"idleTimeout,omitempty" json:"idleTimeout,omitempty"`
+	// Action taken on expiry or idle timeout, Stop|Delete, default: Stop
+	Action string `yaml:"action,omitempty" json:"action,omitempty"`
+	// GracePeriod is how long before the action a warning event is recorded, default: 10m
+	GracePeriod *metav1.Duration `yaml:"gracePeriod,omitempty" json:"gracePeriod,omitempty"`
+}
+
+func (l *Lifecycle) Validate() error {
+	switch l.Action {
+	case "", LifecycleStop, LifecycleDelete:
+	default:
+		return fmt.Errorf("unknown lifecycle action: %s, expect Stop|Delete", l.Action)
+	}
+	return nil
+}
+
+// Grace returns the warning period before the lifecycle action.
+func (l *Lifecycle) Grace() time.Duration {
+	if l.GracePeriod == nil {
+		return 10 * time.Minute
+	}
+	return l.GracePeriod.Duration
+}
+
+// Activity is reported by the guest agent and the host agent, and is used
+// by the daemon to detect idle vms.
+type Activity struct {
+	// SSHSessions is the number of established ssh connections in guest
+	SSHSessions int `json:"sshSessions,omitempty"`
+	// CPUBusy and CPUTotal are cumulative guest cpu jiffies from /proc/stat
+	CPUBusy  uint64 `json:"cpuBusy,omitempty"`
+	CPUTotal uint64 `json:"cpuTotal,omitempty"`
+	// Connections is the number of open forwarded connections on host
+	Connections int `json:"connections,omitempty"`
+	// LastActive is the last time a forwarded connection opened or closed
+	LastActive *metav1.Time `json:"lastActive,omitempty"`
 }
 
 func (s *VirtualMachineSpec) SetForward(ports ...PortForward) {
@@ -130,10 +223,13 @@ type VirtualMachine struct {
 
 // VirtualMachineStatus defines the observed state of GuestInfo
 type VirtualMachineStatus struct {
-	Events  []Event  `json:"events,omitempty"`
-	Phase   string   `json:"phase,omitempty"`
-	Message string   `json:"message,omitempty"`
-	Address []string `json:"address,omitempty"`
+	Events     []Event            `json:"events,omitempty"`
+	Phase      string             `json:"phase,omitempty"`
+	Message    string             `json:"message,omitempty"`
+	Address    []string           `json:"address,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Provision is the status of the provision steps of the current boot
+	Provision *ProvisionStatus `json:"provision,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -176,3 +272,12 @@ func EmptyVM(k string) *VirtualMachine {
 func init() {
 	SchemeBuilder.Register(&VirtualMachine{}, &VirtualMachineList{})
 }
+
+// KeyRotation is the result of rotating the ssh key of a vm.
+type KeyRotation struct {
+	VM string `json:"vm"`
+	// Pushed is set when the new key was pushed into the running vm, stopped
+	// vms pick it up on the next boot
+	Pushed bool   `json:"pushed,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
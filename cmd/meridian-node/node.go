@@ -30,6 +30,8 @@ func NewCommandHost() *cobra.Command {
 	cmd.AddCommand(NewCommandVersion())
 	cmd.AddCommand(NewCommandDestroy())
 	cmd.AddCommand(NewCommandCreate())
+	cmd.AddCommand(NewCommandUpdate())
+	cmd.AddCommand(NewCommandUpgrade())
 	return cmd
 }
 func NewCommandVersion() *cobra.Command {
@@ -57,6 +59,7 @@ func NewJoinRequest(req *v1.Request) *v1.Request {
 // NewCommandDestroy create resource
 func NewCommandDestroy() *cobra.Command {
 	forceDestroy := false
+	role := string(v1.NodeRoleMaster)
 	cmd := &cobra.Command{
 		Use:   "destroy",
 		Short: "meridian destroy /etc/meridian/request.yaml",
@@ -85,7 +88,7 @@ func NewCommandDestroy() *cobra.Command {
 				if err != nil {
 					return err
 				}
-				md, err := node.NewMeridianNode("init", v1.NodeRoleMaster, "", "", req, []string{})
+				md, err := node.NewMeridianNode("init", v1.NodeRole(role), "", "", req, []string{})
 				if err != nil {
 					return err
 				}
@@ -94,6 +97,44 @@ func NewCommandDestroy() *cobra.Command {
 		},
 	}
 	cmd.PersistentFlags().BoolVar(&forceDestroy, "force", false, "force destroy")
+	cmd.PersistentFlags().StringVarP(&role, "role", "r", string(v1.NodeRoleMaster), "node role, one of master|worker")
+	return cmd
+}
+
+// NewCommandUpgrade upgrades kubernetes of the node in place
+func NewCommandUpgrade() *cobra.Command {
+	role := string(v1.NodeRoleMaster)
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "meridian upgrade config.yml",
+		Long:  "upgrade installs the kubernetes version of the config and upgrades the node with kubeadm,\nthe first master upgrades the control plane and the addons, the other nodes follow it.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf(meridian.Logo)
+			if len(args) < 1 {
+				return fmt.Errorf("resource file is needed. eg. [config.yml]")
+			}
+			switch role {
+			case string(v1.NodeRoleMaster), string(v1.NodeRoleWorker):
+			default:
+				return fmt.Errorf("invalid role: %s", role)
+			}
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			req := &v1.Request{}
+			err = yaml.Unmarshal(data, req)
+			if err != nil {
+				return err
+			}
+			md, err := node.NewMeridianNode("upgrade", v1.NodeRole(role), "", "", req, []string{})
+			if err != nil {
+				return errors.Wrapf(err, "meridian upgrade")
+			}
+			return md.UpgradeNode()
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&role, "role", "r", string(v1.NodeRoleMaster), "node role, one of master|worker")
 	return cmd
 }
 
@@ -131,6 +172,8 @@ func NewCommandInit() *cobra.Command {
 func NewCommandCreate() *cobra.Command {
 	var version string
 	var registry string
+	var registries string
+	var options v1.DockerOptions
 	cmd := &cobra.Command{
 		Use:   "create",
 		Short: "meridian create",
@@ -146,12 +189,20 @@ func NewCommandCreate() *cobra.Command {
 				if version == "" || registry == "" {
 					return fmt.Errorf("version or registry is needed for init")
 				}
+				regs, err := loadRegistries(registries)
+				if err != nil {
+					return err
+				}
+				err = options.Validate()
+				if err != nil {
+					return err
+				}
 				md, err := node.NewMeridianNode(
 					v1.ActionInit, v1.NodeRoleMaster, "", "", nil, []string{})
 				if err != nil {
 					return errors.Wrapf(err, "meridian init")
 				}
-				return md.CreateDocker(context.Background(), version, registry)
+				return md.CreateDocker(context.Background(), version, registry, regs, &options)
 			default:
 				return fmt.Errorf("unknown resource: %s", r)
 			}
@@ -159,9 +210,65 @@ func NewCommandCreate() *cobra.Command {
 	}
 	cmd.Flags().StringVar(&version, "version", "", "docker version")
 	cmd.Flags().StringVar(&registry, "registry", "", "registry version")
+	cmd.Flags().StringVar(&registries, "registries", "", "registries config file of docker and containerd")
+	cmd.Flags().StringVar(&options.StorageDriver, "storage-driver", "", "docker storage driver")
+	cmd.Flags().StringVar(&options.LogDriver, "log-driver", "", "docker log driver")
+	cmd.Flags().StringToStringVar(&options.LogOpts, "log-opt", nil, "docker log driver options, key=value")
+	cmd.Flags().StringVar(&options.CgroupDriver, "cgroup-driver", "", "docker cgroup driver, systemd|cgroupfs")
+	return cmd
+}
+
+// NewCommandUpdate updates the registries of docker and containerd
+func NewCommandUpdate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "meridian-node update registries [registries.yml]",
+		Long:  "",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return fmt.Errorf("resource is needed for update")
+			}
+			switch args[0] {
+			case "registries":
+				// without a config file the builtin mirrors are restored
+				var file string
+				if len(args) > 1 {
+					file = args[1]
+				}
+				regs, err := loadRegistries(file)
+				if err != nil {
+					return err
+				}
+				md, err := node.NewMeridianNode(
+					v1.ActionInit, v1.NodeRoleMaster, "", "", nil, []string{})
+				if err != nil {
+					return errors.Wrapf(err, "meridian update")
+				}
+				return md.UpdateRegistries(context.Background(), regs)
+			default:
+				return fmt.Errorf("unknown resource: %s", args[0])
+			}
+		},
+	}
 	return cmd
 }
 
+func loadRegistries(file string) (*v1.Registries, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	regs := &v1.Registries{}
+	err = yaml.Unmarshal(data, regs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unmarshal registries %s", file)
+	}
+	return regs, regs.Validate()
+}
+
 // NewCommandJoin create resource
 func NewCommandJoin() *cobra.Command {
 	var (
@@ -174,18 +281,34 @@ func NewCommandJoin() *cobra.Command {
 	)
 	cmd := &cobra.Command{
 		Use:   "join",
-		Short: "meridian join",
-		Long:  "",
+		Short: "meridian join [config.yml]",
+		Long:  "join with a config joins the node with the token and access point in it,\nwithout it the config is fetched from the apiserver with --token.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			fmt.Printf(meridian.Logo)
-			if role == "" || endpoint == "" || token == "" {
-				return fmt.Errorf("role and endpoint and token required")
-			}
 			switch role {
 			case string(v1.NodeRoleMaster), string(v1.NodeRoleWorker):
 			default:
 				return fmt.Errorf("invalid role: %s", role)
 			}
+			if len(args) > 0 {
+				data, err := os.ReadFile(args[0])
+				if err != nil {
+					return err
+				}
+				req := &v1.Request{}
+				err = yaml.Unmarshal(data, req)
+				if err != nil {
+					return err
+				}
+				md, err := node.NewMeridianNode(v1.ActionJoin, v1.NodeRole(role), nodeGroup, cloud, req, labels)
+				if err != nil {
+					return errors.Wrapf(err, "meridian join")
+				}
+				return md.EnsureNode()
+			}
+			if endpoint == "" || token == "" {
+				return fmt.Errorf("config or endpoint and token required")
+			}
 			md, err := node.InitNode(v1.ActionJoin, v1.NodeRole(role), endpoint, token, nodeGroup, cloud, labels)
 			if err != nil {
 				return errors.Wrapf(err, "init meridian node")
@@ -193,7 +316,7 @@ func NewCommandJoin() *cobra.Command {
 			return md.EnsureNode()
 		},
 	}
-	cmd.PersistentFlags().StringVarP(&role, "role", "r", string(v1.NodeRoleWorker), "node role, one of Master|Worker")
+	cmd.PersistentFlags().StringVarP(&role, "role", "r", string(v1.NodeRoleWorker), "node role, one of master|worker")
 	cmd.PersistentFlags().StringVarP(&endpoint, "api-server", "s", "", "meridian apiserver endpoint. eg. 192.168.1.1:6443")
 	cmd.PersistentFlags().StringVarP(&token, "token", "t", "", "meridian kubeadm join token")
 	cmd.PersistentFlags().StringVarP(&nodeGroup, "group", "g", "", "meridian node group")
@@ -0,0 +1,100 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"github.com/aoxn/meridian"
+	v1 "github.com/aoxn/meridian/api/v1"
+	user "github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/tool"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func readStack(file string) (*v1.Stack, error) {
+	if file == "" {
+		return nil, fmt.Errorf("stack file must be specified: eg. [m apply -f stack.yml]")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read stack file %s", file)
+	}
+	var stack v1.Stack
+	err = yaml.Unmarshal(data, &stack)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unmarshal stack file %s", file)
+	}
+	return &stack, stack.Validate()
+}
+
+func postStack(action, file string) error {
+	stack, err := readStack(file)
+	if err != nil {
+		return err
+	}
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return err
+	}
+	var plan v1.StackPlan
+	err = client.Raw().
+		Post(context.TODO()).
+		PathPrefix("/api/v1/").
+		Resource(action).
+		ResourceName(stack.Name).
+		Body(stack).
+		Do(&plan)
+	if err != nil {
+		return errors.Wrapf(err, "%s stack %s", action, stack.Name)
+	}
+	showPlan(&plan)
+	return nil
+}
+
+func showPlan(plan *v1.StackPlan) {
+	switch v1.G.OutPut {
+	case "json":
+		fmt.Println(tool.PrettyJson(plan))
+	case "yaml", "yml":
+		fmt.Println(tool.PrettyYaml(plan))
+	default:
+		fmt.Printf("%-10s%-20s%-12s%-40s\n", "RESOURCE", "NAME", "ACTION", "REASON")
+		for _, c := range plan.Changes {
+			fmt.Printf("%-10s%-20s%-12s%-40s\n", c.Resource, c.Name, c.Action, c.Reason)
+		}
+	}
+}
+
+// NewCommandApply apply a stack of resources
+func NewCommandApply() *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "meridian apply -f stack.yml",
+		Long:  "create, update or delete the vms, docker and kubernetes described by a stack file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf(meridian.Logo)
+			return postStack("apply", file)
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "stack file")
+	return cmd
+}
+
+// NewCommandDiff show the changes to be made by apply
+func NewCommandDiff() *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "meridian diff -f stack.yml",
+		Long:  "show the changes [meridian apply] would make without applying them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf(meridian.Logo)
+			return postStack("diff", file)
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "stack file")
+	return cmd
+}
@@ -0,0 +1,112 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	user "github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/tool"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func cacheStatus() (*v1.CacheStatus, error) {
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return nil, errors.Wrap(err, "get client failed")
+	}
+	var status v1.CacheStatus
+	err = client.List(context.TODO(), "cache", &status)
+	if err != nil {
+		return nil, errors.Wrap(err, "get image cache failed")
+	}
+	return &status, nil
+}
+
+func listCache(output string) error {
+	status, err := cacheStatus()
+	if err != nil {
+		return err
+	}
+	switch output {
+	case "json":
+		fmt.Println(tool.PrettyJson(status))
+	case "yaml", "yml":
+		fmt.Println(tool.PrettyYaml(status))
+	default:
+		fmt.Printf("%-45s%-25s%-20s%-12s%-12s\n", "REPOSITORY", "REGISTRY", "DIGEST", "SIZE", "LAST ACCESS")
+		for _, e := range status.Entries {
+			fmt.Printf("%-45s%-25s%-20s%-12s%-12s\n", e.Repository, e.Registry, shortDigest(e.Digest),
+				units.BytesSize(float64(e.Size)), time.Since(e.LastAccess).Round(time.Second))
+		}
+		printCacheSize(status)
+	}
+	return nil
+}
+
+func printCacheSize(status *v1.CacheStatus) {
+	fmt.Printf("\n%d entries, %s of %s\n", len(status.Entries),
+		units.BytesSize(float64(status.Size)), units.BytesSize(float64(status.MaxSize)))
+}
+
+func shortDigest(dgst string) string {
+	if len(dgst) > 19 {
+		return dgst[:19]
+	}
+	return dgst
+}
+
+// NewCommandCache inspects and prunes the image cache shared by the vms
+func NewCommandCache() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "meridian cache ls",
+		Long:  "inspect and prune the pull-through image cache shared by the vms.",
+	}
+	var output string
+	ls := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "meridian cache ls",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listCache(output)
+		},
+	}
+	ls.Flags().StringVarP(&output, "output", "o", "", "output format: json,yaml")
+
+	var req v1.CachePrune
+	prune := &cobra.Command{
+		Use:   "prune",
+		Short: "meridian cache prune --max-size 10GiB",
+		Long:  "prune removes the least recently used entries beyond --max-size, default to the configured max size.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if req.MaxSize != "" {
+				if _, err := units.RAMInBytes(req.MaxSize); err != nil {
+					return fmt.Errorf("invalid max size %s: %s", req.MaxSize, err.Error())
+				}
+			}
+			client, err := user.Client(ListenSock)
+			if err != nil {
+				return errors.Wrap(err, "get client failed")
+			}
+			err = client.Update(context.TODO(), "cache", "prune", &req)
+			if err != nil {
+				return errors.Wrap(err, "prune image cache failed")
+			}
+			status, err := cacheStatus()
+			if err != nil {
+				return err
+			}
+			printCacheSize(status)
+			return nil
+		},
+	}
+	prune.Flags().BoolVar(&req.All, "all", false, "remove every cached entry")
+	prune.Flags().StringVar(&req.MaxSize, "max-size", "", "prune the cache to the size, eg. 10GiB")
+
+	cmd.AddCommand(ls, prune)
+	return cmd
+}
@@ -46,6 +46,13 @@ type createflag struct {
 	in      string
 	version string
 
+	profile  string
+	minReady int
+	max      int
+
+	masters int
+	workers int
+
 	withNodeGroups bool
 	withKubernetes bool
 }
@@ -65,6 +72,8 @@ const (
 	DockerResource         = "docker"
 	KubernetesResource     = "kubernetes"
 	KubernetesResourceShot = "k8s"
+	PoolResource           = "pool"
+	ForwardResource        = "forward"
 )
 
 func transformResource(resource string) string {
@@ -11,6 +11,8 @@ import (
 	gerrors "github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"os"
+	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 )
@@ -24,6 +26,8 @@ func createNew(flags *createflag, args []string) error {
 		return createDocker(flags, args)
 	case KubernetesResourceShot, KubernetesResource:
 		return createK8s(flags, args)
+	case PoolResource:
+		return createPool(flags, args)
 	}
 	return fmt.Errorf("unexpected resource: %s", r)
 }
@@ -37,10 +41,34 @@ func createDocker(flags *createflag, args []string) error {
 	if name == "" {
 		return fmt.Errorf("vm name is required by --in=xxx ")
 	}
-	var spec = meta.Docker{Name: name}
+	docker, err := loadDocker(flags.config, flags.version)
+	if err != nil {
+		return err
+	}
+	var spec = meta.Docker{Name: name, Version: docker.Version, Options: docker.Options}
 	return client.Create(ctx, "docker", name, &spec)
 }
 
+// loadDocker reads the docker spec from file, version overrides the one in
+// it. Without both the default of the catalog is installed.
+func loadDocker(file, version string) (*v1.Docker, error) {
+	docker := &v1.Docker{}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.Unmarshal(data, docker)
+		if err != nil {
+			return nil, gerrors.Wrapf(err, "unmarshal docker %s", file)
+		}
+	}
+	if version != "" {
+		docker.Version = version
+	}
+	return docker, docker.Validate()
+}
+
 func createK8s(flags *createflag, args []string) error {
 	client, err := user.Client(ListenSock)
 	if err != nil {
@@ -48,13 +76,81 @@ func createK8s(flags *createflag, args []string) error {
 	}
 	var ctx = context.TODO()
 	var name = flags.in
+	if len(args) > 1 {
+		name = args[1]
+	}
 	if name == "" {
 		return fmt.Errorf("vm name is required by --in=xxx ")
 	}
-	var spec = meta.Kubernetes{Name: name, Version: flags.version, VmName: name}
+	var spec = meta.Kubernetes{
+		Name:    name,
+		Version: flags.version,
+		VmName:  name,
+		Masters: flags.masters,
+		Workers: flags.workers,
+	}
+	err = spec.Validate()
+	if err != nil {
+		return err
+	}
 	return client.Create(ctx, "k8s", name, &spec)
 }
 
+func createPool(flags *createflag, args []string) error {
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("pool name must be specified: eg. [meridian create pool agents --profile agent.yml]")
+	}
+	name := args[1]
+	if flags.profile != "" {
+		flags.config, err = profileFile(flags.profile)
+		if err != nil {
+			return err
+		}
+		// the daemon reads the profile for each new vm
+		flags.config, err = filepath.Abs(flags.config)
+		if err != nil {
+			return err
+		}
+	}
+	spec, err := newMachine(name, flags)
+	if err != nil {
+		return gerrors.Wrapf(err, "create pool")
+	}
+	var pool = meta.Pool{
+		Name:     name,
+		Profile:  flags.config,
+		MinReady: flags.minReady,
+		Max:      flags.max,
+		Spec:     spec,
+	}
+	err = pool.Validate()
+	if err != nil {
+		return err
+	}
+	return client.Create(context.TODO(), "pool", name, &pool)
+}
+
+// profileFile resolves a profile to a vm config file, either a path or the
+// name of a file under ~/.meridian/config/profile
+func profileFile(profile string) (string, error) {
+	_, err := os.Stat(profile)
+	if err == nil {
+		return profile, nil
+	}
+	for _, ext := range []string{".yml", ".yaml"} {
+		f := path.Join(meta.Local.Config().Dir(), "profile", profile+ext)
+		_, err = os.Stat(f)
+		if err == nil {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("profile %s not found", profile)
+}
+
 func createVm(flags *createflag, args []string) error {
 	client, err := user.Client(ListenSock)
 	if err != nil {
@@ -80,7 +176,7 @@ func newMachine(name string, flags *createflag) (*v1.VirtualMachineSpec, error)
 		if err != nil {
 			return nil, err
 		}
-		err = yaml.Unmarshal(data, spec)
+		err = yaml.Unmarshal(data, &spec)
 		return &spec, err
 	}
 
@@ -155,5 +251,10 @@ func NewCommandCreate() *cobra.Command {
 
 	cmd.PersistentFlags().BoolVarP(&cmdline.withNodeGroups, "with-nodegroups", "n", true, "with nodegroups support")
 	cmd.PersistentFlags().StringVar(&cmdline.arch, "arch", "", "with arch")
+	cmd.PersistentFlags().StringVar(&cmdline.profile, "profile", "", "pool vm profile, a vm config file or a name under ~/.meridian/config/profile")
+	cmd.PersistentFlags().IntVar(&cmdline.minReady, "min-ready", 1, "number of pool vms kept ready to claim")
+	cmd.PersistentFlags().IntVar(&cmdline.max, "max", 5, "max number of vms in pool, claimed included")
+	cmd.PersistentFlags().IntVar(&cmdline.masters, "masters", 1, "number of kubernetes masters, 3 or more behind a virtual ip for a highly available control plane")
+	cmd.PersistentFlags().IntVar(&cmdline.workers, "workers", 0, "number of kubernetes workers, each in a vm of its own")
 	return cmd
 }
@@ -34,6 +34,11 @@ func deleter(r string, args []string) error {
 			return fmt.Errorf("id must be provided")
 		}
 		return deleteK8s(args[1])
+	case PoolResource:
+		if len(args) < 2 {
+			return fmt.Errorf("id must be provided")
+		}
+		return deletePool(args[1])
 	default:
 	}
 	return fmt.Errorf("unknown resource %s", r)
@@ -56,6 +61,15 @@ func deleteK8s(name string) error {
 	return resource.Delete(context.TODO(), "k8s", name, &meta.Kubernetes{})
 }
 
+func deletePool(name string) error {
+	resource, err := user.Client(ListenSock)
+	if err != nil {
+		return err
+	}
+
+	return resource.Delete(context.TODO(), "pool", name, &meta.Pool{})
+}
+
 func deleteVm(name string) error {
 	resource, err := user.Client(ListenSock)
 	if err != nil {
@@ -0,0 +1,54 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"github.com/aoxn/meridian"
+	user "github.com/aoxn/meridian/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+func extend(args []string) error {
+	r := args[0]
+	switch r {
+	case VirtualMachine, VirtualMachineShot:
+		return extendVm(args[1:])
+	default:
+	}
+	return fmt.Errorf("unknown resource [%s], available %s", r, expectedResource)
+}
+
+func extendVm(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("vm name and duration are required: eg. [meridian extend vm aoxn 2h]")
+	}
+	d, err := time.ParseDuration(args[1])
+	if err != nil {
+		return errors.Wrapf(err, "parse duration %s", args[1])
+	}
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	return client.Update(context.TODO(), "vm/extend", args[0], &metav1.Duration{Duration: d})
+}
+
+// NewCommandExtend returns a new cobra.Command to extend vm lifetime
+func NewCommandExtend() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extend",
+		Short: "meridian extend vm aoxn 2h",
+		Long:  "extend the expiry of a vm and restart its idle window",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf(meridian.Logo)
+			if len(args) < 1 {
+				return fmt.Errorf("resource is needed for extend")
+			}
+			return extend(args)
+		},
+	}
+	return cmd
+}
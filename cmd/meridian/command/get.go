@@ -7,16 +7,19 @@ import (
 	user "github.com/aoxn/meridian/client"
 	"github.com/aoxn/meridian/client/rest"
 	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/docker/go-units"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
 	v1 "github.com/aoxn/meridian/api/v1"
 	"github.com/aoxn/meridian/internal/tool"
 	"github.com/pkg/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 )
 
 var ListenSock = "/tmp/meridian.sock"
@@ -223,6 +226,8 @@ var (
 		AddonResource,
 		KubeconfigResource,
 		DockerResource,
+		PoolResource,
+		ForwardResource,
 	}
 )
 
@@ -237,6 +242,10 @@ func GetNew(flags *commandFlags, args []string) error {
 		return showDocker(flags)
 	case KubernetesResource, KubernetesResourceShot:
 		return showK8s(flags)
+	case PoolResource:
+		return showPools(flags)
+	case ForwardResource:
+		return showForwards(flags, args[1:])
 	default:
 	}
 	return fmt.Errorf("unknown resource [%s], available %s", r, expectedResource)
@@ -299,20 +308,32 @@ func showVms(flags *commandFlags) error {
 	case "yaml", "yml":
 		fmt.Println(tool.PrettyYaml(mchs))
 	default:
-		fmt.Printf("%-15s%-10s%-8s%-8s%-8s%-10s%-20s\n",
-			"NAME", "OS", "ARCH", "CPUs", "MEMs", "STATE", "ADDRESS")
+		fmt.Printf("%-15s%-10s%-8s%-8s%-8s%-10s%-7s%-10s%-10s%-20s\n",
+			"NAME", "OS", "ARCH", "CPUs", "MEMs", "STATE", "READY", "RESTARTS", "EXPIRES", "ADDRESS")
 		for _, mch := range mchs {
 			addr := lo.Map(mch.Spec.Networks, func(item v1.Network, index int) string {
 				return item.Address
 			})
-			fmt.Printf("%-15s%-10s%-8s%-8d%-8s%-10s%-20s\n",
-				mch.Name, mch.Spec.OS, mch.Spec.Arch, mch.Spec.CPUs, mch.Spec.Memory,
-				mch.State, strings.Join(addr, ","))
+			fmt.Printf("%-15s%-10s%-8s%-8d%-8s%-10s%-7t%-10d%-10s%-20s\n",
+				mch.Name, mch.Spec.OS, mch.Spec.Arch, mch.Spec.CPUs, mch.Spec.Memory, mch.State,
+				apimeta.IsStatusConditionTrue(mch.Status.Conditions, v1.ConditionReady),
+				mch.Restarts, expires(mch.Spec.Lifecycle), strings.Join(addr, ","))
 		}
 	}
 	return nil
 }
 
+func expires(lc *v1.Lifecycle) string {
+	if lc == nil || lc.ExpireAt == nil {
+		return "-"
+	}
+	left := time.Until(lc.ExpireAt.Time)
+	if left <= 0 {
+		return "expired"
+	}
+	return left.Round(time.Minute).String()
+}
+
 func showDocker(flags *commandFlags) error {
 	var mchs []*meta.Docker
 	client, err := user.Client(ListenSock)
@@ -330,11 +351,12 @@ func showDocker(flags *commandFlags) error {
 	case "yaml", "yml":
 		fmt.Println(tool.PrettyYaml(mchs))
 	default:
-		fmt.Printf("%-15s%-15s%-15s%-30s\n",
-			"NAME", "VERSION", "REF_VM", "ENDPOINT")
+		fmt.Printf("%-15s%-15s%-15s%-15s%-30s\n",
+			"NAME", "VERSION", "SERVER", "REF_VM", "ENDPOINT")
 		for _, mch := range mchs {
-			fmt.Printf("%-15s%-15s%-15s%-30s\n",
-				mch.Name, mch.Version, mch.VmName, fmt.Sprintf("[docker context use %s]", mch.Name))
+			fmt.Printf("%-15s%-15s%-15s%-15s%-30s\n",
+				mch.Name, mch.Version, lo.Ternary(mch.ServerVersion == "", "-", mch.ServerVersion),
+				mch.VmName, fmt.Sprintf("[docker context use %s]", mch.Name))
 		}
 	}
 	return nil
@@ -357,11 +379,89 @@ func showK8s(flags *commandFlags) error {
 	case "yaml", "yml":
 		fmt.Println(tool.PrettyYaml(mchs))
 	default:
-		fmt.Printf("%-15s%-20s%-15s%-10s%-30s\n",
-			"NAME", "VERSION", "REF_VM", "STATE", "ENDPOINT")
+		fmt.Printf("%-15s%-20s%-15s%-10s%-8s%-30s\n",
+			"NAME", "VERSION", "REF_VM", "STATE", "NODES", "ENDPOINT")
 		for _, mch := range mchs {
-			fmt.Printf("%-15s%-20s%-15s%-10s%-30s\n",
-				mch.Name, mch.Spec.Config.Kubernetes.Version, mch.VmName, mch.State, fmt.Sprintf("[kubectl context use %s]", mch.Name))
+			ready := lo.CountBy(mch.Nodes, func(n meta.KubernetesNode) bool { return n.State == "Running" })
+			fmt.Printf("%-15s%-20s%-15s%-10s%-8s%-30s\n",
+				mch.Name, mch.Spec.Config.Kubernetes.Version, mch.VmName, mch.State,
+				fmt.Sprintf("%d/%d", ready, max(mch.Masters, 1)+mch.Workers), fmt.Sprintf("[kubectl context use %s]", mch.Name))
+		}
+		if flags.output == "wide" {
+			fmt.Printf("\n%-25s%-15s%-10s%-20s%-40s\n", "NODE", "ROLE", "STATE", "VERSION", "MESSAGE")
+			for _, mch := range mchs {
+				for _, n := range mch.Nodes {
+					fmt.Printf("%-25s%-15s%-10s%-20s%-40s\n", n.VmName, n.Role, n.State, mch.NodeVersion(n), n.Message)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func showPools(flags *commandFlags) error {
+	var pools []*meta.Pool
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	err = client.List(context.TODO(), "pool", &pools)
+	if err != nil {
+		return errors.Wrap(err, "get pool failed")
+	}
+
+	switch flags.output {
+	case "json":
+		fmt.Println(tool.PrettyJson(pools))
+	case "yaml", "yml":
+		fmt.Println(tool.PrettyYaml(pools))
+	default:
+		fmt.Printf("%-15s%-20s%-10s%-8s%-10s%-8s%-8s\n",
+			"NAME", "PROFILE", "MIN_READY", "MAX", "READY", "CLAIMED", "TOTAL")
+		for _, p := range pools {
+			fmt.Printf("%-15s%-20s%-10d%-8d%-10d%-8d%-8d\n",
+				p.Name, lo.Ternary(p.Profile == "", "", filepath.Base(p.Profile)), p.MinReady, p.Max, p.Ready, p.Claimed, p.Total)
+		}
+	}
+	return nil
+}
+
+func showForwards(flags *commandFlags, args []string) error {
+	var fwds []v1.ForwardStatus
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	if len(args) > 0 {
+		err = client.Get(context.TODO(), "forward", args[0], &fwds)
+	} else {
+		err = client.List(context.TODO(), "forward", &fwds)
+	}
+	if err != nil {
+		return errors.Wrap(err, "get forward failed")
+	}
+
+	switch flags.output {
+	case "json":
+		fmt.Println(tool.PrettyJson(fwds))
+	case "yaml", "yml":
+		fmt.Println(tool.PrettyYaml(fwds))
+	case "wide":
+		fmt.Printf("%-15s%-45s%-12s%-8s%-12s%-12s%-12s%-30s\n",
+			"VM", "RULE", "STATE", "CONNS", "BYTES IN", "BYTES OUT", "LAST ACTIVE", "LAST ERROR")
+		for _, f := range fwds {
+			active := "-"
+			if f.LastActive != nil {
+				active = time.Since(f.LastActive.Time).Round(time.Second).String()
+			}
+			fmt.Printf("%-15s%-45s%-12s%-8d%-12s%-12s%-12s%-30s\n",
+				f.VM, f.Rule, f.State, f.Connections, units.BytesSize(float64(f.BytesIn)),
+				units.BytesSize(float64(f.BytesOut)), active, f.LastError)
+		}
+	default:
+		fmt.Printf("%-15s%-45s%-12s%-8s\n", "VM", "RULE", "STATE", "CONNS")
+		for _, f := range fwds {
+			fmt.Printf("%-15s%-45s%-12s%-8d\n", f.VM, f.Rule, f.State, f.Connections)
 		}
 	}
 	return nil
@@ -389,7 +489,7 @@ func NewCommandGet() *cobra.Command {
 		},
 		PreRunE: checkServerHeartbeat,
 	}
-	cmd.Flags().StringVarP(&flags.output, "output", "o", "", "output format: json,yaml")
+	cmd.Flags().StringVarP(&flags.output, "output", "o", "", "output format: json,yaml,wide")
 	cmd.Flags().BoolVarP(&flags.discover, "discover", "d", false, "discover available addons from server")
 	return cmd
 }
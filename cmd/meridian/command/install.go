@@ -5,12 +5,14 @@ import (
 	"fmt"
 	user "github.com/aoxn/meridian/client"
 	"os"
+	"runtime"
 	"strings"
 
 	"github.com/aoxn/meridian"
 	api "github.com/aoxn/meridian/api/v1"
 	"github.com/aoxn/meridian/internal/node/block/post/addons"
 	"github.com/aoxn/meridian/internal/tool/kubeclient"
+	"github.com/aoxn/meridian/internal/vmm/dns"
 	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -29,7 +31,7 @@ var (
 func NewCommandInstall() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "install",
-		Short: "meridian install [addon]",
+		Short: "meridian install [addon|dns]",
 		Long:  HelpLong,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			fmt.Printf(meridian.Logo)
@@ -39,6 +41,8 @@ func NewCommandInstall() *cobra.Command {
 			switch args[0] {
 			case "addon":
 				return installAddon("addon", args[1:])
+			case "dns":
+				return installDNS()
 			}
 			return fmt.Errorf("unknown install command for resource: %s", args[0])
 		},
@@ -51,6 +55,21 @@ func NewCommandInstall() *cobra.Command {
 	return cmd
 }
 
+// installDNS points the host resolver at meridiand for meridian.internal,
+// which needs root.
+func installDNS() error {
+	path, err := dns.InstallResolver(dns.ZoneDomain, dns.ZoneAddress)
+	if err != nil {
+		return errors.Wrapf(err, "install resolver for %s, try with sudo", dns.ZoneDomain)
+	}
+	fmt.Printf("resolver installed: %s\n", path)
+	if runtime.GOOS == "linux" {
+		fmt.Printf("run [systemctl restart systemd-resolved] to apply\n")
+	}
+	fmt.Printf("vms are now resolvable as <vm>.%s\n", dns.ZoneDomain)
+	return nil
+}
+
 func installAddon(r string, args []string) error {
 	if discover {
 		klog.V(5).Infof("list available addons")
@@ -0,0 +1,172 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	user "github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/aoxn/meridian/internal/vmm/sshutil"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+type sshflag struct {
+	forwardAgent bool
+	local        []string
+	remote       []string
+}
+
+func sshVm(flags *sshflag, args []string) error {
+	switch args[0] {
+	case VirtualMachine, VirtualMachineShot:
+	case "rotate-keys":
+		return rotateKeys(args[1:])
+	default:
+		return fmt.Errorf("unknown resource [%s], available [vm, rotate-keys]", args[0])
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("vm name is needed for ssh")
+	}
+	name := args[1]
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	var mch meta.Machine
+	err = client.Get(context.TODO(), "vm", name, &mch)
+	if err != nil {
+		return errors.Wrapf(err, "get vm %s failed", name)
+	}
+	n := lo.FirstOr(mch.Spec.Networks, v1.Network{})
+	addr := strings.Split(n.Address, "/")[0]
+	if addr == "" {
+		return fmt.Errorf("vm %s has no address yet, state: %s", name, mch.State)
+	}
+	opts, err := sshutil.NewSSHMgr(addr, sshutil.KeyDir(meta.Local.Config().Dir(), name)).VMOpts(name)
+	if err != nil {
+		return errors.Wrap(err, "ssh options")
+	}
+	// BatchMode fails instead of prompting, drop it for an interactive session
+	opts = lo.Reject(opts, func(o string, _ int) bool { return strings.HasPrefix(o, "BatchMode=") })
+
+	sshArgs := []string{"-F", "/dev/null"}
+	for _, o := range opts {
+		sshArgs = append(sshArgs, "-o", o)
+	}
+	if flags.forwardAgent {
+		sshArgs = append(sshArgs, "-A")
+	}
+	for _, l := range flags.local {
+		sshArgs = append(sshArgs, "-L", l)
+	}
+	for _, r := range flags.remote {
+		sshArgs = append(sshArgs, "-R", r)
+	}
+	cmd := args[2:]
+	if len(cmd) == 0 {
+		sshArgs = append(sshArgs, "-t")
+	}
+	sshArgs = append(sshArgs, "md-"+name)
+	sshArgs = append(sshArgs, cmd...)
+
+	klog.V(5).Infof("run ssh %s", strings.Join(sshArgs, " "))
+	c := exec.Command("ssh", sshArgs...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = c.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		os.Exit(exit.ExitCode())
+	}
+	return err
+}
+
+// rotateKeys replaces the ssh key of the vm in args, or of all vms.
+func rotateKeys(args []string) error {
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	var (
+		name   string
+		result []v1.KeyRotation
+	)
+	if len(args) > 0 {
+		name = args[0]
+	}
+	err = client.Update(context.TODO(), "ssh/rotate-keys", name, &result)
+	if err != nil {
+		return errors.Wrap(err, "rotate ssh keys")
+	}
+	fmt.Printf("%-15s%-8s%s\n", "VM", "PUSHED", "ERROR")
+	for _, r := range result {
+		msg := r.Error
+		switch {
+		case msg != "":
+			msg += ", the vm keeps its old key"
+		case !r.Pushed:
+			msg = "-, applied on next boot"
+		default:
+			msg = "-"
+		}
+		fmt.Printf("%-15s%-8t%s\n", r.VM, r.Pushed, msg)
+	}
+	return nil
+}
+
+// NewCommandSSH returns a new cobra.Command to log in a vm or run a command in it
+func NewCommandSSH() *cobra.Command {
+	flags := &sshflag{}
+	cmd := &cobra.Command{
+		Use:   "ssh",
+		Short: "meridian ssh vm aoxn [-- command] | meridian ssh rotate-keys [aoxn]",
+		Long: "open a shell in the vm, or run the command after -- in it.\n" +
+			"rotate-keys replaces the ssh key of the vm, or the key shared by all vms,\n" +
+			"pushes the new key into running vms and retires the old one.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sshVm(flags, args)
+		},
+	}
+	cmd.Flags().BoolVarP(&flags.forwardAgent, "forward-agent", "A", false, "forward the ssh agent into the vm")
+	cmd.Flags().StringSliceVarP(&flags.local, "local", "L", nil, "forward a local port to the vm, [bind_address:]port:host:hostport")
+	cmd.Flags().StringSliceVarP(&flags.remote, "remote", "R", nil, "forward a vm port to the local host, [bind_address:]port:host:hostport")
+	return cmd
+}
+
+// NewCommandSSHConfig returns a new cobra.Command to print the ssh config of
+// all vms which meridiand keeps up to date.
+func NewCommandSSHConfig() *cobra.Command {
+	var path bool
+	cmd := &cobra.Command{
+		Use:   "ssh-config",
+		Short: "meridian ssh-config [--path]",
+		Long: "print the ssh config of all vms, a 'Host md-<vm>' entry for each.\n" +
+			"Add 'Include <path>' to ~/.ssh/config to use it with ssh, scp, rsync and editors,\n" +
+			"meridiand keeps the file up to date as vms are created and deleted.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := filepath.Join(meta.Local.Config().Dir(), v1.SSHConfigAll)
+			if path {
+				fmt.Println(file)
+				return nil
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return fmt.Errorf("no ssh config yet at %s, it is written once a vm has an address", file)
+				}
+				return errors.Wrap(err, "read ssh config")
+			}
+			fmt.Print(string(data))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&path, "path", false, "print the path of the config for 'Include'")
+	return cmd
+}
@@ -0,0 +1,111 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"github.com/aoxn/meridian"
+	v1 "github.com/aoxn/meridian/api/v1"
+	user "github.com/aoxn/meridian/client"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"sort"
+	"time"
+)
+
+type topflag struct {
+	interval time.Duration
+	noStream bool
+}
+
+func top(flags *topflag, args []string) error {
+	r := args[0]
+	switch r {
+	case VirtualMachine, VirtualMachineShot:
+	default:
+		return fmt.Errorf("unknown resource [%s], available [vm]", r)
+	}
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	for {
+		var mts []*v1.VirtualMachineMetrics
+		if len(args) > 1 {
+			var m v1.VirtualMachineMetrics
+			err = client.Get(context.TODO(), "metrics", args[1], &m)
+			mts = append(mts, &m)
+		} else {
+			err = client.List(context.TODO(), "metrics", &mts)
+		}
+		if err != nil {
+			return errors.Wrap(err, "get metrics failed")
+		}
+		if !flags.noStream {
+			// clear screen and move cursor home
+			fmt.Print("\033[H\033[2J")
+		}
+		showMetrics(mts)
+		if flags.noStream {
+			return nil
+		}
+		time.Sleep(flags.interval)
+	}
+}
+
+func showMetrics(mts []*v1.VirtualMachineMetrics) {
+	sort.Slice(mts, func(i, j int) bool { return mts[i].Name < mts[j].Name })
+	fmt.Printf("%-15s%-8s%-20s%-16s%-10s%-22s%-22s%-10s%-10s\n",
+		"NAME", "CPU%", "MEM", "LOAD", "DISK%", "DISK R/W", "NET RX/TX", "VMM CPU%", "VMM RSS")
+	for _, m := range mts {
+		var (
+			mem, load, disk = "-", "-", "-"
+			dio, nio        = "-", "-"
+			vcpu, rss       = "-", "-"
+		)
+		if g := m.Guest; g != nil {
+			mem = fmt.Sprintf("%s/%s", units.BytesSize(float64(g.MemTotal-g.MemAvailable)), units.BytesSize(float64(g.MemTotal)))
+			load = fmt.Sprintf("%.2f %.2f %.2f", g.Load1, g.Load5, g.Load15)
+			for _, fs := range g.Filesystems {
+				if fs.Mount == "/" && fs.Total > 0 {
+					disk = fmt.Sprintf("%.1f", 100*float64(fs.Used)/float64(fs.Total))
+				}
+			}
+			dio = fmt.Sprintf("%s/%s", rate(m.DiskRead), rate(m.DiskWrite))
+			nio = fmt.Sprintf("%s/%s", rate(m.NetRx), rate(m.NetTx))
+		}
+		if h := m.Host; h != nil {
+			vcpu = fmt.Sprintf("%.1f", h.CPUPercent)
+			rss = units.BytesSize(float64(h.RSS))
+		}
+		fmt.Printf("%-15s%-8.1f%-20s%-16s%-10s%-22s%-22s%-10s%-10s\n",
+			m.Name, m.CPUPercent, mem, load, disk, dio, nio, vcpu, rss)
+		if m.Message != "" {
+			fmt.Printf("  %s\n", m.Message)
+		}
+	}
+}
+
+func rate(bytes float64) string {
+	return units.BytesSize(bytes) + "/s"
+}
+
+// NewCommandTop returns a new cobra.Command to show vm resource usage
+func NewCommandTop() *cobra.Command {
+	flags := &topflag{}
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "meridian top vm [aoxn]",
+		Long:  "show live cpu, memory, disk and network usage of running vms and their sandbox process",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf(meridian.Logo)
+			if len(args) < 1 {
+				return fmt.Errorf("resource is needed for top")
+			}
+			return top(flags, args)
+		},
+	}
+	cmd.Flags().DurationVarP(&flags.interval, "interval", "i", 2*time.Second, "refresh interval")
+	cmd.Flags().BoolVar(&flags.noStream, "no-stream", false, "print the usage once and exit")
+	return cmd
+}
@@ -6,9 +6,13 @@ import (
 	"github.com/aoxn/meridian"
 	v1 "github.com/aoxn/meridian/api/v1"
 	user "github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	u "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"os"
 )
 
 var scheme = runtime.NewScheme()
@@ -20,10 +24,18 @@ func init() {
 	}
 }
 
-func updater(r string, args []string) error {
+func updater(file, version string, workers int, r string, args []string) error {
 	if len(args) <= 0 {
 		return fmt.Errorf("id must be provided")
 	}
+	switch r {
+	case "registries":
+		return updateRegistries(file, args[0])
+	case DockerResource:
+		return updateDocker(file, version, args[0])
+	case KubernetesResource, KubernetesResourceShot:
+		return scaleK8s(workers, args[0])
+	}
 	r = transformResource(r)
 	resource, err := user.Client(ListenSock)
 	if err != nil {
@@ -43,20 +55,95 @@ func updater(r string, args []string) error {
 	return fmt.Errorf("unimplemented resource: %s", r)
 }
 
+// updateRegistries replaces the registries of the vm with the file, no file
+// restores the builtin mirrors.
+func updateRegistries(file, name string) error {
+	var registries *v1.Registries
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		registries = &v1.Registries{}
+		err = yaml.Unmarshal(data, registries)
+		if err != nil {
+			return errors.Wrapf(err, "unmarshal registries %s", file)
+		}
+		err = registries.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	return client.Update(context.TODO(), "registries", name, &registries)
+}
+
+// updateDocker upgrades docker in the vm to version in place, options in the
+// file replace the current ones.
+func updateDocker(file, version, name string) error {
+	docker, err := loadDocker(file, version)
+	if err != nil {
+		return err
+	}
+	if docker.Version == "" && file == "" {
+		return fmt.Errorf("--version or -f is required, available versions %v", v1.DockerVersions())
+	}
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	spec := meta.Docker{Name: name, Version: docker.Version, Options: docker.Options}
+	err = client.Update(context.TODO(), "docker", name, &spec)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("docker %s upgraded to %s, server version %s\n", name, spec.Version, spec.ServerVersion)
+	return nil
+}
+
+// scaleK8s joins or removes workers of the cluster in background.
+func scaleK8s(workers int, name string) error {
+	if workers < 0 {
+		return fmt.Errorf("--workers is required")
+	}
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	spec := meta.Kubernetes{Name: name, Workers: workers}
+	err = client.Update(context.TODO(), "k8s", name, &spec)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("k8s %s is scaling to %d workers, see [meridian get k8s]\n", name, workers)
+	return nil
+}
+
 // NewCommandUpdate update resource
 func NewCommandUpdate() *cobra.Command {
-
+	var file, version string
+	var workers int
 	cmd := &cobra.Command{
 		Use:   "update",
-		Short: "meridian update resource",
-		Long:  "",
+		Short: "meridian update resource | meridian update registries aoxn -f registries.yml | meridian update docker aoxn --version 1.7.22 | meridian update k8s aoxn --workers 3",
+		Long: "update registries replaces the mirrors, insecure registries and credentials\n" +
+			"of docker and containerd in the vm, without -f the builtin mirrors are restored.\n" +
+			"update docker upgrades docker of a running vm in place, images, volumes and\n" +
+			"running containers are kept, -f replaces the daemon options.\n" +
+			"update k8s scales the workers, removed ones are drained, reset and destroyed.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			fmt.Printf(meridian.Logo)
 			if len(args) < 1 {
 				return fmt.Errorf("resource is needed for delete")
 			}
-			return updater(args[0], args[1:])
+			return updater(file, version, workers, args[0], args[1:])
 		},
 	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "registries or docker config file")
+	cmd.Flags().StringVar(&version, "version", "", "docker version")
+	cmd.Flags().IntVar(&workers, "workers", -1, "number of kubernetes workers")
 	return cmd
 }
@@ -0,0 +1,62 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"github.com/aoxn/meridian"
+	v1 "github.com/aoxn/meridian/api/v1"
+	user "github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func upgrade(version, r string, args []string) error {
+	if len(args) <= 0 {
+		return fmt.Errorf("id must be provided")
+	}
+	switch r {
+	case KubernetesResource, KubernetesResourceShot:
+		return upgradeK8s(version, args[0])
+	}
+	return fmt.Errorf("unknown resource %s", r)
+}
+
+// upgradeK8s upgrades the cluster to version in background.
+func upgradeK8s(version, name string) error {
+	if version == "" {
+		return fmt.Errorf("--version is required, available versions %v", v1.KubernetesVersions())
+	}
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	spec := meta.Kubernetes{Name: name, UpgradeTo: version}
+	err = client.Update(context.TODO(), "k8s", name, &spec)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("k8s %s is upgrading to %s, see [meridian get k8s -o wide]\n", name, spec.UpgradeTo)
+	return nil
+}
+
+// NewCommandUpgrade upgrade resource
+func NewCommandUpgrade() *cobra.Command {
+	var version string
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "meridian upgrade k8s aoxn --version v1.32.1-aliyun.1",
+		Long: "upgrade k8s upgrades kubernetes of the cluster in place one minor version at a time,\n" +
+			"the masters with kubeadm first, then each worker is drained, upgraded and uncordoned.\n" +
+			"a failed upgrade is resumed by running it again with the same version.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf(meridian.Logo)
+			if len(args) < 1 {
+				return fmt.Errorf("resource is needed for upgrade")
+			}
+			return upgrade(version, args[0], args[1:])
+		},
+	}
+	cmd.Flags().StringVar(&version, "version", "", "kubernetes version")
+	return cmd
+}
@@ -0,0 +1,62 @@
+package command
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	user "github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/tool"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+)
+
+// useDocker points the docker cli of the current user at the docker.sock
+// forwarded from vm name, the context is named after the vm.
+func useDocker(name string) error {
+	client, err := user.Client(ListenSock)
+	if err != nil {
+		return errors.Wrap(err, "get client failed")
+	}
+	var d meta.Docker
+	err = client.Get(context.TODO(), "docker", name, &d)
+	if err != nil {
+		return errors.Wrapf(err, "get docker %s failed", name)
+	}
+	var mch meta.Machine
+	err = client.Get(context.TODO(), "vm", d.VmName, &mch)
+	if err != nil {
+		return errors.Wrapf(err, "get vm %s failed", d.VmName)
+	}
+	err = tool.DockerContext(name, mch.DockerSock(), true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("current docker context is now %s\n", name)
+	if !apimeta.IsStatusConditionTrue(mch.Status.Conditions, v1.ConditionDockerReady) {
+		fmt.Printf("warning: docker of %s is not ready yet, state: %s\n", name, mch.State)
+	}
+	return nil
+}
+
+// NewCommandUse switch the current context to resource
+func NewCommandUse() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "use",
+		Short: "meridian use docker aoxn",
+		Long:  "use docker creates or updates the docker cli context of the vm and makes it current.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return fmt.Errorf("resource and name are needed, eg. meridian use docker aoxn")
+			}
+			switch args[0] {
+			case DockerResource:
+				return useDocker(args[1])
+			}
+			return fmt.Errorf("unknown resource [%s], available [docker]", args[0])
+		},
+	}
+	return cmd
+}
@@ -34,6 +34,7 @@ func NewCommand() *cobra.Command {
 	cmd.AddCommand(command.NewCommandVersion())
 	cmd.AddCommand(command.NewCommandCreate())
 	cmd.AddCommand(command.NewCommandUpdate())
+	cmd.AddCommand(command.NewCommandUpgrade())
 	cmd.AddCommand(command.NewCommandDelete())
 	cmd.AddCommand(command.NewCommandPull())
 	cmd.AddCommand(command.NewCommandInstall())
@@ -42,6 +43,14 @@ func NewCommand() *cobra.Command {
 	cmd.AddCommand(command.NewCommandStop())
 	cmd.AddCommand(command.NewCommandSet())
 	cmd.AddCommand(command.NewCommandRedeploy())
+	cmd.AddCommand(command.NewCommandApply())
+	cmd.AddCommand(command.NewCommandDiff())
+	cmd.AddCommand(command.NewCommandExtend())
+	cmd.AddCommand(command.NewCommandTop())
+	cmd.AddCommand(command.NewCommandSSH())
+	cmd.AddCommand(command.NewCommandSSHConfig())
+	cmd.AddCommand(command.NewCommandUse())
+	cmd.AddCommand(command.NewCommandCache())
 	return cmd
 }
 
@@ -0,0 +1,58 @@
+package apis
+
+import (
+	"fmt"
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/daemon/core"
+	"github.com/aoxn/meridian/internal/tool/server"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func newApplyHandler(ctx *core.Context) *applyHandler {
+	return &applyHandler{ctx: ctx}
+}
+
+type applyHandler struct {
+	ctx *core.Context
+}
+
+func (h *applyHandler) apply(r *http.Request, w http.ResponseWriter) int {
+	stack, err := h.decode(r)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	plan, err := h.ctx.ApplyMgr().Apply(r.Context(), stack)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJsonCode(w, plan, http.StatusAccepted)
+}
+
+func (h *applyHandler) diff(r *http.Request, w http.ResponseWriter) int {
+	stack, err := h.decode(r)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	plan, err := h.ctx.ApplyMgr().Diff(stack)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, plan)
+}
+
+func (h *applyHandler) decode(r *http.Request) (*v1.Stack, error) {
+	name := mux.Vars(r)["name"]
+	switch name {
+	case "":
+		return nil, fmt.Errorf("unexpected empty name")
+	default:
+	}
+	var stack v1.Stack
+	err := server.DecodeBody(r.Body, &stack)
+	if err != nil {
+		return nil, err
+	}
+	stack.Name = name
+	return &stack, nil
+}
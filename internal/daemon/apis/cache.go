@@ -0,0 +1,43 @@
+package apis
+
+import (
+	"fmt"
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/daemon/core"
+	"github.com/aoxn/meridian/internal/tool/server"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func newCacheHandler(ctx *core.Context) *cacheHandler {
+	return &cacheHandler{ctx: ctx}
+}
+
+type cacheHandler struct {
+	ctx *core.Context
+}
+
+func (h *cacheHandler) get(r *http.Request, w http.ResponseWriter) int {
+	status, err := h.ctx.CacheMgr().Status()
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, status)
+}
+
+func (h *cacheHandler) prune(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	if name != "prune" {
+		return httpJson(w, fmt.Errorf("unknown cache action %s", name))
+	}
+	var p v1.CachePrune
+	err := server.DecodeBody(r.Body, &p)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	status, err := h.ctx.CacheMgr().Prune(&p)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, status)
+}
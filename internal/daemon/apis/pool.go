@@ -0,0 +1,101 @@
+package apis
+
+import (
+	"fmt"
+	"github.com/aoxn/meridian/internal/daemon/core"
+	"github.com/aoxn/meridian/internal/tool/server"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/gorilla/mux"
+	"k8s.io/klog/v2"
+	"net/http"
+)
+
+func newPoolHandler(ctx *core.Context) *poolHandler {
+	return &poolHandler{ctx: ctx}
+}
+
+type poolHandler struct {
+	ctx *core.Context
+}
+
+func (h *poolHandler) create(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	switch name {
+	case "":
+		return httpJson(w, fmt.Errorf("unexpected empty name"))
+	default:
+	}
+	var spec meta.Pool
+	err := server.DecodeBody(r.Body, &spec)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	spec.Name = name
+	err = h.ctx.PoolMgr().Create(r.Context(), &spec)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJsonCode(w, spec, http.StatusAccepted)
+}
+
+func (h *poolHandler) destroy(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	switch name {
+	case "":
+		return httpJson(w, fmt.Errorf("unexpected empty name"))
+	default:
+	}
+	err := h.ctx.PoolMgr().Destroy(r.Context(), name)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJsonCode(w, meta.Pool{Name: name}, http.StatusAccepted)
+}
+
+func (h *poolHandler) get(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	switch name {
+	case "":
+		pools, err := h.ctx.PoolMgr().List()
+		if err != nil {
+			return httpJson(w, err)
+		}
+		klog.Infof("handler: list pool, return count [%d]", len(pools))
+		return httpJson(w, pools)
+	default:
+	}
+	p, err := h.ctx.PoolMgr().Get(name)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, p)
+}
+
+func (h *poolHandler) claim(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	switch name {
+	case "":
+		return httpJson(w, fmt.Errorf("unexpected empty name"))
+	default:
+	}
+	vm, err := h.ctx.PoolMgr().Claim(r.Context(), name)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, vm)
+}
+
+func (h *poolHandler) release(r *http.Request, w http.ResponseWriter) int {
+	var (
+		name = mux.Vars(r)["name"]
+		vm   = mux.Vars(r)["vm"]
+	)
+	if name == "" || vm == "" {
+		return httpJson(w, fmt.Errorf("unexpected empty name"))
+	}
+	err := h.ctx.PoolMgr().Release(r.Context(), name, vm)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJsonCode(w, meta.Machine{Name: vm, Pool: name}, http.StatusAccepted)
+}
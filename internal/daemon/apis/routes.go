@@ -15,24 +15,40 @@ func CoreRoute(ctx *core.Context) map[string]map[string]server.HandlerFunc {
 	d := newDockerHandler(ctx)
 	k := newK8sHandler(ctx)
 	i := newImageHandler(ctx)
+	a := newApplyHandler(ctx)
+	p := newPoolHandler(ctx)
+	c := newCacheHandler(ctx)
 	var r = map[string]map[string]server.HandlerFunc{
 		"PUT": {
 			"/api/v1/vm/start/{name}":        v.startVm,
 			"/api/v1/vm/stop/{name}":         v.stopVm,
+			"/api/v1/vm/extend/{name}":       v.extendVm,
 			"/api/v1/k8s/redeploy/{name}":    k.redeploy,
 			"/api/v1/docker/redeploy/{name}": v.debug,
+			"/api/v1/docker/{name}":          d.update,
+			"/api/v1/k8s/{name}":             k.update,
+			"/api/v1/ssh/rotate-keys/{name}": v.rotateKeys,
+			"/api/v1/ssh/rotate-keys":        v.rotateKeys,
+			"/api/v1/registries/{name}":      d.updateRegistries,
+			"/api/v1/cache/{name}":           c.prune,
 		},
 		"POST": {
-			"/api/v1/docker/{name}": d.create,
-			"/api/v1/k8s/{name}":    k.create,
-			"/api/v1/vm/run/{name}": v.runVm,
-			"/api/v1/vm/{name}":     v.createVm,
+			"/api/v1/docker/{name}":            d.create,
+			"/api/v1/k8s/{name}":               k.create,
+			"/api/v1/vm/run/{name}":            v.runVm,
+			"/api/v1/vm/{name}":                v.createVm,
+			"/api/v1/apply/{name}":             a.apply,
+			"/api/v1/diff/{name}":              a.diff,
+			"/api/v1/pool/{name}":              p.create,
+			"/api/v1/pool/{name}/claim":        p.claim,
+			"/api/v1/pool/{name}/release/{vm}": p.release,
 		},
 		"DELETE": {
 			"/api/v1/docker/{name}": d.destroy,
 			"/api/v1/k8s/{name}":    k.destroy,
 			"/api/v1/vm/{name}":     v.deleteVm,
 			"/api/v1/image/{name}":  i.delete,
+			"/api/v1/pool/{name}":   p.destroy,
 		},
 		"GET": {
 			"/api/v1/docker/{name}":     d.get,
@@ -43,6 +59,13 @@ func CoreRoute(ctx *core.Context) map[string]map[string]server.HandlerFunc {
 			"/api/v1/vm/{name}":         v.getVm,
 			"/api/v1/vm":                v.getVm,
 			"/api/v1/image/pull/{name}": i.pull,
+			"/api/v1/pool/{name}":       p.get,
+			"/api/v1/pool":              p.get,
+			"/api/v1/metrics/{name}":    v.metrics,
+			"/api/v1/metrics":           v.metrics,
+			"/api/v1/forward/{name}":    v.forwards,
+			"/api/v1/forward":           v.forwards,
+			"/api/v1/cache":             c.get,
 		},
 	}
 	return r
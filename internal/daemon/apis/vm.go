@@ -8,6 +8,8 @@ import (
 	"github.com/aoxn/meridian/internal/tool/server"
 	"github.com/aoxn/meridian/internal/vmm/meta"
 	"github.com/gorilla/mux"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 	"net/http"
 	"path"
@@ -99,6 +101,25 @@ func (h *vmhandler) startVm(r *http.Request, w http.ResponseWriter) int {
 	return httpJsonCode(w, vm, http.StatusAccepted)
 }
 
+func (h *vmhandler) extendVm(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	switch name {
+	case "":
+		return httpJson(w, fmt.Errorf("unexpected empty name"))
+	default:
+	}
+	var d metav1.Duration
+	err := server.DecodeBody(r.Body, &d)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	err = h.ctx.VMMgr().Extend(r.Context(), name, d.Duration)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, d)
+}
+
 func (h *vmhandler) stopVm(r *http.Request, w http.ResponseWriter) int {
 	name := mux.Vars(r)["name"]
 	backend := h.ctx.Backend().Machine()
@@ -138,6 +159,41 @@ func (h *vmhandler) getVm(r *http.Request, w http.ResponseWriter) int {
 	return httpJson(w, vm)
 }
 
+func (h *vmhandler) metrics(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	switch name {
+	case "":
+		return httpJson(w, h.ctx.VMMgr().ListMetrics(r.Context()))
+	default:
+	}
+	m, err := h.ctx.VMMgr().Metrics(r.Context(), name)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, m)
+}
+
+func (h *vmhandler) forwards(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	fwds, err := h.ctx.VMMgr().Forwards(r.Context(), name)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, fwds)
+}
+
+func (h *vmhandler) rotateKeys(r *http.Request, w http.ResponseWriter) int {
+	var names []string
+	if name := mux.Vars(r)["name"]; name != "" {
+		names = append(names, name)
+	}
+	result, err := h.ctx.VMMgr().RotateKeys(r.Context(), names...)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, result)
+}
+
 func (h *vmhandler) deleteVm(r *http.Request, w http.ResponseWriter) int {
 	name := mux.Vars(r)["name"]
 	machine := h.ctx.Backend().Machine()
@@ -177,7 +233,13 @@ func (h *dockerHandler) create(r *http.Request, w http.ResponseWriter) int {
 		return httpJson(w, fmt.Errorf("unexpected empty name"))
 	default:
 	}
-	err := h.ctx.DockerMgr().Create(r.Context(), name)
+	var spec meta.Docker
+	err := server.DecodeBody(r.Body, &spec)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	spec.Name = name
+	err = h.ctx.DockerMgr().Create(r.Context(), &spec)
 	if err != nil {
 		return httpJson(w, err)
 	}
@@ -188,6 +250,39 @@ func (h *dockerHandler) create(r *http.Request, w http.ResponseWriter) int {
 	return httpJsonCode(w, d, http.StatusAccepted)
 }
 
+func (h *dockerHandler) update(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	var spec meta.Docker
+	err := server.DecodeBody(r.Body, &spec)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	spec.Name = name
+	err = h.ctx.DockerMgr().Update(r.Context(), &spec)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	d, err := h.ctx.Backend().Docker().Get(name)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, d)
+}
+
+func (h *dockerHandler) updateRegistries(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	var registries *v1.Registries
+	err := server.DecodeBody(r.Body, &registries)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	err = h.ctx.DockerMgr().UpdateRegistries(r.Context(), name, registries)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJson(w, registries)
+}
+
 func (h *dockerHandler) destroy(r *http.Request, w http.ResponseWriter) int {
 	name := mux.Vars(r)["name"]
 	switch name {
@@ -251,15 +346,29 @@ func (h *k8sHandler) create(r *http.Request, w http.ResponseWriter) int {
 	if err != nil {
 		return httpJson(w, err)
 	}
-	err = server.DecodeBody(r.Body, &spec)
+	data, err := io.ReadAll(r.Body)
 	if err != nil {
 		return httpJson(w, err)
 	}
+	// the body carries the topology of meta.Kubernetes besides the spec
+	var topology meta.Kubernetes
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &spec)
+		if err != nil {
+			return httpJson(w, err)
+		}
+		err = json.Unmarshal(data, &topology)
+		if err != nil {
+			return httpJson(w, err)
+		}
+	}
 	k := meta.Kubernetes{
 		Name:    name,
 		Spec:    *spec,
 		Version: spec.Config.Kubernetes.Version,
 		VmName:  name,
+		Masters: topology.Masters,
+		Workers: topology.Workers,
 	}
 	err = h.ctx.K8sMgr().Create(r.Context(), &k)
 	if err != nil {
@@ -292,6 +401,30 @@ func (h *k8sHandler) destroy(r *http.Request, w http.ResponseWriter) int {
 	return httpJsonCode(w, d, http.StatusAccepted)
 }
 
+// update upgrades the cluster to the UpgradeTo of the body, or scales the
+// workers of the cluster to the Workers of the body without it.
+func (h *k8sHandler) update(r *http.Request, w http.ResponseWriter) int {
+	name := mux.Vars(r)["name"]
+	var k meta.Kubernetes
+	err := server.DecodeBody(r.Body, &k)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	if k.UpgradeTo != "" {
+		err = h.ctx.K8sMgr().Upgrade(r.Context(), name, k.UpgradeTo)
+	} else {
+		err = h.ctx.K8sMgr().Scale(r.Context(), name, k.Workers)
+	}
+	if err != nil {
+		return httpJson(w, err)
+	}
+	d, err := h.ctx.Backend().K8S().Get(name)
+	if err != nil {
+		return httpJson(w, err)
+	}
+	return httpJsonCode(w, d, http.StatusAccepted)
+}
+
 func (h *k8sHandler) redeploy(r *http.Request, w http.ResponseWriter) int {
 	name := mux.Vars(r)["name"]
 	switch name {
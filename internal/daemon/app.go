@@ -6,6 +6,7 @@ import (
 	"github.com/aoxn/meridian/internal/daemon/apis"
 	"github.com/aoxn/meridian/internal/daemon/core"
 	"github.com/aoxn/meridian/internal/tool/server"
+	"github.com/aoxn/meridian/internal/vmm/dns"
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
 	"os"
@@ -27,9 +28,10 @@ func NewApp(ctx context.Context, cfg *Configuration) App {
 		panic(fmt.Errorf("init core context failed: %v", err))
 	}
 	app := App{
-		cfg: cfg,
-		ctx: ctx,
-		svr: server.NewOrDie(ctx, scfg, apis.CoreRoute(mgr)),
+		cfg:    cfg,
+		ctx:    ctx,
+		svr:    server.NewOrDie(ctx, scfg, apis.CoreRoute(mgr)),
+		appCtx: mgr,
 	}
 	return app
 }
@@ -53,6 +55,20 @@ func (ap *App) Start() error {
 	if err != nil {
 		return errors.Wrapf(err, "start server failed")
 	}
+	cache, err := ap.appCtx.CacheMgr().Serve()
+	if err != nil {
+		// pulls fall back to the next mirror
+		klog.Errorf("serve image cache: %v", err)
+	} else if cache != nil {
+		defer cache.Close()
+	}
+	zone, err := dns.ServeZone(dns.ZoneAddress, ap.appCtx.VMMgr().Zone())
+	if err != nil {
+		// vms are still reachable by address
+		klog.Errorf("serve dns zone %s: %v", dns.ZoneDomain, err)
+	} else {
+		defer zone.Shutdown()
+	}
 
 	for {
 		klog.Infof("waiting for signal")
@@ -9,6 +9,8 @@ import (
 	"github.com/samber/lo"
 	"k8s.io/klog/v2"
 	"net"
+	"os"
+	"strconv"
 )
 
 var (
@@ -16,10 +18,14 @@ var (
 	defaultCIDR    = "192.168.64.1/24"
 )
 
+// needAddress reports whether an address of the vz nat network is allocated
+// to n, other networks are addressed statically or with dhcp.
+func needAddress(n v1.Network) bool {
+	return n.VZNAT && n.Address == ""
+}
+
 func allocateAddress(m *meta.Machine, total []*meta.Machine) error {
-	_, needAllocate := lo.Find(m.Spec.Networks, func(item v1.Network) bool {
-		return item.Address == ""
-	})
+	_, needAllocate := lo.Find(m.Spec.Networks, needAddress)
 	if !needAllocate {
 		return nil
 	}
@@ -49,6 +55,9 @@ func allocateAddress(m *meta.Machine, total []*meta.Machine) error {
 	}
 	n := iplib.NewNet4(ip, 24)
 	for index, _ := range m.Spec.Networks {
+		if !needAddress(m.Spec.Networks[index]) {
+			continue
+		}
 		succeed := false
 		for i := 0; i < 255; i++ {
 			ip, err = n.NextIP(ip)
@@ -74,3 +83,76 @@ func allocateAddress(m *meta.Machine, total []*meta.Machine) error {
 	}
 	return nil
 }
+
+// allocateProxyAddress gives the network proxy of m the first free port from
+// 1080 when no address is set, a port taken by another vm is rejected.
+func allocateProxyAddress(m *meta.Machine, total []*meta.Machine) error {
+	np := m.Spec.NetworkProxy
+	if np == nil || !np.Enabled {
+		return nil
+	}
+	used := map[string]string{}
+	for _, item := range total {
+		if item.Name == m.Name || item.Spec == nil {
+			continue
+		}
+		p := item.Spec.NetworkProxy
+		if p == nil || !p.Enabled {
+			continue
+		}
+		_, port, err := net.SplitHostPort(p.GetAddress())
+		if err == nil {
+			used[port] = item.Name
+		}
+	}
+	if np.Address != "" {
+		_, port, err := net.SplitHostPort(np.Address)
+		if err != nil {
+			return errors.Wrapf(err, "network proxy address %s", np.Address)
+		}
+		if vm, ok := used[port]; ok {
+			return fmt.Errorf("network proxy port %s is used by vm %s", port, vm)
+		}
+		return nil
+	}
+	for port := 1080; port < 1180; port++ {
+		if _, ok := used[strconv.Itoa(port)]; ok {
+			continue
+		}
+		np.Address = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		return nil
+	}
+	return fmt.Errorf("no available network proxy port")
+}
+
+// vipMachine is a placeholder holding the virtual ip of cluster, an empty
+// vip is allocated with allocateAddress.
+func vipMachine(cluster, vip string) *meta.Machine {
+	n := v1.Network{VZNAT: true}
+	if vip != "" {
+		n.Address = fmt.Sprintf("%s/24", vip)
+	}
+	return &meta.Machine{
+		Name: fmt.Sprintf("%s-vip", cluster),
+		Spec: &v1.VirtualMachineSpec{Networks: []v1.Network{n}},
+	}
+}
+
+// vipMachines are the placeholders of the virtual ips of all clusters, they
+// are never allocated to a vm.
+func vipMachines(bk meta.Backend) []*meta.Machine {
+	k8s, err := bk.K8S().List()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("list k8s for virtual ips: %v", err)
+		}
+		return nil
+	}
+	var vms []*meta.Machine
+	for _, k := range k8s {
+		if k.VIP != "" {
+			vms = append(vms, vipMachine(k.Name, k.VIP))
+		}
+	}
+	return vms
+}
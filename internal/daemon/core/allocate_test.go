@@ -28,3 +28,32 @@ func TestAlloc(t *testing.T) {
 	}
 	t.Logf("address: %s", tool.PrettyJson(vm))
 }
+
+func TestAllocProxyAddress(t *testing.T) {
+	proxyVM := func(name, addr string) *meta.Machine {
+		return &meta.Machine{
+			Name: name,
+			Spec: &v1.VirtualMachineSpec{
+				NetworkProxy: &v1.NetworkProxy{Enabled: true, Address: addr},
+			},
+		}
+	}
+	total := []*meta.Machine{proxyVM("a", ""), proxyVM("b", "127.0.0.1:1081")}
+
+	vm := proxyVM("c", "")
+	err := allocateProxyAddress(vm, total)
+	if err != nil {
+		t.Fatalf("allocate: %s", err)
+	}
+	if vm.Spec.NetworkProxy.Address != "127.0.0.1:1082" {
+		t.Fatalf("expect 127.0.0.1:1082, got %s", vm.Spec.NetworkProxy.Address)
+	}
+	err = allocateProxyAddress(proxyVM("d", "0.0.0.0:1081"), total)
+	if err == nil {
+		t.Fatalf("expect port 1081 used by b")
+	}
+	err = allocateProxyAddress(proxyVM("a", "127.0.0.1:1080"), total)
+	if err != nil {
+		t.Fatalf("the proxy of a itself: %s", err)
+	}
+}
@@ -0,0 +1,400 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ApplyStack = "apply-stack"
+)
+
+func NewLocalApplyMgr(vmMgr *LocalVMMgr, dockerMgr *LocalDockerMgr, k8sMgr *LocalK8sMgr) *LocalApplyMgr {
+	return &LocalApplyMgr{
+		tskMgr:    vmMgr.tskMgr,
+		vmMgr:     vmMgr,
+		dockerMgr: dockerMgr,
+		k8sMgr:    k8sMgr,
+	}
+}
+
+// LocalApplyMgr converges a declarative v1.Stack against the meta records
+// of machines, docker and kubernetes.
+type LocalApplyMgr struct {
+	tskMgr    *taskMgr
+	vmMgr     *LocalVMMgr
+	dockerMgr *LocalDockerMgr
+	k8sMgr    *LocalK8sMgr
+}
+
+// Diff computes the plan to converge stack without changing anything.
+func (mgr *LocalApplyMgr) Diff(stack *v1.Stack) (*v1.StackPlan, error) {
+	err := stack.Validate()
+	if err != nil {
+		return nil, err
+	}
+	bk := mgr.vmMgr.backend
+	dockers, err := bk.Docker().List()
+	if err != nil {
+		return nil, errors.Wrap(err, "list docker")
+	}
+	k8s, err := bk.K8S().List()
+	if err != nil {
+		return nil, errors.Wrap(err, "list kubernetes")
+	}
+	var (
+		plan     = &v1.StackPlan{Stack: stack.Name}
+		machines = mgr.vmMgr.stateMgr.List()
+		dockerOf = lo.SliceToMap(dockers, func(d *meta.Docker) (string, *meta.Docker) { return d.VmName, d })
+		k8sOf    = lo.SliceToMap(k8s, func(k *meta.Kubernetes) (string, *meta.Kubernetes) { return k.VmName, k })
+		vmOf     = lo.SliceToMap(machines, func(m *meta.Machine) (string, *meta.Machine) { return m.Name, m })
+	)
+
+	// deletions, reverse dependency order
+	var deletes [3][]v1.StackChange
+	for _, m := range machines {
+		if m.Stack != stack.Name {
+			continue
+		}
+		want := stack.Machine(m.Name)
+		if k, ok := k8sOf[m.Name]; ok && (want == nil || want.Kubernetes == nil) {
+			deletes[0] = append(deletes[0], v1.StackChange{
+				Resource: v1.StackResourceK8s, Name: k.Name, Action: v1.ChangeDelete, Reason: "removed from stack",
+			})
+		}
+		if d, ok := dockerOf[m.Name]; ok && (want == nil || want.Docker == nil) {
+			deletes[1] = append(deletes[1], v1.StackChange{
+				Resource: v1.StackResourceDocker, Name: d.Name, Action: v1.ChangeDelete, Reason: "removed from stack",
+			})
+		}
+		if want == nil {
+			deletes[2] = append(deletes[2], v1.StackChange{
+				Resource: v1.StackResourceVM, Name: m.Name, Action: v1.ChangeDelete, Reason: "removed from stack",
+			})
+		}
+	}
+	for _, d := range deletes {
+		plan.Changes = append(plan.Changes, d...)
+	}
+
+	// creations and updates, dependency order
+	for _, want := range stack.Spec.Machines {
+		cur, ok := vmOf[want.Name]
+		switch {
+		case !ok:
+			plan.Changes = append(plan.Changes, v1.StackChange{
+				Resource: v1.StackResourceVM, Name: want.Name, Action: v1.ChangeCreate,
+			})
+		case cur.Stack != stack.Name:
+			return nil, fmt.Errorf("vm %s already exists and is not managed by stack %s", cur.Name, stack.Name)
+		default:
+			drift, err := vmDrift(&want.Spec, cur)
+			if err != nil {
+				return nil, errors.Wrapf(err, "vm %s", cur.Name)
+			}
+			plan.Changes = append(plan.Changes, v1.StackChange{
+				Resource: v1.StackResourceVM,
+				Name:     want.Name,
+				Action:   lo.Ternary(len(drift) == 0, v1.ChangeUnchanged, v1.ChangeUpdate),
+				Reason:   strings.Join(drift, ", "),
+			})
+		}
+		if want.Docker != nil {
+			change := v1.StackChange{Resource: v1.StackResourceDocker, Name: want.Name, Action: v1.ChangeUnchanged}
+			d, ok := dockerOf[want.Name]
+			switch {
+			case !ok:
+				change.Action = v1.ChangeCreate
+			case want.Docker.Version != "" && want.Docker.Version != d.Version:
+				change.Action = v1.ChangeUpdate
+				change.Reason = fmt.Sprintf("version: %s -> %s", d.Version, want.Docker.Version)
+			}
+			plan.Changes = append(plan.Changes, change)
+		}
+		if want.Kubernetes != nil {
+			change := v1.StackChange{Resource: v1.StackResourceK8s, Name: want.Name, Action: v1.ChangeUnchanged}
+			k, ok := k8sOf[want.Name]
+			switch {
+			case !ok:
+				change.Action = v1.ChangeCreate
+			case want.Kubernetes.Version != "" &&
+				v1.NormalizeKubernetesVersion(want.Kubernetes.Version) != v1.NormalizeKubernetesVersion(k.Spec.Config.Kubernetes.Version):
+				change.Action = v1.ChangeUpdate
+				change.Reason = fmt.Sprintf("version: %s -> %s", k.Spec.Config.Kubernetes.Version, want.Kubernetes.Version)
+			}
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+	return plan, nil
+}
+
+// Apply computes the plan of stack and executes it in background. Applying
+// the same stack twice is a no-op.
+func (mgr *LocalApplyMgr) Apply(ctx context.Context, stack *v1.Stack) (*v1.StackPlan, error) {
+	plan, err := mgr.Diff(stack)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.Pending() {
+		klog.Infof("[%s]stack is up to date", stack.Name)
+		return plan, nil
+	}
+	err = mgr.tskMgr.Send(ApplyStack, stack.Name, func(ctx context.Context) error {
+		return mgr.execute(ctx, stack, plan)
+	})
+	return plan, err
+}
+
+func (mgr *LocalApplyMgr) execute(ctx context.Context, stack *v1.Stack, plan *v1.StackPlan) error {
+	for _, c := range plan.Changes {
+		if c.Action == v1.ChangeUnchanged {
+			continue
+		}
+		klog.Infof("[%s]apply change: %s %s/%s %s", stack.Name, c.Action, c.Resource, c.Name, c.Reason)
+		err := mgr.executeOne(ctx, stack, c)
+		if err != nil {
+			return errors.Wrapf(err, "%s %s/%s", c.Action, c.Resource, c.Name)
+		}
+	}
+	klog.Infof("[%s]stack applied", stack.Name)
+	return nil
+}
+
+func (mgr *LocalApplyMgr) executeOne(ctx context.Context, stack *v1.Stack, c v1.StackChange) error {
+	want := stack.Machine(c.Name)
+	switch c.Resource {
+	case v1.StackResourceVM:
+		switch c.Action {
+		case v1.ChangeDelete:
+			return mgr.vmMgr.Destroy(ctx, c.Name)
+		case v1.ChangeCreate:
+			spec := want.Spec.DeepCopy()
+			vm := &meta.Machine{
+				Name:   c.Name,
+				Spec:   spec,
+				Stack:  stack.Name,
+				AbsDir: path.Join(mgr.vmMgr.backend.Machine().Dir(), c.Name),
+			}
+			err := mgr.vmMgr.Create(ctx, vm)
+			if err != nil {
+				return err
+			}
+			return mgr.vmMgr.startAndWait(ctx, c.Name)
+		case v1.ChangeUpdate:
+			return mgr.updateVm(ctx, &want.Spec, c.Name)
+		}
+	case v1.StackResourceDocker:
+		switch c.Action {
+		case v1.ChangeDelete:
+			err := mgr.dockerMgr.Destroy(ctx, c.Name)
+			if err != nil {
+				return err
+			}
+			return mgr.vmMgr.backend.Docker().Remove(&meta.Docker{Name: c.Name})
+		case v1.ChangeCreate:
+			err := mgr.vmMgr.waitRunning(ctx, c.Name)
+			if err != nil {
+				return err
+			}
+			return mgr.dockerMgr.Create(ctx, &meta.Docker{
+				Name: c.Name, Version: want.Docker.Version, Options: want.Docker.Options,
+			})
+		case v1.ChangeUpdate:
+			return mgr.dockerMgr.Update(ctx, &meta.Docker{
+				Name: c.Name, Version: want.Docker.Version, Options: want.Docker.Options,
+			})
+		}
+	case v1.StackResourceK8s:
+		switch c.Action {
+		case v1.ChangeDelete:
+			return mgr.k8sMgr.Destroy(ctx, c.Name)
+		case v1.ChangeCreate:
+			err := mgr.vmMgr.waitRunning(ctx, c.Name)
+			if err != nil {
+				return err
+			}
+			spec, err := DftRequest()
+			if err != nil {
+				return err
+			}
+			if want.Kubernetes.Version != "" {
+				spec.Config.Kubernetes.Version = want.Kubernetes.Version
+			}
+			return mgr.k8sMgr.Create(ctx, &meta.Kubernetes{
+				Name:    c.Name,
+				Spec:    *spec,
+				Version: spec.Config.Kubernetes.Version,
+				VmName:  c.Name,
+			})
+		case v1.ChangeUpdate:
+			return mgr.k8sMgr.Upgrade(ctx, c.Name, want.Kubernetes.Version)
+		}
+	}
+	return fmt.Errorf("unexpected change: %s %s", c.Action, c.Resource)
+}
+
+// updateVm persists the mutable fields of spec. Port forwards and authorized
+// keys are applied to the running vm directly, while cpu, memory and mount
+// changes take effect after a restart.
+func (mgr *LocalApplyMgr) updateVm(ctx context.Context, spec *v1.VirtualMachineSpec, name string) error {
+	state := mgr.vmMgr.stateMgr.Get(name)
+	if state == nil || state.machine == nil {
+		return fmt.Errorf("vm %s not found", name)
+	}
+	var (
+		vm      = state.machine
+		restart = false
+	)
+	if spec.CPUs != 0 && spec.CPUs != vm.Spec.CPUs {
+		vm.Spec.CPUs, restart = spec.CPUs, true
+	}
+	if spec.Memory != "" && spec.Memory != vm.Spec.Memory {
+		vm.Spec.Memory, restart = spec.Memory, true
+	}
+	for _, m := range spec.Mounts {
+		if !lo.Contains(vm.Spec.Mounts, m) {
+			vm.Spec.SetMounts(m)
+			restart = true
+		}
+	}
+	keys := keysDrift(spec, vm)
+	if keys {
+		vm.Spec.SSH.AuthorizedKeys = spec.SSH.AuthorizedKeys
+	}
+	add, remove := forwardDrift(spec, vm)
+	vm.Spec.RemoveForward(remove...)
+	vm.Spec.SetForward(add...)
+	err := mgr.vmMgr.backend.Machine().Update(vm)
+	if err != nil {
+		return errors.Wrapf(err, "update machine metadata")
+	}
+	if vm.State != Running {
+		return nil
+	}
+	if restart {
+		err = mgr.vmMgr.Stop(ctx, name)
+		if err != nil {
+			return err
+		}
+		return mgr.vmMgr.startAndWait(ctx, name)
+	}
+	if keys {
+		err = mgr.vmMgr.PushAuthorizedKeys(ctx, state)
+		if err != nil {
+			return err
+		}
+	}
+	sdbx, err := client.Client(vm.SandboxSock())
+	if err != nil {
+		return errors.Wrapf(err, "get client sandbox sdbx")
+	}
+	if len(remove) > 0 {
+		err = sdbx.Delete(ctx, "forward", name, remove)
+		if err != nil {
+			return errors.Wrapf(err, "remove forward")
+		}
+	}
+	if len(add) > 0 {
+		return sdbx.Create(ctx, "forward", name, &add)
+	}
+	return nil
+}
+
+// vmDrift lists the fields of want that differ from the current machine.
+// Fields left empty in want are defaulted on creation and never drift.
+func vmDrift(want *v1.VirtualMachineSpec, cur *meta.Machine) ([]string, error) {
+	var drift []string
+	immutable := func(field string, w, c any) error {
+		if reflect.ValueOf(w).IsZero() || reflect.DeepEqual(w, c) {
+			return nil
+		}
+		return fmt.Errorf("field %s is immutable: %v -> %v, delete the vm first", field, c, w)
+	}
+	for _, err := range []error{
+		immutable("image", want.Image.Name, cur.Spec.Image.Name),
+		immutable("arch", want.Arch, cur.Spec.Arch),
+		immutable("os", want.OS, cur.Spec.OS),
+		immutable("disk", want.Disk, cur.Spec.Disk),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	if want.CPUs != 0 && want.CPUs != cur.Spec.CPUs {
+		drift = append(drift, fmt.Sprintf("cpus: %d -> %d", cur.Spec.CPUs, want.CPUs))
+	}
+	if want.Memory != "" && want.Memory != cur.Spec.Memory {
+		drift = append(drift, fmt.Sprintf("memory: %s -> %s", cur.Spec.Memory, want.Memory))
+	}
+	for _, m := range want.Mounts {
+		if !lo.Contains(cur.Spec.Mounts, m) {
+			drift = append(drift, fmt.Sprintf("mount: %s", m.Location))
+		}
+	}
+	if keysDrift(want, cur) {
+		drift = append(drift, fmt.Sprintf("ssh.authorizedKeys: %d -> %d keys",
+			len(cur.Spec.SSH.AuthorizedKeys), len(want.SSH.AuthorizedKeys)))
+	}
+	add, remove := forwardDrift(want, cur)
+	for _, f := range add {
+		drift = append(drift, fmt.Sprintf("+forward: %s", f.Rule()))
+	}
+	for _, f := range remove {
+		drift = append(drift, fmt.Sprintf("-forward: %s", f.Rule()))
+	}
+	return drift, nil
+}
+
+// keysDrift reports whether want declares other authorized keys, which are
+// pushed into the running vm.
+func keysDrift(want *v1.VirtualMachineSpec, cur *meta.Machine) bool {
+	return want.SSH.AuthorizedKeys != nil &&
+		!slices.Equal(want.SSH.AuthorizedKeys, cur.Spec.SSH.AuthorizedKeys)
+}
+
+// forwardDrift compares the user declared port forwards, forwards installed
+// by meridian itself (guest agent, docker) are left alone.
+func forwardDrift(want *v1.VirtualMachineSpec, cur *meta.Machine) (add, remove []v1.PortForward) {
+	rules := func(fwd []v1.PortForward) map[string]v1.PortForward {
+		return lo.SliceToMap(fwd, func(f v1.PortForward) (string, v1.PortForward) { return f.Rule(), f })
+	}
+	var (
+		wanted  = rules(want.PortForwards)
+		current = rules(lo.Reject(cur.Spec.PortForwards, func(f v1.PortForward, _ int) bool {
+			return isSystemForward(cur, f)
+		}))
+	)
+	for _, f := range want.PortForwards {
+		if _, ok := current[f.Rule()]; !ok {
+			add = append(add, f)
+		}
+	}
+	for _, f := range cur.Spec.PortForwards {
+		if _, ok := current[f.Rule()]; !ok {
+			continue
+		}
+		if _, ok := wanted[f.Rule()]; !ok {
+			remove = append(remove, f)
+		}
+	}
+	return add, remove
+}
+
+func isSystemForward(m *meta.Machine, f v1.PortForward) bool {
+	if f.SrcProto != "unix" {
+		return false
+	}
+	addr := f.SrcAddr.String()
+	return addr == m.GuestSock() || addr == m.DockerSock() || addr == m.DockerProbeSock()
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+)
+
+func TestVmDrift(t *testing.T) {
+	var (
+		user = v1.PortForward{
+			SrcProto: "tcp", SrcAddr: intstr.FromString("0.0.0.0:8080"),
+			DstProto: "tcp", DstAddr: intstr.FromString("127.0.0.1:80"),
+		}
+		vm = &meta.Machine{
+			Name:   "abc",
+			AbsDir: "/tmp/abc",
+			Spec: &v1.VirtualMachineSpec{
+				CPUs:   2,
+				Memory: "4GiB",
+				Image:  v1.ImageLocation{Name: "ubuntu"},
+			},
+		}
+	)
+	vm.Spec.PortForwards = append(newDockerForward(vm), user)
+
+	drift, err := vmDrift(&v1.VirtualMachineSpec{PortForwards: []v1.PortForward{user}}, vm)
+	if err != nil {
+		t.Fatalf("drift: %s", err)
+	}
+	if len(drift) != 0 {
+		t.Fatalf("expect no drift, system forwards must be ignored: %v", drift)
+	}
+
+	drift, err = vmDrift(&v1.VirtualMachineSpec{CPUs: 4}, vm)
+	if err != nil {
+		t.Fatalf("drift: %s", err)
+	}
+	if len(drift) != 2 {
+		t.Fatalf("expect cpus and removed forward drift, got: %v", drift)
+	}
+
+	keys := v1.SSH{AuthorizedKeys: []string{"ssh-ed25519 AAAA teammate"}}
+	drift, err = vmDrift(&v1.VirtualMachineSpec{SSH: keys, PortForwards: []v1.PortForward{user}}, vm)
+	if err != nil {
+		t.Fatalf("drift: %s", err)
+	}
+	if len(drift) != 1 {
+		t.Fatalf("expect authorized keys drift, got: %v", drift)
+	}
+
+	_, err = vmDrift(&v1.VirtualMachineSpec{Image: v1.ImageLocation{Name: "centos"}}, vm)
+	if err == nil {
+		t.Fatalf("expect immutable image error")
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/vmm/imagecache"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/docker/go-units"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+)
+
+// LocalCacheMgr runs the pull-through image cache shared by the vms and
+// wires it into their container runtimes.
+type LocalCacheMgr struct {
+	cfg v1.ImageCache
+	dir string
+	// cache is nil when disabled or an external cache is reused
+	cache *imagecache.Cache
+}
+
+func NewLocalCacheMgr(backend meta.Backend) (*LocalCacheMgr, error) {
+	mgr := &LocalCacheMgr{
+		dir: filepath.Join(filepath.Dir(backend.Config().Dir()), "cache"),
+	}
+	file := filepath.Join(backend.Config().Dir(), v1.ImageCacheConfig)
+	data, err := os.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, errors.Wrapf(err, "read %s", file)
+	default:
+		err = yaml.Unmarshal(data, &mgr.cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %s", file)
+		}
+	}
+	err = mgr.cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if !mgr.cfg.Enabled || mgr.cfg.Address != "" {
+		return mgr, nil
+	}
+	mgr.cache, err = imagecache.New(filepath.Join(mgr.dir, "images"), &mgr.cfg)
+	return mgr, err
+}
+
+func (mgr *LocalCacheMgr) sock() string {
+	return filepath.Join(mgr.dir, "registry.sock")
+}
+
+// Serve listens on the socket the vms are forwarded to.
+func (mgr *LocalCacheMgr) Serve() (*http.Server, error) {
+	if mgr.cache == nil {
+		return nil, nil
+	}
+	return mgr.cache.Listen(mgr.sock())
+}
+
+func (mgr *LocalCacheMgr) Enabled() bool { return mgr.cfg.Enabled }
+
+// Status reports the content of the cache of meridiand.
+func (mgr *LocalCacheMgr) Status() (*v1.CacheStatus, error) {
+	if mgr.cache == nil {
+		return nil, fmt.Errorf("image cache is not run by meridiand, enabled=%t address=%s",
+			mgr.cfg.Enabled, mgr.cfg.Address)
+	}
+	status := mgr.cache.Status()
+	status.Address = mgr.sock()
+	return status, nil
+}
+
+// Prune removes the least recently used entries beyond p.MaxSize, all of
+// them with p.All. Without both the cache is pruned to its MaxSize.
+func (mgr *LocalCacheMgr) Prune(p *v1.CachePrune) (*v1.CacheStatus, error) {
+	if mgr.cache == nil {
+		return nil, fmt.Errorf("image cache is not run by meridiand")
+	}
+	size := mgr.cfg.GetMaxSize()
+	switch {
+	case p.All:
+		size = 0
+	case p.MaxSize != "":
+		var err error
+		size, err = units.RAMInBytes(p.MaxSize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid max size %s", p.MaxSize)
+		}
+	}
+	removed, err := mgr.cache.Prune(size)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("image cache: pruned %d entries", len(removed))
+	return mgr.Status()
+}
+
+// Registries puts the cache in front of the mirrors of docker hub and of
+// upstreams, r is left untouched. Without r the builtin mirror of docker
+// hub is kept behind the cache.
+func (mgr *LocalCacheMgr) Registries(r *v1.Registries, upstreams ...string) *v1.Registries {
+	if !mgr.Enabled() {
+		return r
+	}
+	out := r.DeepCopy()
+	if out == nil {
+		out = &v1.Registries{Mirrors: map[string][]string{v1.DockerHub: {v1.DefaultDockerHubMirror}}}
+	}
+	if out.Mirrors == nil {
+		out.Mirrors = map[string][]string{}
+	}
+	cache := "http://" + v1.ImageCacheGuestAddress
+	for _, u := range lo.Uniq(append([]string{v1.DockerHub}, upstreams...)) {
+		// a registry may carry the namespace of the images
+		u = strings.SplitN(u, "/", 2)[0]
+		if u == "" || lo.Contains(out.Mirrors[u], cache) {
+			continue
+		}
+		out.Mirrors[u] = append([]string{cache}, out.Mirrors[u]...)
+	}
+	return out
+}
+
+// Forward reverse forwards the cache into vm, the rule is kept in the vm
+// spec to be restored with the sandbox.
+func (mgr *LocalCacheMgr) Forward(ctx context.Context, vm *vmState) error {
+	if !mgr.Enabled() {
+		return nil
+	}
+	fwd := v1.PortForward{
+		Reverse:  true,
+		SrcProto: "tcp",
+		SrcAddr:  intstr.FromString(v1.ImageCacheGuestAddress),
+		DstProto: "unix",
+		DstAddr:  intstr.FromString(mgr.sock()),
+	}
+	if mgr.cfg.Address != "" {
+		fwd.DstProto, fwd.DstAddr = "tcp", intstr.FromString(mgr.cfg.Address)
+	}
+	vm.machine.Spec.SetForward(fwd)
+	err := vm.meta.Machine().Update(vm.machine)
+	if err != nil {
+		return errors.Wrapf(err, "update machine metadata")
+	}
+	sdbx, err := client.Client(vm.machine.SandboxSock())
+	if err != nil {
+		return errors.Wrapf(err, "get client sandbox sdbx")
+	}
+	return sdbx.Create(ctx, "forward", "image-cache", &[]v1.PortForward{fwd})
+}
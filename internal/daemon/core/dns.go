@@ -0,0 +1,40 @@
+package core
+
+import (
+	"net"
+	"strings"
+
+	"github.com/aoxn/meridian/internal/vmm/dns"
+)
+
+// reconcileDNS publishes <vm>.meridian.internal for every running vm.
+func (mgr *LocalVMMgr) reconcileDNS() {
+	var records []dns.Record
+	for _, state := range mgr.stateMgr.States() {
+		if state.machine.State != Running {
+			continue
+		}
+		var ips []net.IP
+		for _, n := range state.machine.Spec.Networks {
+			ip, _, err := net.ParseCIDR(n.Address)
+			if err != nil {
+				ip = net.ParseIP(n.Address)
+			}
+			if ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			continue
+		}
+		records = append(records, dns.Record{
+			Name:     strings.ToLower(state.name),
+			IPs:      ips,
+			Wildcard: state.machine.Spec.DNSWildcard,
+		})
+	}
+	mgr.zone.Sync(records)
+}
+
+// Zone is the meridian.internal zone of the vms.
+func (mgr *LocalVMMgr) Zone() *dns.Zone { return mgr.zone }
@@ -5,32 +5,42 @@ import (
 	"fmt"
 	v1 "github.com/aoxn/meridian/api/v1"
 	"github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/tool"
 	"github.com/aoxn/meridian/internal/tool/cmd"
 	"github.com/aoxn/meridian/internal/tool/downloader"
 	"github.com/aoxn/meridian/internal/vmm/meta"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/klog/v2"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 )
 
-func NewLocalDockerMgr(stateMgr *vmStateMgr) (*LocalDockerMgr, error) {
-	return &LocalDockerMgr{
+func NewLocalDockerMgr(stateMgr *vmStateMgr, cacheMgr *LocalCacheMgr) (*LocalDockerMgr, error) {
+	mgr := &LocalDockerMgr{
 		stateMgr: stateMgr,
-	}, nil
+		cacheMgr: cacheMgr,
+	}
+	go mgr.healthLoop()
+	return mgr, nil
 }
 
 type LocalDockerMgr struct {
 	tskMgr   *taskMgr
 	stateMgr *vmStateMgr
+	cacheMgr *LocalCacheMgr
+	health   dockerHealth
 }
 
-func (mgr *LocalDockerMgr) Create(ctx context.Context, at string) error {
+func (mgr *LocalDockerMgr) Create(ctx context.Context, d *meta.Docker) error {
+	at := d.Name
 	vm := mgr.stateMgr.Get(at)
-	if vm.machine == nil {
+	if vm == nil || vm.machine == nil {
 		return fmt.Errorf("vm %s not found", at)
 	}
 	l := mgr.stateMgr.meta.Docker()
@@ -38,16 +48,22 @@ func (mgr *LocalDockerMgr) Create(ctx context.Context, at string) error {
 	if err == nil {
 		return fmt.Errorf("docker already exists %s", at)
 	}
-	var (
-		version  = "1.6.28"
-		registry = "registry.cn-hangzhou.aliyuncs.com"
-	)
-	out, err := vm.SSH().RunCommand(ctx, at, getCmd(ActionInstall, version, registry))
+	spec := v1.Docker{Version: d.Version, Options: d.Options}
+	err = spec.Validate()
+	if err != nil {
+		return err
+	}
+	spec.Version = spec.GetVersion()
+	registries, err := mgr.registries(ctx, vm)
+	if err != nil {
+		return err
+	}
+	out, err := vm.SSH().RunCommand(ctx, at, getCmd(ActionInstall, &spec, dockerRegistry, registries))
 	if err != nil {
 		return errors.Wrap(err, "run install docker command")
 	}
 	klog.Infof("install command result: %s", string(out))
-	err = mgr.setDockerContext(vm.machine)
+	err = tool.DockerContext(vm.machine.Name, vm.machine.DockerSock(), false)
 	if err != nil {
 		return errors.Wrapf(err, "set docker context")
 	}
@@ -56,13 +72,86 @@ func (mgr *LocalDockerMgr) Create(ctx context.Context, at string) error {
 		return errors.Wrapf(err, "forward docker to host")
 	}
 	return l.Create(&meta.Docker{
-		Name: at, VmName: at, Version: version, State: "Installed",
+		Name:          at,
+		VmName:        at,
+		Version:       spec.Version,
+		Options:       spec.Options,
+		State:         "Installed",
+		ServerVersion: serverVersion(ctx, vm),
 	})
 }
 
+// Update upgrades docker of a running vm to d.Version in place and applies
+// d.Options. meridian-node reinstalls the package tarball of the version by
+// file.Ensure, images and volumes are kept in /var/lib/docker and containers
+// survive the restart with live-restore.
+func (mgr *LocalDockerMgr) Update(ctx context.Context, d *meta.Docker) error {
+	l := mgr.stateMgr.meta.Docker()
+	cur, err := l.Get(d.Name)
+	if err != nil {
+		return fmt.Errorf("docker %s not found", d.Name)
+	}
+	vm := mgr.stateMgr.Get(cur.VmName)
+	if vm == nil || vm.machine == nil {
+		return fmt.Errorf("vm %s not found", cur.VmName)
+	}
+	if vm.machine.State != Running {
+		return fmt.Errorf("vm %s is %s, start it before updating docker", cur.VmName, vm.machine.State)
+	}
+	spec := v1.Docker{
+		Version: lo.Ternary(d.Version == "", cur.Version, d.Version),
+		Options: cur.Options,
+	}
+	if !reflect.DeepEqual(d.Options, v1.DockerOptions{}) {
+		spec.Options = d.Options
+	}
+	err = spec.Validate()
+	if err != nil {
+		return err
+	}
+	registries, err := mgr.registries(ctx, vm)
+	if err != nil {
+		return err
+	}
+	klog.Infof("[%-10s]upgrade docker: %s -> %s", d.Name, cur.Version, spec.Version)
+	out, err := vm.SSH().RunCommand(ctx, cur.VmName, getCmd(ActionUpgrade, &spec, dockerRegistry, registries))
+	if err != nil {
+		klog.Infof("[%-10s]upgrade docker: %s", d.Name, string(out))
+		return errors.Wrap(err, "run upgrade docker command")
+	}
+	cur.Version, cur.Options = spec.Version, spec.Options
+	cur.ServerVersion = serverVersion(ctx, vm)
+	return l.Update(cur)
+}
+
+// registries are the registries of vm with the image cache in front, a
+// missing cache only costs the fallback to the next mirror.
+func (mgr *LocalDockerMgr) registries(ctx context.Context, vm *vmState) (*v1.Registries, error) {
+	resolved, err := resolveRegistries(vm.machine.Spec.Registries)
+	if err != nil {
+		return nil, err
+	}
+	err = mgr.cacheMgr.Forward(ctx, vm)
+	if err != nil {
+		klog.Warningf("[%-10s]forward image cache: %v", vm.name, err)
+	}
+	return mgr.cacheMgr.Registries(resolved), nil
+}
+
+// serverVersion asks the docker daemon of vm for its version, empty when
+// it is not reachable.
+func serverVersion(ctx context.Context, vm *vmState) string {
+	out, err := vm.SSH().RunCommand(ctx, vm.machine.Name, "sudo docker version --format '{{.Server.Version}}'")
+	if err != nil {
+		klog.Warningf("[%-10s]get docker server version: %s", vm.machine.Name, err.Error())
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
 func (mgr *LocalDockerMgr) Destroy(ctx context.Context, at string) error {
 	vm := mgr.stateMgr.Get(at)
-	if vm.machine == nil {
+	if vm == nil || vm.machine == nil {
 		klog.Infof("vm %s not found", at)
 		return nil
 	}
@@ -72,13 +161,8 @@ func (mgr *LocalDockerMgr) Destroy(ctx context.Context, at string) error {
 		klog.Infof("docker not found: %s", at)
 		return nil
 	}
-	var (
-		version  = ""
-		registry = ""
-	)
-
 	_ = mgr.removeDockerContext(at)
-	out, err := vm.SSH().RunCommand(ctx, at, getCmd(ActionDestroy, version, registry))
+	out, err := vm.SSH().RunCommand(ctx, at, getCmd(ActionDestroy, nil, "", nil))
 	if err != nil {
 		klog.Infof("command result: %s", string(out))
 		return errors.Wrap(err, "run destroy docker command")
@@ -130,6 +214,12 @@ func newDockerForward(vm *meta.Machine) []v1.PortForward {
 			DstProto: "vsock",
 			DstAddr:  intstr.FromInt32(10240),
 		},
+		{
+			SrcProto: "unix",
+			SrcAddr:  intstr.FromString(vm.DockerProbeSock()),
+			DstProto: "vsock",
+			DstAddr:  intstr.FromInt32(10240),
+		},
 	}
 }
 
@@ -144,31 +234,6 @@ func (mgr *LocalDockerMgr) initBinary(ctx context.Context) {
 	}
 }
 
-func (mgr *LocalDockerMgr) setDockerContext(vm *meta.Machine) error {
-	docker := "/usr/local/bin/docker"
-	content := []string{"context", "inspect", vm.Name}
-	r := <-cmd.NewCmd(docker, content...).Start()
-	err := cmd.CmdError(r)
-	if err != nil {
-		content = []string{
-			"context", "create", vm.Name,
-			"--docker", fmt.Sprintf("host=unix://%s", vm.DockerSock()),
-			"--description", "meridian docker endpoint",
-		}
-		r = <-cmd.NewCmd(docker, content...).Start()
-		return cmd.CmdError(r)
-	}
-	content = []string{
-		"context",
-		"update",
-		vm.Name,
-		"--docker", fmt.Sprintf("host=unix://%s", vm.DockerSock()),
-	}
-	r = <-cmd.NewCmd(docker, content...).Start()
-	return cmd.CmdError(r)
-
-}
-
 func (mgr *LocalDockerMgr) removeDockerContext(name string) error {
 	content := []string{
 		"context",
@@ -281,17 +346,32 @@ fi
 
 const (
 	ActionInstall = "install"
+	ActionUpgrade = "upgrade"
 	ActionDestroy = "destroy"
+	// ActionReset removes a worker from kubernetes
+	ActionReset = "reset"
+	// ActionJoinMaster joins a master into the control plane of kubernetes
+	ActionJoinMaster = "join-master"
+	// ActionUpgradeWorker upgrades the kubelet of a worker, ActionUpgrade
+	// upgrades a master
+	ActionUpgradeWorker = "upgrade-worker"
+
+	dockerRegistry = "registry.cn-hangzhou.aliyuncs.com"
 )
 
-func getCmd(action string, version, registry string) string {
+func getCmd(action string, d *v1.Docker, registry string, registries *v1.Registries) string {
 	var command []string
 	switch action {
-	case ActionInstall:
-		command = []string{
-			base,
-			fmt.Sprintf("sudo /usr/local/bin/meridian-node create docker --version %s --registry %s", version, registry),
+	case ActionInstall, ActionUpgrade:
+		// meridian-node upgrades an installed docker in place
+		install := fmt.Sprintf("sudo /usr/local/bin/meridian-node create docker --version %s --registry %s%s",
+			d.Version, registry, optionFlags(&d.Options))
+		command = []string{base}
+		if registries != nil {
+			command = append(command, registriesCmd(registries))
+			install += " --registries registries.yml"
 		}
+		command = append(command, install)
 	case ActionDestroy:
 		command = []string{
 			base,
@@ -300,3 +380,25 @@ func getCmd(action string, version, registry string) string {
 	}
 	return strings.Join(command, "\n")
 }
+
+func optionFlags(o *v1.DockerOptions) string {
+	var flags []string
+	if o.StorageDriver != "" {
+		flags = append(flags, "--storage-driver", o.StorageDriver)
+	}
+	if o.LogDriver != "" {
+		flags = append(flags, "--log-driver", o.LogDriver)
+	}
+	keys := lo.Keys(o.LogOpts)
+	sort.Strings(keys)
+	for _, k := range keys {
+		flags = append(flags, "--log-opt", fmt.Sprintf("'%s=%s'", k, o.LogOpts[k]))
+	}
+	if o.CgroupDriver != "" {
+		flags = append(flags, "--cgroup-driver", o.CgroupDriver)
+	}
+	if len(flags) == 0 {
+		return ""
+	}
+	return " " + strings.Join(flags, " ")
+}
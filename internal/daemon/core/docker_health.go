@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/pkg/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ReasonDockerReady       = "DockerReady"
+	ReasonDockerUnreachable = "DockerUnreachable"
+	ReasonDockerReforwarded = "DockerReforwarded"
+
+	dockerProbeInterval = 5 * time.Second
+	dockerProbeTimeout  = 3 * time.Second
+	// dockerFailureThreshold consecutive failed pings mark docker unready
+	// and re-establish the forward, it is retried every threshold failures
+	dockerFailureThreshold = 3
+)
+
+// dockerHealth tracks the docker api probe of every vm with docker.
+type dockerHealth struct {
+	mu     sync.Mutex
+	probes map[string]*probeState
+}
+
+func (h *dockerHealth) get(name string) *probeState {
+	if h.probes == nil {
+		h.probes = map[string]*probeState{}
+	}
+	ps, ok := h.probes[name]
+	if !ok {
+		ps = &probeState{message: "not probed yet"}
+		h.probes[name] = ps
+	}
+	return ps
+}
+
+func (mgr *LocalDockerMgr) healthLoop() {
+	wait.Until(mgr.reconcileDocker, time.Second, make(<-chan struct{}))
+}
+
+// reconcileDocker pings the docker api of every running vm with docker and
+// updates its DockerReady condition.
+func (mgr *LocalDockerMgr) reconcileDocker() {
+	dockers, err := mgr.stateMgr.meta.Docker().List()
+	if err != nil {
+		klog.V(5).Infof("list docker: %v", err)
+		return
+	}
+	installed := map[string]bool{}
+	for _, d := range dockers {
+		installed[d.VmName] = true
+	}
+	now := time.Now()
+	for _, state := range mgr.stateMgr.States() {
+		if !installed[state.name] {
+			mgr.health.mu.Lock()
+			delete(mgr.health.probes, state.name)
+			mgr.health.mu.Unlock()
+			if apimeta.FindStatusCondition(state.machine.Status.Conditions, v1.ConditionDockerReady) != nil {
+				state.removeCondition(v1.ConditionDockerReady)
+			}
+			continue
+		}
+		if state.machine.State != Running {
+			mgr.health.mu.Lock()
+			delete(mgr.health.probes, state.name)
+			mgr.health.mu.Unlock()
+			state.setCondition(v1.ConditionDockerReady, metav1.ConditionFalse, state.machine.State, "vm is not running")
+			continue
+		}
+		mgr.health.mu.Lock()
+		ps := mgr.health.get(state.name)
+		healthy, msg := ps.healthy, ps.message
+		if !ps.running && !now.Before(ps.next) {
+			ps.running = true
+			go mgr.probeDocker(state, ps)
+		}
+		mgr.health.mu.Unlock()
+
+		if !healthy {
+			state.setCondition(v1.ConditionDockerReady, metav1.ConditionFalse, ReasonNotReady, msg)
+			continue
+		}
+		state.setCondition(v1.ConditionDockerReady, metav1.ConditionTrue, ReasonDockerReady, "docker api is reachable")
+	}
+}
+
+func (mgr *LocalDockerMgr) probeDocker(state *vmState, ps *probeState) {
+	err := pingDocker(state.machine.DockerProbeSock())
+
+	mgr.health.mu.Lock()
+	ps.running, ps.next = false, time.Now().Add(dockerProbeInterval)
+	if err == nil {
+		recovered := ps.failures >= dockerFailureThreshold
+		ps.healthy, ps.failures, ps.message = true, 0, "ok"
+		mgr.health.mu.Unlock()
+		if recovered {
+			state.event(ReasonDockerReady, "docker api is reachable again")
+		}
+		return
+	}
+	ps.failures++
+	ps.message = err.Error()
+	failures := ps.failures
+	klog.V(5).Infof("[%s]docker ping failed %d times: %v", state.name, failures, err)
+	if failures%dockerFailureThreshold != 0 {
+		mgr.health.mu.Unlock()
+		return
+	}
+	ps.healthy = false
+	mgr.health.mu.Unlock()
+
+	if failures == dockerFailureThreshold {
+		state.event(ReasonDockerUnreachable, "docker api failed %d times: %s", failures, err.Error())
+	}
+	err = mgr.reforward(state)
+	if err != nil {
+		klog.Warningf("[%s]re-forward docker: %v", state.name, err)
+		return
+	}
+	state.event(ReasonDockerReforwarded, "docker.sock forward re-established")
+}
+
+// reforward replaces the docker.sock forwards of the sandbox, they go stale
+// when the sandbox or the guest agent restarts. The probe forward is added to
+// the spec of vms installed before it existed.
+func (mgr *LocalDockerMgr) reforward(state *vmState) error {
+	sdbx, err := client.Client(state.machine.SandboxSock())
+	if err != nil {
+		return errors.Wrapf(err, "get client sandbox sdbx")
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	err = sdbx.Delete(ctx, "forward", "docker", newDockerForward(state.machine))
+	if err != nil {
+		klog.V(5).Infof("[%s]remove docker forward: %v", state.name, err)
+	}
+	return mgr.ForwardDocker(ctx, state)
+}
+
+// pingDocker calls /_ping of the docker api listening on sock.
+func pingDocker(sock string) error {
+	hc := &http.Client{
+		Timeout: dockerProbeTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	defer hc.CloseIdleConnections()
+	resp, err := hc.Get("http://docker/_ping")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker ping: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
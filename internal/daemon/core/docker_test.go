@@ -8,6 +8,9 @@ import (
 	"github.com/aoxn/meridian/internal/tool"
 	"github.com/aoxn/meridian/internal/vmm/meta"
 	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"path/filepath"
 	"testing"
 )
 
@@ -39,6 +42,35 @@ func TestSandBox(t *testing.T) {
 	}
 }
 
+func TestPingDocker(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "docker.sock")
+	if pingDocker(sock) == nil {
+		t.Fatalf("ping without a listener should fail")
+	}
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen %s: %s", sock, err)
+	}
+	healthy := true
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ping" || !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("OK"))
+	})}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	if err = pingDocker(sock); err != nil {
+		t.Fatalf("ping docker: %s", err)
+	}
+	healthy = false
+	if pingDocker(sock) == nil {
+		t.Fatalf("ping should fail on a non 200 response")
+	}
+}
+
 func DecodeBody(data []byte, v interface{}) error {
 	if len(data) == 0 {
 		return nil
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Forwards returns the forward rules of running vm name, or of all running
+// vms when name is empty.
+func (mgr *LocalVMMgr) Forwards(ctx context.Context, name string) ([]v1.ForwardStatus, error) {
+	if name != "" {
+		state := mgr.stateMgr.Get(name)
+		if state == nil || state.machine == nil {
+			return nil, fmt.Errorf("vm %s not found", name)
+		}
+		return forwardsOf(ctx, state)
+	}
+	var result []v1.ForwardStatus
+	for _, state := range mgr.stateMgr.States() {
+		if state.machine.State != Running {
+			continue
+		}
+		fwds, err := forwardsOf(ctx, state)
+		if err != nil {
+			klog.Warningf("[%s]list forward: %v", state.name, err)
+			continue
+		}
+		result = append(result, fwds...)
+	}
+	return result, nil
+}
+
+func forwardsOf(ctx context.Context, state *vmState) ([]v1.ForwardStatus, error) {
+	if state.machine.State != Running {
+		return nil, fmt.Errorf("vm %s is not running: %s", state.name, state.machine.State)
+	}
+	sdbx, err := client.Client(state.machine.SandboxSock())
+	if err != nil {
+		return nil, err
+	}
+	var fwds []v1.ForwardStatus
+	err = sdbx.List(ctx, "forward", &fwds)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list forward of vm %s", state.name)
+	}
+	return fwds, nil
+}
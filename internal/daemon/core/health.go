@@ -0,0 +1,207 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/pkg/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ReasonUnhealthy = "Unhealthy"
+	ReasonHealthy   = "Healthy"
+	ReasonNotReady  = "NotReady"
+)
+
+// probeState is the result of the recent probes of a single health check.
+type probeState struct {
+	next     time.Time
+	running  bool
+	healthy  bool
+	failures int
+	message  string
+}
+
+// healthState tracks the health checks of a running vm. Probes run in
+// background and report back under mu.
+type healthState struct {
+	mu     sync.Mutex
+	probes map[string]*probeState
+}
+
+func (h *healthState) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.probes = nil
+}
+
+func validateHealthChecks(checks []v1.HealthCheck) error {
+	names := map[string]bool{}
+	for i := range checks {
+		err := checks[i].Validate()
+		if err != nil {
+			return err
+		}
+		if names[checks[i].Name] {
+			return fmt.Errorf("duplicated health check name: %s", checks[i].Name)
+		}
+		names[checks[i].Name] = true
+	}
+	return nil
+}
+
+func (mgr *LocalVMMgr) healthLoop() {
+	wait.Until(mgr.reconcileHealth, time.Second, make(<-chan struct{}))
+}
+
+// reconcileHealth starts the probes which are due and updates the Ready
+// condition of every vm. A vm without health checks is ready once running.
+func (mgr *LocalVMMgr) reconcileHealth() {
+	now := time.Now()
+	for _, state := range mgr.stateMgr.States() {
+		if state.machine.State != Running {
+			state.health.reset()
+			state.setReady(metav1.ConditionFalse, state.machine.State, "vm is not running")
+			continue
+		}
+		checks := state.machine.Spec.HealthChecks
+		if len(checks) == 0 {
+			state.setReady(metav1.ConditionTrue, Running, "vm is running")
+			continue
+		}
+		state.health.mu.Lock()
+		if state.health.probes == nil {
+			state.health.probes = map[string]*probeState{}
+		}
+		var failing []string
+		for i := range checks {
+			check := checks[i]
+			ps, ok := state.health.probes[check.Name]
+			if !ok {
+				ps = &probeState{message: "not probed yet"}
+				state.health.probes[check.Name] = ps
+			}
+			if !ps.healthy {
+				failing = append(failing, fmt.Sprintf("%s: %s", check.Name, ps.message))
+			}
+			if ps.running || now.Before(ps.next) {
+				continue
+			}
+			ps.running = true
+			go mgr.probe(state, &check, ps)
+		}
+		state.health.mu.Unlock()
+
+		if len(failing) > 0 {
+			state.setReady(metav1.ConditionFalse, ReasonUnhealthy, strings.Join(failing, "; "))
+			continue
+		}
+		state.setReady(metav1.ConditionTrue, ReasonHealthy, "all health checks passed")
+	}
+}
+
+func (mgr *LocalVMMgr) probe(state *vmState, check *v1.HealthCheck, ps *probeState) {
+	err := probeGuest(state.machine.GuestSock(), check)
+
+	state.health.mu.Lock()
+	defer state.health.mu.Unlock()
+	ps.running, ps.next = false, time.Now().Add(check.GetInterval())
+	if err == nil {
+		ps.healthy, ps.failures, ps.message = true, 0, "ok"
+		return
+	}
+	ps.failures++
+	ps.message = err.Error()
+	klog.V(5).Infof("[%s]health check %s failed %d times: %v", state.name, check.Name, ps.failures, err)
+	if ps.failures != check.GetFailureThreshold() {
+		return
+	}
+	ps.healthy = false
+	state.event(ReasonUnhealthy, "health check %s failed %d times: %s", check.Name, ps.failures, ps.message)
+	if check.RestartOnFailure {
+		mgr.restartUnhealthy(state, check.Name)
+	}
+}
+
+func (mgr *LocalVMMgr) restartUnhealthy(state *vmState, check string) {
+	name := state.name
+	err := mgr.tskMgr.Send(RestartVM, name, func(ctx context.Context) error {
+		state.machine.Restarts++
+		state.event(ReasonRestarting, "restart #%d, health check %s is failing", state.machine.Restarts, check)
+		err := mgr.Stop(ctx, name)
+		if err != nil {
+			return err
+		}
+		return mgr.startAndWait(ctx, name)
+	})
+	if err != nil {
+		klog.Errorf("[%s]restart unhealthy vm: %v", name, err)
+	}
+}
+
+// probeGuest asks the guest agent to run check once.
+func probeGuest(sock string, check *v1.HealthCheck) error {
+	ga, err := client.Client(sock)
+	if err != nil {
+		return err
+	}
+	// leave the guest agent enough time to report its own timeout
+	ctx, cancel := context.WithTimeout(context.TODO(), check.GetTimeout()+2*time.Second)
+	defer cancel()
+	var result v1.HealthCheckResult
+	err = ga.Raw().
+		Post(ctx).
+		PathPrefix("/api/v1/").
+		Resource("healthcheck").
+		Body(check).
+		Do(&result)
+	if err != nil {
+		return errors.Wrapf(err, "call guest agent")
+	}
+	if !result.Healthy {
+		return errors.New(result.Message)
+	}
+	return nil
+}
+
+// setReady persists the Ready condition when it changes.
+func (m *vmState) setReady(status metav1.ConditionStatus, reason, msg string) {
+	m.setCondition(v1.ConditionReady, status, reason, msg)
+}
+
+// setCondition persists the condition of type t when it changes.
+func (m *vmState) setCondition(t string, status metav1.ConditionStatus, reason, msg string) {
+	cond := metav1.Condition{
+		Type:    t,
+		Status:  status,
+		Reason:  reason,
+		Message: msg,
+	}
+	if !apimeta.SetStatusCondition(&m.machine.Status.Conditions, cond) {
+		return
+	}
+	err := m.meta.Machine().Update(m.machine)
+	if err != nil {
+		klog.Errorf("update machine %s %s condition failed: %v", m.machine.Name, t, err)
+	}
+}
+
+// removeCondition persists the removal of the condition of type t.
+func (m *vmState) removeCondition(t string) {
+	if !apimeta.RemoveStatusCondition(&m.machine.Status.Conditions, t) {
+		return
+	}
+	err := m.meta.Machine().Update(m.machine)
+	if err != nil {
+		klog.Errorf("update machine %s %s condition failed: %v", m.machine.Name, t, err)
+	}
+}
@@ -22,18 +22,22 @@ import (
 	"sync"
 )
 
-func NewK8sMgr(stateMgr *vmStateMgr) (*LocalK8sMgr, error) {
+func NewK8sMgr(vmMgr *LocalVMMgr, cacheMgr *LocalCacheMgr) (*LocalK8sMgr, error) {
 	var err error
 	mgr := &LocalK8sMgr{
-		vmStateMgr: stateMgr,
+		vmMgr:      vmMgr,
+		vmStateMgr: vmMgr.stateMgr,
+		cacheMgr:   cacheMgr,
 	}
-	mgr.stateStore, err = mgr.newK8sStateStore(stateMgr.meta)
+	mgr.stateStore, err = mgr.newK8sStateStore(vmMgr.stateMgr.meta)
 	return mgr, err
 }
 
 type LocalK8sMgr struct {
 	tskMgr     *taskMgr
+	vmMgr      *LocalVMMgr
 	vmStateMgr *vmStateMgr
+	cacheMgr   *LocalCacheMgr
 	stateStore *k8sStateStore
 }
 
@@ -46,15 +50,49 @@ func (mgr *LocalK8sMgr) Create(ctx context.Context, k8s *meta.Kubernetes) error
 	if l != nil {
 		return fmt.Errorf("k8s %s already exists", k8s.Name)
 	}
+	err := k8s.Validate()
+	if err != nil {
+		return err
+	}
+	switch {
+	case k8s.HighlyAvailable():
+		vip := vipMachine(k8s.Name, "")
+		err = allocateAddress(vip, append(mgr.vmStateMgr.List(), vipMachines(mgr.vmStateMgr.meta)...))
+		if err != nil {
+			return errors.Wrap(err, "allocate virtual ip")
+		}
+		k8s.VIP = vmAddress(vip)
+		nodeAccessPoint(&k8s.Spec, k8s.VIP)
+	case k8s.Workers > 0:
+		nodeAccessPoint(&k8s.Spec, vmAddress(vm.machine))
+	}
+	if k8s.Spec.Config.Proxy == nil {
+		k8s.Spec.Config.Proxy = clusterProxy(vm.machine.Spec)
+	}
+	if k8s.Spec.Config.Registries == nil {
+		registries, err := resolveRegistries(vm.machine.Spec.Registries)
+		if err != nil {
+			return err
+		}
+		err = mgr.cacheMgr.Forward(ctx, vm)
+		if err != nil {
+			klog.Warningf("[%-10s]forward image cache: %v", vm.name, err)
+		}
+		k8s.Spec.Config.Registries = mgr.cacheMgr.Registries(
+			registries, k8s.Spec.Config.Registry, "registry.k8s.io")
+	}
 	kstate, err := mgr.stateStore.Create(&meta.Kubernetes{
 		Name: k8s.Name, Spec: k8s.Spec, State: "Created", VmName: k8s.VmName,
+		Masters: k8s.Masters, Workers: k8s.Workers, VIP: k8s.VIP,
+		Nodes: []meta.KubernetesNode{{VmName: k8s.VmName, Role: v1.NodeRoleMaster, State: Created}},
 	})
 	if err != nil {
 		return errors.Wrap(err, "create kubernetes error")
 	}
 	if kstate.tryLock() {
 		go func() {
-			err = kstate.deploy(context.TODO())
+			defer kstate.unlock()
+			err := mgr.deploy(context.TODO(), kstate)
 			if err != nil {
 				klog.Errorf("deploy kubernetes %s: %s", k8s.Name, err.Error())
 			}
@@ -77,7 +115,8 @@ func (mgr *LocalK8sMgr) Redeploy(ctx context.Context, k8s *meta.Kubernetes) erro
 	}
 	if kstate.tryLock() {
 		go func() {
-			err := kstate.deploy(context.TODO())
+			defer kstate.unlock()
+			err := mgr.deploy(context.TODO(), kstate)
 			if err != nil {
 				klog.Errorf("deploy kubernetes %s: %s", k8s.Name, err.Error())
 			}
@@ -87,12 +126,51 @@ func (mgr *LocalK8sMgr) Redeploy(ctx context.Context, k8s *meta.Kubernetes) erro
 	return fmt.Errorf("another deploying is in progress: %s, wait for timeout", k8s.Name)
 }
 
+// deploy initializes the master and then joins the other masters and the
+// workers.
+func (mgr *LocalK8sMgr) deploy(ctx context.Context, kstate *k8sState) error {
+	if kstate.k8s.HighlyAvailable() {
+		err := mgr.ensureMasters(ctx, kstate)
+		if err != nil {
+			kstate.setState(Error, "boot masters: %v", err.Error())
+			return err
+		}
+	}
+	err := kstate.deploy(ctx)
+	if err != nil {
+		return err
+	}
+	err = mgr.joinMasters(ctx, kstate)
+	if err != nil {
+		return err
+	}
+	return mgr.reconcileNodes(ctx, kstate)
+}
+
+// Destroy removes the node vms and the cluster, it is refused while the
+// cluster is deploying, scaling or upgrading.
 func (mgr *LocalK8sMgr) Destroy(ctx context.Context, at string) error {
 
 	kstate := mgr.stateStore.Get(at)
 	if kstate == nil || kstate.k8s == nil {
 		return fmt.Errorf("k8s %s not found", at)
 	}
+	if !kstate.tryLock() {
+		return fmt.Errorf("another deploying is in progress: %s, wait for timeout", at)
+	}
+	defer kstate.unlock()
+	// the workers and the other masters are vms of the cluster, nothing
+	// to drain
+	nodes := lo.Filter(kstate.k8s.Nodes, func(n meta.KubernetesNode, _ int) bool {
+		return n.VmName != kstate.k8s.VmName
+	})
+	for _, n := range nodes {
+		err := mgr.vmMgr.Destroy(ctx, n.VmName)
+		if err != nil {
+			return errors.Wrapf(err, "destroy %s %s", n.Role, n.VmName)
+		}
+		kstate.removeNode(n.VmName)
+	}
 	vm := mgr.vmStateMgr.Get(kstate.k8s.VmName)
 	if vm.machine == nil {
 		klog.Errorf("correspond vm %s not found", kstate.k8s.VmName)
@@ -110,6 +188,7 @@ func (mgr *LocalK8sMgr) newK8sStateStore(bk meta.Backend) (*k8sStateStore, error
 	var vms = make(map[string]*k8sState)
 
 	for _, k8s := range machines {
+		backfillNodes(k8s)
 		vm := mgr.vmStateMgr.Get(k8s.VmName)
 		vms[k8s.Name] = &k8sState{
 			name:    k8s.Name,
@@ -122,6 +201,15 @@ func (mgr *LocalK8sMgr) newK8sStateStore(bk meta.Backend) (*k8sStateStore, error
 	return &k8sStateStore{mu: &sync.RWMutex{}, vmStateMgr: mgr.vmStateMgr, k8s: vms, meta: bk}, nil
 }
 
+// backfillNodes records the first master of a cluster created before nodes
+// were recorded, which is the vm of the cluster.
+func backfillNodes(k8s *meta.Kubernetes) {
+	if len(k8s.Nodes) > 0 || k8s.VmName == "" {
+		return
+	}
+	k8s.Nodes = []meta.KubernetesNode{{VmName: k8s.VmName, Role: v1.NodeRoleMaster, State: k8s.State}}
+}
+
 type k8sStateStore struct {
 	mu         *sync.RWMutex
 	meta       meta.Backend
@@ -195,6 +283,21 @@ type k8sState struct {
 
 func (st *k8sState) setState(state string, msg ...any) {
 	st.k8s.State, st.k8s.Message = state, fmtMessage(msg...)
+	st.save()
+}
+
+// setNode records the membership of a vm in the cluster.
+func (st *k8sState) setNode(n meta.KubernetesNode) {
+	st.k8s.SetNode(n)
+	st.save()
+}
+
+func (st *k8sState) removeNode(vm string) {
+	st.k8s.RemoveNode(vm)
+	st.save()
+}
+
+func (st *k8sState) save() {
 	err := st.meta.K8S().Update(st.k8s)
 	if err != nil {
 		klog.Errorf("update k8s %s state failed: %v", st.k8s.Name, err)
@@ -225,28 +328,34 @@ func (st *k8sState) tryLock() bool {
 	return false
 }
 
+func (st *k8sState) unlock() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.deploying = false
+}
+
+// deploy initializes the master, the caller holds the lock of tryLock.
 func (st *k8sState) deploy(ctx context.Context) error {
-	free := func() {
-		st.mu.Lock()
-		defer st.mu.Unlock()
-		st.deploying = false
-	}
-	defer free()
+	master := meta.KubernetesNode{VmName: st.k8s.VmName, Role: v1.NodeRoleMaster}
 	st.setState(Deploying, "k8s is in deploying")
 	out, err := st.vmState.SSH().RunCommand(ctx, st.k8s.VmName, getK8sCmd(ActionInstall, st.k8s))
 	if err != nil {
+		master.State, master.Message = Error, err.Error()
+		st.setNode(master)
 		st.setState(Error, "run command: %v", err.Error())
 		klog.Errorf("run deploy command result: %s, %s", err.Error(), out)
 		return errors.Wrap(err, "run install k8s command")
 	}
 	klog.Infof("install command result: %s", string(out))
-	addr := lo.FirstOr(st.vmState.machine.Spec.Networks, v1.Network{})
-	if addr.Address == "" {
-		st.setState(Error, "vm address not found: %v", addr)
+	addr := st.apiAddress()
+	if addr == "" {
+		st.setState(Error, "vm address not found: %s", st.k8s.VmName)
 		return fmt.Errorf("unexpected empty address: %s", st.k8s.Name)
 	}
 
-	err = st.setKubernetesContext(st.k8s, strings.Split(addr.Address, "/")[0])
+	master.State, master.Message = Running, "initialized"
+	st.setNode(master)
+	err = st.setKubernetesContext(st.k8s, addr)
 	if err == nil {
 		st.setState(Running, "k8s is running")
 		return nil
@@ -255,6 +364,15 @@ func (st *k8sState) deploy(ctx context.Context) error {
 	return errors.Wrap(err, "set k8s context")
 }
 
+// apiAddress is where the nodes and the kubeconfig reach the apiserver, the
+// virtual ip of multiple masters or the master vm.
+func (st *k8sState) apiAddress() string {
+	if st.k8s.VIP != "" {
+		return st.k8s.VIP
+	}
+	return vmAddress(st.vmState.machine)
+}
+
 func (st *k8sState) setKubernetesContext(k8s *meta.Kubernetes, addr string) error {
 	root := k8s.Spec.Config.TLS["root"]
 	if root == nil {
@@ -394,6 +512,31 @@ EOF
 			fmt.Sprintf(tpl, tool.PrettyYaml(req)),
 			"sudo /usr/local/bin/meridian-node destroy config.yml",
 		}
+	case v1.ActionJoin:
+		command = []string{
+			base,
+			fmt.Sprintf(tpl, tool.PrettyYaml(req)),
+			fmt.Sprintf("sudo /usr/local/bin/meridian-node join config.yml --role %s", v1.NodeRoleWorker),
+		}
+	case ActionJoinMaster:
+		command = []string{
+			base,
+			fmt.Sprintf(tpl, tool.PrettyYaml(req)),
+			fmt.Sprintf("sudo /usr/local/bin/meridian-node join config.yml --role %s", v1.NodeRoleMaster),
+		}
+	case ActionUpgrade, ActionUpgradeWorker:
+		role := lo.Ternary(action == ActionUpgrade, v1.NodeRoleMaster, v1.NodeRoleWorker)
+		command = []string{
+			base,
+			fmt.Sprintf(tpl, tool.PrettyYaml(req)),
+			fmt.Sprintf("sudo /usr/local/bin/meridian-node upgrade config.yml --role %s", role),
+		}
+	case ActionReset:
+		command = []string{
+			base,
+			fmt.Sprintf(tpl, tool.PrettyYaml(req)),
+			fmt.Sprintf("sudo /usr/local/bin/meridian-node destroy config.yml --role %s", v1.NodeRoleWorker),
+		}
 	}
 	return strings.Join(command, "\n")
 }
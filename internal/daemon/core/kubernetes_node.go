@@ -0,0 +1,276 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"k8s.io/klog/v2"
+)
+
+// workerName is the vm of the i-th worker of cluster.
+func workerName(cluster string, i int) string {
+	return fmt.Sprintf("%s-worker-%d", cluster, i)
+}
+
+// masterName is the vm of the i-th master of cluster besides the first one,
+// which is the vm the cluster is created on.
+func masterName(cluster string, i int) string {
+	return fmt.Sprintf("%s-master-%d", cluster, i)
+}
+
+// vmAddress is where the other vms reach vm.
+func vmAddress(vm *meta.Machine) string {
+	n := lo.FirstOr(vm.Spec.Networks, v1.Network{})
+	return strings.Split(n.Address, "/")[0]
+}
+
+// nodeAccessPoint points the apiserver domain of the nodes at the master
+// vm, the apiserver is reached directly on 6443 across the vms.
+func nodeAccessPoint(spec *v1.RequestSpec, master string) {
+	spec.AccessPoint.Internet = master
+	spec.AccessPoint.APIPort = "6443"
+}
+
+// workerSpec is the vm of a worker or of another master, sized and imaged
+// like the first master. The rest is defaulted, the vm gets an address of
+// its own.
+func workerSpec(master *v1.VirtualMachineSpec) *v1.VirtualMachineSpec {
+	return &v1.VirtualMachineSpec{
+		VMType:       master.VMType,
+		OS:           master.OS,
+		Arch:         master.Arch,
+		Image:        master.Image,
+		CPUs:         master.CPUs,
+		GuestVersion: master.GuestVersion,
+		Memory:       master.Memory,
+		Disk:         master.Disk,
+		Proxy:        master.Proxy.DeepCopy(),
+		Registries:   master.Registries.DeepCopy(),
+	}
+}
+
+// nodeCmd runs the kubectl commands on the node at addr, named $node in
+// them. It runs on the master.
+func nodeCmd(addr string, cmds ...string) string {
+	kubectl := "sudo /usr/local/bin/kubectl --kubeconfig /etc/kubernetes/admin.conf"
+	script := []string{
+		"set -e",
+		fmt.Sprintf(`node=$(%s get no -o wide --no-headers | awk '$6=="%s"{print $1}')`, kubectl, addr),
+		`[ -n "$node" ] || exit 0`,
+	}
+	for _, c := range cmds {
+		script = append(script, fmt.Sprintf("%s %s", kubectl, c))
+	}
+	return strings.Join(script, "\n")
+}
+
+const drain = "drain $node --ignore-daemonsets --delete-emptydir-data --force --timeout=180s"
+
+// drainCmd drains and deletes the node at addr.
+func drainCmd(addr string) string {
+	return nodeCmd(addr, drain, "delete node $node")
+}
+
+// ensureMasters boots the vms of the masters besides the first one and puts
+// all of them behind the virtual ip of the cluster.
+func (mgr *LocalK8sMgr) ensureMasters(ctx context.Context, st *k8sState) error {
+	if st.vmState == nil || st.vmState.machine == nil {
+		return fmt.Errorf("master vm %s not found", st.k8s.VmName)
+	}
+	backends := []v1.Endpoint{{Id: st.k8s.VmName, Ip: vmAddress(st.vmState.machine)}}
+	for i := 1; i < st.k8s.Masters; i++ {
+		name := masterName(st.name, i)
+		if st.k8s.GetNode(name) == nil {
+			st.setNode(meta.KubernetesNode{VmName: name, Role: v1.NodeRoleMaster, State: Created})
+		}
+		err := mgr.ensureNodeVm(ctx, st, name)
+		if err != nil {
+			st.setNode(meta.KubernetesNode{VmName: name, Role: v1.NodeRoleMaster, State: Error, Message: err.Error()})
+			return errors.Wrapf(err, "boot master %s", name)
+		}
+		vm := mgr.vmStateMgr.Get(name)
+		backends = append(backends, v1.Endpoint{Id: name, Ip: vmAddress(vm.machine)})
+	}
+	st.k8s.Spec.AccessPoint.Backends = backends
+	st.save()
+	return nil
+}
+
+// joinMasters joins the masters besides the first one into the control
+// plane one by one, each of them grows the etcd cluster by a member.
+func (mgr *LocalK8sMgr) joinMasters(ctx context.Context, st *k8sState) error {
+	for _, n := range st.k8s.MasterNodes() {
+		if n.VmName == st.k8s.VmName || n.State == Running {
+			continue
+		}
+		st.setNode(meta.KubernetesNode{VmName: n.VmName, Role: v1.NodeRoleMaster, State: Deploying, Message: "joining"})
+		err := mgr.runJoin(ctx, st, n.VmName, ActionJoinMaster)
+		if err != nil {
+			st.setNode(meta.KubernetesNode{VmName: n.VmName, Role: v1.NodeRoleMaster, State: Error, Message: err.Error()})
+			st.setState(Error, "join master %s: %v", n.VmName, err.Error())
+			return errors.Wrapf(err, "join master %s", n.VmName)
+		}
+		st.setNode(meta.KubernetesNode{VmName: n.VmName, Role: v1.NodeRoleMaster, State: Running, Message: "joined"})
+		klog.Infof("[%-10s]master %s joined", st.name, n.VmName)
+	}
+	return nil
+}
+
+// Scale joins or removes workers of the cluster name in background until it
+// has workers of them.
+func (mgr *LocalK8sMgr) Scale(ctx context.Context, name string, workers int) error {
+	kstate := mgr.stateStore.Get(name)
+	if kstate == nil {
+		return fmt.Errorf("k8s %s does not exists", name)
+	}
+	if workers < 0 {
+		return fmt.Errorf("workers must not be negative: %d", workers)
+	}
+	if !kstate.tryLock() {
+		return fmt.Errorf("another deploying is in progress: %s, wait for timeout", name)
+	}
+	kstate.k8s.Workers = workers
+	kstate.setState(kstate.k8s.State, "scale workers to %d", workers)
+	go func() {
+		defer kstate.unlock()
+		err := mgr.reconcileNodes(context.TODO(), kstate)
+		if err != nil {
+			klog.Errorf("scale kubernetes %s: %s", name, err.Error())
+		}
+	}()
+	return nil
+}
+
+// reconcileNodes removes the latest joined workers beyond k8s.Workers, then
+// retries the failed ones and joins new ones until there are enough.
+func (mgr *LocalK8sMgr) reconcileNodes(ctx context.Context, st *k8sState) error {
+	if st.vmState == nil || st.vmState.machine == nil {
+		return fmt.Errorf("master vm %s not found", st.k8s.VmName)
+	}
+	workers := st.k8s.WorkerNodes()
+	for i := len(workers) - 1; i >= st.k8s.Workers; i-- {
+		err := mgr.removeWorker(ctx, st, workers[i])
+		if err != nil {
+			return err
+		}
+	}
+	for _, n := range st.k8s.WorkerNodes() {
+		if n.State == Running {
+			continue
+		}
+		err := mgr.joinWorker(ctx, st, n.VmName)
+		if err != nil {
+			return err
+		}
+	}
+	for i := 1; len(st.k8s.WorkerNodes()) < st.k8s.Workers; i++ {
+		name := workerName(st.name, i)
+		if st.k8s.GetNode(name) != nil || mgr.vmStateMgr.Get(name) != nil {
+			continue
+		}
+		err := mgr.joinWorker(ctx, st, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinWorker creates and boots the vm name when missing and joins it into
+// the cluster as a worker.
+func (mgr *LocalK8sMgr) joinWorker(ctx context.Context, st *k8sState, name string) error {
+	st.setNode(meta.KubernetesNode{VmName: name, Role: v1.NodeRoleWorker, State: Deploying, Message: "joining"})
+	err := mgr.ensureNodeVm(ctx, st, name)
+	if err == nil {
+		err = mgr.runJoin(ctx, st, name, v1.ActionJoin)
+	}
+	if err != nil {
+		st.setNode(meta.KubernetesNode{VmName: name, Role: v1.NodeRoleWorker, State: Error, Message: err.Error()})
+		return errors.Wrapf(err, "join worker %s", name)
+	}
+	st.setNode(meta.KubernetesNode{VmName: name, Role: v1.NodeRoleWorker, State: Running, Message: "joined"})
+	klog.Infof("[%-10s]worker %s joined", st.name, name)
+	return nil
+}
+
+// ensureNodeVm creates the vm name like the first master when missing and
+// boots it.
+func (mgr *LocalK8sMgr) ensureNodeVm(ctx context.Context, st *k8sState, name string) error {
+	vm := mgr.vmStateMgr.Get(name)
+	if vm == nil {
+		err := mgr.vmMgr.Create(ctx, &meta.Machine{
+			Name:   name,
+			Spec:   workerSpec(st.vmState.machine.Spec),
+			AbsDir: path.Join(mgr.vmMgr.backend.Machine().Dir(), name),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if vm != nil && vm.machine.State == Running {
+		return nil
+	}
+	return mgr.vmMgr.startAndWait(ctx, name)
+}
+
+// runJoin runs the join action of a worker or a master on the vm name.
+func (mgr *LocalK8sMgr) runJoin(ctx context.Context, st *k8sState, name, action string) error {
+	vm := mgr.vmStateMgr.Get(name)
+	if vm == nil || vm.machine == nil {
+		return fmt.Errorf("vm %s not found", name)
+	}
+	err := mgr.cacheMgr.Forward(ctx, vm)
+	if err != nil {
+		klog.Warningf("[%-10s]forward image cache: %v", name, err)
+	}
+	spec := st.k8s.Spec.DeepCopy()
+	nodeAccessPoint(spec, st.apiAddress())
+	out, err := vm.SSH().RunCommand(ctx, name,
+		getK8sCmd(action, &meta.Kubernetes{Name: st.name, Spec: *spec}))
+	if err != nil {
+		klog.Errorf("run join command result: %s, %s", err.Error(), out)
+		return errors.Wrap(err, "run join k8s command")
+	}
+	return nil
+}
+
+// removeWorker drains and deletes the node from the cluster, resets it
+// with kubeadm and destroys its vm.
+func (mgr *LocalK8sMgr) removeWorker(ctx context.Context, st *k8sState, n meta.KubernetesNode) error {
+	fail := func(err error, msg string) error {
+		st.setNode(meta.KubernetesNode{VmName: n.VmName, Role: n.Role, State: Error, Message: err.Error()})
+		return errors.Wrapf(err, "%s %s", msg, n.VmName)
+	}
+	st.setNode(meta.KubernetesNode{VmName: n.VmName, Role: n.Role, State: Stopping, Message: "draining"})
+	vm := mgr.vmStateMgr.Get(n.VmName)
+	if vm != nil && vm.machine != nil {
+		out, err := st.vmState.SSH().RunCommand(ctx, st.vmState.name, drainCmd(vmAddress(vm.machine)))
+		if err != nil {
+			klog.Errorf("run drain command result: %s, %s", err.Error(), out)
+			return fail(err, "drain")
+		}
+		if vm.machine.State == Running {
+			spec := st.k8s.Spec.DeepCopy()
+			nodeAccessPoint(spec, st.apiAddress())
+			out, err = vm.SSH().RunCommand(ctx, n.VmName,
+				getK8sCmd(ActionReset, &meta.Kubernetes{Name: st.name, Spec: *spec}))
+			if err != nil {
+				klog.Errorf("run reset command result: %s, %s", err.Error(), out)
+				return fail(err, "reset")
+			}
+		}
+		err = mgr.vmMgr.Destroy(ctx, n.VmName)
+		if err != nil {
+			return fail(err, "destroy vm")
+		}
+	}
+	st.removeNode(n.VmName)
+	klog.Infof("[%-10s]worker %s removed", st.name, n.VmName)
+	return nil
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"testing"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+)
+
+func TestWorkerSpec(t *testing.T) {
+	master := &v1.VirtualMachineSpec{
+		CPUs:     4,
+		Memory:   "8GiB",
+		Image:    v1.ImageLocation{Name: "ubuntu"},
+		Networks: []v1.Network{{VZNAT: true, Address: "192.168.64.2/24", MACAddress: "52:55:55:00:00:01"}},
+		Registries: &v1.Registries{
+			Mirrors: map[string][]string{v1.DockerHub: {"https://mirror.example.com"}},
+		},
+	}
+	spec := workerSpec(master)
+	if spec.CPUs != 4 || spec.Memory != "8GiB" || spec.Image.Name != "ubuntu" {
+		t.Fatalf("worker should be sized like the master: %+v", spec)
+	}
+	if len(spec.Networks) != 0 {
+		t.Fatalf("worker must not reuse the network of the master: %+v", spec.Networks)
+	}
+	spec.Registries.Mirrors[v1.DockerHub][0] = "changed"
+	if master.Registries.Mirrors[v1.DockerHub][0] == "changed" {
+		t.Fatalf("worker registries must be a copy")
+	}
+	if vmAddress(&meta.Machine{Spec: master}) != "192.168.64.2" {
+		t.Fatalf("unexpected master address")
+	}
+}
+
+func TestKubernetesNodes(t *testing.T) {
+	k := &meta.Kubernetes{Name: "aoxn", VmName: "aoxn", Workers: 2}
+	k.SetNode(meta.KubernetesNode{VmName: "aoxn", Role: v1.NodeRoleMaster, State: Running})
+	for i := 1; i <= 2; i++ {
+		k.SetNode(meta.KubernetesNode{VmName: workerName(k.Name, i), Role: v1.NodeRoleWorker, State: Deploying})
+	}
+	k.SetNode(meta.KubernetesNode{VmName: "aoxn-worker-1", Role: v1.NodeRoleWorker, State: Running})
+	workers := k.WorkerNodes()
+	if len(workers) != 2 || workers[0].VmName != "aoxn-worker-1" || workers[0].State != Running {
+		t.Fatalf("unexpected workers: %+v", workers)
+	}
+	k.RemoveNode("aoxn-worker-1")
+	if k.GetNode("aoxn-worker-1") != nil || len(k.Nodes) != 2 {
+		t.Fatalf("unexpected nodes after remove: %+v", k.Nodes)
+	}
+}
+
+func TestVipMachine(t *testing.T) {
+	vms := []*meta.Machine{
+		{Name: "aoxn", Spec: &v1.VirtualMachineSpec{Networks: []v1.Network{{VZNAT: true, Address: "192.168.64.2/24"}}}},
+		vipMachine("other", "192.168.64.3"),
+	}
+	vip := vipMachine("aoxn", "")
+	err := allocateAddress(vip, vms)
+	if err != nil {
+		t.Fatalf("allocate vip: %s", err)
+	}
+	if vmAddress(vip) != "192.168.64.4" {
+		t.Fatalf("vip must skip the vms and the other vips: %s", vmAddress(vip))
+	}
+	k := &meta.Kubernetes{Name: "aoxn", Masters: 2}
+	if k.Validate() == nil {
+		t.Fatalf("even masters should be rejected")
+	}
+	k.Masters = 3
+	if k.Validate() != nil || !k.HighlyAvailable() {
+		t.Fatalf("3 masters should be highly available")
+	}
+}
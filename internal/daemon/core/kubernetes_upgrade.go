@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Upgrade upgrades the cluster name in place to version in background, the
+// masters first and then the workers one by one. A failed upgrade is
+// resumed by upgrading to the same version again, the upgraded nodes are
+// skipped.
+func (mgr *LocalK8sMgr) Upgrade(ctx context.Context, name, version string) error {
+	kstate := mgr.stateStore.Get(name)
+	if kstate == nil {
+		return fmt.Errorf("k8s %s does not exists", name)
+	}
+	version = v1.NormalizeKubernetesVersion(version)
+	k8s := kstate.k8s
+	if len(k8s.MasterNodes()) == 0 {
+		return fmt.Errorf("k8s %s has no master node recorded", name)
+	}
+	err := nodesRunning(mgr.vmStateMgr, k8s)
+	if err != nil {
+		return errors.Wrapf(err, "upgrade k8s %s", name)
+	}
+	switch k8s.UpgradeTo {
+	case "":
+		err = v1.ValidateKubernetesUpgrade(k8s.Spec.Config.Kubernetes.Version, version)
+		if err != nil {
+			return err
+		}
+	case version:
+	default:
+		return fmt.Errorf("upgrade to %s is unfinished, resume it first", k8s.UpgradeTo)
+	}
+	if !kstate.tryLock() {
+		return fmt.Errorf("another deploying is in progress: %s, wait for timeout", name)
+	}
+	k8s.UpgradeTo = version
+	kstate.setState(Upgrading, "upgrade %s -> %s", k8s.Spec.Config.Kubernetes.Version, version)
+	go func() {
+		defer kstate.unlock()
+		err := mgr.upgrade(context.TODO(), kstate)
+		if err != nil {
+			kstate.setState(Error, "upgrade to %s: %v", version, err.Error())
+			klog.Errorf("upgrade kubernetes %s: %s", name, err.Error())
+		}
+	}()
+	return nil
+}
+
+// nodesRunning checks that the vms of all nodes of k8s are running, the
+// upgrade runs its commands on them.
+func nodesRunning(vms *vmStateMgr, k8s *meta.Kubernetes) error {
+	for _, n := range k8s.Nodes {
+		vm := vms.Get(n.VmName)
+		if vm == nil || vm.machine == nil {
+			return fmt.Errorf("vm %s of %s not found", n.VmName, n.Role)
+		}
+		if vm.machine.State != Running {
+			return fmt.Errorf("vm %s of %s is %s, start it first", n.VmName, n.Role, vm.machine.State)
+		}
+	}
+	return nil
+}
+
+// upgrade rolls the nodes not yet at k8s.UpgradeTo, the caller holds the
+// lock of tryLock.
+func (mgr *LocalK8sMgr) upgrade(ctx context.Context, st *k8sState) error {
+	k8s := st.k8s
+	for _, n := range k8s.MasterNodes() {
+		if k8s.NodeVersion(n) == k8s.UpgradeTo {
+			continue
+		}
+		err := mgr.upgradeNode(ctx, st, n, ActionUpgrade)
+		if err != nil {
+			return err
+		}
+	}
+	for _, n := range k8s.WorkerNodes() {
+		if k8s.NodeVersion(n) == k8s.UpgradeTo {
+			continue
+		}
+		err := mgr.upgradeWorker(ctx, st, n)
+		if err != nil {
+			return err
+		}
+	}
+	k8s.Spec.Config.Kubernetes.Version, k8s.UpgradeTo = k8s.UpgradeTo, ""
+	st.setState(Running, "upgraded to %s", k8s.Spec.Config.Kubernetes.Version)
+	klog.Infof("[%-10s]kubernetes upgraded to %s", st.name, k8s.Spec.Config.Kubernetes.Version)
+	return nil
+}
+
+// upgradeWorker drains the worker on the master, upgrades it and makes it
+// schedulable again.
+func (mgr *LocalK8sMgr) upgradeWorker(ctx context.Context, st *k8sState, n meta.KubernetesNode) error {
+	vm := mgr.vmStateMgr.Get(n.VmName)
+	if vm == nil || vm.machine == nil {
+		return fmt.Errorf("vm %s not found", n.VmName)
+	}
+	addr := vmAddress(vm.machine)
+	n.State, n.Message = Upgrading, "draining"
+	st.setNode(n)
+	out, err := st.vmState.SSH().RunCommand(ctx, st.vmState.name, nodeCmd(addr, drain))
+	if err != nil {
+		klog.Errorf("run drain command result: %s, %s", err.Error(), out)
+		n.State, n.Message = Error, err.Error()
+		st.setNode(n)
+		return errors.Wrapf(err, "drain %s", n.VmName)
+	}
+	err = mgr.upgradeNode(ctx, st, n, ActionUpgradeWorker)
+	if err != nil {
+		return err
+	}
+	out, err = st.vmState.SSH().RunCommand(ctx, st.vmState.name, nodeCmd(addr, "uncordon $node"))
+	if err != nil {
+		klog.Errorf("run uncordon command result: %s, %s", err.Error(), out)
+		return errors.Wrapf(err, "uncordon %s", n.VmName)
+	}
+	return nil
+}
+
+// upgradeNode runs the upgrade action on the vm of n and records the
+// version of n on success.
+func (mgr *LocalK8sMgr) upgradeNode(ctx context.Context, st *k8sState, n meta.KubernetesNode, action string) error {
+	vm := mgr.vmStateMgr.Get(n.VmName)
+	if vm == nil || vm.machine == nil {
+		return fmt.Errorf("vm %s not found", n.VmName)
+	}
+	n.State, n.Message = Upgrading, fmt.Sprintf("upgrading to %s", st.k8s.UpgradeTo)
+	st.setNode(n)
+	spec := st.k8s.Spec.DeepCopy()
+	spec.Config.Kubernetes.Version = st.k8s.UpgradeTo
+	if action == ActionUpgradeWorker {
+		nodeAccessPoint(spec, st.apiAddress())
+	}
+	out, err := vm.SSH().RunCommand(ctx, n.VmName,
+		getK8sCmd(action, &meta.Kubernetes{Name: st.name, Spec: *spec}))
+	if err != nil {
+		klog.Errorf("run upgrade command result: %s, %s", err.Error(), out)
+		n.State, n.Message = Error, err.Error()
+		st.setNode(n)
+		return errors.Wrapf(err, "upgrade %s %s", n.Role, n.VmName)
+	}
+	n.State, n.Message, n.Version = Running, fmt.Sprintf("upgraded to %s", st.k8s.UpgradeTo), st.k8s.UpgradeTo
+	st.setNode(n)
+	klog.Infof("[%-10s]%s %s upgraded to %s", st.name, n.Role, n.VmName, st.k8s.UpgradeTo)
+	return nil
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+)
+
+func TestValidateKubernetesUpgrade(t *testing.T) {
+	for _, c := range []struct {
+		from, to string
+		ok       bool
+	}{
+		{"1.31.1-aliyun.1", "1.32.1-aliyun.1", true},
+		{"1.31.1-aliyun.1", "v1.32.1-aliyun.1", true},
+		{"1.31.1-aliyun.1", "1.31.1-aliyun.1", false},
+		{"1.32.1-aliyun.1", "1.31.1-aliyun.1", false},
+		{"1.30.2-aliyun.1", "1.32.1-aliyun.1", false},
+		{"1.31.1-aliyun.1", "1.33.0-aliyun.1", false},
+	} {
+		err := v1.ValidateKubernetesUpgrade(c.from, c.to)
+		if (err == nil) != c.ok {
+			t.Fatalf("upgrade %s -> %s: expect ok=%v, got %v", c.from, c.to, c.ok, err)
+		}
+	}
+}
+
+func TestNodeVersion(t *testing.T) {
+	k := &meta.Kubernetes{Name: "aoxn", VmName: "aoxn"}
+	k.Spec.Config.Kubernetes.Version = "1.31.1-aliyun.1"
+	k.SetNode(meta.KubernetesNode{VmName: "aoxn", Role: v1.NodeRoleMaster, State: Running, Version: "1.32.1-aliyun.1"})
+	k.SetNode(meta.KubernetesNode{VmName: "aoxn-worker-1", Role: v1.NodeRoleWorker, State: Running})
+	if v := k.NodeVersion(*k.GetNode("aoxn")); v != "1.32.1-aliyun.1" {
+		t.Fatalf("upgraded master should report its version: %s", v)
+	}
+	if v := k.NodeVersion(*k.GetNode("aoxn-worker-1")); v != "1.31.1-aliyun.1" {
+		t.Fatalf("worker should report the version of the spec: %s", v)
+	}
+	if cmd := nodeCmd("192.168.64.3", "uncordon $node"); !strings.Contains(cmd, "uncordon $node") || strings.Contains(cmd, "delete node") {
+		t.Fatalf("unexpected node command: %s", cmd)
+	}
+}
+
+func TestBackfillNodes(t *testing.T) {
+	k := &meta.Kubernetes{Name: "aoxn", VmName: "aoxn", State: Running}
+	backfillNodes(k)
+	masters := k.MasterNodes()
+	if len(masters) != 1 || masters[0].VmName != "aoxn" || masters[0].State != Running {
+		t.Fatalf("expect the vm of the cluster as its first master: %+v", k.Nodes)
+	}
+	k.SetNode(meta.KubernetesNode{VmName: "aoxn-worker-1", Role: v1.NodeRoleWorker, State: Running})
+	backfillNodes(k)
+	if len(k.Nodes) != 2 {
+		t.Fatalf("recorded nodes should be kept: %+v", k.Nodes)
+	}
+}
+
+func TestNodesRunning(t *testing.T) {
+	vm := func(name, state string) *vmState {
+		return &vmState{name: name, machine: &meta.Machine{Name: name, State: state}}
+	}
+	vms := &vmStateMgr{mu: &sync.RWMutex{}, vms: map[string]*vmState{
+		"aoxn":          vm("aoxn", Running),
+		"aoxn-worker-1": vm("aoxn-worker-1", Stopped),
+	}}
+	k := &meta.Kubernetes{Name: "aoxn", VmName: "aoxn"}
+	k.SetNode(meta.KubernetesNode{VmName: "aoxn", Role: v1.NodeRoleMaster, State: Running})
+	if err := nodesRunning(vms, k); err != nil {
+		t.Fatalf("expect the running master ready to upgrade: %s", err)
+	}
+	k.SetNode(meta.KubernetesNode{VmName: "aoxn-worker-1", Role: v1.NodeRoleWorker, State: Running})
+	err := nodesRunning(vms, k)
+	if err == nil || !strings.Contains(err.Error(), "aoxn-worker-1") {
+		t.Fatalf("expect the stopped worker refused: %v", err)
+	}
+	k.SetNode(meta.KubernetesNode{VmName: "aoxn-worker-2", Role: v1.NodeRoleWorker, State: Running})
+	vms.vms["aoxn-worker-1"].machine.State = Running
+	if err = nodesRunning(vms, k); err == nil {
+		t.Fatalf("expect the missing worker refused")
+	}
+}
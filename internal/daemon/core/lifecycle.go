@@ -0,0 +1,214 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	LifecycleVM = "lifecycle-vm"
+
+	// cpuActiveRatio is the guest cpu usage above which a vm is active
+	cpuActiveRatio = 0.05
+
+	ReasonExpired          = "Expired"
+	ReasonIdle             = "Idle"
+	ReasonExtended         = "Extended"
+	ReasonLifecycleWarning = "LifecycleWarning"
+)
+
+// idleState tracks the activity of a running vm between two ticks.
+type idleState struct {
+	// mu guards idleState and the Lifecycle of the vm spec
+	mu         sync.Mutex
+	lastActive time.Time
+	cpuBusy    uint64
+	cpuTotal   uint64
+	// warned is the deadline the last warning event was recorded for
+	warned time.Time
+}
+
+// reconcileLifecycle stops or deletes vms which are past their ExpireAt, or
+// running and idle for longer than IdleTimeout.
+func (mgr *LocalVMMgr) reconcileLifecycle() {
+	now := time.Now()
+	for _, state := range mgr.stateMgr.States() {
+		lc, reason, due := state.lifecycleDue(now)
+		if !due {
+			continue
+		}
+		name := state.name
+		err := mgr.tskMgr.Send(LifecycleVM, name, func(ctx context.Context) error {
+			state.event(reason, "%s vm %s", action(lc), name)
+			switch lc.Action {
+			case v1.LifecycleDelete:
+				return mgr.Destroy(ctx, name)
+			default:
+				return mgr.Stop(ctx, name)
+			}
+		})
+		if err != nil {
+			klog.V(5).Infof("[%s]lifecycle action: %v", name, err)
+		}
+	}
+}
+
+// lifecycleDue returns the lifecycle of the vm and why it is due for the
+// lifecycle action, a warning event is recorded within the grace period.
+func (m *vmState) lifecycleDue(now time.Time) (*v1.Lifecycle, string, bool) {
+	m.idle.mu.Lock()
+	defer m.idle.mu.Unlock()
+	lc := m.machine.Spec.Lifecycle
+	running := m.machine.State == Running
+	if !running {
+		// the idle window starts over on next boot
+		m.idle.lastActive, m.idle.cpuBusy, m.idle.cpuTotal = time.Time{}, 0, 0
+	}
+	if lc == nil || !m.lifecycleApplies() {
+		return nil, "", false
+	}
+	reason, deadline := m.lifecycleDeadline(now, running)
+	if deadline.IsZero() {
+		return nil, "", false
+	}
+	if now.Before(deadline) {
+		if now.Add(lc.Grace()).After(deadline) && !m.idle.warned.Equal(deadline) {
+			m.idle.warned = deadline
+			m.event(ReasonLifecycleWarning, "vm will be %s in %s: %s",
+				action(lc), deadline.Sub(now).Round(time.Second), reason)
+		}
+		return nil, "", false
+	}
+	return lc, reason, true
+}
+
+// Extend moves the expiry of vm name forward by d and restarts its idle
+// window.
+func (mgr *LocalVMMgr) Extend(ctx context.Context, name string, d time.Duration) error {
+	state := mgr.stateMgr.Get(name)
+	if state == nil || state.machine == nil {
+		return fmt.Errorf("vm %s not found", name)
+	}
+	state.idle.mu.Lock()
+	defer state.idle.mu.Unlock()
+	lc := state.machine.Spec.Lifecycle
+	if lc == nil || (lc.ExpireAt == nil && lc.IdleTimeout == nil) {
+		return fmt.Errorf("vm %s has neither ttl nor idle timeout", name)
+	}
+	state.idle.lastActive = time.Now()
+	if lc.ExpireAt == nil {
+		state.event(ReasonExtended, "idle window restarted")
+		return nil
+	}
+	base := time.Now()
+	if lc.ExpireAt.After(base) {
+		base = lc.ExpireAt.Time
+	}
+	expire := metav1.NewTime(base.Add(d))
+	lc.ExpireAt = &expire
+	state.event(ReasonExtended, "vm expires at %s", expire.Format(time.RFC3339))
+	return nil
+}
+
+// lifecycleApplies reports whether the lifecycle action changes the vm in
+// its current state. A stopped vm is deleted on expiry but not stopped again,
+// a vm in transition is left alone.
+func (m *vmState) lifecycleApplies() bool {
+	switch m.machine.State {
+	case Running, Error:
+		return true
+	case Created, Stopped:
+		return m.machine.Spec.Lifecycle.Action == v1.LifecycleDelete
+	}
+	return false
+}
+
+// lifecycleDeadline returns the earliest of expiry and idle deadline, the
+// idle deadline counts only for a running vm.
+func (m *vmState) lifecycleDeadline(now time.Time, running bool) (string, time.Time) {
+	var (
+		lc       = m.machine.Spec.Lifecycle
+		reason   string
+		deadline time.Time
+	)
+	if lc.ExpireAt != nil {
+		reason, deadline = ReasonExpired, lc.ExpireAt.Time
+	}
+	if lc.IdleTimeout != nil && running {
+		if m.idle.lastActive.IsZero() || m.active(now) {
+			m.idle.lastActive = now
+		}
+		idle := m.idle.lastActive.Add(lc.IdleTimeout.Duration)
+		if deadline.IsZero() || idle.Before(deadline) {
+			reason, deadline = ReasonIdle, idle
+		}
+	}
+	return reason, deadline
+}
+
+// active reports whether the vm has ssh sessions, forwarded connections or
+// guest cpu usage since last check. A vm which can not be inspected is
+// considered active.
+func (m *vmState) active(now time.Time) bool {
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	guest, err := activityOf(ctx, m.machine.GuestSock())
+	if err != nil {
+		klog.V(5).Infof("[%s]guest activity: %v", m.name, err)
+		return true
+	}
+	host, err := activityOf(ctx, m.machine.SandboxSock())
+	if err != nil {
+		klog.V(5).Infof("[%s]sandbox activity: %v", m.name, err)
+		return true
+	}
+	return m.activeBy(guest, host)
+}
+
+// activeBy reports whether the guest and host activity since last check
+// keep the vm active.
+func (m *vmState) activeBy(guest, host *v1.Activity) bool {
+	var (
+		busy  = guest.CPUBusy - m.idle.cpuBusy
+		total = guest.CPUTotal - m.idle.cpuTotal
+		first = m.idle.cpuTotal == 0
+	)
+	m.idle.cpuBusy, m.idle.cpuTotal = guest.CPUBusy, guest.CPUTotal
+	switch {
+	case guest.SSHSessions > 0, host.Connections > 0:
+		return true
+	case host.LastActive != nil && host.LastActive.After(m.idle.lastActive):
+		return true
+	case !first && total > 0 && float64(busy)/float64(total) > cpuActiveRatio:
+		return true
+	}
+	return false
+}
+
+func activityOf(ctx context.Context, sock string) (*v1.Activity, error) {
+	c, err := client.Client(sock)
+	if err != nil {
+		return nil, err
+	}
+	var act v1.Activity
+	err = c.List(ctx, "activity", &act)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get activity from %s", sock)
+	}
+	return &act, nil
+}
+
+func action(lc *v1.Lifecycle) string {
+	if lc.Action == v1.LifecycleDelete {
+		return "deleted"
+	}
+	return "stopped"
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLifecycleDeadlineStopped(t *testing.T) {
+	now := time.Now()
+	expire := metav1.NewTime(now.Add(-time.Minute))
+	lc := &v1.Lifecycle{
+		ExpireAt:    &expire,
+		IdleTimeout: &metav1.Duration{Duration: time.Second},
+		Action:      v1.LifecycleDelete,
+	}
+	vm := &vmState{name: "aoxn", machine: &meta.Machine{
+		Name:  "aoxn",
+		State: Stopped,
+		Spec:  &v1.VirtualMachineSpec{Lifecycle: lc},
+	}}
+	if !vm.lifecycleApplies() {
+		t.Fatalf("an expired stopped vm should still be deleted")
+	}
+	reason, deadline := vm.lifecycleDeadline(now, false)
+	if reason != ReasonExpired || !deadline.Equal(expire.Time) {
+		t.Fatalf("a stopped vm should expire by its ttl only: %s %s", reason, deadline)
+	}
+	lc.Action = v1.LifecycleStop
+	if vm.lifecycleApplies() {
+		t.Fatalf("a stopped vm should not be stopped again")
+	}
+	vm.machine.State = Error
+	if !vm.lifecycleApplies() {
+		t.Fatalf("an expired vm in error should be stopped")
+	}
+}
+
+func TestLifecycleIdleDocker(t *testing.T) {
+	bk, err := meta.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("new backend: %s", err)
+	}
+	newVM := func(name string, lastActive time.Time) (*vmState, *v1.Activity) {
+		vm := &vmState{name: name, meta: bk, mu: &sync.RWMutex{}, machine: &meta.Machine{
+			Name:   name,
+			AbsDir: t.TempDir(),
+			State:  Running,
+			Spec: &v1.VirtualMachineSpec{Lifecycle: &v1.Lifecycle{
+				IdleTimeout: &metav1.Duration{Duration: 5 * time.Minute},
+				GracePeriod: &metav1.Duration{Duration: 2 * time.Minute},
+			}},
+		}}
+		vm.idle.lastActive = lastActive
+		vm.idle.cpuBusy, vm.idle.cpuTotal = 100, 10000
+		serveActivity(t, vm.machine.GuestSock(), &v1.Activity{CPUBusy: 101, CPUTotal: 20000})
+		// the sandbox leaves the docker probe out of its activity
+		host := &v1.Activity{}
+		serveActivity(t, vm.machine.SandboxSock(), host)
+		return vm, host
+	}
+	var (
+		now          = time.Now()
+		idle, _      = newVM("idle", now.Add(-10*time.Minute))
+		warned, _    = newVM("warned", now.Add(-4*time.Minute))
+		used, client = newVM("used", now.Add(-10*time.Minute))
+	)
+	recent := metav1.NewTime(now.Add(-10 * time.Second))
+	client.LastActive = &recent
+	mgr := &LocalVMMgr{
+		tskMgr: newTaskMgr(),
+		stateMgr: &vmStateMgr{mu: &sync.RWMutex{}, meta: bk, vms: map[string]*vmState{
+			"idle": idle, "warned": warned, "used": used,
+		}},
+	}
+	mgr.reconcileLifecycle()
+
+	hasEvent := func(vm *vmState, reason string) bool {
+		return lo.ContainsBy(vm.machine.Events, func(e v1.Event) bool { return e.Reason == reason })
+	}
+	if !hasEvent(warned, ReasonLifecycleWarning) {
+		t.Fatalf("an idle docker vm within grace period should be warned: %v", warned.machine.Events)
+	}
+	if hasEvent(used, ReasonLifecycleWarning) || !used.idle.lastActive.After(now) {
+		t.Fatalf("a recent docker client should keep the vm active: %v", used.machine.Events)
+	}
+	for i := 0; i < 100; i++ {
+		idle.mu.RLock()
+		state := idle.machine.State
+		idle.mu.RUnlock()
+		if state == Stopped {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("an idle docker vm should be stopped")
+}
+
+// serveActivity answers the activity api of a guest or sandbox on sock.
+func serveActivity(t *testing.T, sock string, act *v1.Activity) {
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen %s: %s", sock, err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(act)
+	})}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+}
+
+func TestLifecycleExtendConcurrent(t *testing.T) {
+	bk, err := meta.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("new backend: %s", err)
+	}
+	expire := metav1.NewTime(time.Now().Add(time.Hour))
+	vm := &vmState{name: "aoxn", meta: bk, mu: &sync.RWMutex{}, machine: &meta.Machine{
+		Name:  "aoxn",
+		State: Stopped,
+		Spec: &v1.VirtualMachineSpec{Lifecycle: &v1.Lifecycle{
+			ExpireAt: &expire,
+			Action:   v1.LifecycleDelete,
+		}},
+	}}
+	mgr := &LocalVMMgr{stateMgr: &vmStateMgr{mu: &sync.RWMutex{}, vms: map[string]*vmState{"aoxn": vm}, meta: bk}}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			vm.lifecycleDue(time.Now())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_ = mgr.Extend(context.TODO(), "aoxn", time.Minute)
+		}
+	}()
+	wg.Wait()
+	if want := expire.Add(50 * time.Minute); !vm.machine.Spec.Lifecycle.ExpireAt.Time.Equal(want) {
+		t.Fatalf("expect expiry %s, got %s", want, vm.machine.Spec.Lifecycle.ExpireAt)
+	}
+}
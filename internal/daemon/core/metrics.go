@@ -0,0 +1,146 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/pkg/errors"
+	proc "github.com/shirou/gopsutil/v4/process"
+	"k8s.io/klog/v2"
+)
+
+// metricsState keeps the previous guest sample and the sandbox process
+// handle, so that rates are computed between two consecutive requests.
+type metricsState struct {
+	mu   sync.Mutex
+	last *v1.GuestMetrics
+	proc *proc.Process
+}
+
+// Metrics returns guest and sandbox process metrics of running vm name.
+func (mgr *LocalVMMgr) Metrics(ctx context.Context, name string) (*v1.VirtualMachineMetrics, error) {
+	state := mgr.stateMgr.Get(name)
+	if state == nil || state.machine == nil {
+		return nil, fmt.Errorf("vm %s not found", name)
+	}
+	if state.machine.State != Running {
+		return nil, fmt.Errorf("vm %s is not running: %s", name, state.machine.State)
+	}
+	return state.collectMetrics(ctx), nil
+}
+
+// ListMetrics returns metrics of all running vms.
+func (mgr *LocalVMMgr) ListMetrics(ctx context.Context) []*v1.VirtualMachineMetrics {
+	var result []*v1.VirtualMachineMetrics
+	for _, state := range mgr.stateMgr.States() {
+		if state.machine.State != Running {
+			continue
+		}
+		result = append(result, state.collectMetrics(ctx))
+	}
+	return result
+}
+
+// collectMetrics never fails, a side which can not be inspected is left
+// empty and explained in Message.
+func (m *vmState) collectMetrics(ctx context.Context) *v1.VirtualMachineMetrics {
+	m.metrics.mu.Lock()
+	defer m.metrics.mu.Unlock()
+
+	result := &v1.VirtualMachineMetrics{Name: m.name}
+	host, err := m.hostMetrics(ctx)
+	if err != nil {
+		klog.V(5).Infof("[%s]sandbox metrics: %v", m.name, err)
+		result.Message = err.Error()
+	}
+	result.Host = host
+
+	guest, err := guestMetrics(ctx, m.machine.GuestSock())
+	if err != nil {
+		klog.V(5).Infof("[%s]guest metrics: %v", m.name, err)
+		result.Message = err.Error()
+		return result
+	}
+	result.Guest = guest
+	rates(result, m.metrics.last, guest)
+	m.metrics.last = guest
+	return result
+}
+
+func (m *vmState) hostMetrics(ctx context.Context) (*v1.ProcessMetrics, error) {
+	pid, err := m.machine.LoadPID()
+	if err != nil {
+		return nil, errors.Wrapf(err, "load sandbox pid")
+	}
+	if m.metrics.proc == nil || m.metrics.proc.Pid != pid {
+		p, err := proc.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "find sandbox process %d", pid)
+		}
+		m.metrics.proc = p
+	}
+	// Percent with zero interval is relative to the previous call
+	cpu, err := m.metrics.proc.PercentWithContext(ctx, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sandbox cpu")
+	}
+	mem, err := m.metrics.proc.MemoryInfoWithContext(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sandbox memory")
+	}
+	return &v1.ProcessMetrics{PID: pid, CPUPercent: cpu, RSS: mem.RSS}, nil
+}
+
+// rates fills the guest cpu usage and io rates between last and cur. Without
+// a previous sample cpu usage is averaged since guest boot.
+func rates(r *v1.VirtualMachineMetrics, last, cur *v1.GuestMetrics) {
+	if last == nil || cur.CPUTotal <= last.CPUTotal {
+		if cur.CPUTotal > 0 {
+			r.CPUPercent = 100 * float64(cur.CPUBusy) / float64(cur.CPUTotal)
+		}
+		return
+	}
+	r.CPUPercent = 100 * float64(cur.CPUBusy-last.CPUBusy) / float64(cur.CPUTotal-last.CPUTotal)
+	seconds := cur.Timestamp.Sub(last.Timestamp.Time).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	r.DiskRead, r.DiskWrite = ioRate(last.Disks, cur.Disks, seconds)
+	r.NetRx, r.NetTx = ioRate(last.Networks, cur.Networks, seconds)
+}
+
+func ioRate(last, cur []v1.IOMetrics, seconds float64) (float64, float64) {
+	prev := map[string]v1.IOMetrics{}
+	for _, io := range last {
+		prev[io.Name] = io
+	}
+	var rx, tx float64
+	for _, io := range cur {
+		p, ok := prev[io.Name]
+		if !ok || io.RxBytes < p.RxBytes || io.TxBytes < p.TxBytes {
+			continue
+		}
+		rx += float64(io.RxBytes - p.RxBytes)
+		tx += float64(io.TxBytes - p.TxBytes)
+	}
+	return rx / seconds, tx / seconds
+}
+
+func guestMetrics(ctx context.Context, sock string) (*v1.GuestMetrics, error) {
+	c, err := client.Client(sock)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var m v1.GuestMetrics
+	err = c.List(ctx, "metrics", &m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get metrics from %s", sock)
+	}
+	return &m, nil
+}
@@ -0,0 +1,291 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/tool"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ReplenishPool = "replenish-pool"
+	ReleasePoolVM = "release-pool-vm"
+)
+
+func NewLocalPoolMgr(vmMgr *LocalVMMgr) *LocalPoolMgr {
+	mgr := &LocalPoolMgr{
+		mu:       &sync.Mutex{},
+		creating: map[string]int{},
+		tskMgr:   vmMgr.tskMgr,
+		vmMgr:    vmMgr,
+	}
+	go mgr.periodical()
+	return mgr
+}
+
+// LocalPoolMgr keeps MinReady booted vms per pool which can be claimed
+// instantly. A released vm is destroyed and replaced in background.
+type LocalPoolMgr struct {
+	// mu serializes claims and replenishment so that a ready vm is handed
+	// out only once and a pool never grows beyond Max
+	mu *sync.Mutex
+	// creating counts the vms of each pool being created outside of mu
+	creating map[string]int
+	tskMgr   *taskMgr
+	vmMgr    *LocalVMMgr
+}
+
+func (mgr *LocalPoolMgr) periodical() {
+	wait.Until(func() {
+		pools, err := mgr.vmMgr.backend.Pool().List()
+		if err != nil {
+			klog.Errorf("periodical list pool: %v", err)
+			return
+		}
+		for _, p := range pools {
+			mgr.replenish(p)
+		}
+	}, 5*time.Second, make(<-chan struct{}))
+}
+
+func (mgr *LocalPoolMgr) Create(ctx context.Context, p *meta.Pool) error {
+	if p.Release == "" {
+		p.Release = meta.ReleaseDestroy
+	}
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+	err = mgr.vmMgr.backend.Pool().Create(p)
+	if err != nil {
+		return errors.Wrapf(err, "create pool %s", p.Name)
+	}
+	mgr.replenish(p)
+	return nil
+}
+
+// Destroy removes the pool and all its unclaimed vms. Claimed vms are kept
+// and become ordinary vms.
+func (mgr *LocalPoolMgr) Destroy(ctx context.Context, name string) error {
+	p, err := mgr.vmMgr.backend.Pool().Get(name)
+	if err != nil {
+		return errors.Wrapf(err, "get pool %s", name)
+	}
+	err = mgr.vmMgr.backend.Pool().Remove(p)
+	if err != nil {
+		return errors.Wrapf(err, "remove pool %s", name)
+	}
+	for _, m := range mgr.members(name) {
+		if m.Claimed {
+			m.Pool, m.Claimed = "", false
+			err = mgr.vmMgr.backend.Machine().Update(m)
+			if err != nil {
+				klog.Errorf("[%s]detach claimed vm %s: %v", name, m.Name, err)
+			}
+			continue
+		}
+		mgr.release(m.Name)
+	}
+	return nil
+}
+
+func (mgr *LocalPoolMgr) Get(name string) (*meta.Pool, error) {
+	p, err := mgr.vmMgr.backend.Pool().Get(name)
+	if err != nil {
+		return nil, err
+	}
+	mgr.status(p)
+	return p, nil
+}
+
+func (mgr *LocalPoolMgr) List() ([]*meta.Pool, error) {
+	pools, err := mgr.vmMgr.backend.Pool().List()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pools {
+		mgr.status(p)
+	}
+	return pools, nil
+}
+
+// Claim hands out a running vm whose guest agent is healthy.
+func (mgr *LocalPoolMgr) Claim(ctx context.Context, name string) (*meta.Machine, error) {
+	p, err := mgr.vmMgr.backend.Pool().Get(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get pool %s", name)
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	for _, m := range mgr.members(name) {
+		if m.Claimed || m.State != Running {
+			continue
+		}
+		if !guestHealthy(ctx, m) {
+			klog.V(5).Infof("[%s]vm %s guest agent not ready, skip", name, m.Name)
+			continue
+		}
+		m.Claimed = true
+		err = mgr.vmMgr.backend.Machine().Update(m)
+		if err != nil {
+			m.Claimed = false
+			return nil, errors.Wrapf(err, "claim vm %s", m.Name)
+		}
+		klog.Infof("[%s]vm %s claimed", name, m.Name)
+		go mgr.replenish(p)
+		return m, nil
+	}
+	go mgr.replenish(p)
+	return nil, fmt.Errorf("NoReadyVM: pool %s has no ready vm, retry later", name)
+}
+
+// Release gives a claimed vm back to the pool.
+func (mgr *LocalPoolMgr) Release(ctx context.Context, name, vm string) error {
+	state := mgr.vmMgr.stateMgr.Get(vm)
+	if state == nil || state.machine == nil {
+		return fmt.Errorf("vm %s not found", vm)
+	}
+	if state.machine.Pool != name {
+		return fmt.Errorf("vm %s does not belong to pool %s", vm, name)
+	}
+	if !state.machine.Claimed {
+		return fmt.Errorf("vm %s is not claimed", vm)
+	}
+	mgr.release(vm)
+	return nil
+}
+
+func (mgr *LocalPoolMgr) release(vm string) {
+	err := mgr.tskMgr.Send(ReleasePoolVM, vm, func(ctx context.Context) error {
+		klog.Infof("release pool vm: %s", vm)
+		return mgr.vmMgr.Destroy(ctx, vm)
+	})
+	if err != nil {
+		klog.Errorf("release pool vm %s: %v", vm, err)
+	}
+}
+
+// replenish creates vms until MinReady unclaimed vms exist, bounded by Max.
+// The vms are created one by one without holding mu, so that claims are not
+// blocked, and the counts are checked again before each of them.
+func (mgr *LocalPoolMgr) replenish(p *meta.Pool) {
+	for first := true; ; first = false {
+		if !mgr.reserve(p, first) {
+			return
+		}
+		err := mgr.create(p)
+
+		mgr.mu.Lock()
+		mgr.creating[p.Name]--
+		mgr.mu.Unlock()
+		if err != nil {
+			klog.Errorf("[%s]replenish: %v", p.Name, err)
+			return
+		}
+	}
+}
+
+// reserve counts a vm to be created in creating when the pool has less than
+// MinReady unclaimed vms and less than Max vms. Vms in error are recycled
+// and stopped ones booted on the first check.
+func (mgr *LocalPoolMgr) reserve(p *meta.Pool, first bool) bool {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	var (
+		total   = mgr.creating[p.Name]
+		standby = mgr.creating[p.Name]
+	)
+	for _, m := range mgr.members(p.Name) {
+		total++
+		if m.Claimed {
+			continue
+		}
+		switch m.State {
+		case Error:
+			if first {
+				klog.Infof("[%s]vm %s in error state, recycle: %s", p.Name, m.Name, m.Message)
+				mgr.release(m.Name)
+			}
+			continue
+		case Stopped:
+			if first {
+				mgr.boot(m.Name)
+			}
+		}
+		standby++
+	}
+	if standby >= p.MinReady || total >= p.Max {
+		return false
+	}
+	mgr.creating[p.Name]++
+	return true
+}
+
+func (mgr *LocalPoolMgr) create(p *meta.Pool) error {
+	spec, err := p.VMSpec()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s", p.Name, tool.RandomID(6))
+	vm := &meta.Machine{
+		Name:   name,
+		Spec:   spec,
+		Pool:   p.Name,
+		AbsDir: path.Join(mgr.vmMgr.backend.Machine().Dir(), name),
+	}
+	err = mgr.vmMgr.Create(context.TODO(), vm)
+	if err != nil {
+		return errors.Wrapf(err, "create vm %s", vm.Name)
+	}
+	klog.Infof("[%s]replenish vm %s", p.Name, vm.Name)
+	mgr.boot(vm.Name)
+	return nil
+}
+
+func (mgr *LocalPoolMgr) boot(vm string) {
+	// errors are expected when a boot task for vm is already in flight
+	_ = mgr.tskMgr.Send(ReplenishPool, vm, func(ctx context.Context) error {
+		return mgr.vmMgr.startAndWait(ctx, vm)
+	})
+}
+
+func (mgr *LocalPoolMgr) members(name string) []*meta.Machine {
+	var machines []*meta.Machine
+	for _, m := range mgr.vmMgr.stateMgr.List() {
+		if m.Pool == name {
+			machines = append(machines, m)
+		}
+	}
+	return machines
+}
+
+func (mgr *LocalPoolMgr) status(p *meta.Pool) {
+	p.Ready, p.Claimed, p.Total = 0, 0, 0
+	for _, m := range mgr.members(p.Name) {
+		p.Total++
+		switch {
+		case m.Claimed:
+			p.Claimed++
+		case m.State == Running:
+			p.Ready++
+		}
+	}
+}
+
+func guestHealthy(ctx context.Context, m *meta.Machine) bool {
+	ga, err := client.Client(m.GuestSock())
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return ga.Healthz(ctx) == nil
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/pkg/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ReasonProvisioning    = "Provisioning"
+	ReasonProvisioned     = "Provisioned"
+	ReasonProvisionFailed = "ProvisionFailed"
+)
+
+// reconcileProvision polls the guest agent of running vms for the status of
+// their provision steps and drives the Provisioned condition.
+func (mgr *LocalVMMgr) reconcileProvision() {
+	for _, state := range mgr.stateMgr.States() {
+		if len(state.machine.Spec.Provision) == 0 {
+			continue
+		}
+		if state.machine.State != Running {
+			state.resetProvision()
+			continue
+		}
+		status, err := guestProvision(context.TODO(), state.machine.GuestSock())
+		if err != nil {
+			klog.V(5).Infof("[%s]provision status: %v", state.name, err)
+			continue
+		}
+		state.setProvision(status)
+	}
+}
+
+// setProvision persists status and the Provisioned condition when any of
+// them changes.
+func (m *vmState) setProvision(status *v1.ProvisionStatus) {
+	cond := metav1.Condition{
+		Type:    v1.ConditionProvisioned,
+		Status:  metav1.ConditionFalse,
+		Reason:  ReasonProvisioning,
+		Message: fmt.Sprintf("%d/%d steps finished", finished(status), len(status.Steps)),
+	}
+	var failed []string
+	for _, s := range status.Steps {
+		if s.Phase == v1.ProvisionFailed {
+			failed = append(failed, fmt.Sprintf("%s exited with %d", s.Name, s.ExitCode))
+		}
+	}
+	switch {
+	case len(failed) > 0:
+		cond.Reason, cond.Message = ReasonProvisionFailed, strings.Join(failed, "; ")
+	case status.Done:
+		cond.Status, cond.Reason, cond.Message = metav1.ConditionTrue, ReasonProvisioned, "all steps succeeded"
+	}
+	changed := !reflect.DeepEqual(m.machine.Status.Provision, status)
+	m.machine.Status.Provision = status
+	if apimeta.SetStatusCondition(&m.machine.Status.Conditions, cond) {
+		changed = true
+		if cond.Reason != ReasonProvisioning {
+			m.event(cond.Reason, cond.Message)
+		}
+	}
+	if !changed {
+		return
+	}
+	err := m.meta.Machine().Update(m.machine)
+	if err != nil {
+		klog.Errorf("update machine %s provision status failed: %v", m.machine.Name, err)
+	}
+}
+
+// resetProvision forgets the status of the last boot, the steps run again
+// on the next one.
+func (m *vmState) resetProvision() {
+	if m.machine.Status.Provision == nil {
+		return
+	}
+	m.machine.Status.Provision = nil
+	apimeta.SetStatusCondition(&m.machine.Status.Conditions, metav1.Condition{
+		Type:    v1.ConditionProvisioned,
+		Status:  metav1.ConditionFalse,
+		Reason:  m.machine.State,
+		Message: "vm is not running",
+	})
+	err := m.meta.Machine().Update(m.machine)
+	if err != nil {
+		klog.Errorf("update machine %s provision status failed: %v", m.machine.Name, err)
+	}
+}
+
+func finished(status *v1.ProvisionStatus) int {
+	n := 0
+	for _, s := range status.Steps {
+		if s.Phase == v1.ProvisionSucceeded || s.Phase == v1.ProvisionFailed {
+			n++
+		}
+	}
+	return n
+}
+
+func guestProvision(ctx context.Context, sock string) (*v1.ProvisionStatus, error) {
+	c, err := client.Client(sock)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var status v1.ProvisionStatus
+	err = c.List(ctx, "provision", &status)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get provision status from %s", sock)
+	}
+	return &status, nil
+}
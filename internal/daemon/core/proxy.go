@@ -0,0 +1,55 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// daemonProxy is the proxy in the config dir, the default of every vm. It
+// is nil when no proxy is configured.
+func (mgr *LocalVMMgr) daemonProxy() (*v1.HTTPProxy, error) {
+	file := filepath.Join(mgr.backend.Config().Dir(), v1.ProxyConfig)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "read %s", file)
+	}
+	var proxy v1.HTTPProxy
+	err = yaml.Unmarshal(data, &proxy)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %s", file)
+	}
+	return &proxy, nil
+}
+
+// setProxy merges the proxy of the daemon into the vm on create. The merged
+// proxy is kept in the vm spec, an updated proxy.yaml applies only to the
+// vms created after it.
+func (mgr *LocalVMMgr) setProxy(spec *v1.VirtualMachineSpec) error {
+	dft, err := mgr.daemonProxy()
+	if err != nil {
+		return err
+	}
+	spec.Proxy = spec.Proxy.Merge(dft)
+	if spec.Proxy == nil {
+		return nil
+	}
+	return spec.Proxy.Validate()
+}
+
+// clusterProxy is the proxy of the vm for the runtime and the kubelet, the
+// vm subnets are not proxied.
+func clusterProxy(spec *v1.VirtualMachineSpec) *v1.HTTPProxy {
+	if spec.Proxy == nil {
+		return nil
+	}
+	proxy := spec.Proxy.DeepCopy()
+	proxy.NoProxy = append(proxy.NoProxy, v1.Subnets(spec.Networks)...)
+	return proxy
+}
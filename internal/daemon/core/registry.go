@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/tool"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// resolveRegistries reads the credentials referenced by r into a copy of
+// it for the guest.
+func resolveRegistries(r *v1.Registries) (*v1.Registries, error) {
+	if r == nil {
+		return nil, nil
+	}
+	out := r.DeepCopy()
+	for i := range out.Auths {
+		a := &out.Auths[i]
+		if a.File == "" {
+			continue
+		}
+		data, err := os.ReadFile(a.File)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read credentials of %s", a.Registry)
+		}
+		cred := strings.TrimSpace(string(data))
+		if !strings.Contains(cred, ":") {
+			return nil, fmt.Errorf("credentials of %s: expect username:password in %s", a.Registry, a.File)
+		}
+		a.Auth, a.File = base64.StdEncoding.EncodeToString([]byte(cred)), ""
+	}
+	return out, nil
+}
+
+// registriesCmd writes the resolved registries to registries.yml in the
+// guest, the file is passed to meridian-node.
+func registriesCmd(r *v1.Registries) string {
+	return fmt.Sprintf(`
+cat >registries.yml << 'EOF'
+%s
+EOF
+chmod 600 registries.yml
+`, tool.PrettyYaml(r))
+}
+
+// UpdateRegistries saves the registries of the vm and applies them to a
+// running vm, they are used by docker and kubernetes installed later.
+func (mgr *LocalDockerMgr) UpdateRegistries(ctx context.Context, name string, r *v1.Registries) error {
+	vm := mgr.stateMgr.Get(name)
+	if vm == nil || vm.machine == nil {
+		return fmt.Errorf("vm %s not found", name)
+	}
+	if r != nil {
+		err := r.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	// credentials are read before the registries are saved
+	_, err := resolveRegistries(r)
+	if err != nil {
+		return err
+	}
+	vm.machine.Spec.Registries = r
+	err = vm.meta.Machine().Update(vm.machine)
+	if err != nil {
+		return errors.Wrapf(err, "update machine metadata")
+	}
+	if vm.machine.State != Running {
+		klog.Infof("[%-10s]vm is %s, registries saved for docker and kubernetes installed later", name, vm.machine.State)
+		return nil
+	}
+	resolved, err := mgr.registries(ctx, vm)
+	if err != nil {
+		return err
+	}
+	// without registries meridian-node restores the builtin mirrors
+	command := []string{
+		"[ -x /usr/local/bin/meridian-node ] || exit 0",
+		"sudo /usr/local/bin/meridian-node update registries",
+	}
+	if resolved != nil {
+		command = []string{
+			command[0],
+			registriesCmd(resolved),
+			command[1] + " registries.yml",
+			"rc=$?; rm -f registries.yml; exit $rc",
+		}
+	}
+	out, err := vm.SSH().RunCommand(ctx, name, strings.Join(command, "\n"))
+	if err != nil {
+		klog.Infof("[%-10s]update registries: %s", name, string(out))
+		return errors.Wrap(err, "run update registries command")
+	}
+	return nil
+}
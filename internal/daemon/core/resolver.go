@@ -0,0 +1,186 @@
+package core
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/dns"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ReasonResolverFailed = "ResolverFailed"
+
+	resolverCacheSize = 4096
+	queryLogFile      = "dns-query.log"
+)
+
+// guestResolver serves dns to the vms with HostResolver enabled on their
+// gateway address. The vz nat vms share one gateway, a server per gateway
+// answers each vm with its own settings picked by the client ip.
+type guestResolver struct {
+	// port is 53, nameservers of the guests take no port
+	port string
+	// servers by gateway address
+	servers map[string]*gatewayServer
+	// guests by vm name
+	guests map[string]*guestEntry
+	// failed is the last error of a vm, it is recorded as event once
+	failed map[string]string
+}
+
+type gatewayServer struct {
+	mux *dns.Mux
+	srv *dns.Server
+}
+
+type guestEntry struct {
+	ip      string
+	gateway string
+	conf    v1.HostResolver
+	log     io.Closer
+}
+
+func newGuestResolver() *guestResolver {
+	return &guestResolver{
+		port:    "53",
+		servers: map[string]*gatewayServer{},
+		guests:  map[string]*guestEntry{},
+		failed:  map[string]string{},
+	}
+}
+
+// reconcileResolver serves the running vms with HostResolver enabled, a
+// gateway which can not be bound is reported as an event of the vm.
+func (mgr *LocalVMMgr) reconcileResolver() {
+	r := mgr.resolver
+	want := map[string]bool{}
+	for _, state := range mgr.stateMgr.States() {
+		m := state.machine
+		hr := m.Spec.HostResolver
+		if m.State != Running || !hr.Enabled || len(m.Spec.Networks) == 0 {
+			continue
+		}
+		var (
+			n       = m.Spec.Networks[0]
+			ip      = addressIP(n.Address)
+			gateway = n.IpGateway
+		)
+		if ip == "" || gateway == "" {
+			continue
+		}
+		want[state.name] = true
+		g := r.guests[state.name]
+		if g != nil && g.ip == ip && g.gateway == gateway && reflect.DeepEqual(g.conf, hr) {
+			continue
+		}
+		r.remove(state.name)
+		err := r.add(state, ip, gateway)
+		if err != nil {
+			if r.failed[state.name] != err.Error() {
+				r.failed[state.name] = err.Error()
+				state.event(ReasonResolverFailed, "serve guest dns: %s", err.Error())
+			}
+			continue
+		}
+		delete(r.failed, state.name)
+	}
+	for name := range r.guests {
+		if !want[name] {
+			r.remove(name)
+		}
+	}
+	for name := range r.failed {
+		if !want[name] {
+			delete(r.failed, name)
+		}
+	}
+}
+
+func (r *guestResolver) add(state *vmState, ip, gateway string) error {
+	hr := state.machine.Spec.HostResolver
+	opts := dns.HandlerOptions{
+		IPv6:            hr.IPv6,
+		StaticHosts:     hr.Hosts,
+		UpstreamServers: hr.Upstreams,
+	}
+	if !hr.DisableCache {
+		opts.CacheSize = resolverCacheSize
+	}
+	gs := r.servers[gateway]
+	if gs == nil {
+		mux := dns.NewMux()
+		address := net.JoinHostPort(gateway, r.port)
+		srv, err := dns.Serve(address, mux)
+		if err != nil {
+			return errors.Wrapf(err, "serve dns on %s", address)
+		}
+		klog.Infof("serving guest dns on %s", address)
+		gs = &gatewayServer{mux: mux, srv: srv}
+		r.servers[gateway] = gs
+	}
+	var log io.Closer
+	if hr.QueryLog {
+		f, err := os.OpenFile(filepath.Join(state.machine.Dir(), queryLogFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			r.release(gateway)
+			return errors.Wrap(err, "open dns query log")
+		}
+		opts.QueryLog, log = f, f
+	}
+	h, err := dns.NewHandler(opts)
+	if err != nil {
+		if log != nil {
+			_ = log.Close()
+		}
+		r.release(gateway)
+		return errors.Wrap(err, "new dns handler")
+	}
+	gs.mux.Set(ip, h)
+	r.guests[state.name] = &guestEntry{ip: ip, gateway: gateway, conf: *hr.DeepCopy(), log: log}
+	klog.Infof("[%s]guest dns of %s served on %s", state.name, ip, gateway)
+	return nil
+}
+
+func (r *guestResolver) remove(name string) {
+	g, ok := r.guests[name]
+	if !ok {
+		return
+	}
+	delete(r.guests, name)
+	if gs := r.servers[g.gateway]; gs != nil {
+		gs.mux.Remove(g.ip)
+	}
+	if g.log != nil {
+		_ = g.log.Close()
+	}
+	r.release(g.gateway)
+}
+
+// release shuts the server of gateway down when it serves no vm.
+func (r *guestResolver) release(gateway string) {
+	gs := r.servers[gateway]
+	if gs == nil || gs.mux.Len() > 0 {
+		return
+	}
+	gs.srv.Shutdown()
+	delete(r.servers, gateway)
+	klog.Infof("stop guest dns on %s", gateway)
+}
+
+// addressIP is the ip of an address with or without prefix length.
+func addressIP(address string) string {
+	ip, _, err := net.ParseCIDR(address)
+	if err != nil {
+		ip = net.ParseIP(address)
+	}
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
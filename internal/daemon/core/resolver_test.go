@@ -0,0 +1,98 @@
+package core
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/miekg/dns"
+)
+
+func TestGuestResolverSharedGateway(t *testing.T) {
+	bk, err := meta.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("new backend: %s", err)
+	}
+	guest := func(name, address, gateway, registry string) *vmState {
+		return &vmState{name: name, meta: bk, mu: &sync.RWMutex{}, machine: &meta.Machine{
+			Name:   name,
+			State:  Running,
+			AbsDir: t.TempDir(),
+			Spec: &v1.VirtualMachineSpec{
+				Networks: []v1.Network{{VZNAT: true, Address: address, IpGateway: gateway}},
+				HostResolver: v1.HostResolver{
+					Enabled: true,
+					Hosts:   map[string]string{"registry.local": registry},
+				},
+			},
+		}}
+	}
+	vms := map[string]*vmState{
+		"a": guest("a", "127.0.0.1/8", "127.0.0.1", "10.0.0.1"),
+		"b": guest("b", "127.0.0.2/8", "127.0.0.1", "10.0.0.2"),
+		// documentation address, never bound
+		"c": guest("c", "127.0.0.3/8", "192.0.2.1", "10.0.0.3"),
+	}
+	mgr := &LocalVMMgr{
+		stateMgr: &vmStateMgr{mu: &sync.RWMutex{}, vms: vms, meta: bk},
+		resolver: newGuestResolver(),
+	}
+	mgr.resolver.port = strconv.Itoa(freePort(t))
+	defer func() {
+		for name := range mgr.resolver.guests {
+			mgr.resolver.remove(name)
+		}
+	}()
+
+	query := func(from string) string {
+		c := &dns.Client{Dialer: &net.Dialer{LocalAddr: &net.UDPAddr{IP: net.ParseIP(from)}}}
+		req := new(dns.Msg)
+		req.SetQuestion("registry.local.", dns.TypeA)
+		reply, _, err := c.Exchange(req, net.JoinHostPort("127.0.0.1", mgr.resolver.port))
+		if err != nil {
+			t.Fatalf("query from %s: %s", from, err)
+		}
+		if len(reply.Answer) == 0 {
+			return dns.RcodeToString[reply.Rcode]
+		}
+		return reply.Answer[0].(*dns.A).A.String()
+	}
+
+	mgr.reconcileResolver()
+	if got := query("127.0.0.1"); got != "10.0.0.1" {
+		t.Fatalf("vm a should be answered with its own hosts, got %s", got)
+	}
+	if got := query("127.0.0.2"); got != "10.0.0.2" {
+		t.Fatalf("vm b should be answered with its own hosts, got %s", got)
+	}
+	events := vms["c"].machine.Events
+	if len(events) != 1 || events[0].Reason != ReasonResolverFailed {
+		t.Fatalf("expect the bind failure of vm c as event: %+v", events)
+	}
+	mgr.reconcileResolver()
+	if len(vms["c"].machine.Events) != 1 {
+		t.Fatalf("expect the same failure recorded once: %+v", vms["c"].machine.Events)
+	}
+
+	// vm b keeps its dns when vm a stops
+	vms["a"].machine.State = Stopped
+	mgr.reconcileResolver()
+	if got := query("127.0.0.2"); got != "10.0.0.2" {
+		t.Fatalf("vm b should still be answered, got %s", got)
+	}
+	if got := query("127.0.0.1"); got != "REFUSED" {
+		t.Fatalf("stopped vm a should be refused, got %s", got)
+	}
+}
+
+func freePort(t *testing.T) int {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"os"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	RestartVM = "restart-vm"
+
+	// restartBackoffBase doubles on every restart until restartBackoffMax
+	restartBackoffBase = 10 * time.Second
+	restartBackoffMax  = 5 * time.Minute
+	// stableRunning resets the crash loop counter of a running vm
+	stableRunning = 10 * time.Minute
+
+	ReasonSandboxExited  = "SandboxExited"
+	ReasonSandboxCrashed = "SandboxCrashed"
+	ReasonRestarting     = "Restarting"
+	ReasonAutostart      = "Autostart"
+)
+
+type restartState struct {
+	// at is when the next restart is due, zero when none is scheduled
+	at time.Time
+	// since is when the vm became running
+	since time.Time
+}
+
+func restartBackoff(restarts int) time.Duration {
+	d := restartBackoffBase
+	for i := 1; i < restarts && d < restartBackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, restartBackoffMax)
+}
+
+// sandboxExited checks whether the sandbox process of a running vm is gone.
+// A vm which stops cleanly removes its pid file, a stale pid file means the
+// sandbox crashed.
+func sandboxExited(m *vmState) (exited bool, crashed bool) {
+	_, err := os.Stat(m.machine.PIDFile())
+	if os.IsNotExist(err) {
+		return true, false
+	}
+	_, err = m.machine.LoadPID()
+	if err != nil {
+		return true, true
+	}
+	return false, false
+}
+
+// autostart runs once when meridiand starts. Vms left in Running state by a
+// host reboot are marked Stopped, which is not counted as a failure, and vms
+// with Autostart or RestartPolicy Always are started again.
+func (mgr *LocalVMMgr) autostart() {
+	for _, state := range mgr.stateMgr.States() {
+		spec := state.machine.Spec
+		if !state.machine.StageUtil().Initialized() {
+			continue
+		}
+		switch state.machine.State {
+		case Running, Starting, Stopping:
+			if exited, _ := sandboxExited(state); !exited {
+				continue
+			}
+			state.setState(Stopped, "sandbox gone since meridiand last run")
+		}
+		if state.machine.State != Stopped && state.machine.State != Created {
+			continue
+		}
+		if !spec.Autostart && spec.RestartPolicy != v1.RestartAlways {
+			continue
+		}
+		name := state.name
+		err := mgr.tskMgr.Send(RestartVM, name, func(ctx context.Context) error {
+			state.event(ReasonAutostart, "start vm on meridiand boot")
+			err := mgr.Start(ctx, name)
+			if err != nil {
+				return err
+			}
+			return mgr.waitRunning(ctx, name)
+		})
+		if err != nil {
+			klog.Errorf("[%s]autostart vm: %v", name, err)
+		}
+	}
+}
+
+// reconcileRestart detects dead sandboxes of running vms and restarts them
+// according to their RestartPolicy, with exponential backoff.
+func (mgr *LocalVMMgr) reconcileRestart() {
+	now := time.Now()
+	for _, state := range mgr.stateMgr.States() {
+		if state.machine.State == Running {
+			if state.restart.since.IsZero() {
+				state.restart.since = now
+			}
+			if exited, crashed := sandboxExited(state); exited {
+				state.restart.since = time.Time{}
+				mgr.onSandboxExit(state, crashed)
+			} else if state.machine.Restarts > 0 && now.Sub(state.restart.since) > stableRunning {
+				state.machine.Restarts = 0
+				state.setState(Running, "vm is running stably")
+			}
+		} else {
+			state.restart.since = time.Time{}
+		}
+		if state.restart.at.IsZero() || now.Before(state.restart.at) {
+			continue
+		}
+		state.restart.at = time.Time{}
+		mgr.restart(state)
+	}
+}
+
+func (mgr *LocalVMMgr) onSandboxExit(state *vmState, crashed bool) {
+	if crashed {
+		state.setState(Error, "sandbox process exited unexpectedly")
+		state.event(ReasonSandboxCrashed, "sandbox process %d is gone", state.machine.SandboxPID)
+	} else {
+		state.setState(Stopped, "vm exited")
+		state.event(ReasonSandboxExited, "vm exited")
+	}
+	switch state.machine.Spec.RestartPolicy {
+	case v1.RestartAlways:
+	case v1.RestartOnFailure:
+		if !crashed {
+			return
+		}
+	default:
+		return
+	}
+	mgr.scheduleRestart(state)
+}
+
+func (mgr *LocalVMMgr) scheduleRestart(state *vmState) {
+	state.machine.Restarts++
+	backoff := restartBackoff(state.machine.Restarts)
+	state.restart.at = time.Now().Add(backoff)
+	state.event(ReasonRestarting, "restart #%d in %s", state.machine.Restarts, backoff)
+}
+
+func (mgr *LocalVMMgr) restart(state *vmState) {
+	name := state.name
+	err := mgr.tskMgr.Send(RestartVM, name, func(ctx context.Context) error {
+		err := mgr.Start(ctx, name)
+		if err == nil {
+			err = mgr.waitRunning(ctx, name)
+		}
+		if err != nil {
+			mgr.scheduleRestart(state)
+		}
+		return err
+	})
+	if err != nil {
+		klog.Errorf("[%s]restart vm: %v", name, err)
+	}
+}
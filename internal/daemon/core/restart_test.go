@@ -0,0 +1,20 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	for restarts, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		4:  80 * time.Second,
+		6:  5 * time.Minute,
+		40: 5 * time.Minute,
+	} {
+		if got := restartBackoff(restarts); got != want {
+			t.Fatalf("restart #%d: expect backoff %s, got %s", restarts, want, got)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/sshutil"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"k8s.io/klog/v2"
+)
+
+const ReasonKeyRotated = "KeyRotated"
+
+const sshConfigHeader = `# Host entries of the meridian vms, kept up to date by meridiand.
+# Add "Include %s" to ~/.ssh/config to use them with ssh, scp and editors.
+`
+
+// reconcileSSHConfig rewrites the ssh config of all vms when it changes.
+func (mgr *LocalVMMgr) reconcileSSHConfig() {
+	path := filepath.Join(mgr.backend.Config().Dir(), v1.SSHConfigAll)
+	states := mgr.stateMgr.States()
+	sort.Slice(states, func(i, j int) bool { return states[i].name < states[j].name })
+
+	// the options only depend on the vm addresses, skip when none changed
+	var (
+		hosts []string
+		sshs  []*sshutil.SSHMgr
+	)
+	for _, state := range states {
+		ssh := state.SSH()
+		if ssh.GetAddr() == "" {
+			continue
+		}
+		hosts = append(hosts, state.name+"@"+ssh.GetAddr()+"@"+ssh.KeyDir())
+		sshs = append(sshs, ssh)
+	}
+	if strings.Join(hosts, ",") == mgr.sshHosts {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(fmtMessage(sshConfigHeader, path))
+	for i, ssh := range sshs {
+		name := strings.Split(hosts[i], "@")[0]
+		opts, err := ssh.VMOpts(name)
+		if err != nil {
+			// the user key is created with the first vm, try again later
+			klog.V(5).Infof("[%s]ssh config: %v", name, err)
+			return
+		}
+		b.WriteString("\n")
+		err = sshutil.Format(&b, name, sshutil.FormatConfig, opts)
+		if err != nil {
+			klog.Errorf("[%s]format ssh config: %v", name, err)
+			return
+		}
+	}
+	err := os.WriteFile(path, b.Bytes(), 0o600)
+	if err != nil {
+		klog.Errorf("write ssh config %s: %v", path, err)
+		return
+	}
+	mgr.sshHosts = strings.Join(hosts, ",")
+}
+
+// RotateKeys gives each named vm a new ssh key of its own, or replaces the
+// key shared by all vms when no name is given. The new key is pushed into
+// running vms and the old one retired once the new one logs in, stopped vms
+// pick it up on the next boot.
+func (mgr *LocalVMMgr) RotateKeys(ctx context.Context, names ...string) ([]v1.KeyRotation, error) {
+	var (
+		cfg    = mgr.backend.Config().Dir()
+		result []v1.KeyRotation
+	)
+	for _, name := range names {
+		state := mgr.stateMgr.Get(name)
+		if state == nil || state.machine == nil {
+			return result, fmt.Errorf("vm %s not found", name)
+		}
+		next := filepath.Join(cfg, v1.SSHKeysDir, "."+name+".new")
+		r, err := mgr.rotateKey(ctx, state, next, filepath.Join(cfg, v1.SSHKeysDir, name))
+		if err != nil {
+			return result, err
+		}
+		result = append(result, r)
+	}
+	if len(names) > 0 {
+		return result, nil
+	}
+
+	next := filepath.Join(cfg, v1.SSHKeysDir, ".new")
+	err := newKey(next)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(next)
+	states := mgr.stateMgr.States()
+	sort.Slice(states, func(i, j int) bool { return states[i].name < states[j].name })
+	for _, state := range states {
+		r := mgr.pushKey(ctx, state, next)
+		if r.Error != "" && sshutil.KeyDir(cfg, state.name) == cfg {
+			// keep the old key for the vm, the shared one is replaced
+			err = sshutil.CopyKey(cfg, filepath.Join(cfg, v1.SSHKeysDir, state.name))
+			if err != nil {
+				return result, errors.Wrapf(err, "keep the old key of vm %s", state.name)
+			}
+		}
+		result = append(result, r)
+	}
+	err = sshutil.CopyKey(next, cfg)
+	if err != nil {
+		return result, errors.Wrap(err, "replace shared key")
+	}
+	for _, r := range result {
+		if r.Error == "" {
+			_ = os.RemoveAll(filepath.Join(cfg, v1.SSHKeysDir, r.VM))
+		}
+	}
+	return result, nil
+}
+
+// rotateKey generates a key in next, pushes it into state and installs it
+// in dst.
+func (mgr *LocalVMMgr) rotateKey(ctx context.Context, state *vmState, next, dst string) (v1.KeyRotation, error) {
+	err := newKey(next)
+	if err != nil {
+		return v1.KeyRotation{}, err
+	}
+	defer os.RemoveAll(next)
+	r := mgr.pushKey(ctx, state, next)
+	if r.Error != "" {
+		return r, nil
+	}
+	return r, errors.Wrapf(sshutil.CopyKey(next, dst), "install key of vm %s", state.name)
+}
+
+// pushKey authorizes the key in dir for a running vm, logging in with its
+// current key, then removes the current key logging in with the new one.
+func (mgr *LocalVMMgr) pushKey(ctx context.Context, state *vmState, dir string) v1.KeyRotation {
+	r := v1.KeyRotation{VM: state.name}
+	cur := state.SSH()
+	if state.machine.State != Running || cur.GetAddr() == "" {
+		return r
+	}
+	next := sshutil.NewSSHMgr(cur.GetAddr(), dir)
+	err := func() error {
+		extra := state.machine.Spec.SSH.AuthorizedKeys
+		keys, err := next.AuthorizedKeys(extra)
+		if err != nil {
+			return err
+		}
+		old, err := cur.AuthorizedKeys(extra)
+		if err != nil {
+			return err
+		}
+		err = cur.PushAuthorizedKeys(ctx, state.name, lo.Uniq(append(keys, old...)))
+		if err != nil {
+			return err
+		}
+		return next.PushAuthorizedKeys(ctx, state.name, keys)
+	}()
+	if err != nil {
+		klog.Errorf("[%s]rotate ssh key: %v", state.name, err)
+		r.Error = err.Error()
+		return r
+	}
+	r.Pushed = true
+	state.event(ReasonKeyRotated, "ssh key rotated")
+	return r
+}
+
+// PushAuthorizedKeys writes the meridian key and spec.ssh.authorizedKeys to
+// the authorized keys of a running vm.
+func (mgr *LocalVMMgr) PushAuthorizedKeys(ctx context.Context, state *vmState) error {
+	ssh := state.SSH()
+	keys, err := ssh.AuthorizedKeys(state.machine.Spec.SSH.AuthorizedKeys)
+	if err != nil {
+		return err
+	}
+	return ssh.PushAuthorizedKeys(ctx, state.name, keys)
+}
+
+func newKey(dir string) error {
+	_ = os.RemoveAll(dir)
+	return errors.Wrap(sshutil.GenerateKey(dir), "generate ssh key")
+}
@@ -6,11 +6,13 @@ import (
 	v1 "github.com/aoxn/meridian/api/v1"
 	"github.com/aoxn/meridian/client"
 	"github.com/aoxn/meridian/internal/tool"
+	"github.com/aoxn/meridian/internal/vmm/dns"
 	hostagent "github.com/aoxn/meridian/internal/vmm/host"
 	"github.com/aoxn/meridian/internal/vmm/meta"
 	"github.com/aoxn/meridian/internal/vmm/sshutil"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"os"
@@ -32,17 +34,24 @@ func NewContext() (*Context, error) {
 	if err != nil {
 		return nil, err
 	}
-	dockerMgr, err := NewLocalDockerMgr(vmMgr.stateMgr)
+	cacheMgr, err := NewLocalCacheMgr(backend)
 	if err != nil {
 		return nil, err
 	}
-	k8sMgr, err := NewK8sMgr(vmMgr.stateMgr)
+	dockerMgr, err := NewLocalDockerMgr(vmMgr.stateMgr, cacheMgr)
+	if err != nil {
+		return nil, err
+	}
+	k8sMgr, err := NewK8sMgr(vmMgr, cacheMgr)
 	return &Context{
 		meta:      backend,
 		vmMgr:     vmMgr,
 		imageMgr:  vmMgr.imgMgr,
 		dockerMgr: dockerMgr,
 		k8sMgr:    k8sMgr,
+		applyMgr:  NewLocalApplyMgr(vmMgr, dockerMgr, k8sMgr),
+		poolMgr:   NewLocalPoolMgr(vmMgr),
+		cacheMgr:  cacheMgr,
 	}, nil
 }
 
@@ -52,6 +61,9 @@ type Context struct {
 	imageMgr  *LocalImageMgr
 	dockerMgr *LocalDockerMgr
 	k8sMgr    *LocalK8sMgr
+	applyMgr  *LocalApplyMgr
+	poolMgr   *LocalPoolMgr
+	cacheMgr  *LocalCacheMgr
 }
 
 func (ctx *Context) Backend() meta.Backend { return ctx.meta }
@@ -64,6 +76,12 @@ func (ctx *Context) DockerMgr() *LocalDockerMgr { return ctx.dockerMgr }
 
 func (ctx *Context) K8sMgr() *LocalK8sMgr { return ctx.k8sMgr }
 
+func (ctx *Context) ApplyMgr() *LocalApplyMgr { return ctx.applyMgr }
+
+func (ctx *Context) PoolMgr() *LocalPoolMgr { return ctx.poolMgr }
+
+func (ctx *Context) CacheMgr() *LocalCacheMgr { return ctx.cacheMgr }
+
 func NewLocalVMMgr(backend meta.Backend) (*LocalVMMgr, error) {
 	stateMgr, err := newVMStateMgr(backend)
 	if err != nil {
@@ -74,8 +92,16 @@ func NewLocalVMMgr(backend meta.Backend) (*LocalVMMgr, error) {
 		tskMgr:   newTaskMgr(),
 		stateMgr: stateMgr,
 		imgMgr:   NewLocalImageMgr(backend),
+		zone:     dns.NewZone(dns.ZoneDomain),
+		resolver: newGuestResolver(),
 	}
+	local.autostart()
 	go local.periodical()
+	go local.healthLoop()
+	go wait.Until(local.reconcileDNS, time.Second, make(<-chan struct{}))
+	go wait.Until(local.reconcileResolver, time.Second, make(<-chan struct{}))
+	go wait.Until(local.reconcileSSHConfig, time.Second, make(<-chan struct{}))
+	go wait.Until(local.reconcileProvision, 5*time.Second, make(<-chan struct{}))
 	return local, nil
 }
 
@@ -84,6 +110,10 @@ type LocalVMMgr struct {
 	tskMgr   *taskMgr
 	stateMgr *vmStateMgr
 	imgMgr   *LocalImageMgr
+	zone     *dns.Zone
+	resolver *guestResolver
+	// sshHosts are the vms in the ssh config last written
+	sshHosts string
 }
 
 func (mgr *LocalVMMgr) periodical() {
@@ -99,6 +129,8 @@ func (mgr *LocalVMMgr) periodical() {
 				klog.Errorf("periodical initialize-vm err: %v", err)
 			}
 		}
+		mgr.reconcileRestart()
+		mgr.reconcileLifecycle()
 	}
 	wait.Until(func() {
 		tick := time.NewTicker(10 * time.Second)
@@ -121,10 +153,68 @@ func (mgr *LocalVMMgr) Create(ctx context.Context, vm *meta.Machine) error {
 	if err != nil {
 		return errors.Wrapf(err, "set default machine value: %s", vm.Name)
 	}
-	err = allocateAddress(vm, mgr.stateMgr.List())
+	err = vm.Spec.RestartPolicy.Validate()
+	if err != nil {
+		return err
+	}
+	err = validateHealthChecks(vm.Spec.HealthChecks)
+	if err != nil {
+		return err
+	}
+	err = v1.ValidateProvision(vm.Spec.Provision)
+	if err != nil {
+		return err
+	}
+	err = v1.ValidateNetworks(vm.Spec.Networks)
+	if err != nil {
+		return err
+	}
+	for i := range vm.Spec.PortForwards {
+		err = vm.Spec.PortForwards[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if af := vm.Spec.AutoForward; af != nil {
+		err = af.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if np := vm.Spec.NetworkProxy; np != nil {
+		err = np.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	err = mgr.setProxy(vm.Spec)
+	if err != nil {
+		return err
+	}
+	if rg := vm.Spec.Registries; rg != nil {
+		err = rg.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if lc := vm.Spec.Lifecycle; lc != nil {
+		err = lc.Validate()
+		if err != nil {
+			return err
+		}
+		if lc.TTL != nil && lc.ExpireAt == nil {
+			expire := metav1.NewTime(time.Now().Add(lc.TTL.Duration))
+			lc.ExpireAt = &expire
+		}
+	}
+	err = allocateAddress(vm, append(mgr.stateMgr.List(), vipMachines(mgr.stateMgr.meta)...))
 	if err != nil {
 		return errors.Wrapf(err, "allocate machine address")
 	}
+	err = allocateProxyAddress(vm, mgr.stateMgr.List())
+	if err != nil {
+		return err
+	}
 
 	klog.V(5).Infof("debug create machine %s: %s", vm.Name, tool.PrettyJson(vm))
 	state, err = mgr.stateMgr.Create(vm)
@@ -168,6 +258,8 @@ func (mgr *LocalVMMgr) Stop(ctx context.Context, name string) error {
 	if vm == nil {
 		return fmt.Errorf("vm %s not exist", name)
 	}
+	// stopped by user, cancel pending restart
+	vm.restart.at = time.Time{}
 	switch vm.machine.State {
 	case Created, Stopped:
 		return nil
@@ -210,6 +302,45 @@ func (mgr *LocalVMMgr) Start(ctx context.Context, name string) error {
 	return nil
 }
 
+// startAndWait starts vm name once initialized and waits for it running.
+func (mgr *LocalVMMgr) startAndWait(ctx context.Context, name string) error {
+	err := wait.PollUntilContextTimeout(ctx, 3*time.Second, 30*time.Minute, true,
+		func(ctx context.Context) (bool, error) {
+			state := mgr.stateMgr.Get(name)
+			if state == nil {
+				return false, fmt.Errorf("vm %s not found", name)
+			}
+			return state.machine.StageUtil().Initialized(), nil
+		},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "wait vm %s initialized", name)
+	}
+	err = mgr.Start(ctx, name)
+	if err != nil {
+		return err
+	}
+	return mgr.waitRunning(ctx, name)
+}
+
+func (mgr *LocalVMMgr) waitRunning(ctx context.Context, name string) error {
+	return wait.PollUntilContextTimeout(ctx, 3*time.Second, 10*time.Minute, true,
+		func(ctx context.Context) (bool, error) {
+			state := mgr.stateMgr.Get(name)
+			if state == nil {
+				return false, fmt.Errorf("vm %s not found", name)
+			}
+			switch state.machine.State {
+			case Running:
+				return true, nil
+			case Error:
+				return false, fmt.Errorf("vm %s in error state: %s", name, state.machine.Message)
+			}
+			return false, nil
+		},
+	)
+}
+
 func (mgr *LocalVMMgr) Destroy(ctx context.Context, name string) error {
 	vm := mgr.stateMgr.Get(name)
 	if vm == nil {
@@ -230,6 +361,8 @@ func (mgr *LocalVMMgr) Destroy(ctx context.Context, name string) error {
 	if err != nil {
 		return errors.Wrapf(err, "destroy machine %s", name)
 	}
+	// the key of its own after a rotation
+	_ = os.RemoveAll(filepath.Join(mgr.backend.Config().Dir(), v1.SSHKeysDir, name))
 	mgr.stateMgr.Delete(vm.name)
 	return nil
 }
@@ -313,6 +446,10 @@ type vmState struct {
 	machine    *meta.Machine
 	meta       meta.Backend
 	cancelFn   context.CancelFunc
+	idle       idleState
+	restart    restartState
+	health     healthState
+	metrics    metricsState
 }
 
 const (
@@ -320,6 +457,7 @@ const (
 	Created   = "Created"
 	Running   = "Running"
 	Deploying = "Deploying"
+	Upgrading = "Upgrading"
 	Stopping  = "Stopping"
 	Stopped   = "Stopped"
 	Starting  = "Starting"
@@ -348,6 +486,12 @@ func (mgr *vmStateMgr) List() []*meta.Machine {
 	return lo.MapToSlice(mgr.vms, transFn)
 }
 
+func (mgr *vmStateMgr) States() []*vmState {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	return lo.Values(mgr.vms)
+}
+
 func (mgr *vmStateMgr) Delete(name string) {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
@@ -394,6 +538,16 @@ func (m *vmState) setState(state string, msg ...any) {
 	}
 }
 
+func (m *vmState) event(reason string, msg ...any) {
+	description := fmtMessage(msg...)
+	klog.Infof("[%-10s]event %s: %s", m.name, reason, description)
+	m.machine.AddEvent(reason, description)
+	err := m.meta.Machine().Update(m.machine)
+	if err != nil {
+		klog.Errorf("update machine %s event failed: %v", m.machine.Name, err)
+	}
+}
+
 func (m *vmState) restStage(phase string, msg ...any) {
 
 	stage := meta.Stage{
@@ -417,7 +571,7 @@ func (m *vmState) addStage(phase string, msg ...any) {
 func (m *vmState) SSH() *sshutil.SSHMgr {
 	n := lo.FirstOr(m.machine.Spec.Networks, v1.Network{})
 
-	return sshutil.NewSSHMgr(strings.Split(n.Address, "/")[0], m.meta.Config().Dir())
+	return sshutil.NewSSHMgr(strings.Split(n.Address, "/")[0], sshutil.KeyDir(m.meta.Config().Dir(), m.name))
 }
 
 func (m *vmState) stopVm(ctx context.Context) error {
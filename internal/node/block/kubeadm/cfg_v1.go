@@ -109,6 +109,16 @@ func NewInitCfg(req *v1.Request, host host.Host) string {
 		KubernetesVersion: req.Spec.Config.Kubernetes.Version,
 		ClusterName:       req.Name,
 	}
+	if access := req.Spec.AccessPoint; access.HighlyAvailable() {
+		// the apiservers of all masters share the etcd of all masters, the
+		// apiserver domain resolves to the master itself on a master
+		cluster.Etcd.External.Endpoints = nil
+		for _, b := range access.Backends {
+			cluster.Etcd.External.Endpoints = append(
+				cluster.Etcd.External.Endpoints, fmt.Sprintf("https://%s:2379", b.Ip))
+		}
+		cluster.ControlPlaneEndpoint = fmt.Sprintf("%s:6443", access.APIDomain)
+	}
 	// https://kubernetes.io/zh-cn/docs/reference/config-api/kubeadm-config.v1beta3/
 	scfg := tool.PrettyYaml(icfg)
 	scluster := tool.PrettyYaml(cluster)
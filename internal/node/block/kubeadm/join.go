@@ -27,6 +27,7 @@ import (
 	"github.com/aoxn/meridian/internal/node/block"
 	"github.com/aoxn/meridian/internal/node/host"
 	"github.com/aoxn/meridian/internal/tool/cmd"
+	"github.com/pkg/errors"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"os"
@@ -36,6 +37,8 @@ import (
 type joinBlock struct {
 	req  *v1.Request
 	host host.Host
+	// controlPlane joins the node as a master of a highly available cluster
+	controlPlane bool
 }
 
 // NewJoinBlock returns a new joinBlock for kubeadm init
@@ -43,6 +46,16 @@ func NewJoinBlock(req *v1.Request, host host.Host) (block.Block, error) {
 	return &joinBlock{req: req, host: host}, nil
 }
 
+// NewControlPlaneJoinBlock returns a joinBlock which runs the apiserver,
+// controller manager and scheduler on the node against the etcd of all
+// masters.
+func NewControlPlaneJoinBlock(req *v1.Request, host host.Host) (block.Block, error) {
+	if !req.Spec.AccessPoint.HighlyAvailable() {
+		return nil, fmt.Errorf("control plane join needs the backends of a highly available access point")
+	}
+	return &joinBlock{req: req, host: host, controlPlane: true}, nil
+}
+
 // Ensure runs the joinBlock
 func (a *joinBlock) Ensure(ctx context.Context) error {
 	cfg := NewConfigTpl(a.req, a.host)
@@ -67,8 +80,8 @@ func (a *joinBlock) Ensure(ctx context.Context) error {
 	if cfg.Spec.AccessPoint.APIPort != "" {
 		port = cfg.Spec.AccessPoint.APIPort
 	}
-	status := <-cmd.NewCmd(
-		"/usr/local/bin/kubeadm", "join",
+	args := []string{
+		"join",
 		// increase verbosity for debugging
 		"--v=6",
 		// preflight errors are expected, in particular for swap being enabled
@@ -76,21 +89,57 @@ func (a *joinBlock) Ensure(ctx context.Context) error {
 		"--node-name", cfg.NodeName,
 		"--token", cfg.Spec.Config.Token,
 		"--discovery-token-unsafe-skip-ca-verification",
-		fmt.Sprintf("%s:%s", endpoint, port),
-	).Start()
+	}
+	if a.controlPlane {
+		err = a.prepareControlPlane()
+		if err != nil {
+			return err
+		}
+		// the apiserver domain resolves to the master itself, discover
+		// the cluster from the first master instead
+		endpoint = cfg.Spec.AccessPoint.Backends[0].Ip
+		args = append(args, "--control-plane", "--apiserver-advertise-address", a.host.NodeIP())
+	}
+	args = append(args, fmt.Sprintf("%s:%s", endpoint, port))
+	status := <-cmd.NewCmd("/usr/local/bin/kubeadm", args...).Start()
 	if err := cmd.CmdError(status); err != nil {
 		return fmt.Errorf("kubeadm join: %s", err.Error())
 	}
 	return WaitJoin(a.req)
 }
 
+// prepareControlPlane puts the shared certificates and the konnectivity
+// server in place, kubeadm signs the rest of the certificates with them.
+func (a *joinBlock) prepareControlPlane() error {
+	err := setOriginalPki(a.req)
+	if err != nil {
+		return err
+	}
+	init := &actionInit{req: a.req, host: a.host}
+	err = init.createKonnectivityPod()
+	if err != nil {
+		return errors.Wrap(err, "failed to create konnectivity pod")
+	}
+	return nil
+}
+
 func (a *joinBlock) Name() string {
 	return fmt.Sprintf("kubelet join: [%s]", a.host.NodeID())
 }
 
+// Purge resets the node joined, the node is expected to be drained and
+// deleted from the cluster beforehand.
 func (a *joinBlock) Purge(ctx context.Context) error {
-	//TODO implement me
-	panic("implement me")
+	_, err := os.Stat("/usr/local/bin/kubeadm")
+	if os.IsNotExist(err) {
+		klog.Infof("kubeadm not found, skip kubeadm reset")
+		return nil
+	}
+	status := <-cmd.NewCmd("/usr/local/bin/kubeadm", "reset", "--force").Start()
+	if err := cmd.CmdError(status); err != nil {
+		return fmt.Errorf("kubeadm reset: %s", err.Error())
+	}
+	return nil
 }
 
 func (a *joinBlock) CleanUp(ctx context.Context) error {
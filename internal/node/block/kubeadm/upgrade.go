@@ -0,0 +1,109 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package kubeadm
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/node/block"
+	"github.com/aoxn/meridian/internal/node/block/file"
+	"github.com/aoxn/meridian/internal/node/host"
+	"github.com/aoxn/meridian/internal/tool/cmd"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+type upgradeBlock struct {
+	file *file.File
+	req  *v1.Request
+	host host.Host
+	role v1.NodeRole
+}
+
+// NewUpgradeBlock returns the block which upgrades the node in place to the
+// kubernetes version of req.
+func NewUpgradeBlock(req *v1.Request, host host.Host, role v1.NodeRole) (block.Block, error) {
+	info := file.PathInfo{
+		InnerAddr: false,
+		Arch:      host.Arch(),
+		OSRelease: host.OS(),
+		Region:    host.Region(),
+	}
+	err := info.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &upgradeBlock{
+		req:  req,
+		host: host,
+		role: role,
+		file: &file.File{
+			Path:    info,
+			Pkg:     file.PKG_KUBERNETES,
+			Ftype:   file.FILE_BINARY,
+			Version: req.Spec.Config.Kubernetes.Version,
+		},
+	}, nil
+}
+
+// IsFirstMaster reports whether the node is the first of the Backends or the
+// only master. It upgrades the control plane with kubeadm upgrade apply, the
+// other nodes follow it with kubeadm upgrade node.
+func IsFirstMaster(req *v1.Request, host host.Host, role v1.NodeRole) bool {
+	if role != v1.NodeRoleMaster {
+		return false
+	}
+	backends := req.Spec.AccessPoint.Backends
+	return len(backends) == 0 || backends[0].Ip == host.NodeIP()
+}
+
+// Ensure replaces kubeadm, kubelet and kubectl, upgrades the static pods or
+// the kubelet config with kubeadm and restarts the kubelet.
+func (a *upgradeBlock) Ensure(ctx context.Context) error {
+	version := a.req.Spec.Config.Kubernetes.Version
+	if err := a.file.Ensure(ctx); err != nil {
+		return errors.Wrapf(err, "install kubernetes %s", version)
+	}
+	args := []string{"upgrade", "node", "--v=6"}
+	if IsFirstMaster(a.req, a.host, a.role) {
+		args = []string{
+			"upgrade", "apply", fmt.Sprintf("v%s", v1.NormalizeKubernetesVersion(version)),
+			"--yes", "--v=6",
+			// the versions of the package mirror carry a pre-release suffix
+			"--allow-experimental-upgrades",
+			// etcd runs outside of kubeadm
+			"--etcd-upgrade=false",
+			// coredns is deployed by the addons instead of kubeadm
+			"--ignore-preflight-errors=CoreDNSUnsupportedPlugins,CoreDNSMigration",
+		}
+	}
+	klog.Infof("upgrade kubernetes to %s: kubeadm %v", version, args)
+	status := <-cmd.NewCmd("/usr/local/bin/kubeadm", args...).Start()
+	if err := cmd.CmdError(status); err != nil {
+		return fmt.Errorf("kubeadm upgrade: %s", err.Error())
+	}
+	err := a.host.Service().DaemonReload()
+	if err != nil {
+		return fmt.Errorf("systecmctl daemon-reload error,%s ", err.Error())
+	}
+	err = a.host.Service().Restart("kubelet")
+	if err != nil {
+		return fmt.Errorf("systecmctl restart kubelet error,%s ", err.Error())
+	}
+	return WaitJoin(a.req)
+}
+
+func (a *upgradeBlock) Name() string {
+	return fmt.Sprintf("kubernetes upgrade [%s]", a.host.NodeID())
+}
+
+func (a *upgradeBlock) Purge(ctx context.Context) error {
+	return nil
+}
+
+func (a *upgradeBlock) CleanUp(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,199 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package kubeadm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/template"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/node/block"
+	"github.com/aoxn/meridian/internal/node/host"
+	"github.com/aoxn/meridian/internal/tool/cmd"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	keepalivedConfig = "/etc/keepalived/keepalived.conf"
+	keepalivedCheck  = "/etc/keepalived/check_apiserver.sh"
+)
+
+var keepalivedTpl = template.Must(template.New("keepalived").Parse(`global_defs {
+    script_user root
+    enable_script_security
+}
+vrrp_script check_apiserver {
+    script "{{ .Check }}"
+    interval 3
+    fall 3
+    rise 2
+}
+vrrp_instance meridian {
+    state BACKUP
+    interface {{ .Interface }}
+    virtual_router_id {{ .RouterID }}
+    priority {{ .Priority }}
+    advert_int 1
+    authentication {
+        auth_type PASS
+        auth_pass {{ .Password }}
+    }
+    unicast_src_ip {{ .Me }}
+    unicast_peer {
+{{- range .Peers }}
+        {{ . }}
+{{- end }}
+    }
+    virtual_ipaddress {
+        {{ .VIP }}/24
+    }
+    track_script {
+        check_apiserver
+    }
+}
+`))
+
+var checkScript = `#!/bin/sh
+curl -sfk --max-time 2 -o /dev/null https://127.0.0.1:6443/healthz
+`
+
+// NewVIPBlock returns the block which keeps AccessPoint.Internet on one of
+// the healthy masters with keepalived.
+func NewVIPBlock(req *v1.Request, host host.Host) (block.Block, error) {
+	return &vipBlock{req: req, host: host}, nil
+}
+
+type vipBlock struct {
+	req  *v1.Request
+	host host.Host
+}
+
+func (a *vipBlock) Ensure(ctx context.Context) error {
+	access := a.req.Spec.AccessPoint
+	if net.ParseIP(access.Internet).To4() == nil {
+		return fmt.Errorf("virtual ip must be an ipv4 address: %q", access.Internet)
+	}
+	cfg, err := a.config()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat("/usr/sbin/keepalived")
+	if os.IsNotExist(err) {
+		c := cmd.NewCmd("apt-get", "install", "-y", "keepalived")
+		c.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		if err := cmd.CmdError(<-c.Start()); err != nil {
+			return errors.Wrap(err, "install keepalived")
+		}
+	}
+	err = os.MkdirAll("/etc/keepalived", 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(keepalivedCheck, []byte(checkScript), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", keepalivedCheck)
+	}
+	err = os.WriteFile(keepalivedConfig, cfg, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", keepalivedConfig)
+	}
+	err = a.host.Service().Enable("keepalived")
+	if err != nil {
+		return fmt.Errorf("systecmctl enable keepalived error,%s ", err.Error())
+	}
+	return a.host.Service().Restart("keepalived")
+}
+
+// config renders keepalived.conf, the masters advertise by unicast and the
+// earlier a master is in Backends the higher its priority.
+func (a *vipBlock) config() ([]byte, error) {
+	var (
+		access   = a.req.Spec.AccessPoint
+		me       = a.host.NodeIP()
+		peers    []string
+		priority = 0
+	)
+	for i, b := range access.Backends {
+		if b.Ip == me {
+			priority = 150 - i
+			continue
+		}
+		peers = append(peers, b.Ip)
+	}
+	if priority == 0 {
+		return nil, fmt.Errorf("node %s is not a backend of %s", me, access.Internet)
+	}
+	iface, err := interfaceOf(me)
+	if err != nil {
+		return nil, err
+	}
+	token := strings.Split(a.req.Spec.Config.Token, ".")
+	var buf bytes.Buffer
+	err = keepalivedTpl.Execute(&buf, map[string]any{
+		"Check":     keepalivedCheck,
+		"Interface": iface,
+		// unique among the clusters sharing the network of the vms
+		"RouterID": net.ParseIP(access.Internet).To4()[3],
+		"Priority": priority,
+		// keepalived takes the first 8 characters
+		"Password": token[0],
+		"Me":       me,
+		"Peers":    peers,
+		"VIP":      access.Internet,
+	})
+	return buf.Bytes(), err
+}
+
+// interfaceOf finds the network interface with address ip.
+func interfaceOf(ip string) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, i := range ifaces {
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipn, ok := addr.(*net.IPNet)
+			if ok && ipn.IP.String() == ip {
+				return i.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface with address %s", ip)
+}
+
+func (a *vipBlock) Name() string {
+	return fmt.Sprintf("keepalived vip [%s]", a.req.Spec.AccessPoint.Internet)
+}
+
+func (a *vipBlock) Purge(ctx context.Context) error {
+	_, err := os.Stat(keepalivedConfig)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	err = a.host.Service().Stop("keepalived")
+	if err != nil {
+		klog.Warningf("stop keepalived: %v", err)
+	}
+	for _, f := range []string{keepalivedConfig, keepalivedCheck} {
+		err = os.RemoveAll(f)
+		if err != nil {
+			return errors.Wrapf(err, "remove[%s]", f)
+		}
+	}
+	return nil
+}
+
+func (a *vipBlock) CleanUp(ctx context.Context) error {
+	return nil
+}
@@ -369,6 +369,18 @@ func LoadTemplates() (AddonTpls, error) {
 	return addonTpls, nil
 }
 
+// imageVersion is the image of addon, kube-proxy runs the kubernetes
+// version of the cluster so that it is rolled on upgrade.
+func imageVersion(addon *v1.Addon, cfg v1.ClusterConfig) string {
+	switch addon.Name {
+	case KUBEPROXY_MASTER.Name, KUBEPROXY_WORKER.Name:
+		if cfg.Kubernetes.Version != "" {
+			return fmt.Sprintf("v%s", v1.NormalizeKubernetesVersion(cfg.Kubernetes.Version))
+		}
+	}
+	return addon.Version
+}
+
 func renderAddon(addon *v1.Addon, tpldata string, data *RenderData) (string, error) {
 	var (
 		sgid string
@@ -393,7 +405,7 @@ func renderAddon(addon *v1.Addon, tpldata string, data *RenderData) (string, err
 	tpl := &ConfigTpl{
 		Tpl:          tpldata,
 		Name:         addon.Name,
-		ImageVersion: addon.Version,
+		ImageVersion: imageVersion(addon, cfg),
 	}
 	klog.V(5).Infof("debug cluster config: %s", tool.PrettyJson(cfg))
 	port := "6443"
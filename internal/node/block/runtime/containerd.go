@@ -3,6 +3,7 @@ package runtime
 import (
 	"context"
 	"fmt"
+	v1 "github.com/aoxn/meridian/api/v1"
 	"github.com/aoxn/meridian/internal/node/block"
 	"github.com/aoxn/meridian/internal/node/block/file"
 	"github.com/aoxn/meridian/internal/node/host"
@@ -14,12 +15,23 @@ import (
 )
 
 type containerdBlock struct {
-	registry string
-	host     host.Host
-	file     *file.File
+	registry   string
+	registries *v1.Registries
+	options    *v1.DockerOptions
+	host       host.Host
+	file       *file.File
 }
 
-func NewContainerdBlock(host host.Host, version, registry string) (block.Block, error) {
+// NewContainerdBlock installs containerd and docker, registries configures
+// their mirrors and options the docker daemon, both may be nil. Running it
+// with another version upgrades the packages in place, images, volumes and
+// the live-restored containers are kept.
+func NewContainerdBlock(
+	host host.Host,
+	version, registry string,
+	registries *v1.Registries,
+	options *v1.DockerOptions,
+) (block.Block, error) {
 
 	info := file.PathInfo{
 		InnerAddr: false,
@@ -32,8 +44,10 @@ func NewContainerdBlock(host host.Host, version, registry string) (block.Block,
 		return nil, err
 	}
 	return &containerdBlock{
-		host:     host,
-		registry: registry,
+		host:       host,
+		registry:   registry,
+		registries: registries,
+		options:    options,
 		file: &file.File{
 			Path:    info,
 			Pkg:     file.PKG_CONTAINERD,
@@ -68,6 +82,10 @@ func (a *containerdBlock) Ensure(ctx context.Context) error {
 			return fmt.Errorf("write docker config: %s", err.Error())
 		}
 	}
+	err = WriteDockerConfig(a.registries, a.options)
+	if err != nil {
+		return errors.Wrap(err, "write registry config")
+	}
 	klog.Infof("add docker group...")
 	sta := <-cmd.NewCmd("groupadd", "-r", "docker").Start()
 	if err := cmd.CmdError(sta); err != nil {
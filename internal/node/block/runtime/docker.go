@@ -68,6 +68,10 @@ func (a *action) Ensure(ctx context.Context) error {
 			return fmt.Errorf("write docker config: %s", err.Error())
 		}
 	}
+	err = WriteDockerConfig(nil, nil)
+	if err != nil {
+		return fmt.Errorf("write registry config: %s", err.Error())
+	}
 	klog.Infof("add docker group...")
 	sta := <-cmd.NewCmd("groupadd", "-r", "docker").Start()
 	if err := cmd.CmdError(sta); err != nil {
@@ -156,7 +160,7 @@ var cfgs = map[string]string{
 	"/lib/systemd/system/docker.socket":  dockersock,
 	// "/etc/containerd/config.toml":            containerdcfg,
 	// "/lib/systemd/system/containerd.service": containerdsvc,
-	"/etc/docker/daemon.json": daemonjson,
+	// "/etc/docker/daemon.json" is rendered by WriteDockerConfig
 }
 
 var dockerunit = `
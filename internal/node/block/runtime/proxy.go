@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/node/block"
+	"github.com/aoxn/meridian/internal/node/host"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// proxyServices are given the proxy of the cluster by a systemd drop-in
+var proxyServices = []string{"docker", "containerd", "kubelet"}
+
+// proxyDropIn is ordered after the drop-in of the vm, see cidata
+// boot/07-http-proxy.sh, its no_proxy includes the cluster cidrs.
+const proxyDropIn = "20-http-proxy.conf"
+
+type proxyBlock struct {
+	req  *v1.Request
+	host host.Host
+}
+
+// NewProxyBlock writes the proxy drop-ins before the runtime and the kubelet
+// start, it does nothing without a proxy.
+func NewProxyBlock(req *v1.Request, host host.Host) (block.Block, error) {
+	return &proxyBlock{req: req, host: host}, nil
+}
+
+func (a *proxyBlock) Ensure(ctx context.Context) error {
+	cfg := a.req.Spec.Config
+	if cfg.Proxy == nil {
+		return nil
+	}
+	dropIn := cfg.Proxy.SystemdDropIn(
+		a.host.NodeIP(), v1.APIServerDomain, cfg.Network.SVCCIDR, cfg.Network.PodCIDR, ".svc", cfg.Network.Domain)
+	for _, svc := range proxyServices {
+		dir := fmt.Sprintf("/etc/systemd/system/%s.service.d", svc)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return errors.Wrapf(err, "make %s", dir)
+		}
+		err = os.WriteFile(path.Join(dir, proxyDropIn), []byte(dropIn), 0644)
+		if err != nil {
+			return errors.Wrapf(err, "write proxy drop-in of %s", svc)
+		}
+	}
+	klog.Infof("proxy drop-in written for %v", proxyServices)
+	return a.host.Service().DaemonReload()
+}
+
+func (a *proxyBlock) Purge(ctx context.Context) error {
+	for _, svc := range proxyServices {
+		f := fmt.Sprintf("/etc/systemd/system/%s.service.d/%s", svc, proxyDropIn)
+		err := os.Remove(f)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "remove %s", f)
+		}
+	}
+	return nil
+}
+
+func (a *proxyBlock) CleanUp(ctx context.Context) error {
+	return nil
+}
+
+func (a *proxyBlock) Name() string {
+	return fmt.Sprintf("proxy drop-in [%s]", a.host.NodeID())
+}
@@ -0,0 +1,237 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerDaemonJSON = "/etc/docker/daemon.json"
+	// containerdCertDir is the config_path of the cri registry, a hosts.toml
+	// for each upstream
+	containerdCertDir = "/etc/containerd/cert.d"
+)
+
+// daemonJSON renders daemon.json with the docker hub mirrors and insecure
+// registries of r, or the builtin mirror without r, and the daemon options
+// of o.
+func daemonJSON(r *v1.Registries, o *v1.DockerOptions) ([]byte, error) {
+	cfg, err := parseDaemonJSON([]byte(daemonjson))
+	if err != nil {
+		return nil, errors.Wrap(err, "builtin daemon.json")
+	}
+	err = setRegistries(cfg, r)
+	if err != nil {
+		return nil, err
+	}
+	setDockerOptions(cfg, o)
+	return marshalDaemonJSON(cfg)
+}
+
+func parseDaemonJSON(data []byte) (map[string]interface{}, error) {
+	var cfg map[string]interface{}
+	err := json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+	return cfg, nil
+}
+
+func marshalDaemonJSON(cfg map[string]interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func setRegistries(cfg map[string]interface{}, r *v1.Registries) error {
+	delete(cfg, "registry-mirrors")
+	delete(cfg, "insecure-registries")
+	if r == nil {
+		builtin, err := parseDaemonJSON([]byte(daemonjson))
+		if err != nil {
+			return errors.Wrap(err, "builtin daemon.json")
+		}
+		cfg["registry-mirrors"] = builtin["registry-mirrors"]
+		return nil
+	}
+	if mirrors := r.Mirrors[v1.DockerHub]; len(mirrors) > 0 {
+		cfg["registry-mirrors"] = mirrors
+	}
+	if len(r.Insecure) > 0 {
+		cfg["insecure-registries"] = r.Insecure
+	}
+	return nil
+}
+
+// setDockerOptions overrides the builtin daemon options, the builtin
+// log-opts only apply to the json-file and local drivers.
+func setDockerOptions(cfg map[string]interface{}, o *v1.DockerOptions) {
+	if o == nil {
+		return
+	}
+	if o.StorageDriver != "" {
+		cfg["storage-driver"] = o.StorageDriver
+	}
+	if o.LogDriver != "" {
+		cfg["log-driver"] = o.LogDriver
+		if o.LogDriver != "json-file" && o.LogDriver != "local" {
+			delete(cfg, "log-opts")
+		}
+	}
+	if len(o.LogOpts) > 0 {
+		cfg["log-opts"] = o.LogOpts
+	}
+	if o.CgroupDriver != "" {
+		cfg["exec-opts"] = []string{"native.cgroupdriver=" + o.CgroupDriver}
+	}
+}
+
+// hostsTOML renders the hosts.toml of every upstream with mirrors,
+// credentials or an unverified certificate. The key is the path under
+// containerdCertDir.
+func hostsTOML(r *v1.Registries) map[string][]byte {
+	if r == nil {
+		return nil
+	}
+	upstreams := map[string]bool{}
+	for u := range r.Mirrors {
+		upstreams[u] = true
+	}
+	for _, i := range r.Insecure {
+		upstreams[i] = true
+	}
+	for _, a := range r.Auths {
+		upstreams[a.Registry] = true
+	}
+	files := map[string][]byte{}
+	for u := range upstreams {
+		server := "https://" + u
+		if u == v1.DockerHub {
+			server = "https://registry-1.docker.io"
+		}
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "server = %q\n", server)
+		if r.IsInsecure(u) {
+			buf.WriteString("skip_verify = true\n")
+		}
+		if auth := r.AuthOf(u); auth != "" {
+			fmt.Fprintf(&buf, "\n[header]\n  Authorization = %q\n", "Basic "+auth)
+		}
+		for _, m := range r.Mirrors[u] {
+			host := hostOf(m)
+			fmt.Fprintf(&buf, "\n[host.%q]\n", m)
+			buf.WriteString("  capabilities = [\"pull\", \"resolve\"]\n")
+			if r.IsInsecure(host) {
+				buf.WriteString("  skip_verify = true\n")
+			}
+			if auth := r.AuthOf(host); auth != "" {
+				fmt.Fprintf(&buf, "  [host.%q.header]\n", m)
+				fmt.Fprintf(&buf, "    Authorization = %q\n", "Basic "+auth)
+			}
+		}
+		files[path.Join(u, "hosts.toml")] = buf.Bytes()
+	}
+	return files
+}
+
+func hostOf(mirror string) string {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return mirror
+	}
+	return u.Host
+}
+
+// WriteDockerConfig writes daemon.json with the registries and daemon
+// options, and the registry configs of containerd.
+func WriteDockerConfig(r *v1.Registries, o *v1.DockerOptions) error {
+	data, err := daemonJSON(r, o)
+	if err != nil {
+		return err
+	}
+	err = writeDaemonJSON(data)
+	if err != nil {
+		return err
+	}
+	return writeHostsTOML(r)
+}
+
+// WriteRegistries writes the registry configs of docker and containerd, the
+// daemon options in daemon.json are kept. containerd reads hosts.toml on
+// every pull, docker has to be reloaded.
+func WriteRegistries(r *v1.Registries) error {
+	data, err := os.ReadFile(dockerDaemonJSON)
+	switch {
+	case os.IsNotExist(err):
+		data = []byte(daemonjson)
+	case err != nil:
+		return errors.Wrap(err, "read docker daemon.json")
+	}
+	cfg, err := parseDaemonJSON(data)
+	if err != nil {
+		return errors.Wrapf(err, "parse %s", dockerDaemonJSON)
+	}
+	err = setRegistries(cfg, r)
+	if err != nil {
+		return err
+	}
+	data, err = marshalDaemonJSON(cfg)
+	if err != nil {
+		return err
+	}
+	err = writeDaemonJSON(data)
+	if err != nil {
+		return err
+	}
+	return writeHostsTOML(r)
+}
+
+func writeDaemonJSON(data []byte) error {
+	err := os.MkdirAll(path.Dir(dockerDaemonJSON), 0755)
+	if err != nil {
+		return errors.Wrap(err, "make docker config dir")
+	}
+	err = os.WriteFile(dockerDaemonJSON, data, 0644)
+	if err != nil {
+		return errors.Wrap(err, "write docker daemon.json")
+	}
+	return nil
+}
+
+func writeHostsTOML(r *v1.Registries) error {
+	err := os.RemoveAll(containerdCertDir)
+	if err != nil {
+		return errors.Wrap(err, "clean containerd registry config")
+	}
+	files := hostsTOML(r)
+	var names []string
+	for f := range files {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	for _, f := range names {
+		p := path.Join(containerdCertDir, f)
+		err = os.MkdirAll(path.Dir(p), 0755)
+		if err != nil {
+			return errors.Wrapf(err, "make %s", path.Dir(p))
+		}
+		err = os.WriteFile(p, files[f], 0644)
+		if err != nil {
+			return errors.Wrapf(err, "write %s", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package runtime
+
+import (
+	"encoding/json"
+	"testing"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+)
+
+func TestRegistries(t *testing.T) {
+	r := &v1.Registries{
+		Mirrors: map[string][]string{
+			"docker.io": {"https://mirror.gcr.io", "http://registry.local:5000"},
+			"ghcr.io":   {"https://ghcr.mirror.example.com"},
+		},
+		Insecure: []string{"registry.local:5000"},
+		Auths:    []v1.RegistryAuth{{Registry: "ghcr.mirror.example.com", Auth: "dXNlcjpwYXNz"}},
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("validate: %s", err)
+	}
+
+	data, err := daemonJSON(r, nil)
+	if err != nil {
+		t.Fatalf("render daemon.json: %s", err)
+	}
+	var cfg struct {
+		Mirrors  []string `json:"registry-mirrors"`
+		Insecure []string `json:"insecure-registries"`
+		Driver   string   `json:"storage-driver"`
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("unmarshal daemon.json: %s", err)
+	}
+	if len(cfg.Mirrors) != 2 || cfg.Mirrors[0] != "https://mirror.gcr.io" ||
+		len(cfg.Insecure) != 1 || cfg.Driver != "overlay2" {
+		t.Fatalf("unexpected daemon.json: %s", data)
+	}
+
+	files := hostsTOML(r)
+	if len(files) != 4 {
+		t.Fatalf("expect hosts.toml of 4 registries, got %d", len(files))
+	}
+	expect := map[string]string{
+		"docker.io/hosts.toml": `server = "https://registry-1.docker.io"
+
+[host."https://mirror.gcr.io"]
+  capabilities = ["pull", "resolve"]
+
+[host."http://registry.local:5000"]
+  capabilities = ["pull", "resolve"]
+  skip_verify = true
+`,
+		"ghcr.io/hosts.toml": `server = "https://ghcr.io"
+
+[host."https://ghcr.mirror.example.com"]
+  capabilities = ["pull", "resolve"]
+  [host."https://ghcr.mirror.example.com".header]
+    Authorization = "Basic dXNlcjpwYXNz"
+`,
+		"registry.local:5000/hosts.toml": `server = "https://registry.local:5000"
+skip_verify = true
+`,
+	}
+	for f, want := range expect {
+		if got := string(files[f]); got != want {
+			t.Fatalf("unexpected %s:\n%s", f, got)
+		}
+	}
+
+	// the builtin mirror is kept without registries
+	data, err = daemonJSON(nil, nil)
+	if err != nil {
+		t.Fatalf("render builtin daemon.json: %s", err)
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil || len(cfg.Mirrors) != 1 {
+		t.Fatalf("unexpected builtin daemon.json: %s", data)
+	}
+	if hostsTOML(nil) != nil {
+		t.Fatalf("no hosts.toml expected without registries")
+	}
+
+	bad := &v1.Registries{Mirrors: map[string][]string{"docker.io": {"mirror.gcr.io"}}}
+	if err = bad.Validate(); err == nil {
+		t.Fatalf("mirror without scheme should be rejected")
+	}
+}
+
+func TestDaemonOptions(t *testing.T) {
+	o := &v1.DockerOptions{
+		StorageDriver: "fuse-overlayfs",
+		LogDriver:     "journald",
+		CgroupDriver:  "cgroupfs",
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatalf("validate: %s", err)
+	}
+	data, err := daemonJSON(nil, o)
+	if err != nil {
+		t.Fatalf("render daemon.json: %s", err)
+	}
+	var cfg struct {
+		Driver    string            `json:"storage-driver"`
+		LogDriver string            `json:"log-driver"`
+		LogOpts   map[string]string `json:"log-opts"`
+		ExecOpts  []string          `json:"exec-opts"`
+		Restore   bool              `json:"live-restore"`
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("unmarshal daemon.json: %s", err)
+	}
+	if cfg.Driver != "fuse-overlayfs" || cfg.LogDriver != "journald" || cfg.LogOpts != nil ||
+		len(cfg.ExecOpts) != 1 || cfg.ExecOpts[0] != "native.cgroupdriver=cgroupfs" || !cfg.Restore {
+		t.Fatalf("unexpected daemon.json: %s", data)
+	}
+
+	bad := &v1.DockerOptions{LogDriver: "splunk"}
+	if err = bad.Validate(); err == nil {
+		t.Fatalf("unknown log driver should be rejected")
+	}
+}
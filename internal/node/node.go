@@ -15,6 +15,7 @@ import (
 	"github.com/aoxn/meridian/internal/node/host/meta/alibaba"
 	"github.com/aoxn/meridian/internal/node/host/meta/local"
 	"github.com/aoxn/meridian/internal/tool"
+	"github.com/aoxn/meridian/internal/tool/cmd"
 	"github.com/pkg/errors"
 	kruntime "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -125,7 +126,13 @@ type Meridian struct {
 	request *v1.Request
 }
 
-func (m *Meridian) CreateDocker(ctx context.Context, version, registry string) error {
+// CreateDocker installs docker, or upgrades it in place to version.
+func (m *Meridian) CreateDocker(
+	ctx context.Context,
+	version, registry string,
+	registries *v1.Registries,
+	options *v1.DockerOptions,
+) error {
 	local, err := NewLocal(m.cloud)
 	if err != nil {
 		return errors.Wrap(err, "new local host when")
@@ -135,7 +142,7 @@ func (m *Meridian) CreateDocker(ctx context.Context, version, registry string) e
 		return fmt.Errorf("not support darwin yet")
 	}
 	runtimeBlock, err := runtime.NewContainerdBlock(
-		local, version, registry)
+		local, version, registry, registries, options)
 	if err != nil {
 		return errors.Wrap(err, "new runtime block while")
 	}
@@ -150,13 +157,31 @@ func (m *Meridian) DestroyDocker(ctx context.Context) error {
 	if gruntime.GOOS == "darwin" {
 		return fmt.Errorf("not support darwin yet")
 	}
-	runtimeBlock, err := runtime.NewContainerdBlock(local, "", "")
+	runtimeBlock, err := runtime.NewContainerdBlock(local, "", "", nil, nil)
 	if err != nil {
 		return errors.Wrap(err, "new runtime block while")
 	}
 	return runtimeBlock.Purge(ctx)
 }
 
+// UpdateRegistries rewrites the registry configs of docker and containerd,
+// containerd picks them up on the next pull and docker is reloaded.
+func (m *Meridian) UpdateRegistries(ctx context.Context, registries *v1.Registries) error {
+	if gruntime.GOOS == "darwin" {
+		return fmt.Errorf("not support darwin yet")
+	}
+	err := runtime.WriteRegistries(registries)
+	if err != nil {
+		return err
+	}
+	sta := <-cmd.NewCmd("systemctl", "is-active", "--quiet", "docker").Start()
+	if sta.Exit != 0 {
+		klog.Infof("docker is not running, registries applied on its start")
+		return nil
+	}
+	return cmd.CmdError(<-cmd.NewCmd("systemctl", "reload", "docker").Start())
+}
+
 func (m *Meridian) EnsureNode() error {
 	m.request.Name = ClusterName
 	if err := m.request.Validate(); err != nil {
@@ -224,14 +249,38 @@ func (m *Meridian) DestroyNode(force bool) error {
 	if err != nil {
 		return errors.Wrap(err, "new kube auth block while")
 	}
+	proxyBlock, err := runtime.NewProxyBlock(m.request, local)
+	if err != nil {
+		return errors.Wrap(err, "new proxy block while")
+	}
 	blocks := []block.Block{
-		kubeAuthBlock, kubeletBlock, nvidiaBlock, etcdBlock,
+		kubeAuthBlock, kubeletBlock, nvidiaBlock, etcdBlock, proxyBlock,
+	}
+	if m.role == v1.NodeRoleWorker {
+		joinBlock, err := kubeadm.NewJoinBlock(m.request, local)
+		if err != nil {
+			return errors.Wrap(err, "new kube join block while")
+		}
+		// a worker runs neither etcd nor the kube auth of masters
+		blocks = []block.Block{joinBlock, kubeletBlock, nvidiaBlock, proxyBlock}
+	}
+	if m.role == v1.NodeRoleMaster && m.request.Spec.AccessPoint.HighlyAvailable() {
+		joinBlock, err := kubeadm.NewControlPlaneJoinBlock(m.request, local)
+		if err != nil {
+			return errors.Wrap(err, "new kube join block while")
+		}
+		vipBlock, err := kubeadm.NewVIPBlock(m.request, local)
+		if err != nil {
+			return errors.Wrap(err, "new vip block while")
+		}
+		// give up the vip first, then reset the control plane with kubeadm
+		blocks = append([]block.Block{vipBlock, joinBlock}, blocks...)
 	}
 
 	var runtimeBlock block.Block
 	if force {
 		runtimeBlock, err = runtime.NewContainerdBlock(
-			local, m.request.Spec.Config.Runtime.Version, m.request.Spec.Config.Registry)
+			local, m.request.Spec.Config.Runtime.Version, m.request.Spec.Config.Registry, m.request.Spec.Config.Registries, nil)
 		if err != nil {
 			return errors.Wrap(err, "new runtime block while")
 		}
@@ -247,13 +296,41 @@ func (m *Meridian) DestroyNode(force bool) error {
 	return nil
 }
 
+// UpgradeNode upgrades the node in place to the kubernetes version of the
+// request, the first master rolls the addons to the version afterwards.
+func (m *Meridian) UpgradeNode() error {
+	m.request.Name = ClusterName
+	local, err := NewLocal(m.cloud)
+	if err != nil {
+		return errors.Wrap(err, "new local host when")
+	}
+	klog.Infof("local host(upgrade): %v", local)
+	upgradeBlock, err := kubeadm.NewUpgradeBlock(m.request, local, m.role)
+	if err != nil {
+		return errors.Wrap(err, "new upgrade block while")
+	}
+	blocks := []block.Block{upgradeBlock}
+	if kubeadm.IsFirstMaster(m.request, local, m.role) {
+		postAddon, err := post.NewPostAddon(m.request, local)
+		if err != nil {
+			return errors.Wrap(err, "new post addon")
+		}
+		blocks = append(blocks, postAddon)
+	}
+	err = block.RunBlocks(blocks)
+	if err != nil {
+		return err
+	}
+	return m.saveRequest()
+}
+
 func (m *Meridian) buildActionBlocks(local host.Host) ([]block.Block, error) {
 	etcdBlock, err := etcd.NewBlock(m.request, local, m.action)
 	if err != nil {
 		return nil, errors.Wrap(err, "new etcd block while")
 	}
 	runtimeBlock, err := runtime.NewContainerdBlock(
-		local, m.request.Spec.Config.Runtime.Version, m.request.Spec.Config.Registry)
+		local, m.request.Spec.Config.Runtime.Version, m.request.Spec.Config.Registry, m.request.Spec.Config.Registries, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "new containerd block while")
 	}
@@ -282,6 +359,10 @@ func (m *Meridian) buildActionBlocks(local host.Host) ([]block.Block, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "new nvidia block while")
 	}
+	proxyBlock, err := runtime.NewProxyBlock(m.request, local)
+	if err != nil {
+		return nil, errors.Wrap(err, "new proxy block while")
+	}
 	//postBlock, err := post.NewPostBlock(m.request, local)
 	//if err != nil {
 	//	return nil, errors.Wrap(err, "new post block while")
@@ -293,6 +374,9 @@ func (m *Meridian) buildActionBlocks(local host.Host) ([]block.Block, error) {
 	var blocks []block.Block
 	switch m.role {
 	case v1.NodeRoleMaster:
+		if m.request.Spec.AccessPoint.HighlyAvailable() {
+			return m.buildHABlocks(local, etcdBlock, runtimeBlock, kubeletBlock, kubeAuthBlock, nvidiaBlock, proxyBlock, initBlock, block.NewConcurrentBlock([]block.Block{ccmBlock, postAddon}))
+		}
 		base := []block.Block{
 			etcdBlock,
 			runtimeBlock,
@@ -303,19 +387,42 @@ func (m *Meridian) buildActionBlocks(local host.Host) ([]block.Block, error) {
 			ccmBlock,
 			postAddon,
 		}
-		blocks = append(blocks, block.NewConcurrentBlock(base), nvidiaBlock, initBlock, kubeAuthBlock, block.NewConcurrentBlock(addon))
+		blocks = append(blocks, proxyBlock, block.NewConcurrentBlock(base), nvidiaBlock, initBlock, kubeAuthBlock, block.NewConcurrentBlock(addon))
 	case v1.NodeRoleWorker:
 		base := []block.Block{
 			runtimeBlock,
 			kubeletBlock,
 		}
 
-		blocks = append(blocks, block.NewConcurrentBlock(base), nvidiaBlock, kubejoinBlock)
+		blocks = append(blocks, proxyBlock, block.NewConcurrentBlock(base), nvidiaBlock, kubejoinBlock)
 	}
 	klog.Infof("building blocks generated for [%s] initialize", m.role)
 	return blocks, nil
 }
 
+// buildHABlocks builds the blocks of a master of a highly available cluster.
+// The first master inits the cluster, the others join its control plane, and
+// all of them run keepalived for the vip once the apiserver is up.
+func (m *Meridian) buildHABlocks(
+	local host.Host,
+	etcdBlock, runtimeBlock, kubeletBlock, kubeAuthBlock, nvidiaBlock, proxyBlock, initBlock, addon block.Block,
+) ([]block.Block, error) {
+	vipBlock, err := kubeadm.NewVIPBlock(m.request, local)
+	if err != nil {
+		return nil, errors.Wrap(err, "new vip block while")
+	}
+	base := block.NewConcurrentBlock([]block.Block{etcdBlock, runtimeBlock, kubeletBlock})
+	if m.action == v1.ActionJoin {
+		joinBlock, err := kubeadm.NewControlPlaneJoinBlock(m.request, local)
+		if err != nil {
+			return nil, errors.Wrap(err, "new kube join block while")
+		}
+		// addons are deployed by the first master only
+		return []block.Block{proxyBlock, base, nvidiaBlock, joinBlock, kubeAuthBlock, vipBlock}, nil
+	}
+	return []block.Block{proxyBlock, base, nvidiaBlock, initBlock, kubeAuthBlock, vipBlock, addon}, nil
+}
+
 func NewLocal(pvd string) (host.Host, error) {
 	info := local.NewMetaData(&local.Config{
 		VpcID:     "xxx",
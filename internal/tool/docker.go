@@ -0,0 +1,39 @@
+package tool
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// DockerContext creates or updates the docker cli context name pointing at
+// the docker api listening on sock, and makes it current when use is set.
+func DockerContext(name, sock string, use bool) error {
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		docker = "/usr/local/bin/docker"
+	}
+	action := "update"
+	if exec.Command(docker, "context", "inspect", name).Run() != nil {
+		action = "create"
+	}
+	cmds := [][]string{{
+		"context", action, name,
+		"--docker", fmt.Sprintf("host=unix://%s", sock),
+		"--description", "meridian docker endpoint",
+	}}
+	if use {
+		cmds = append(cmds, []string{"context", "use", name})
+	}
+	for _, args := range cmds {
+		klog.V(5).Infof("run %s %v", docker, args)
+		out, err := exec.Command(docker, args...).CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "docker %s: %s", strings.Join(args[:2], " "), strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
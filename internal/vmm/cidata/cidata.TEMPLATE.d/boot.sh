@@ -43,10 +43,29 @@ for f in "${MD_CIDATA_MNT}"/boot/*; do
         fi
 done
 
+# The guest agent reports the provision steps from PROVISION_STATUS, where
+# each step leaves <step>.start, its output in <step>.log and <step>.exit.
+PROVISION_STATUS=/run/md-provision
+rm -rf "${PROVISION_STATUS}"
+mkdir -p "${PROVISION_STATUS}"
+if [ -f "${MD_CIDATA_MNT}"/provision.steps ]; then
+	cp "${MD_CIDATA_MNT}"/provision.steps "${PROVISION_STATUS}"/steps
+fi
+
+provision() {
+	step="$(basename "$1")"
+	shift
+	: >"${PROVISION_STATUS}/${step}.start"
+	rc=0
+	"$@" >"${PROVISION_STATUS}/${step}.log" 2>&1 || rc=$?
+	echo "$rc" >"${PROVISION_STATUS}/${step}.exit"
+	return "$rc"
+}
+
 if [ -d "${MD_CIDATA_MNT}"/provision.system ]; then
 	for f in "${MD_CIDATA_MNT}"/provision.system/*; do
 		INFO "Executing $f"
-		if ! "$f"; then
+		if ! provision "$f" "$f"; then
 			WARNING "Failed to execute $f"
 			CODE=1
 		fi
@@ -63,7 +82,7 @@ if [ -d "${MD_CIDATA_MNT}"/provision.user ]; then
 		cp "$f" "${USER_SCRIPT}"
 		chown "${MD_CIDATA_USER}" "${USER_SCRIPT}"
 		chmod 755 "${USER_SCRIPT}"
-		if ! sudo -iu "${MD_CIDATA_USER}" "XDG_RUNTIME_DIR=/run/user/${MD_CIDATA_UID}" "${USER_SCRIPT}"; then
+		if ! provision "$f" sudo -iu "${MD_CIDATA_USER}" "XDG_RUNTIME_DIR=/run/user/${MD_CIDATA_UID}" "${USER_SCRIPT}"; then
 			WARNING "Failed to execute $f (as user ${MD_CIDATA_USER})"
 			CODE=1
 		fi
@@ -71,6 +90,8 @@ if [ -d "${MD_CIDATA_MNT}"/provision.user ]; then
 	done
 fi
 
+touch "${PROVISION_STATUS}"/done
+
 # Signal that provisioning is done. The instance-id in the meta-data file changes on every boot,
 # so any copy from a previous boot cycle will have different content.
 cp "${MD_CIDATA_MNT}"/meta-data /run/md-boot-done
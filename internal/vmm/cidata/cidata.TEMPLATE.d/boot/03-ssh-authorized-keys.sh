@@ -0,0 +1,12 @@
+#!/bin/sh
+set -eu
+
+# cloud-init only adds keys to authorized_keys, replace it with the keys of
+# this boot so rotated and removed keys no longer log in.
+[ -f "${MD_CIDATA_MNT}"/ssh_authorized_keys ] || exit 0
+[ -d "${MD_CIDATA_HOME}" ] || exit 0
+
+mkdir -p "${MD_CIDATA_HOME}"/.ssh
+install -m 600 "${MD_CIDATA_MNT}"/ssh_authorized_keys "${MD_CIDATA_HOME}"/.ssh/authorized_keys
+chown -R "${MD_CIDATA_USER}" "${MD_CIDATA_HOME}"/.ssh
+chmod 700 "${MD_CIDATA_HOME}"/.ssh
@@ -0,0 +1,47 @@
+#!/bin/sh
+set -eu
+
+# systemd services do not read /etc/environment, docker, containerd and the
+# kubelet get the proxy by a drop-in. meridian-node adds the kubernetes cidrs
+# to no_proxy in 20-http-proxy.conf which takes precedence.
+PROXY="${MD_CIDATA_MNT}"/proxy
+
+changed=
+for svc in docker containerd kubelet; do
+	dropin=/etc/systemd/system/${svc}.service.d/10-http-proxy.conf
+	if [ -f "${PROXY}"/http-proxy.conf ]; then
+		if ! cmp -s "${PROXY}"/http-proxy.conf "${dropin}"; then
+			mkdir -p "$(dirname "${dropin}")"
+			install -m 644 "${PROXY}"/http-proxy.conf "${dropin}"
+			changed="${changed} ${svc}"
+		fi
+	elif [ -f "${dropin}" ]; then
+		rm -f "${dropin}"
+		changed="${changed} ${svc}"
+	fi
+done
+if [ -n "${changed}" ] && command -v systemctl >/dev/null 2>&1; then
+	systemctl daemon-reload
+	# shellcheck disable=SC2086
+	systemctl try-restart ${changed} || true
+fi
+
+if [ -d /etc/apt/apt.conf.d ]; then
+	if [ -f "${PROXY}"/apt.conf ]; then
+		install -m 644 "${PROXY}"/apt.conf /etc/apt/apt.conf.d/95md-proxy
+	else
+		rm -f /etc/apt/apt.conf.d/95md-proxy
+	fi
+fi
+
+for conf in /etc/dnf/dnf.conf /etc/yum.conf; do
+	[ -f "${conf}" ] || continue
+	sed -i '/^#MD-PROXY$/,+1d' "${conf}"
+	if [ -f "${PROXY}"/dnf.conf ]; then
+		# read the proxy by sed r, it may hold characters special to sed a
+		block=$(mktemp)
+		{ echo "#MD-PROXY"; cat "${PROXY}"/dnf.conf; } >"${block}"
+		sed -i "/^\[main\]/r ${block}" "${conf}"
+		rm -f "${block}"
+	fi
+done
@@ -53,15 +53,19 @@ func (i *CloudInit) CreateBootDisk() error {
 		return errors.Wrap(err, "failed to build guest binary reader")
 	}
 	layout = append(layout, bin)
+	layout = append(layout, provisionLayout(i.ii.Spec.Provision)...)
+	layout = append(layout, proxyLayout(i.ii.Spec.Proxy, i.ii.Spec.Networks)...)
 
 	instDir := i.ii.Dir()
 	_ = ensurePath(instDir, true)
 	klog.Infof("write iso file Path: %s", filepath.Join(instDir, v1.CIDataISO))
+	// authorized_keys is rewritten on every boot, keys retired meanwhile
+	// are dropped
+	layout = append(layout, &Entry{
+		Path:   "ssh_authorized_keys",
+		reader: strings.NewReader(strings.Join(tplModel.SSHPubKeys, "\n") + "\n"),
+	})
 	if tplModel.VMType == string(v1.WSL2) {
-		layout = append(layout, &Entry{
-			Path:   "ssh_authorized_keys",
-			reader: strings.NewReader(strings.Join(tplModel.SSHPubKeys, "\n")),
-		})
 		return writeDir(filepath.Join(instDir, "cidata"), layout)
 	}
 
@@ -0,0 +1,128 @@
+package cidata
+
+import (
+	"flag"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestNetworkConfig(t *testing.T) {
+	off := false
+	cases := map[string][]v1.Network{
+		"default": {
+			{VZNAT: true, MACAddress: "52:55:55:12:34:56", Address: "192.168.64.2/24", IpGateway: "192.168.64.1"},
+		},
+		"multi-nat": {
+			{VZNAT: true, MACAddress: "52:55:55:12:34:56", Address: "192.168.64.2/24", IpGateway: "192.168.64.1"},
+			{VZNAT: true, MACAddress: "52:55:55:65:43:21", Address: "192.168.64.3/24", IpGateway: "192.168.64.1"},
+		},
+		"multi-nic": {
+			{
+				VZNAT:      true,
+				MACAddress: "52:55:55:12:34:56",
+				Address:    "192.168.64.2/24",
+				IpGateway:  "192.168.64.1",
+				MTU:        1400,
+				Routes:     []v1.Route{{To: "10.0.0.0/8", Via: "192.168.64.1", Metric: 100}},
+				Nameservers: &v1.Nameservers{
+					Addresses: []string{"192.168.64.1"},
+					Search:    []string{"meridian.internal"},
+				},
+			},
+			{
+				MACAddress: "52:55:55:65:43:21",
+				DHCP4:      &off,
+				DHCP6:      true,
+				Addresses:  []string{"172.16.0.10/24", "fd00::10/64"},
+				Routes:     []v1.Route{{To: "default", Via: "172.16.0.1", OnLink: true}},
+				VLANs: []v1.VLAN{
+					{ID: 100, Addresses: []string{"10.100.0.2/24"}},
+					{ID: 200, Name: "storage", DHCP4: true, MTU: 9000},
+				},
+			},
+		},
+	}
+	tmpl, err := fs.ReadFile(ciDataFS, path.Join(ciFSRoot, "network-config"))
+	if err != nil {
+		t.Fatalf("read template: %s", err)
+	}
+	for name, nws := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := v1.ValidateNetworks(nws)
+			if err != nil {
+				t.Fatalf("validate: %s", err)
+			}
+			var args TemplateArgs
+			args.Networks, args.VLANs = networks(nws)
+			out, err := render(string(tmpl), &args)
+			if err != nil {
+				t.Fatalf("render: %s", err)
+			}
+			golden := filepath.Join("testdata", "network-config", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, out, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden: %s", err)
+			}
+			if string(out) != string(want) {
+				t.Fatalf("network-config differs from %s, got:\n%s", golden, out)
+			}
+		})
+	}
+}
+
+func TestValidateNetworks(t *testing.T) {
+	mac, mac2 := "52:55:55:12:34:56", "52:55:55:65:43:21"
+	for _, nws := range [][]v1.Network{
+		{{MACAddress: mac, Address: "192.168.64.2"}},
+		{{MACAddress: mac, Routes: []v1.Route{{To: "10.0.0.0", Via: "192.168.64.1"}}}},
+		{{MACAddress: mac, VLANs: []v1.VLAN{{ID: 4095}}}},
+		{{MACAddress: mac, Interface: "eth0"}, {MACAddress: mac2, Interface: "eth0"}},
+		{{MACAddress: mac, Interface: "eth0", VLANs: []v1.VLAN{{ID: 1, Name: "eth1"}}}, {MACAddress: mac2, Interface: "eth1"}},
+		{{MACAddress: mac, VZNAT: true}, {Interface: "md1"}},
+	} {
+		if err := v1.ValidateNetworks(nws); err == nil {
+			t.Fatalf("expect invalid networks: %+v", nws)
+		}
+	}
+}
+
+func TestUserDataDNS(t *testing.T) {
+	tmpl, err := fs.ReadFile(ciDataFS, path.Join(ciFSRoot, "user-data"))
+	if err != nil {
+		t.Fatalf("read template: %s", err)
+	}
+	spec := &v1.VirtualMachineSpec{
+		Networks:     []v1.Network{{VZNAT: true, Address: "192.168.64.2/24", IpGateway: "192.168.64.1"}},
+		HostResolver: v1.HostResolver{Enabled: true},
+	}
+	args := TemplateArgs{
+		User:         &user.User{Uid: "1000", Username: "vm"},
+		DNSAddresses: dnsAddresses(spec),
+	}
+	out, err := render(string(tmpl), &args)
+	if err != nil {
+		t.Fatalf("render: %s", err)
+	}
+	if !strings.Contains(string(out), "nameservers:\n  - 192.168.64.1\n") {
+		t.Fatalf("expect the gateway as nameserver, got:\n%s", out)
+	}
+
+	spec.HostResolver.Enabled = false
+	if ns := dnsAddresses(spec); len(ns) != 0 {
+		t.Fatalf("expect no nameservers without host resolver, got %v", ns)
+	}
+}
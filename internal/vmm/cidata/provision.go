@@ -0,0 +1,92 @@
+package cidata
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+)
+
+// ProvisionSteps lists "<mode> <file>" of every step in the order boot.sh
+// runs them, the guest agent reports on the steps it names.
+const ProvisionSteps = "provision.steps"
+
+// provisionLayout renders the provision steps into provision.system and
+// provision.user. A step is named by its position and name so the scripts
+// of a mode run in the declared order.
+func provisionLayout(steps []v1.Provision) []*Entry {
+	if len(steps) == 0 {
+		return nil
+	}
+	var (
+		layout  []*Entry
+		ordered = map[string][]string{}
+	)
+	for i := range steps {
+		step := &steps[i]
+		mode := step.GetMode()
+		file := fmt.Sprintf("%02d-%s", i, step.Name)
+		ordered[mode] = append(ordered[mode], fmt.Sprintf("%s %s", mode, file))
+		layout = append(layout, &Entry{
+			Path:   path.Join("provision."+mode, file),
+			reader: strings.NewReader(provisionScript(step)),
+		})
+	}
+	manifest := append(ordered[v1.ProvisionSystem], ordered[v1.ProvisionUser]...)
+	return append(layout, &Entry{
+		Path:   ProvisionSteps,
+		reader: strings.NewReader(strings.Join(manifest, "\n") + "\n"),
+	})
+}
+
+func provisionScript(step *v1.Provision) string {
+	switch {
+	case step.File != nil:
+		f := step.File
+		perm, owner := f.Permissions, f.Owner
+		if perm == "" {
+			perm = "0644"
+		}
+		if owner == "" {
+			owner = "root"
+		}
+		return fmt.Sprintf(`#!/bin/sh
+set -eu
+mkdir -p %[1]s
+echo %[2]s | base64 -d > %[3]s
+chmod %[4]s %[3]s
+chown %[5]s %[3]s
+`, shellQuote(path.Dir(f.Path)), base64.StdEncoding.EncodeToString([]byte(f.Content)),
+			shellQuote(f.Path), shellQuote(perm), shellQuote(owner))
+	case len(step.Packages) > 0:
+		var pkgs []string
+		for _, p := range step.Packages {
+			pkgs = append(pkgs, shellQuote(p))
+		}
+		return fmt.Sprintf(`#!/bin/sh
+set -eu
+if command -v apt-get >/dev/null 2>&1; then
+	export DEBIAN_FRONTEND=noninteractive
+	apt-get update
+	apt-get install -y %[1]s
+elif command -v dnf >/dev/null 2>&1; then
+	dnf install -y %[1]s
+elif command -v apk >/dev/null 2>&1; then
+	apk add %[1]s
+else
+	echo "no supported package manager" >&2
+	exit 1
+fi
+`, strings.Join(pkgs, " "))
+	}
+	if strings.HasPrefix(step.Script, "#!") {
+		return step.Script
+	}
+	return "#!/bin/sh\n" + step.Script
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,67 @@
+package cidata
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+)
+
+// ProxyDir holds the proxy configs boot/07-http-proxy.sh installs
+const ProxyDir = "proxy"
+
+// proxyLayout renders the systemd drop-in of docker, containerd and the
+// kubelet and the proxy configs of apt and dnf.
+func proxyLayout(p *v1.HTTPProxy, spec []v1.Network) []*Entry {
+	if p == nil {
+		return nil
+	}
+	pkgProxy := p.HTTPProxy
+	if pkgProxy == "" {
+		pkgProxy = p.HTTPSProxy
+	}
+	var apt []string
+	if p.HTTPProxy != "" {
+		apt = append(apt, fmt.Sprintf("Acquire::http::Proxy %q;", p.HTTPProxy))
+	}
+	if p.HTTPSProxy != "" {
+		apt = append(apt, fmt.Sprintf("Acquire::https::Proxy %q;", p.HTTPSProxy))
+	}
+	return []*Entry{
+		{
+			Path:   path.Join(ProxyDir, "http-proxy.conf"),
+			reader: strings.NewReader(p.SystemdDropIn(v1.Subnets(spec)...)),
+		},
+		{
+			Path:   path.Join(ProxyDir, "apt.conf"),
+			reader: strings.NewReader(strings.Join(apt, "\n") + "\n"),
+		},
+		{
+			Path:   path.Join(ProxyDir, "dnf.conf"),
+			reader: strings.NewReader(fmt.Sprintf("proxy=%s\n", pkgProxy)),
+		},
+	}
+}
+
+// proxyEnv adds the proxy environment to env for /etc/environment.
+func proxyEnv(env map[string]string, p *v1.HTTPProxy, spec []v1.Network) map[string]string {
+	out := map[string]string{}
+	for k, v := range env {
+		out[k] = v
+	}
+	if p == nil {
+		return out
+	}
+	for k, v := range p.Env(v1.Subnets(spec)...) {
+		out[k] = v
+	}
+	return out
+}
+
+func proxyCA(p *v1.HTTPProxy) []Cert {
+	if p == nil || p.CA == "" {
+		return nil
+	}
+	return []Cert{{Lines: strings.Split(strings.TrimSpace(p.CA), "\n")}}
+}
@@ -7,13 +7,17 @@ import (
 	"bytes"
 	"embed"
 	"fmt"
+	v1 "github.com/aoxn/meridian/api/v1"
 	"github.com/aoxn/meridian/internal/vmm/meta"
 	"github.com/aoxn/meridian/internal/vmm/sshutil"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"io"
 	"io/fs"
 	"k8s.io/klog/v2"
+	"net"
 	"os/user"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -36,6 +40,16 @@ type Network struct {
 	Interface  string
 	IpAddress  string
 	IpGateway  string
+	// Addresses include IpAddress
+	Addresses   []string
+	DHCP4       bool
+	DHCP6       bool
+	MTU         int
+	Routes      []v1.Route
+	Nameservers *v1.Nameservers
+	// VLANId and Link are set for vlans only
+	VLANId int
+	Link   string
 }
 type Mount struct {
 	Tag        string
@@ -63,6 +77,7 @@ type TemplateArgs struct {
 	MountType          string
 	Disks              []Disk
 	Networks           []Network
+	VLANs              []Network
 	Env                map[string]string
 	DNSAddresses       []string
 	CACerts            CACerts
@@ -86,6 +101,11 @@ func NewTpl(ii *meta.Machine, pub []sshutil.PubKey) (*TemplateArgs, error) {
 	for _, p := range pub {
 		pubs = append(pubs, p.Content)
 	}
+	for _, k := range ii.Spec.SSH.AuthorizedKeys {
+		if k = strings.TrimSpace(k); k != "" && !lo.Contains(pubs, k) {
+			pubs = append(pubs, k)
+		}
+	}
 
 	vmInfo := ii.Spec
 	tplModel := TemplateArgs{
@@ -97,7 +117,9 @@ func NewTpl(ii *meta.Machine, pub []sshutil.PubKey) (*TemplateArgs, error) {
 		MountType:  "virtiofs",
 		CACerts: CACerts{
 			RemoveDefaults: false,
+			Trusted:        proxyCA(vmInfo.Proxy),
 		},
+		Env:  proxyEnv(vmInfo.Env, vmInfo.Proxy, vmInfo.Networks),
 		Home: u.HomeDir,
 	}
 	for k, n := range vmInfo.Mounts {
@@ -112,21 +134,84 @@ func NewTpl(ii *meta.Machine, pub []sshutil.PubKey) (*TemplateArgs, error) {
 		tplModel.Mounts = append(tplModel.Mounts, mount)
 	}
 
-	for _, n := range vmInfo.Networks {
-		network := Network{
-			Interface:  "enp0s1",
-			MACAddress: n.MACAddress,
-			IpAddress:  n.Address,
-			IpGateway:  n.IpGateway,
-		}
-		tplModel.Networks = append(tplModel.Networks, network)
-	}
+	tplModel.Networks, tplModel.VLANs = networks(vmInfo.Networks)
+	tplModel.DNSAddresses = dnsAddresses(vmInfo)
 	klog.Infof("network addresses: %+v", tplModel.Networks[0])
 	// change instance id on every boot so network config will be processed again
 	tplModel.IID = fmt.Sprintf("iid-%d", time.Now().Unix())
 	return &tplModel, nil
 }
 
+// dnsAddresses are the nameservers of the guest, the gateway where the host
+// resolver serves when it is enabled.
+func dnsAddresses(spec *v1.VirtualMachineSpec) []string {
+	if spec.HostResolver.Enabled && len(spec.Networks) > 0 {
+		return []string{spec.Networks[0].IpGateway}
+	}
+	return lo.Map(spec.DNS, func(ip net.IP, _ int) string { return ip.String() })
+}
+
+// secondaryRouteMetric is the metric step of the default routes through the
+// gateways of the interfaces other than the first.
+const secondaryRouteMetric = 100
+
+// networks renders spec networks for network-config. The first interface is
+// enp0s1 as before, the others default to md1, md2 etc.
+func networks(spec []v1.Network) (ethernets, vlans []Network) {
+	for i, n := range spec {
+		itf := n.Interface
+		switch {
+		case itf != "":
+		case i == 0:
+			itf = "enp0s1"
+		default:
+			itf = fmt.Sprintf("md%d", i)
+		}
+		var addrs []string
+		if n.Address != "" {
+			addrs = append(addrs, n.Address)
+		}
+		// only the first interface takes the gateway as default route, the
+		// gateways of the others are fallbacks with a lower priority.
+		gateway, routes := n.IpGateway, n.Routes
+		if i > 0 && gateway != "" {
+			routes = append(routes[:len(routes):len(routes)],
+				v1.Route{To: "default", Via: gateway, Metric: secondaryRouteMetric * i})
+			gateway = ""
+		}
+		ethernets = append(ethernets, Network{
+			Interface:   itf,
+			MACAddress:  n.MACAddress,
+			IpAddress:   n.Address,
+			IpGateway:   gateway,
+			Addresses:   append(addrs, n.Addresses...),
+			DHCP4:       n.GetDHCP4(),
+			DHCP6:       n.DHCP6,
+			MTU:         n.MTU,
+			Routes:      routes,
+			Nameservers: n.Nameservers,
+		})
+		for _, v := range n.VLANs {
+			name := v.Name
+			if name == "" {
+				name = fmt.Sprintf("%s.%d", itf, v.ID)
+			}
+			vlans = append(vlans, Network{
+				Interface:   name,
+				Addresses:   v.Addresses,
+				DHCP4:       v.DHCP4,
+				DHCP6:       v.DHCP6,
+				MTU:         v.MTU,
+				Routes:      v.Routes,
+				Nameservers: v.Nameservers,
+				VLANId:      v.ID,
+				Link:        itf,
+			})
+		}
+	}
+	return ethernets, vlans
+}
+
 func (tpl *TemplateArgs) Build(top *embed.FS, root string, validateFn ValidateFn) ([]*Entry, error) {
 	err := validateFn(tpl)
 	if err != nil {
@@ -138,20 +223,6 @@ func (tpl *TemplateArgs) Build(top *embed.FS, root string, validateFn ValidateFn
 		return nil, errors.Wrapf(err, "unexpected root %q", root)
 	}
 
-	render := func(tmpl string, args interface{}) ([]byte, error) {
-		tp, err := template.
-			New("").
-			Parse(tmpl)
-		if err != nil {
-			return nil, err
-		}
-		var b bytes.Buffer
-		err = tp.Execute(&b, args)
-		if err != nil {
-			return nil, err
-		}
-		return b.Bytes(), nil
-	}
 	var layout []*Entry
 	walkFn := func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
@@ -186,6 +257,21 @@ func (tpl *TemplateArgs) Build(top *embed.FS, root string, validateFn ValidateFn
 	return layout, nil
 }
 
+func render(tmpl string, args interface{}) ([]byte, error) {
+	tp, err := template.
+		New("").
+		Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	err = tp.Execute(&b, args)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
 type Entry struct {
 	Path   string
 	reader io.Reader
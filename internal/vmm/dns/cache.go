@@ -0,0 +1,144 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	maxCacheTTL    = time.Hour
+	maxNegativeTTL = 5 * time.Minute
+	// systemCacheTTL is how long the answers of the system resolver are
+	// cached, it reports no ttl
+	systemCacheTTL = 30 * time.Second
+)
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	msg    *dns.Msg
+	stored time.Time
+	expire time.Time
+}
+
+// cache keeps upstream replies for their ttl. Negative replies are kept for
+// the SOA minimum as of RFC 2308, replies without a ttl are not kept.
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*cacheEntry
+	now     func() time.Time
+}
+
+func newCache(size int) *cache {
+	return &cache{size: size, entries: map[cacheKey]*cacheEntry{}, now: time.Now}
+}
+
+func keyOf(req *dns.Msg) (cacheKey, bool) {
+	if len(req.Question) != 1 {
+		return cacheKey{}, false
+	}
+	q := req.Question[0]
+	return cacheKey{name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}, true
+}
+
+// get returns a copy of the cached reply to req with the ttls decreased by
+// the time it has been cached.
+func (c *cache) get(req *dns.Msg) *dns.Msg {
+	key, ok := keyOf(req)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	now := c.now()
+	if !now.Before(e.expire) {
+		delete(c.entries, key)
+		return nil
+	}
+	reply := e.msg.Copy()
+	reply.Id = req.Id
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	for _, rrs := range [][]dns.RR{reply.Answer, reply.Ns, reply.Extra} {
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > elapsed {
+				rr.Header().Ttl -= elapsed
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+	return reply
+}
+
+func (c *cache) set(req, reply *dns.Msg) {
+	c.store(req, reply, ttlOf(reply))
+}
+
+// store keeps reply for ttl regardless of the ttls of its records.
+func (c *cache) store(req, reply *dns.Msg, ttl time.Duration) {
+	key, ok := keyOf(req)
+	if !ok || reply.Truncated || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.entries) >= c.size {
+		c.evict(now)
+	}
+	c.entries[key] = &cacheEntry{msg: reply.Copy(), stored: now, expire: now.Add(ttl)}
+}
+
+// evict removes expired entries, and the one expiring first when none is.
+func (c *cache) evict(now time.Time) {
+	var (
+		first    cacheKey
+		firstExp time.Time
+	)
+	for k, e := range c.entries {
+		if !now.Before(e.expire) {
+			delete(c.entries, k)
+			continue
+		}
+		if firstExp.IsZero() || e.expire.Before(firstExp) {
+			first, firstExp = k, e.expire
+		}
+	}
+	if len(c.entries) >= c.size {
+		delete(c.entries, first)
+	}
+}
+
+func ttlOf(reply *dns.Msg) time.Duration {
+	switch {
+	case reply.Rcode == dns.RcodeSuccess && len(reply.Answer) > 0:
+		ttl := reply.Answer[0].Header().Ttl
+		for _, rr := range reply.Answer {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+		return min(time.Duration(ttl)*time.Second, maxCacheTTL)
+	case reply.Rcode == dns.RcodeSuccess, reply.Rcode == dns.RcodeNameError:
+		for _, rr := range reply.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl := min(soa.Hdr.Ttl, soa.Minttl)
+				return min(time.Duration(ttl)*time.Second, maxNegativeTTL)
+			}
+		}
+	}
+	return 0
+}
@@ -6,6 +6,7 @@ package dns
 
 import (
 	"fmt"
+	"io"
 	"k8s.io/klog/v2"
 	"net"
 	"runtime"
@@ -33,10 +34,17 @@ const (
 )
 
 type HandlerOptions struct {
-	IPv6            bool
-	StaticHosts     map[string]string
+	IPv6        bool
+	StaticHosts map[string]string
+	// UpstreamServers are plain ip[:port], udp://, tcp://, tls:// or https://
+	// resolvers. When set every query not answered by StaticHosts is sent to
+	// them, otherwise the system resolver and resolv.conf are used.
 	UpstreamServers []string
 	TruncateReply   bool
+	// CacheSize is the number of upstream replies cached, 0 disables cache
+	CacheSize int
+	// QueryLog, when set, gets a line for every query
+	QueryLog io.Writer
 }
 
 type ServerOptions struct {
@@ -47,12 +55,14 @@ type ServerOptions struct {
 }
 
 type Handler struct {
-	truncate     bool
-	clientConfig *dns.ClientConfig
-	clients      []*dns.Client
-	ipv6         bool
-	cnameToHost  map[string]string
-	hostToIP     map[string]net.IP
+	truncate    bool
+	upstreams   *Upstreams
+	forward     bool
+	cache       *cache
+	queryLog    *queryLog
+	ipv6        bool
+	cnameToHost map[string]string
+	hostToIP    map[string]net.IP
 }
 
 type Server struct {
@@ -99,7 +109,16 @@ func (h *Handler) lookupCnameToHost(cname string) string {
 func NewHandler(opts HandlerOptions) (dns.Handler, error) {
 	var cc *dns.ClientConfig
 	var err error
-	if len(opts.UpstreamServers) == 0 {
+	var upstreams []Upstream
+	for _, s := range opts.UpstreamServers {
+		up, err := ParseUpstream(s)
+		if err != nil {
+			klog.Warningf("skip dns upstream %s: %s", s, err.Error())
+			continue
+		}
+		upstreams = append(upstreams, up)
+	}
+	if len(upstreams) == 0 {
 		if runtime.GOOS != "windows" {
 			cc, err = dns.ClientConfigFromFile("/etc/resolv.conf")
 			if err != nil {
@@ -117,25 +136,23 @@ func NewHandler(opts HandlerOptions) (dns.Handler, error) {
 				return nil, err
 			}
 		}
-	} else {
-		if cc, err = newStaticClientConfig(opts.UpstreamServers); err != nil {
-			klog.Warningf("failed to create a client config from: %v, falling back to %v, %s", opts.UpstreamServers, defaultFallbackIPs, err.Error())
-			if cc, err = newStaticClientConfig(defaultFallbackIPs); err != nil {
-				return nil, err
-			}
+		for _, srv := range cc.Servers {
+			upstreams = append(upstreams, &plainUpstream{addr: net.JoinHostPort(srv, cc.Port)})
 		}
 	}
-	clients := []*dns.Client{
-		{}, // UDP
-		{Net: "tcp"},
-	}
 	h := &Handler{
-		truncate:     opts.TruncateReply,
-		clientConfig: cc,
-		clients:      clients,
-		ipv6:         opts.IPv6,
-		cnameToHost:  make(map[string]string),
-		hostToIP:     make(map[string]net.IP),
+		truncate:    opts.TruncateReply,
+		upstreams:   NewUpstreams(upstreams...),
+		forward:     len(opts.UpstreamServers) > 0,
+		ipv6:        opts.IPv6,
+		cnameToHost: make(map[string]string),
+		hostToIP:    make(map[string]net.IP),
+	}
+	if opts.CacheSize > 0 {
+		h.cache = newCache(opts.CacheSize)
+	}
+	if opts.QueryLog != nil {
+		h.queryLog = &queryLog{w: opts.QueryLog}
 	}
 	for host, address := range opts.StaticHosts {
 		cname := dns.CanonicalName(host)
@@ -156,7 +173,21 @@ func (h *Handler) handleQuery(w dns.ResponseWriter, req *dns.Msg) {
 	defer w.Close()
 	reply.SetReply(req)
 	klog.Infof("handleQuery received XdpDomain query: %v", req)
+	// answers of the system resolver go through the cache as the upstream
+	// ones do, a disabled AAAA is left to its delay
+	cacheable := h.cache != nil && !h.forward && len(req.Question) == 1 &&
+		!h.isStatic(req.Question[0].Name) && (h.ipv6 || req.Question[0].Qtype != dns.TypeAAAA)
+	if cacheable {
+		if cached := h.cache.get(req); cached != nil {
+			h.writeReply(w, cached, "cache")
+			return
+		}
+	}
 	for _, q := range req.Question {
+		if h.forward && !h.isStatic(q.Name) {
+			// leave it to the upstreams
+			continue
+		}
 		hdr := dns.RR_Header{
 			Name:   q.Name,
 			Rrtype: q.Qtype,
@@ -305,50 +336,76 @@ func (h *Handler) handleQuery(w dns.ResponseWriter, req *dns.Msg) {
 		}
 	}
 	if handled {
-		if h.truncate {
-			reply.Truncate(truncateSize)
+		if cacheable {
+			h.cache.store(req, &reply, systemCacheTTL)
 		}
-		if err := w.WriteMsg(&reply); err != nil {
-			klog.Errorf("handleQuery failed writing XdpDomain reply: %s", err.Error())
-		}
-
+		h.writeReply(w, &reply, "local")
 		return
 	}
 	h.handleDefault(w, req)
 }
 
+func (h *Handler) writeReply(w dns.ResponseWriter, reply *dns.Msg, source string) {
+	answeredBy(w, source)
+	if h.truncate {
+		reply.Truncate(truncateSize)
+	}
+	if err := w.WriteMsg(reply); err != nil {
+		klog.Errorf("handleQuery failed writing XdpDomain reply: %s", err.Error())
+	}
+}
+
 func (h *Handler) handleDefault(w dns.ResponseWriter, req *dns.Msg) {
-	klog.Infof("handleDefault for %v", req)
-	for _, client := range h.clients {
-		for _, srv := range h.clientConfig.Servers {
-			addr := net.JoinHostPort(srv, h.clientConfig.Port)
-			reply, _, err := client.Exchange(req, addr)
-			if err != nil {
-				klog.Errorf("handleDefault failed to perform a synchronous query with upstream [%v], %s", addr, err.Error())
-				continue
-			}
-			if h.truncate {
-				klog.Infof("handleDefault truncating reply: %v", reply)
-				reply.Truncate(truncateSize)
-			}
-			if err = w.WriteMsg(reply); err != nil {
-				klog.Errorf("handleDefault failed writing XdpDomain reply to [%v]: %s", addr, err.Error())
-			}
-			return
-		}
+	klog.V(5).Infof("handleDefault for %v", req)
+	reply, source := h.exchange(req)
+	if reply == nil {
+		reply = new(dns.Msg)
+		reply.SetRcode(req, dns.RcodeServerFailure)
 	}
-	var reply dns.Msg
-	reply.SetReply(req)
+	answeredBy(w, source)
 	if h.truncate {
-		klog.Infof("handleDefault truncating reply: %v", reply)
 		reply.Truncate(truncateSize)
 	}
-	if err := w.WriteMsg(&reply); err != nil {
+	if err := w.WriteMsg(reply); err != nil {
 		klog.Errorf("handleDefault failed writing XdpDomain reply: %s", err.Error())
 	}
 }
 
+// exchange answers req from cache or upstreams, it returns nil when all
+// upstreams fail.
+func (h *Handler) exchange(req *dns.Msg) (*dns.Msg, string) {
+	if h.cache != nil {
+		if reply := h.cache.get(req); reply != nil {
+			return reply, "cache"
+		}
+	}
+	reply, up, err := h.upstreams.Exchange(req)
+	if err != nil {
+		klog.Errorf("handleDefault: %s", err.Error())
+		return nil, "-"
+	}
+	if h.cache != nil {
+		h.cache.set(req, reply)
+	}
+	return reply, up.String()
+}
+
+func (h *Handler) isStatic(name string) bool {
+	cname := dns.CanonicalName(name)
+	if _, ok := h.hostToIP[cname]; ok {
+		return true
+	}
+	_, ok := h.cnameToHost[cname]
+	return ok
+}
+
 func (h *Handler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if h.queryLog != nil {
+		lw := &logWriter{ResponseWriter: w, source: "-"}
+		start := time.Now()
+		defer func() { h.queryLog.record(lw, req, time.Since(start)) }()
+		w = lw
+	}
 	switch req.Opcode {
 	case dns.OpcodeQuery:
 		h.handleQuery(w, req)
@@ -369,6 +426,7 @@ func Start(opts ServerOptions) (*Server, error) {
 	if opts.TCPPort > 0 {
 		tcpSrv, err := listenAndServe(TCP, opts)
 		if err != nil {
+			server.Shutdown()
 			return nil, err
 		}
 		server.tcp = tcpSrv
@@ -390,10 +448,19 @@ func listenAndServe(network Network, opts ServerOptions) (*dns.Server, error) {
 		return nil, err
 	}
 	s := &dns.Server{Net: string(network), Addr: addr, Handler: h}
+	// listen before serving, so that a busy address is reported
+	if network == UDP {
+		s.PacketConn, err = net.ListenPacket(string(network), addr)
+	} else {
+		s.Listener, err = net.Listen(string(network), addr)
+	}
+	if err != nil {
+		return nil, err
+	}
 	go func() {
 		klog.Infof("Start %v XdpDomain listening on: %v", network, addr)
-		if e := s.ListenAndServe(); e != nil {
-			panic(e)
+		if e := s.ActivateAndServe(); e != nil {
+			klog.Errorf("serve %v XdpDomain on %v: %s", network, addr, e.Error())
 		}
 	}()
 
@@ -120,6 +120,23 @@ func TestDNSRecords(t *testing.T) {
 			assert.Assert(t, regexMatch(dnsResult.String(), tc.expectedCNAME))
 		}
 	})
+
+	t.Run("test system answers cached", func(t *testing.T) {
+		options.CacheSize = 16
+		h, err := NewHandler(options)
+		assert.NilError(t, err)
+		cache := h.(*Handler).cache
+
+		req := new(dns.Msg)
+		req.SetQuestion("onerecord.com.", dns.TypeTXT)
+		h.ServeDNS(w, req)
+		assert.Assert(t, cache.get(req) != nil)
+
+		static := new(dns.Msg)
+		static.SetQuestion("my.domain.com.", dns.TypeA)
+		h.ServeDNS(w, static)
+		assert.Assert(t, cache.get(static) == nil)
+	})
 }
 
 type TestResponseWriter struct{}
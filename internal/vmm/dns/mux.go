@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Mux dispatches a query to the handler of its client ip, so that the
+// guests sharing a gateway are answered with their own settings. Queries
+// of other clients are refused.
+type Mux struct {
+	mu       sync.RWMutex
+	handlers map[string]dns.Handler
+}
+
+func NewMux() *Mux {
+	return &Mux{handlers: map[string]dns.Handler{}}
+}
+
+// Set serves the queries from ip with h.
+func (m *Mux) Set(ip string, h dns.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[ip] = h
+}
+
+func (m *Mux) Remove(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.handlers, ip)
+}
+
+// Len is the number of clients served.
+func (m *Mux) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.handlers)
+}
+
+func (m *Mux) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m.mu.RLock()
+	h, ok := m.handlers[clientIP(w.RemoteAddr())]
+	m.mu.RUnlock()
+	if ok {
+		h.ServeDNS(w, req)
+		return
+	}
+	var reply dns.Msg
+	reply.SetRcode(req, dns.RcodeRefused)
+	if err := w.WriteMsg(&reply); err != nil {
+		klog.Errorf("dns mux: write reply: %s", err.Error())
+	}
+}
+
+func clientIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,36 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"gotest.tools/v3/assert"
+)
+
+func TestMux(t *testing.T) {
+	mux := NewMux()
+	srv, err := Serve("127.0.0.1:0", mux)
+	assert.NilError(t, err)
+	defer srv.Shutdown()
+	addr := srv.udp.PacketConn.LocalAddr().String()
+
+	query := func() *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("registry.local.", dns.TypeA)
+		reply, err := dns.Exchange(req, addr)
+		assert.NilError(t, err)
+		return reply
+	}
+	assert.Equal(t, query().Rcode, dns.RcodeRefused)
+
+	h, err := NewHandler(HandlerOptions{StaticHosts: map[string]string{"registry.local": "10.0.0.5"}})
+	assert.NilError(t, err)
+	mux.Set("127.0.0.1", h)
+	reply := query()
+	assert.Equal(t, len(reply.Answer), 1)
+	assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "10.0.0.5")
+
+	mux.Remove("127.0.0.1")
+	assert.Equal(t, query().Rcode, dns.RcodeRefused)
+	assert.Equal(t, mux.Len(), 0)
+}
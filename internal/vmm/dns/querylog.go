@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// queryLog writes a line for every query answered:
+// time client name type rcode answers source duration
+type queryLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *queryLog) record(w *logWriter, req *dns.Msg, d time.Duration) {
+	var (
+		name, qtype = "-", "-"
+		rcode       = "NOREPLY"
+		answers     []string
+	)
+	if len(req.Question) > 0 {
+		name = req.Question[0].Name
+		qtype = dns.TypeToString[req.Question[0].Qtype]
+	}
+	if w.reply != nil {
+		rcode = dns.RcodeToString[w.reply.Rcode]
+		for _, rr := range w.reply.Answer {
+			answers = append(answers, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+	if len(answers) == 0 {
+		answers = []string{"-"}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = fmt.Fprintf(l.w, "%s %s %s %s %s %s %s %s\n",
+		time.Now().Format(time.RFC3339), w.RemoteAddr(), name, qtype, rcode,
+		strings.Join(answers, ","), w.source, d.Round(time.Millisecond))
+}
+
+// logWriter keeps the reply and where it came from for the query log.
+type logWriter struct {
+	dns.ResponseWriter
+	reply  *dns.Msg
+	source string
+}
+
+func (w *logWriter) WriteMsg(m *dns.Msg) error {
+	w.reply = m
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// answeredBy records the source of the reply when w is logged.
+func answeredBy(w dns.ResponseWriter, source string) {
+	if lw, ok := w.(*logWriter); ok {
+		lw.source = source
+	}
+}
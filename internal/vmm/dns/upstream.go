@@ -0,0 +1,233 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	upstreamTimeout = 5 * time.Second
+	// an upstream failing maxFailures times in a row is skipped for
+	// downTime, then tried again
+	maxFailures = 3
+	downTime    = 30 * time.Second
+
+	dnsMessageType = "application/dns-message"
+)
+
+// Upstream exchanges queries with a resolver.
+type Upstream interface {
+	Exchange(req *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// ParseUpstream parses a plain "ip[:port]", "udp://", "tcp://", "tls://"
+// (DNS over TLS) or "https://" (DNS over HTTPS) upstream.
+func ParseUpstream(s string) (Upstream, error) {
+	if !strings.Contains(s, "://") {
+		return &plainUpstream{addr: withPort(s, "53")}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse upstream %s", s)
+	}
+	switch u.Scheme {
+	case "udp":
+		return &plainUpstream{addr: withPort(u.Host, "53")}, nil
+	case "tcp":
+		return &plainUpstream{addr: withPort(u.Host, "53"), tcp: true}, nil
+	case "tls":
+		return &tlsUpstream{
+			addr: withPort(u.Host, "853"),
+			tls:  &tls.Config{ServerName: u.Hostname()},
+		}, nil
+	case "https":
+		return &httpsUpstream{url: u.String(), client: &http.Client{Timeout: upstreamTimeout}}, nil
+	}
+	return nil, fmt.Errorf("unsupported upstream scheme %q", u.Scheme)
+}
+
+func withPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+type plainUpstream struct {
+	addr string
+	tcp  bool
+}
+
+func (u *plainUpstream) String() string {
+	if u.tcp {
+		return "tcp://" + u.addr
+	}
+	return "udp://" + u.addr
+}
+
+// Exchange retries over tcp when the udp reply is truncated.
+func (u *plainUpstream) Exchange(req *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Timeout: upstreamTimeout}
+	if u.tcp {
+		c.Net = "tcp"
+	}
+	reply, _, err := c.Exchange(req, u.addr)
+	if err == nil && reply.Truncated && !u.tcp {
+		c.Net = "tcp"
+		reply, _, err = c.Exchange(req, u.addr)
+	}
+	return reply, err
+}
+
+type tlsUpstream struct {
+	addr string
+	tls  *tls.Config
+}
+
+func (u *tlsUpstream) String() string { return "tls://" + u.addr }
+
+func (u *tlsUpstream) Exchange(req *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: "tcp-tls", TLSConfig: u.tls, Timeout: upstreamTimeout}
+	reply, _, err := c.Exchange(req, u.addr)
+	return reply, err
+}
+
+// httpsUpstream posts wire format messages, see RFC 8484.
+type httpsUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *httpsUpstream) String() string { return u.url }
+
+func (u *httpsUpstream) Exchange(req *dns.Msg) (*dns.Msg, error) {
+	// the id is zero for http caches, RFC 8484 section 4.1
+	q := req.Copy()
+	q.Id = 0
+	body, err := q.Pack()
+	if err != nil {
+		return nil, errors.Wrapf(err, "pack query")
+	}
+	hreq, err := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", dnsMessageType)
+	hreq.Header.Set("Accept", dnsMessageType)
+	resp, err := u.client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh %s: %s", u.url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read doh response")
+	}
+	reply := new(dns.Msg)
+	err = reply.Unpack(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unpack doh response")
+	}
+	reply.Id = req.Id
+	return reply, nil
+}
+
+type upstreamState struct {
+	Upstream
+	failures  int
+	downUntil time.Time
+}
+
+// Upstreams fails over between upstreams in order, skipping those which
+// keep failing.
+type Upstreams struct {
+	mu   sync.Mutex
+	list []*upstreamState
+}
+
+func NewUpstreams(upstreams ...Upstream) *Upstreams {
+	u := &Upstreams{}
+	for _, up := range upstreams {
+		u.list = append(u.list, &upstreamState{Upstream: up})
+	}
+	return u
+}
+
+// order returns the healthy upstreams first, then those down.
+func (u *Upstreams) order() []*upstreamState {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var up, down []*upstreamState
+	now := time.Now()
+	for _, s := range u.list {
+		if now.Before(s.downUntil) {
+			down = append(down, s)
+			continue
+		}
+		up = append(up, s)
+	}
+	return append(up, down...)
+}
+
+func (u *Upstreams) report(s *upstreamState, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err == nil {
+		if !s.downUntil.IsZero() {
+			klog.Infof("dns upstream %s is back", s)
+		}
+		s.failures, s.downUntil = 0, time.Time{}
+		return
+	}
+	s.failures++
+	if s.failures >= maxFailures {
+		if s.downUntil.IsZero() || time.Now().After(s.downUntil) {
+			klog.Warningf("dns upstream %s failed %d times, skip it for %s: %v", s, s.failures, downTime, err)
+		}
+		s.downUntil = time.Now().Add(downTime)
+	}
+}
+
+// Exchange returns the reply of the first upstream which answers.
+func (u *Upstreams) Exchange(req *dns.Msg) (*dns.Msg, Upstream, error) {
+	var errs []error
+	for _, s := range u.order() {
+		reply, err := s.Exchange(req)
+		if err == nil && reply.Rcode == dns.RcodeServerFailure {
+			err = fmt.Errorf("server failure")
+		}
+		u.report(s, err)
+		if err == nil {
+			return reply, s.Upstream, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s, err))
+	}
+	if len(errs) == 0 {
+		return nil, nil, fmt.Errorf("no dns upstream")
+	}
+	return nil, nil, errors.Wrap(joinErrors(errs), "all dns upstreams failed")
+}
+
+func joinErrors(errs []error) error {
+	var msg []string
+	for _, err := range errs {
+		msg = append(msg, err.Error())
+	}
+	return errors.New(strings.Join(msg, "; "))
+}
@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"gotest.tools/v3/assert"
+)
+
+// stub answers every A query with ip and counts the queries.
+type stub struct {
+	ip      string
+	queries int64
+}
+
+func (s *stub) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	_ = w.WriteMsg(s.reply(req))
+}
+
+func (s *stub) reply(req *dns.Msg) *dns.Msg {
+	atomic.AddInt64(&s.queries, 1)
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	q := req.Question[0]
+	if strings.HasPrefix(q.Name, "missing.") {
+		reply.Rcode = dns.RcodeNameError
+		reply.Ns = []dns.RR{&dns.SOA{
+			Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+			Ns:  "ns.example.", Mbox: "root.example.", Minttl: 60,
+		}}
+		return reply
+	}
+	reply.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+		A:   net.ParseIP(s.ip),
+	}}
+	return reply
+}
+
+func serveStub(t *testing.T, s *stub) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	srv := &dns.Server{PacketConn: pc, Handler: s}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func query(name string) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	return req
+}
+
+func TestUpstreamFailover(t *testing.T) {
+	// nothing listens on a closed port
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	dead := pc.LocalAddr().String()
+	_ = pc.Close()
+
+	s := &stub{ip: "10.0.0.1"}
+	dead0, err := ParseUpstream("udp://" + dead)
+	assert.NilError(t, err)
+	live, err := ParseUpstream(serveStub(t, s))
+	assert.NilError(t, err)
+	ups := NewUpstreams(dead0, live)
+
+	for i := 0; i < maxFailures+1; i++ {
+		reply, up, err := ups.Exchange(query("a.example"))
+		assert.NilError(t, err)
+		assert.Equal(t, up, live)
+		assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "10.0.0.1")
+	}
+	// the dead upstream is tried last once it failed maxFailures times
+	order := ups.order()
+	assert.Equal(t, order[0].Upstream, live)
+}
+
+func TestEncryptedUpstreams(t *testing.T) {
+	s := &stub{ip: "10.0.0.2"}
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := new(dns.Msg)
+		if r.Header.Get("Content-Type") != dnsMessageType || req.Unpack(body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := s.reply(req).Pack()
+		w.Header().Set("Content-Type", dnsMessageType)
+		_, _ = io.Copy(w, bytes.NewReader(data))
+	}))
+	defer doh.Close()
+
+	up, err := ParseUpstream(doh.URL + "/dns-query")
+	assert.NilError(t, err)
+	up.(*httpsUpstream).client = doh.Client()
+	reply, err := up.Exchange(query("doh.example"))
+	assert.NilError(t, err)
+	assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "10.0.0.2")
+
+	// DNS over TLS with the certificate of the test server
+	lt, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: doh.TLS.Certificates})
+	assert.NilError(t, err)
+	dot := &dns.Server{Listener: lt, Net: "tcp-tls", Handler: s}
+	go func() { _ = dot.ActivateAndServe() }()
+	defer dot.Shutdown()
+
+	up, err = ParseUpstream("tls://" + lt.Addr().String())
+	assert.NilError(t, err)
+	cfg := doh.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	cfg.ServerName = "example.com"
+	up.(*tlsUpstream).tls = cfg
+	reply, err = up.Exchange(query("dot.example"))
+	assert.NilError(t, err)
+	assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "10.0.0.2")
+}
+
+func TestCache(t *testing.T) {
+	s := &stub{ip: "10.0.0.3"}
+	var log bytes.Buffer
+	h, err := NewHandler(HandlerOptions{
+		UpstreamServers: []string{serveStub(t, s)},
+		CacheSize:       10,
+		QueryLog:        &log,
+	})
+	assert.NilError(t, err)
+	c := h.(*Handler).cache
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	w := new(TestResponseWriter)
+	h.ServeDNS(w, query("a.example"))
+	h.ServeDNS(w, query("a.example"))
+	assert.Equal(t, atomic.LoadInt64(&s.queries), int64(1))
+	assert.Equal(t, dnsResult.Answer[0].(*dns.A).A.String(), "10.0.0.3")
+
+	// ttl is decreased while cached and the entry expires with it
+	now = now.Add(10 * time.Second)
+	h.ServeDNS(w, query("a.example"))
+	assert.Equal(t, dnsResult.Answer[0].Header().Ttl, uint32(20))
+	now = now.Add(20 * time.Second)
+	h.ServeDNS(w, query("a.example"))
+	assert.Equal(t, atomic.LoadInt64(&s.queries), int64(2))
+
+	// negative answers are cached for the soa minimum
+	h.ServeDNS(w, query("missing.example"))
+	h.ServeDNS(w, query("missing.example"))
+	assert.Equal(t, atomic.LoadInt64(&s.queries), int64(3))
+	assert.Equal(t, dnsResult.Rcode, dns.RcodeNameError)
+	now = now.Add(61 * time.Second)
+	h.ServeDNS(w, query("missing.example"))
+	assert.Equal(t, atomic.LoadInt64(&s.queries), int64(4))
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	assert.Equal(t, len(lines), 7)
+	assert.Assert(t, strings.Contains(lines[1], "a.example. A NOERROR 10.0.0.3 cache"))
+}
@@ -0,0 +1,201 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ZoneDomain is the domain vms are resolvable under on the host
+	ZoneDomain = "meridian.internal"
+	// ZoneAddress is where meridiand serves ZoneDomain, over udp and tcp
+	ZoneAddress = "127.0.0.1:5354"
+
+	zoneTTL = 5
+)
+
+// Record resolves Name, and every subdomain of it when Wildcard is set.
+type Record struct {
+	Name     string
+	IPs      []net.IP
+	Wildcard bool
+}
+
+// Zone is an authoritative zone which records are replaced as a whole by
+// Sync. It answers queries in the zone only.
+type Zone struct {
+	origin  string
+	mu      sync.RWMutex
+	records map[string]Record
+}
+
+func NewZone(domain string) *Zone {
+	return &Zone{origin: dns.CanonicalName(domain), records: map[string]Record{}}
+}
+
+// Sync replaces the records of the zone, names are relative to the zone.
+func (z *Zone) Sync(records []Record) {
+	next := make(map[string]Record, len(records))
+	for _, r := range records {
+		next[z.fqdn(r.Name)] = r
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	for name := range next {
+		if _, ok := z.records[name]; !ok {
+			klog.Infof("dns zone: add %s %v", name, next[name].IPs)
+		}
+	}
+	for name := range z.records {
+		if _, ok := next[name]; !ok {
+			klog.Infof("dns zone: remove %s", name)
+		}
+	}
+	z.records = next
+}
+
+func (z *Zone) fqdn(name string) string {
+	return dns.CanonicalName(name + "." + z.origin)
+}
+
+// InZone reports whether name is the zone or a subdomain of it.
+func (z *Zone) InZone(name string) bool {
+	return dns.IsSubDomain(z.origin, dns.CanonicalName(name))
+}
+
+// Lookup returns the addresses of name and whether the name exists.
+func (z *Zone) Lookup(name string) ([]net.IP, bool) {
+	name = dns.CanonicalName(name)
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	if r, ok := z.records[name]; ok {
+		return r.IPs, true
+	}
+	for fqdn, r := range z.records {
+		if r.Wildcard && dns.IsSubDomain(fqdn, name) {
+			return r.IPs, true
+		}
+	}
+	return nil, name == z.origin
+}
+
+// answer fills reply for a question in the zone.
+func (z *Zone) answer(q dns.Question, reply *dns.Msg) {
+	ips, ok := z.Lookup(q.Name)
+	if !ok {
+		reply.Rcode = dns.RcodeNameError
+		return
+	}
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: zoneTTL}
+	for _, ip := range ips {
+		switch {
+		case q.Qtype == dns.TypeA && ip.To4() != nil:
+			hdr.Rrtype = dns.TypeA
+			reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr, A: ip.To4()})
+		case q.Qtype == dns.TypeAAAA && ip.To4() == nil:
+			hdr.Rrtype = dns.TypeAAAA
+			reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip.To16()})
+		}
+	}
+}
+
+// ServeDNS answers queries in the zone and refuses the others.
+func (z *Zone) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	var reply dns.Msg
+	reply.SetReply(req)
+	reply.Authoritative = true
+	for _, q := range req.Question {
+		if !z.InZone(q.Name) {
+			reply.Rcode = dns.RcodeRefused
+			break
+		}
+		z.answer(q, &reply)
+	}
+	if err := w.WriteMsg(&reply); err != nil {
+		klog.Errorf("dns zone: write reply: %s", err.Error())
+	}
+}
+
+// ServeZone serves z on address over udp and tcp.
+func ServeZone(address string, z *Zone) (*Server, error) {
+	server, err := Serve(address, z)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("serving dns zone %s on %s", z.origin, address)
+	return server, nil
+}
+
+// Serve serves h on address over udp and tcp, the address is bound before
+// it returns.
+func Serve(address string, h dns.Handler) (*Server, error) {
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listen udp %s", address)
+	}
+	lt, err := net.Listen("tcp", address)
+	if err != nil {
+		_ = pc.Close()
+		return nil, errors.Wrapf(err, "listen tcp %s", address)
+	}
+	server := &Server{
+		udp: &dns.Server{PacketConn: pc, Handler: h},
+		tcp: &dns.Server{Listener: lt, Handler: h},
+	}
+	for _, s := range []*dns.Server{server.udp, server.tcp} {
+		go func(s *dns.Server) {
+			if err := s.ActivateAndServe(); err != nil {
+				klog.Errorf("dns: serve %s: %s", address, err.Error())
+			}
+		}(s)
+	}
+	return server, nil
+}
+
+// ResolverConfig returns the file which makes the host resolver send
+// queries of domain to address and its content: /etc/resolver on macOS and
+// a systemd-resolved drop-in on linux.
+func ResolverConfig(domain, address string) (string, string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = strconv.Atoi(port); err != nil {
+		return "", "", fmt.Errorf("invalid port %q", port)
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join("/etc/resolver", domain),
+			fmt.Sprintf("nameserver %s\nport %s\n", host, port), nil
+	case "linux":
+		return "/etc/systemd/resolved.conf.d/meridian.conf",
+			fmt.Sprintf("[Resolve]\nDNS=%s\nDomains=~%s\n", address, domain), nil
+	}
+	return "", "", fmt.Errorf("split dns is not supported on %s", runtime.GOOS)
+}
+
+// InstallResolver writes the resolver config of domain.
+func InstallResolver(domain, address string) (string, error) {
+	path, content, err := ResolverConfig(domain, address)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "write %s", path)
+	}
+	return path, nil
+}
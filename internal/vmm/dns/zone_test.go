@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"gotest.tools/v3/assert"
+)
+
+func TestZone(t *testing.T) {
+	z := NewZone(ZoneDomain)
+	z.Sync([]Record{
+		{Name: "aoxn", IPs: []net.IP{net.ParseIP("192.168.64.2")}},
+		{Name: "web", IPs: []net.IP{net.ParseIP("192.168.64.3")}, Wildcard: true},
+	})
+
+	srv, err := ServeZone("127.0.0.1:0", z)
+	assert.NilError(t, err)
+	defer srv.Shutdown()
+	addr := srv.udp.PacketConn.LocalAddr().String()
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(dns.Fqdn(name), qtype)
+		reply, err := dns.Exchange(req, addr)
+		assert.NilError(t, err)
+		return reply
+	}
+
+	reply := query("aoxn.meridian.internal", dns.TypeA)
+	assert.Equal(t, reply.Rcode, dns.RcodeSuccess)
+	assert.Equal(t, len(reply.Answer), 1)
+	assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "192.168.64.2")
+
+	reply = query("api.web.meridian.internal", dns.TypeA)
+	assert.Equal(t, len(reply.Answer), 1)
+	assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "192.168.64.3")
+
+	reply = query("aoxn.meridian.internal", dns.TypeAAAA)
+	assert.Equal(t, reply.Rcode, dns.RcodeSuccess)
+	assert.Equal(t, len(reply.Answer), 0)
+
+	reply = query("api.aoxn.meridian.internal", dns.TypeA)
+	assert.Equal(t, reply.Rcode, dns.RcodeNameError)
+
+	reply = query("example.com", dns.TypeA)
+	assert.Equal(t, reply.Rcode, dns.RcodeRefused)
+
+	// stopped vms are removed
+	z.Sync(nil)
+	reply = query("aoxn.meridian.internal", dns.TypeA)
+	assert.Equal(t, reply.Rcode, dns.RcodeNameError)
+}
@@ -29,9 +29,19 @@ import (
 // Bicopy is from https://github.com/rootless-containers/rootlesskit/blob/v0.10.1/pkg/port/builtin/parent/tcp/tcp.go#L73-L104
 // (originally from libnetwork, Apache License 2.0).
 func Bicopy(x, y net.Conn, quit <-chan struct{}) {
+	BicopyCount(x, y, quit, nil, nil)
+}
+
+// BicopyCount is Bicopy which adds the bytes read from x to in and the bytes
+// read from y to out, counters may be nil.
+func BicopyCount(x, y net.Conn, quit <-chan struct{}, in, out *int64) {
 	var wg sync.WaitGroup
-	broker := func(to, from net.Conn) {
-		cnt, err := io.Copy(to, from)
+	broker := func(to, from net.Conn, n *int64) {
+		var src io.Reader = from
+		if n != nil {
+			src = &countReader{Reader: from, n: n}
+		}
+		cnt, err := io.Copy(to, src)
 		if err != nil {
 			klog.Errorf("[%p]failed to call io.Copy:[%d] copied,  %s", &to, cnt, err.Error())
 		}
@@ -55,8 +65,8 @@ func Bicopy(x, y net.Conn, quit <-chan struct{}) {
 	}
 
 	wg.Add(2)
-	go broker(x, y)
-	go broker(y, x)
+	go broker(x, y, out)
+	go broker(y, x, in)
 	finish := make(chan struct{})
 	go func() {
 		wg.Wait()
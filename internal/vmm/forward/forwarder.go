@@ -2,6 +2,7 @@ package forward
 
 import (
 	"fmt"
+	v1 "github.com/aoxn/meridian/api/v1"
 	"github.com/mdlayher/vsock"
 	"github.com/pkg/errors"
 	dialer "golang.org/x/net/proxy"
@@ -12,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -23,6 +25,22 @@ type Forwarder interface {
 	Stop()
 	BindAddr() string
 	Forward() error
+	Stats() Stats
+}
+
+// Stats is a point in time view of a forwarder.
+type Stats struct {
+	// Connections is the number of connections being forwarded
+	Connections int
+	// LastActive is the last time a connection was opened or closed
+	LastActive time.Time
+	// State is one of Listening, Degraded, Failed and Stopped
+	State string
+	// BytesIn is received from clients, BytesOut is sent back to them
+	BytesIn  int64
+	BytesOut int64
+	// LastError is the most recent error of the forwarder
+	LastError string
 }
 
 func NewForwardMgr() *ForwardMgr {
@@ -72,6 +90,17 @@ func (mgr *ForwardMgr) Remove(key string) {
 	}
 }
 
+// List returns all forwarders managed.
+func (mgr *ForwardMgr) List() []Forwarder {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	var fwds []Forwarder
+	for _, fwd := range mgr.fwd {
+		fwds = append(fwds, fwd)
+	}
+	return fwds
+}
+
 func (mgr *ForwardMgr) String() string {
 	var fwds []string
 	for _, fwd := range mgr.fwd {
@@ -96,13 +125,49 @@ func NewForwarderBy(rule string, dialer ...dialer.Dialer) (Forwarder, error) {
 		return nil, fmt.Errorf("invalid address rule: %s", rule)
 	}
 	klog.Infof("add forwarding rule: %s %s -> %s %s", addrFrom[0], addrFrom[1], addrTo[0], addrTo[1])
+	if _, _, _, ok, _ := splitRange(addrFrom[1]); ok {
+		return newRangeForwarder(rule, addrFrom[0], addrFrom[1], addrTo[0], addrTo[1], dialer...)
+	}
 	return NewForward(rule, addrFrom[0], addrFrom[1], addrTo[0], addrTo[1], dialer...)
 }
 
 func NewForward(rule string, bindNetwork, bindAddr, forwardNetwork, forwardAddr string, dialer ...dialer.Dialer) (Forwarder, error) {
+	if (forwardNetwork == "" || forwardNetwork == "vsock") && len(dialer) == 0 {
+		return nil, fmt.Errorf("virtio need dialer")
+	}
+	switch bindNetwork {
+	case v1.ReversePrefix + "tcp":
+		if len(dialer) == 0 {
+			return nil, fmt.Errorf("reverse forward need dialer")
+		}
+		return &reverseForwarder{
+			rule:       rule,
+			quit:       make(chan struct{}),
+			stopping:   make(chan struct{}),
+			bindAt:     &addr{network: bindNetwork, address: bindAddr},
+			forwardTo:  &addr{network: forwardNetwork, address: forwardAddr},
+			vsock:      dialer[0],
+			hostDialer: addrDialer{},
+		}, nil
+	case "udp", "udp4", "udp6":
+		fwd := &udpForwarder{
+			rule:         rule,
+			quit:         make(chan struct{}),
+			bindAt:       &addr{network: bindNetwork, address: bindAddr},
+			forwardTo:    &addr{network: forwardNetwork, address: forwardAddr},
+			remoteDialer: addrDialer{},
+			idleTimeout:  udpIdleTimeout,
+			sessions:     make(map[string]*udpSession),
+		}
+		if len(dialer) != 0 {
+			fwd.remoteDialer = dialer[0]
+		}
+		return fwd, nil
+	}
 	fwd := &forwarder{
-		rule: rule,
-		quit: make(chan struct{}, 10),
+		rule:     rule,
+		quit:     make(chan struct{}),
+		stopping: make(chan struct{}),
 		bindAt: &addr{
 			network: bindNetwork,
 			address: bindAddr,
@@ -113,9 +178,6 @@ func NewForward(rule string, bindNetwork, bindAddr, forwardNetwork, forwardAddr
 		},
 		remoteDialer: addrDialer{},
 	}
-	if (forwardNetwork == "" || forwardNetwork == "vsock") && len(dialer) == 0 {
-		return nil, fmt.Errorf("virtio need dialer")
-	}
 	if dialer != nil && len(dialer) != 0 {
 		fwd.remoteDialer = dialer[0]
 	}
@@ -123,10 +185,17 @@ func NewForward(rule string, bindNetwork, bindAddr, forwardNetwork, forwardAddr
 }
 
 type forwarder struct {
+	counter
+
 	rule string
 
-	quit chan struct{}
+	// stopping is closed on Stop, quit is closed once connections drained
+	stopping chan struct{}
+	quit     chan struct{}
+	once     sync.Once
 
+	// mu guards lt
+	mu sync.Mutex
 	lt net.Listener
 
 	bindAt *addr
@@ -134,6 +203,11 @@ type forwarder struct {
 	forwardTo *addr
 
 	remoteDialer dialer.Dialer
+
+	// handshake, when set, negotiates the destination with the client and
+	// dials it instead of forwardTo. It returns the client connection to
+	// relay, which holds data buffered during the handshake.
+	handshake func(conn net.Conn, d dialer.Dialer) (client, dst net.Conn, err error)
 }
 
 type addr struct {
@@ -172,14 +246,23 @@ func intPort(sp string) uint32 {
 	return uint32(port)
 }
 
+// Stop closes the listener at once and gives open connections drainTimeout
+// to finish.
 func (p *forwarder) Stop() {
-	_ = p.lt.Close()
-	klog.Infof("stop forwarder: %s", p.bindAt)
-	close(p.quit)
-	if p.bindAt.network != "unix" {
-		return
-	}
-	_ = os.Remove(p.bindAt.address)
+	p.once.Do(func() {
+		p.mu.Lock()
+		close(p.stopping)
+		if p.lt != nil {
+			_ = p.lt.Close()
+		}
+		p.mu.Unlock()
+		p.setState(StateStopped, nil)
+		klog.Infof("stop forwarder: %s", p.bindAt)
+		if p.bindAt.network == "unix" {
+			_ = os.Remove(p.bindAt.address)
+		}
+		go p.drain(p.bindAt, p.quit)
+	})
 }
 
 func (p *forwarder) Rule() string {
@@ -189,38 +272,95 @@ func (p *forwarder) Rule() string {
 func (p *forwarder) BindAddr() string {
 	return p.bindAt.String()
 }
+
+func (p *forwarder) Stats() Stats {
+	return p.stats()
+}
+
+// Forward binds the address and relays accepted connections. Failures to
+// bind, accept or dial are retried with jittered exponential backoff until
+// the forwarder is stopped.
 func (p *forwarder) Forward() error {
-	var err error
-	p.lt, err = p.Listen()
-	if err != nil {
-		return errors.Wrapf(err, "bind listener, %s: %v", p.bindAt, err)
-	}
-	klog.Infof("forwarder listen at: %s", p.bindAt)
+	var bo backoff
 	for {
-		select {
-		case <-p.quit:
+		lt, err := p.Listen()
+		if err == nil {
+			p.mu.Lock()
+			p.lt = lt
+			p.mu.Unlock()
+			break
+		}
+		p.setState(StateFailed, err)
+		klog.Warningf("forwarder: bind listener %s: %v", p.bindAt, err)
+		if !sleep(bo.Step(), p.stopping) {
 			return fmt.Errorf("actively quit forwarder %s", p.bindAt)
-		default:
 		}
+	}
+	select {
+	case <-p.stopping:
+		// stopped while binding
+		_ = p.lt.Close()
+		return fmt.Errorf("actively quit forwarder %s", p.bindAt)
+	default:
+	}
+	bo.Reset()
+	p.setState(StateListening, nil)
+	klog.Infof("forwarder listen at: %s", p.bindAt)
+	for {
 		conn, err := p.lt.Accept()
 		if err != nil {
-			time.Sleep(15 * time.Second)
+			select {
+			case <-p.stopping:
+				return fmt.Errorf("actively quit forwarder %s", p.bindAt)
+			default:
+			}
+			p.setState(StateDegraded, err)
 			klog.Warningf("forwarder: accepting connection [addr %s] with %s", p.bindAt, err)
+			sleep(bo.Step(), p.stopping)
 			continue
 		}
 		klog.V(5).Infof("connection accepted: remote=[%s] -> local=[%s]", conn.RemoteAddr(), conn.LocalAddr())
+		if p.handshake != nil {
+			// a client asking for an unreachable destination does not
+			// degrade the forwarder
+			go p.negotiate(conn)
+			continue
+		}
 		forwardConn, err := p.remoteDialer.Dial(p.forwardTo.network, p.forwardTo.address)
 		if err != nil {
 			_ = conn.Close()
-			time.Sleep(15 * time.Second)
+			p.setState(StateDegraded, err)
 			klog.Warningf("forwarder: dialing connection [addr %s] with %s", p.forwardTo, err)
+			sleep(bo.Step(), p.stopping)
 			continue
 		}
+		bo.Reset()
+		p.setState(StateListening, nil)
 		klog.V(5).Infof("forward new connection: client=[%s] -> local=[%s] -> destination=[%s]", conn.RemoteAddr(), conn.LocalAddr(), p.forwardTo)
-		go Bicopy(conn, forwardConn, p.quit)
+		p.touch()
+		atomic.AddInt64(&p.conns, 1)
+		go func() {
+			BicopyCount(conn, forwardConn, p.quit, &p.bytesIn, &p.bytesOut)
+			atomic.AddInt64(&p.conns, -1)
+			p.touch()
+		}()
 	}
 }
 
+func (p *forwarder) negotiate(conn net.Conn) {
+	client, forwardConn, err := p.handshake(conn, p.remoteDialer)
+	if err != nil {
+		klog.V(5).Infof("forwarder: handshake with %s: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	p.touch()
+	atomic.AddInt64(&p.conns, 1)
+	BicopyCount(client, forwardConn, p.quit, &p.bytesIn, &p.bytesOut)
+	atomic.AddInt64(&p.conns, -1)
+	p.touch()
+}
+
 func (p *forwarder) Listen() (net.Listener, error) {
 	var (
 		err error
@@ -230,7 +370,7 @@ func (p *forwarder) Listen() (net.Listener, error) {
 	switch bindAt.network {
 	case "vsock":
 		lt, err = vsock.Listen(intPort(bindAt.address), &vsock.Config{})
-	case "tcp", "udp", "unix":
+	case "tcp", "unix":
 		if bindAt.network == "unix" {
 			if err = ensureSock(bindAt.address); err != nil {
 				return nil, err
@@ -0,0 +1,90 @@
+package forward
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestForwardStats(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bind := free.Addr().String()
+	_ = free.Close()
+
+	fwd, err := NewForwarderBy("tcp://" + bind + "->tcp://" + echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = fwd.Forward() }()
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		conn, err = net.Dial("tcp", bind)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := fwd.Stats()
+	if stats.State != StateListening || stats.Connections != 1 || stats.BytesIn != 5 || stats.BytesOut != 5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// stop drains the open connection instead of cutting it
+	fwd.Stop()
+	_, err = conn.Write([]byte("again"))
+	if err != nil {
+		t.Fatalf("connection closed by stop: %v", err)
+	}
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "again" {
+		t.Fatalf("expect echo after stop, got %q %v", buf, err)
+	}
+	_ = conn.Close()
+	if fwd.Stats().State != StateStopped {
+		t.Fatalf("expect stopped, got %s", fwd.Stats().State)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var bo backoff
+	for i := 0; i < 20; i++ {
+		d := bo.Step()
+		if d < backoffBase || d > backoffMax+backoffMax/2 {
+			t.Fatalf("step %d out of range: %s", i, d)
+		}
+	}
+	if bo.next != backoffMax {
+		t.Fatalf("expect backoff capped at %s, got %s", backoffMax, bo.next)
+	}
+}
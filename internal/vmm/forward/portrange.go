@@ -0,0 +1,119 @@
+package forward
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	dialer "golang.org/x/net/proxy"
+)
+
+// splitRange splits host:8000-8010 into host and the port range. ok is false
+// when address has no port range.
+func splitRange(address string) (host string, low, high int, ok bool, err error) {
+	host, ports, err := net.SplitHostPort(address)
+	if err != nil || !strings.Contains(ports, "-") {
+		return "", 0, 0, false, nil
+	}
+	lo, hi, found := strings.Cut(ports, "-")
+	low, err = strconv.Atoi(lo)
+	if err == nil {
+		high, err = strconv.Atoi(hi)
+	}
+	if err != nil || low <= 0 || high > 65535 || low > high {
+		return "", 0, 0, false, fmt.Errorf("invalid port range %q", address)
+	}
+	return host, low, high, found, nil
+}
+
+// newRangeForwarder expands a rule with port ranges on both sides into one
+// forwarder per port.
+func newRangeForwarder(
+	rule, bindNetwork, bindAddr, forwardNetwork, forwardAddr string, dialer ...dialer.Dialer,
+) (Forwarder, error) {
+	bindHost, bindLow, bindHigh, ok, err := splitRange(bindAddr)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("invalid bind port range: %s", rule)
+	}
+	fwdHost, fwdLow, fwdHigh, ok, err := splitRange(forwardAddr)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("invalid forward port range: %s", rule)
+	}
+	if bindHigh-bindLow != fwdHigh-fwdLow {
+		return nil, fmt.Errorf("port ranges of different size: %s", rule)
+	}
+	rf := &rangeForwarder{rule: rule, bindAt: &addr{network: bindNetwork, address: bindAddr}}
+	for i := 0; i <= bindHigh-bindLow; i++ {
+		from := net.JoinHostPort(bindHost, strconv.Itoa(bindLow+i))
+		to := net.JoinHostPort(fwdHost, strconv.Itoa(fwdLow+i))
+		sub := fmt.Sprintf("%s://%s->%s://%s", bindNetwork, from, forwardNetwork, to)
+		fwd, err := NewForward(sub, bindNetwork, from, forwardNetwork, to, dialer...)
+		if err != nil {
+			return nil, err
+		}
+		rf.fwds = append(rf.fwds, fwd)
+	}
+	return rf, nil
+}
+
+type rangeForwarder struct {
+	rule   string
+	bindAt *addr
+	fwds   []Forwarder
+}
+
+func (p *rangeForwarder) Rule() string { return p.rule }
+
+func (p *rangeForwarder) BindAddr() string { return p.bindAt.String() }
+
+func (p *rangeForwarder) Stop() {
+	for _, fwd := range p.fwds {
+		fwd.Stop()
+	}
+}
+
+// Forward returns when all ports stopped forwarding, with the first error.
+func (p *rangeForwarder) Forward() error {
+	errs := make(chan error, len(p.fwds))
+	for _, fwd := range p.fwds {
+		go func(fwd Forwarder) {
+			errs <- fwd.Forward()
+		}(fwd)
+	}
+	var first error
+	for range p.fwds {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Stats sums the counters of all ports and reports the worst state.
+func (p *rangeForwarder) Stats() Stats {
+	var stats Stats
+	for _, fwd := range p.fwds {
+		s := fwd.Stats()
+		stats.Connections += s.Connections
+		stats.BytesIn += s.BytesIn
+		stats.BytesOut += s.BytesOut
+		if s.LastActive.After(stats.LastActive) {
+			stats.LastActive = s.LastActive
+		}
+		if stateRank[s.State] > stateRank[stats.State] {
+			stats.State = s.State
+		}
+		if s.LastError != "" {
+			stats.LastError = s.LastError
+		}
+	}
+	return stats
+}
+
+var stateRank = map[string]int{
+	StateStopped:   1,
+	StateListening: 2,
+	StateDegraded:  3,
+	StateFailed:    4,
+}
@@ -0,0 +1,202 @@
+package forward
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	dialer "golang.org/x/net/proxy"
+)
+
+const (
+	socks5Version = 0x05
+
+	socksNoAuth       = 0x00
+	socksNoAcceptable = 0xff
+	socksConnect      = 0x01
+
+	socksIPv4   = 0x01
+	socksDomain = 0x03
+	socksIPv6   = 0x04
+
+	socksSucceeded          = 0x00
+	socksHostUnreachable    = 0x04
+	socksCommandUnsupported = 0x07
+	socksAddrUnsupported    = 0x08
+
+	handshakeTimeout = 10 * time.Second
+)
+
+// NewProxy returns a forwarder serving SOCKS5 and HTTP proxy on bindAddr,
+// destinations are dialed with d. The protocol is told by the first byte a
+// client sends.
+func NewProxy(bindAddr string, d dialer.Dialer) Forwarder {
+	return &forwarder{
+		rule:         fmt.Sprintf("proxy://%s", bindAddr),
+		quit:         make(chan struct{}),
+		stopping:     make(chan struct{}),
+		bindAt:       &addr{network: "tcp", address: bindAddr},
+		forwardTo:    &addr{network: "proxy", address: "guest"},
+		remoteDialer: d,
+		handshake:    proxyHandshake,
+	}
+}
+
+func proxyHandshake(conn net.Conn, d dialer.Dialer) (net.Conn, net.Conn, error) {
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	r := bufio.NewReader(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "read proxy handshake")
+	}
+	var dst net.Conn
+	switch first[0] {
+	case socks5Version:
+		dst, err = socks5Handshake(conn, r, d)
+	default:
+		dst, err = httpHandshake(conn, r, d)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, dst, nil
+	}
+	return conn, dst, nil
+}
+
+// socks5Handshake serves CONNECT without authentication, see RFC 1928.
+func socks5Handshake(conn net.Conn, r *bufio.Reader, d dialer.Dialer) (net.Conn, error) {
+	head := make([]byte, 2)
+	_, err := io.ReadFull(r, head)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read socks greeting")
+	}
+	methods := make([]byte, head[1])
+	_, err = io.ReadFull(r, methods)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read socks methods")
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	_, err = conn.Write([]byte{socks5Version, method})
+	if err != nil || method == socksNoAcceptable {
+		return nil, fmt.Errorf("socks: no acceptable auth method in %v", methods)
+	}
+
+	req := make([]byte, 4)
+	_, err = io.ReadFull(r, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read socks request")
+	}
+	if req[1] != socksConnect {
+		socks5Reply(conn, socksCommandUnsupported)
+		return nil, fmt.Errorf("socks: unsupported command %d", req[1])
+	}
+	var host string
+	switch req[3] {
+	case socksIPv4, socksIPv6:
+		ip := make([]byte, net.IPv4len)
+		if req[3] == socksIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		_, err = io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case socksDomain:
+		var n byte
+		n, err = r.ReadByte()
+		if err == nil {
+			name := make([]byte, n)
+			_, err = io.ReadFull(r, name)
+			host = string(name)
+		}
+	default:
+		socks5Reply(conn, socksAddrUnsupported)
+		return nil, fmt.Errorf("socks: unsupported address type %d", req[3])
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read socks address")
+	}
+	port := make([]byte, 2)
+	_, err = io.ReadFull(r, port)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read socks port")
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	dst, err := d.Dial("tcp", address)
+	if err != nil {
+		socks5Reply(conn, socksHostUnreachable)
+		return nil, errors.Wrapf(err, "socks: dial %s", address)
+	}
+	socks5Reply(conn, socksSucceeded)
+	return dst, nil
+}
+
+// socks5Reply does not report the bound address, clients ignore it for
+// CONNECT.
+func socks5Reply(conn net.Conn, rep byte) {
+	_, _ = conn.Write([]byte{socks5Version, rep, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0})
+}
+
+// httpHandshake serves CONNECT, and plain requests with an absolute uri
+// which are sent once with "Connection: close".
+func httpHandshake(conn net.Conn, r *bufio.Reader, d dialer.Dialer) (net.Conn, error) {
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read http proxy request")
+	}
+	address := req.Host
+	if req.Method != http.MethodConnect {
+		if req.URL.Host == "" {
+			httpReply(conn, http.StatusBadRequest)
+			return nil, fmt.Errorf("http proxy: not a proxy request %s", req.URL)
+		}
+		address = req.URL.Host
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "80")
+	}
+	dst, err := d.Dial("tcp", address)
+	if err != nil {
+		httpReply(conn, http.StatusBadGateway)
+		return nil, errors.Wrapf(err, "http proxy: dial %s", address)
+	}
+	if req.Method == http.MethodConnect {
+		httpReply(conn, http.StatusOK)
+		return dst, nil
+	}
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	req.Close = true
+	err = req.Write(dst)
+	if err != nil {
+		_ = dst.Close()
+		return nil, errors.Wrapf(err, "http proxy: write request to %s", address)
+	}
+	return dst, nil
+}
+
+func httpReply(conn net.Conn, code int) {
+	_, _ = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", code, http.StatusText(code))
+}
+
+// bufferedConn reads what the handshake buffered first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
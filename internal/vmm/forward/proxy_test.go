@@ -0,0 +1,85 @@
+package forward
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	dialer "golang.org/x/net/proxy"
+)
+
+func TestProxy(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	bind := freePort(t)
+	fwd := NewProxy(bind, addrDialer{})
+	defer fwd.Stop()
+	go func() { _ = fwd.Forward() }()
+	for i := 0; i < 100 && fwd.Stats().State != StateListening; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	socks, err := dialer.SOCKS5("tcp", bind, nil, dialer.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := socks.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEcho(t, conn)
+
+	conn, err = net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", echo.Addr(), echo.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expect 200 on CONNECT, got %s", resp.Status)
+	}
+	expectEcho(t, conn)
+}
+
+func expectEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	_, err := conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("expect echo hello, got %q", buf)
+	}
+}
@@ -0,0 +1,277 @@
+package forward
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/pkg/errors"
+	dialer "golang.org/x/net/proxy"
+	"k8s.io/klog/v2"
+)
+
+// Reverse forwarding needs no listener on the host. The host agent opens a
+// control connection through the tunnel with header
+// "reverse+tcp <guest address>\n", the guest answers "ok\n" once listening
+// and then announces every accepted client as "conn <id>\n". The host opens
+// a tunnel connection "accept <id>\n" for it and relays to the host target.
+// The guest listener is closed with the control connection.
+const (
+	acceptNetwork = "accept"
+	// acceptTimeout bounds how long a guest client waits for the host
+	acceptTimeout = 10 * time.Second
+)
+
+// reverseClients are the guest clients waiting for their host connection.
+var reverseClients = &pendingConns{conns: map[uint64]net.Conn{}}
+
+type pendingConns struct {
+	mu    sync.Mutex
+	next  uint64
+	conns map[uint64]net.Conn
+}
+
+func (p *pendingConns) add(conn net.Conn) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next++
+	id := p.next
+	p.conns[id] = conn
+	time.AfterFunc(acceptTimeout, func() {
+		if c := p.take(id); c != nil {
+			klog.Warningf("reverse: host did not accept client %s in %s", c.RemoteAddr(), acceptTimeout)
+			_ = c.Close()
+		}
+	})
+	return id
+}
+
+func (p *pendingConns) take(id uint64) net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.conns[id]
+	if !ok {
+		return nil
+	}
+	delete(p.conns, id)
+	return conn
+}
+
+// serveReverse listens on address inside the guest for as long as the
+// control connection ctl is open.
+func serveReverse(ctl net.Conn, network, address string, quit <-chan struct{}) {
+	defer ctl.Close()
+	lt, err := net.Listen(network, address)
+	if err != nil {
+		_, _ = fmt.Fprintf(ctl, "error %s\n", err)
+		return
+	}
+	defer lt.Close()
+	_, err = fmt.Fprintf(ctl, "ok\n")
+	if err != nil {
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		// the host never writes after the header, read returns once the
+		// control connection is closed
+		_, _ = ctl.Read(make([]byte, 1))
+		close(closed)
+	}()
+	go func() {
+		select {
+		case <-quit:
+		case <-closed:
+		}
+		_ = lt.Close()
+	}()
+	klog.Infof("reverse: listen at %s://%s", network, address)
+	for {
+		conn, err := lt.Accept()
+		if err != nil {
+			klog.Infof("reverse: stop listening at %s://%s: %v", network, address, err)
+			return
+		}
+		id := reverseClients.add(conn)
+		_, err = fmt.Fprintf(ctl, "conn %d\n", id)
+		if err != nil {
+			if c := reverseClients.take(id); c != nil {
+				_ = c.Close()
+			}
+			return
+		}
+	}
+}
+
+// acceptReverse relays the host connection conn to the guest client id.
+func acceptReverse(conn net.Conn, id string, quit <-chan struct{}) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		klog.Warningf("reverse: invalid client id %q", id)
+		_ = conn.Close()
+		return
+	}
+	client := reverseClients.take(n)
+	if client == nil {
+		klog.Warningf("reverse: client %d is gone", n)
+		_ = conn.Close()
+		return
+	}
+	Bicopy(client, conn, quit)
+}
+
+// reverseForwarder is the host side of a reverse forwarding rule. It keeps
+// the control connection open and reconnects with backoff when the guest
+// agent goes away.
+type reverseForwarder struct {
+	counter
+
+	rule string
+
+	// stopping is closed on Stop, quit is closed once connections drained
+	stopping chan struct{}
+	quit     chan struct{}
+	once     sync.Once
+
+	// mu guards ctl
+	mu  sync.Mutex
+	ctl net.Conn
+
+	// bindAt is inside the guest, forwardTo on the host
+	bindAt    *addr
+	forwardTo *addr
+
+	vsock      dialer.Dialer
+	hostDialer dialer.Dialer
+}
+
+func (p *reverseForwarder) Rule() string {
+	return p.rule
+}
+
+func (p *reverseForwarder) BindAddr() string {
+	return p.bindAt.String()
+}
+
+func (p *reverseForwarder) Stats() Stats {
+	return p.stats()
+}
+
+// Stop closes the control connection, which closes the guest listener, and
+// gives open connections drainTimeout to finish.
+func (p *reverseForwarder) Stop() {
+	p.once.Do(func() {
+		p.mu.Lock()
+		close(p.stopping)
+		if p.ctl != nil {
+			_ = p.ctl.Close()
+		}
+		p.mu.Unlock()
+		p.setState(StateStopped, nil)
+		klog.Infof("stop reverse forwarder: %s", p.bindAt)
+		go p.drain(p.bindAt, p.quit)
+	})
+}
+
+func (p *reverseForwarder) Forward() error {
+	var bo backoff
+	for {
+		err := p.serve(&bo)
+		select {
+		case <-p.stopping:
+			return fmt.Errorf("actively quit forwarder %s", p.bindAt)
+		default:
+		}
+		p.setState(StateFailed, err)
+		klog.Warningf("reverse forwarder %s: %v", p.bindAt, err)
+		if !sleep(bo.Step(), p.stopping) {
+			return fmt.Errorf("actively quit forwarder %s", p.bindAt)
+		}
+	}
+}
+
+// serve runs a single control connection until it fails.
+func (p *reverseForwarder) serve(bo *backoff) error {
+	network := strings.TrimPrefix(p.bindAt.network, v1.ReversePrefix)
+	ctl, err := p.dialTunnel(v1.ReversePrefix+network, p.bindAt.address)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	select {
+	case <-p.stopping:
+		p.mu.Unlock()
+		_ = ctl.Close()
+		return nil
+	default:
+	}
+	p.ctl = ctl
+	p.mu.Unlock()
+	defer ctl.Close()
+
+	r := bufio.NewReader(ctl)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return errors.Wrapf(err, "read guest listen result")
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if line != "ok" {
+		return fmt.Errorf("guest listen: %s", strings.TrimPrefix(line, "error "))
+	}
+	bo.Reset()
+	p.setState(StateListening, nil)
+	klog.Infof("reverse forwarder listen in guest at: %s", p.bindAt)
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return errors.Wrapf(err, "control connection closed")
+		}
+		id, found := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "conn ")
+		if !found {
+			klog.Warningf("reverse forwarder %s: unexpected control message %q", p.bindAt, line)
+			continue
+		}
+		go p.relay(id)
+	}
+}
+
+func (p *reverseForwarder) relay(id string) {
+	conn, err := p.dialTunnel(acceptNetwork, id)
+	if err != nil {
+		p.setState(StateDegraded, err)
+		klog.Warningf("reverse forwarder %s: %v", p.bindAt, err)
+		return
+	}
+	dst, err := p.hostDialer.Dial(p.forwardTo.network, p.forwardTo.address)
+	if err != nil {
+		_ = conn.Close()
+		p.setState(StateDegraded, err)
+		klog.Warningf("reverse forwarder: dialing connection [addr %s] with %s", p.forwardTo, err)
+		return
+	}
+	p.setState(StateListening, nil)
+	p.touch()
+	atomic.AddInt64(&p.conns, 1)
+	BicopyCount(conn, dst, p.quit, &p.bytesIn, &p.bytesOut)
+	atomic.AddInt64(&p.conns, -1)
+	p.touch()
+}
+
+func (p *reverseForwarder) dialTunnel(network, address string) (net.Conn, error) {
+	conn, err := p.vsock.Dial("vsock", strconv.Itoa(TunnelPort))
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial tunnel")
+	}
+	_, err = fmt.Fprintf(conn, "%s %s\n", network, address)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "write tunnel header")
+	}
+	return conn, nil
+}
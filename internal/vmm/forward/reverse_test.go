@@ -0,0 +1,98 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReverseForward(t *testing.T) {
+	// host service
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	// guest tunnel
+	tunnel, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	defer tunnel.Close()
+	go func() { _ = ServeTunnel(tunnel, quit) }()
+
+	guest := freePort(t)
+	rule := fmt.Sprintf("reverse+tcp://%s->tcp://%s", guest, echo.Addr())
+	fwd, err := NewForwarderBy(rule, tcpDialer{addr: tunnel.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = fwd.Forward() }()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		conn, err = net.Dial("tcp", guest)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("expect echo hello, got %q", buf)
+	}
+	_ = conn.Close()
+	if s := fwd.Stats(); s.State != StateListening || s.BytesIn != 5 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	fwd.Stop()
+	for i := 0; i < 100; i++ {
+		conn, err = net.Dial("tcp", guest)
+		if err != nil {
+			break
+		}
+		_ = conn.Close()
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err == nil {
+		t.Fatalf("guest listener %s still open after stop", guest)
+	}
+}
+
+func freePort(t *testing.T) string {
+	lt, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lt.Close()
+	return lt.Addr().String()
+}
@@ -0,0 +1,123 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	StateListening = "Listening"
+	// StateDegraded means accepting or dialing the destination fails
+	StateDegraded = "Degraded"
+	// StateFailed means the forwarder can not bind its address
+	StateFailed  = "Failed"
+	StateStopped = "Stopped"
+
+	backoffBase = 200 * time.Millisecond
+	backoffMax  = 15 * time.Second
+	// drainTimeout bounds how long a stopped forwarder waits for its
+	// connections to finish before closing them
+	drainTimeout = 10 * time.Second
+)
+
+// counter is the runtime state shared by forwarders. Counters are accessed
+// atomically, state and lastErr under stateMu.
+type counter struct {
+	conns      int64
+	lastActive int64
+	bytesIn    int64
+	bytesOut   int64
+
+	stateMu sync.Mutex
+	state   string
+	lastErr string
+}
+
+// setState records state, err is kept as last error until the next one.
+func (c *counter) setState(state string, err error) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.state = state
+	if err != nil {
+		c.lastErr = err.Error()
+	}
+}
+
+func (c *counter) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+func (c *counter) stats() Stats {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	s := Stats{
+		Connections: int(atomic.LoadInt64(&c.conns)),
+		State:       c.state,
+		BytesIn:     atomic.LoadInt64(&c.bytesIn),
+		BytesOut:    atomic.LoadInt64(&c.bytesOut),
+		LastError:   c.lastErr,
+	}
+	if nano := atomic.LoadInt64(&c.lastActive); nano != 0 {
+		s.LastActive = time.Unix(0, nano)
+	}
+	return s
+}
+
+// drain waits up to drainTimeout for the connections to finish, then closes
+// quit which closes those left.
+func (c *counter) drain(name fmt.Stringer, quit chan struct{}) {
+	deadline := time.Now().Add(drainTimeout)
+	for atomic.LoadInt64(&c.conns) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&c.conns); n > 0 {
+		klog.Infof("forwarder %s drained with %d connections left, close them", name, n)
+	}
+	close(quit)
+}
+
+// backoff is a jittered exponential backoff between backoffBase and
+// backoffMax.
+type backoff struct {
+	next time.Duration
+}
+
+func (b *backoff) Step() time.Duration {
+	if b.next == 0 {
+		b.next = backoffBase
+	}
+	d := wait.Jitter(b.next, 0.5)
+	b.next = min(2*b.next, backoffMax)
+	return d
+}
+
+func (b *backoff) Reset() { b.next = 0 }
+
+// sleep waits d, it returns false when stop is closed meanwhile.
+func sleep(d time.Duration, stop <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+type countReader struct {
+	io.Reader
+	n *int64
+}
+
+func (r *countReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,113 @@
+package forward
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/pkg/errors"
+	dialer "golang.org/x/net/proxy"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TunnelPort is the vsock port the guest agent serves tunnels on
+	TunnelPort = 10250
+
+	maxTunnelHeader = 512
+)
+
+// ServeTunnel accepts connections on lt and relays each of them to the
+// destination named by its header line "<network> <address>\n", which is
+// dialed inside the guest. Udp datagrams are length framed. Reverse
+// forwarding rules of the host are served here as well.
+func ServeTunnel(lt net.Listener, quit <-chan struct{}) error {
+	for {
+		conn, err := lt.Accept()
+		if err != nil {
+			select {
+			case <-quit:
+				return nil
+			default:
+			}
+			return errors.Wrapf(err, "accept tunnel")
+		}
+		go func() {
+			network, address, err := readTunnelHeader(conn)
+			if err != nil {
+				klog.Warningf("tunnel: read header from %s: %v", conn.RemoteAddr(), err)
+				_ = conn.Close()
+				return
+			}
+			switch {
+			case network == acceptNetwork:
+				acceptReverse(conn, address, quit)
+				return
+			case strings.HasPrefix(network, v1.ReversePrefix):
+				serveReverse(conn, strings.TrimPrefix(network, v1.ReversePrefix), address, quit)
+				return
+			}
+			dst, err := net.Dial(network, address)
+			if err != nil {
+				klog.V(5).Infof("tunnel: dial %s://%s: %v", network, address, err)
+				_ = conn.Close()
+				return
+			}
+			switch network {
+			case "udp", "udp4", "udp6":
+				// datagrams are length framed in the tunnel
+				relayDatagrams(&framedConn{Conn: conn}, newDatagramConn(dst), quit)
+			default:
+				Bicopy(conn, dst, quit)
+			}
+		}()
+	}
+}
+
+// readTunnelHeader reads byte by byte, so that no payload is consumed.
+func readTunnelHeader(conn net.Conn) (string, string, error) {
+	var (
+		buf = make([]byte, 0, 64)
+		b   = make([]byte, 1)
+	)
+	for len(buf) < maxTunnelHeader {
+		_, err := conn.Read(b)
+		if err != nil {
+			return "", "", err
+		}
+		if b[0] == '\n' {
+			network, address, found := strings.Cut(string(buf), " ")
+			if !found {
+				return "", "", fmt.Errorf("invalid tunnel header %q", buf)
+			}
+			return network, address, nil
+		}
+		buf = append(buf, b[0])
+	}
+	return "", "", fmt.Errorf("tunnel header too long")
+}
+
+// NewTunnelDialer returns a dialer which reaches addresses inside the guest
+// through the tunnel, vsock dials the vsock ports of the guest.
+func NewTunnelDialer(vsock dialer.Dialer) dialer.Dialer {
+	return &tunnelDialer{vsock: vsock}
+}
+
+type tunnelDialer struct {
+	vsock dialer.Dialer
+}
+
+func (d *tunnelDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.vsock.Dial("vsock", strconv.Itoa(TunnelPort))
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial tunnel")
+	}
+	_, err = fmt.Fprintf(conn, "%s %s\n", network, address)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "write tunnel header")
+	}
+	return conn, nil
+}
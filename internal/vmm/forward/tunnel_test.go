@@ -0,0 +1,63 @@
+package forward
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// tcpDialer stands in for the vsock dialer of a vm
+type tcpDialer struct {
+	addr string
+}
+
+func (d tcpDialer) Dial(network, addr string) (net.Conn, error) {
+	return net.Dial("tcp", d.addr)
+}
+
+func TestTunnel(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	lt, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	defer lt.Close()
+	go func() { _ = ServeTunnel(lt, quit) }()
+
+	conn, err := NewTunnelDialer(tcpDialer{addr: lt.Addr().String()}).Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("expect echo hello, got %q", buf)
+	}
+}
@@ -0,0 +1,296 @@
+package forward
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dialer "golang.org/x/net/proxy"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// udpIdleTimeout closes a client session without traffic
+	udpIdleTimeout = 60 * time.Second
+	maxDatagram    = 65535
+)
+
+// datagramConn carries whole datagrams, either natively over a udp socket
+// or length framed over a stream like vsock or the guest tunnel.
+type datagramConn interface {
+	ReadDatagram(b []byte) (int, error)
+	WriteDatagram(b []byte) error
+	Close() error
+}
+
+func newDatagramConn(conn net.Conn) datagramConn {
+	if _, ok := conn.(net.PacketConn); ok {
+		return &packetConn{Conn: conn}
+	}
+	return &framedConn{Conn: conn}
+}
+
+type packetConn struct {
+	net.Conn
+}
+
+func (c *packetConn) ReadDatagram(b []byte) (int, error) { return c.Read(b) }
+
+func (c *packetConn) WriteDatagram(b []byte) error {
+	_, err := c.Write(b)
+	return err
+}
+
+// framedConn prefixes every datagram with its length as uint16 big endian.
+type framedConn struct {
+	net.Conn
+	wmu sync.Mutex
+}
+
+func (c *framedConn) ReadDatagram(b []byte) (int, error) {
+	var size [2]byte
+	_, err := io.ReadFull(c.Conn, size[:])
+	if err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(size[:]))
+	if n > len(b) {
+		return 0, fmt.Errorf("datagram of %d bytes exceeds buffer", n)
+	}
+	return io.ReadFull(c.Conn, b[:n])
+}
+
+func (c *framedConn) WriteDatagram(b []byte) error {
+	if len(b) > maxDatagram {
+		return fmt.Errorf("datagram of %d bytes too large", len(b))
+	}
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Write(frame)
+	return err
+}
+
+// relayDatagrams copies datagrams between x and y until either fails.
+func relayDatagrams(x, y datagramConn, quit <-chan struct{}) {
+	done := make(chan struct{}, 2)
+	broker := func(to, from datagramConn) {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, err := from.ReadDatagram(buf)
+			if err != nil {
+				break
+			}
+			if err = to.WriteDatagram(buf[:n]); err != nil {
+				break
+			}
+		}
+		done <- struct{}{}
+	}
+	go broker(x, y)
+	go broker(y, x)
+	select {
+	case <-quit:
+	case <-done:
+	}
+	_ = x.Close()
+	_ = y.Close()
+}
+
+// udpForwarder tracks a session per client address, every session has its
+// own connection to the destination and is closed after udpIdleTimeout.
+type udpForwarder struct {
+	counter
+
+	rule string
+	quit chan struct{}
+	once sync.Once
+
+	bindAt       *addr
+	forwardTo    *addr
+	remoteDialer dialer.Dialer
+	idleTimeout  time.Duration
+
+	// mu guards pc and sessions
+	mu       sync.Mutex
+	pc       net.PacketConn
+	sessions map[string]*udpSession
+}
+
+type udpSession struct {
+	client net.Addr
+	remote datagramConn
+	// last(unix nano) is accessed atomically
+	last int64
+}
+
+func (p *udpForwarder) Rule() string { return p.rule }
+
+func (p *udpForwarder) BindAddr() string { return p.bindAt.String() }
+
+// Stop closes the socket and all sessions, udp has no connection to drain.
+func (p *udpForwarder) Stop() {
+	p.once.Do(func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		close(p.quit)
+		if p.pc != nil {
+			_ = p.pc.Close()
+		}
+		p.setState(StateStopped, nil)
+		klog.Infof("stop forwarder: %s", p.bindAt)
+	})
+}
+
+func (p *udpForwarder) Stats() Stats {
+	return p.stats()
+}
+
+func (p *udpForwarder) touchSession(s *udpSession) {
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+	p.touch()
+}
+
+func (p *udpForwarder) Forward() error {
+	var (
+		bo backoff
+		pc net.PacketConn
+	)
+	for {
+		var err error
+		pc, err = net.ListenPacket(p.bindAt.network, p.bindAt.address)
+		if err == nil {
+			break
+		}
+		p.setState(StateFailed, err)
+		klog.Warningf("forwarder: bind listener %s: %v", p.bindAt, err)
+		if !sleep(bo.Step(), p.quit) {
+			return fmt.Errorf("actively quit forwarder %s", p.bindAt)
+		}
+	}
+	p.mu.Lock()
+	select {
+	case <-p.quit:
+		p.mu.Unlock()
+		_ = pc.Close()
+		return fmt.Errorf("actively quit forwarder %s", p.bindAt)
+	default:
+	}
+	p.pc = pc
+	p.mu.Unlock()
+	p.setState(StateListening, nil)
+	klog.Infof("forwarder listen at: %s", p.bindAt)
+	go p.reap()
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-p.quit:
+				return fmt.Errorf("actively quit forwarder %s", p.bindAt)
+			default:
+			}
+			p.setState(StateDegraded, err)
+			klog.Warningf("forwarder: read datagram [addr %s] with %s", p.bindAt, err)
+			sleep(bo.Step(), p.quit)
+			continue
+		}
+		atomic.AddInt64(&p.bytesIn, int64(n))
+		s, err := p.session(client)
+		if err != nil {
+			p.setState(StateDegraded, err)
+			klog.Warningf("forwarder: dialing [addr %s] with %s", p.forwardTo, err)
+			continue
+		}
+		bo.Reset()
+		p.setState(StateListening, nil)
+		p.touchSession(s)
+		err = s.remote.WriteDatagram(buf[:n])
+		if err != nil {
+			klog.V(5).Infof("forwarder: write datagram to %s: %v", p.forwardTo, err)
+			p.closeSession(s)
+		}
+	}
+}
+
+func (p *udpForwarder) session(client net.Addr) (*udpSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.sessions[client.String()]
+	if ok {
+		return s, nil
+	}
+	conn, err := p.remoteDialer.Dial(p.forwardTo.network, p.forwardTo.address)
+	if err != nil {
+		return nil, err
+	}
+	s = &udpSession{client: client, remote: newDatagramConn(conn)}
+	p.sessions[client.String()] = s
+	atomic.AddInt64(&p.conns, 1)
+	klog.V(5).Infof("forward new udp session: client=[%s] -> destination=[%s]", client, p.forwardTo)
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, err := s.remote.ReadDatagram(buf)
+			if err != nil {
+				break
+			}
+			p.touchSession(s)
+			atomic.AddInt64(&p.bytesOut, int64(n))
+			_, err = p.pc.WriteTo(buf[:n], s.client)
+			if err != nil {
+				break
+			}
+		}
+		p.closeSession(s)
+	}()
+	return s, nil
+}
+
+func (p *udpForwarder) closeSession(s *udpSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sessions[s.client.String()] == s {
+		delete(p.sessions, s.client.String())
+		atomic.AddInt64(&p.conns, -1)
+	}
+	_ = s.remote.Close()
+}
+
+// reap closes idle sessions until the forwarder stops.
+func (p *udpForwarder) reap() {
+	tick := time.NewTicker(p.idleTimeout / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.quit:
+			p.mu.Lock()
+			for _, s := range p.sessions {
+				_ = s.remote.Close()
+			}
+			p.sessions = map[string]*udpSession{}
+			atomic.StoreInt64(&p.conns, 0)
+			p.mu.Unlock()
+			return
+		case <-tick.C:
+		}
+		var idle []*udpSession
+		p.mu.Lock()
+		for _, s := range p.sessions {
+			if time.Since(time.Unix(0, atomic.LoadInt64(&s.last))) > p.idleTimeout {
+				idle = append(idle, s)
+			}
+		}
+		p.mu.Unlock()
+		for _, s := range idle {
+			klog.V(5).Infof("udp session idle, close: client=[%s]", s.client)
+			p.closeSession(s)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package forward
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func udpEcho(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc
+}
+
+func freeUDPAddr(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
+
+func roundTrip(t *testing.T, fwd Forwarder, bind string) {
+	go func() { _ = fwd.Forward() }()
+	defer fwd.Stop()
+
+	conn, err := net.Dial("udp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 64)
+	// the listener may not be bound yet, retry
+	for i := 0; i < 50; i++ {
+		_, _ = conn.Write([]byte("ping"))
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err == nil {
+			if string(buf[:n]) != "ping" {
+				t.Fatalf("expect echo ping, got %q", buf[:n])
+			}
+			if fwd.Stats().Connections != 1 {
+				t.Fatalf("expect 1 udp session, got %d", fwd.Stats().Connections)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("no echo through %s", fwd.Rule())
+}
+
+func TestUDPForward(t *testing.T) {
+	echo := udpEcho(t)
+	defer echo.Close()
+
+	bind := freeUDPAddr(t)
+	fwd, err := NewForwarderBy("udp://" + bind + "->udp://" + echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, fwd, bind)
+}
+
+func TestUDPForwardTunnel(t *testing.T) {
+	echo := udpEcho(t)
+	defer echo.Close()
+
+	lt, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	defer lt.Close()
+	go func() { _ = ServeTunnel(lt, quit) }()
+
+	bind := freeUDPAddr(t)
+	tunnel := NewTunnelDialer(tcpDialer{addr: lt.Addr().String()})
+	fwd, err := NewForwarderBy("udp://"+bind+"->udp://"+echo.LocalAddr().String(), tunnel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, fwd, bind)
+}
+
+func TestPortRange(t *testing.T) {
+	fwd, err := NewForwarderBy("tcp://0.0.0.0:8000-8002->tcp://192.168.64.2:9000-9002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, ok := fwd.(*rangeForwarder)
+	if !ok || len(rf.fwds) != 3 {
+		t.Fatalf("expect 3 forwarders, got %#v", fwd)
+	}
+	if rule := rf.fwds[2].Rule(); rule != "tcp://0.0.0.0:8002->tcp://192.168.64.2:9002" {
+		t.Fatalf("unexpected expanded rule: %s", rule)
+	}
+	_, err = NewForwarderBy("tcp://0.0.0.0:8000-8002->tcp://192.168.64.2:9000-9005")
+	if err == nil {
+		t.Fatalf("expect error for ranges of different size")
+	}
+}
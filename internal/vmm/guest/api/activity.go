@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aoxn/meridian/internal/tool/server"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+)
+
+const (
+	// tcpEstablished is TCP_ESTABLISHED in /proc/net/tcp
+	tcpEstablished = "01"
+	sshPort        = 22
+)
+
+// GetActivity reports ssh sessions and cumulative cpu usage of the guest,
+// used by the daemon to decide whether the vm is idle.
+func GetActivity(r *http.Request, w http.ResponseWriter) int {
+	var act v1.Activity
+	for _, f := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		n, err := countFile(f, func(r io.Reader) (int, error) {
+			return countEstablished(r, sshPort)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return server.HttpJson(w, err)
+		}
+		act.SSHSessions += n
+	}
+	stat, err := os.Open("/proc/stat")
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	defer stat.Close()
+	act.CPUBusy, act.CPUTotal, err = cpuJiffies(stat)
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	return server.HttpJson(w, act)
+}
+
+func countFile(name string, fn func(r io.Reader) (int, error)) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return fn(f)
+}
+
+// countEstablished counts established connections on local port in the
+// format of /proc/net/tcp{,6}
+func countEstablished(r io.Reader, port int) (int, error) {
+	var (
+		cnt     = 0
+		scanner = bufio.NewScanner(r)
+		suffix  = fmt.Sprintf(":%04X", port)
+	)
+	// skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if strings.HasSuffix(fields[1], suffix) && fields[3] == tcpEstablished {
+			cnt++
+		}
+	}
+	return cnt, scanner.Err()
+}
+
+// cpuJiffies returns the busy and total jiffies of the aggregated cpu line
+// in /proc/stat, idle and iowait are not busy.
+func cpuJiffies(r io.Reader) (uint64, uint64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		var total, idle uint64
+		for i, f := range fields[1:] {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("parse /proc/stat field %q: %v", f, err)
+			}
+			total += v
+			// idle and iowait
+			if i == 3 || i == 4 {
+				idle += v
+			}
+		}
+		return total - idle, total, nil
+	}
+	return 0, 0, fmt.Errorf("cpu line not found in /proc/stat")
+}
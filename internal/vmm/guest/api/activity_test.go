@@ -0,0 +1,32 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCountEstablished(t *testing.T) {
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0240A8C0:0016 0140A8C0:E2A4 01 00000000:00000000 02:0009C4CB 00000000     0        0 2 4 0000000000000000 20 4 31 10 -1
+   2: 0240A8C0:1F90 0140A8C0:E2A6 01 00000000:00000000 02:0009C4CB 00000000     0        0 3 4 0000000000000000 20 4 31 10 -1
+`
+	n, err := countEstablished(strings.NewReader(tcp), sshPort)
+	if err != nil {
+		t.Fatalf("count: %s", err)
+	}
+	if n != 1 {
+		t.Fatalf("expect 1 ssh session, got %d", n)
+	}
+}
+
+func TestCpuJiffies(t *testing.T) {
+	stat := "cpu  100 0 50 800 50 0 0 0 0 0\ncpu0 100 0 50 800 50 0 0 0 0 0\n"
+	busy, total, err := cpuJiffies(strings.NewReader(stat))
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	if busy != 150 || total != 1000 {
+		t.Fatalf("unexpected busy=%d total=%d", busy, total)
+	}
+}
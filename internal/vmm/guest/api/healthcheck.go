@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/tool/server"
+)
+
+// HealthCheck runs a single probe of the health check in body inside the
+// guest. A failing probe is reported in the result, not as an error.
+func HealthCheck(r *http.Request, w http.ResponseWriter) int {
+	var check v1.HealthCheck
+	err := server.DecodeBody(r.Body, &check)
+	if err != nil {
+		return server.HttpJsonCode(w, err, http.StatusBadRequest)
+	}
+	err = check.Validate()
+	if err != nil {
+		return server.HttpJsonCode(w, err, http.StatusBadRequest)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), check.GetTimeout())
+	defer cancel()
+	result := v1.HealthCheckResult{Healthy: true}
+	err = probe(ctx, &check)
+	if err != nil {
+		result = v1.HealthCheckResult{Message: err.Error()}
+	}
+	return server.HttpJson(w, result)
+}
+
+func probe(ctx context.Context, check *v1.HealthCheck) error {
+	switch {
+	case check.Exec != nil:
+		out, err := exec.CommandContext(ctx, check.Exec.Command[0], check.Exec.Command[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("exec %s: %v, %s", strings.Join(check.Exec.Command, " "), err, lastLine(out))
+		}
+	case check.TCP != nil:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", hostPort(check.TCP.Host, check.TCP.Port))
+		if err != nil {
+			return err
+		}
+		_ = conn.Close()
+	case check.HTTP != nil:
+		return probeHTTP(ctx, check.HTTP)
+	}
+	return nil
+}
+
+// probeClient is shared by all http probes, a probe opens a connection of
+// its own which is closed once answered.
+var probeClient = &http.Client{
+	Transport: &http.Transport{
+		// probes check liveness, not the certificate
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func probeHTTP(ctx context.Context, h *v1.HTTPCheck) error {
+	scheme := h.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://%s/%s", scheme, hostPort(h.Host, h.Port), strings.TrimPrefix(h.Path, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("GET %s: unexpected status code %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+func hostPort(host string, port int) string {
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+)
+
+func TestProbe(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+	port := svr.Listener.Addr().(*net.TCPAddr).Port
+
+	for name, c := range map[string]struct {
+		check   v1.HealthCheck
+		healthy bool
+	}{
+		"exec ok":   {v1.HealthCheck{Exec: &v1.ExecCheck{Command: []string{"true"}}}, true},
+		"exec fail": {v1.HealthCheck{Exec: &v1.ExecCheck{Command: []string{"false"}}}, false},
+		"tcp ok":    {v1.HealthCheck{TCP: &v1.TCPCheck{Port: port}}, true},
+		"http ok":   {v1.HealthCheck{HTTP: &v1.HTTPCheck{Port: port, Path: "/healthz"}}, true},
+		"http 404":  {v1.HealthCheck{HTTP: &v1.HTTPCheck{Port: port, Path: "/missing"}}, false},
+	} {
+		err := probe(context.TODO(), &c.check)
+		if (err == nil) != c.healthy {
+			t.Fatalf("%s: expect healthy=%t, got err %v", name, c.healthy, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/tool/server"
+)
+
+const (
+	// tcpListen is TCP_LISTEN in /proc/net/tcp
+	tcpListen = "0A"
+	// udpUnconnected is TCP_CLOSE, the state of a bound udp socket
+	udpUnconnected = "07"
+)
+
+// GetListeners reports the tcp and udp sockets listening in guest, used by
+// the host agent to forward ports automatically.
+func GetListeners(r *http.Request, w http.ResponseWriter) int {
+	var (
+		all    []v1.GuestListener
+		inodes = map[string]*v1.GuestListener{}
+	)
+	for _, f := range []struct{ file, proto, state string }{
+		{"/proc/net/tcp", "tcp", tcpListen},
+		{"/proc/net/tcp6", "tcp", tcpListen},
+		{"/proc/net/udp", "udp", udpUnconnected},
+		{"/proc/net/udp6", "udp", udpUnconnected},
+	} {
+		err := readProc(f.file, func(r io.Reader) error {
+			ls, err := listening(r, f.proto, f.state)
+			all = append(all, ls...)
+			return err
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return server.HttpJson(w, err)
+		}
+	}
+	for i := range all {
+		inodes[all[i].Process] = &all[i]
+	}
+	processNames(inodes)
+	return server.HttpJson(w, all)
+}
+
+// listening parses /proc/net/{tcp,udp}{,6} and returns sockets in state.
+// Process is filled with the socket inode and resolved later.
+func listening(r io.Reader, proto, state string) ([]v1.GuestListener, error) {
+	var (
+		ls      []v1.GuestListener
+		seen    = map[string]bool{}
+		scanner = bufio.NewScanner(r)
+	)
+	// skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+		ip, port, err := parseHexAddr(fields[1])
+		if err != nil {
+			return ls, err
+		}
+		if proto == "udp" {
+			// connected udp sockets have a remote peer
+			_, rport, err := parseHexAddr(fields[2])
+			if err != nil {
+				return ls, err
+			}
+			if rport != 0 {
+				continue
+			}
+		}
+		key := fmt.Sprintf("%s:%d", ip, port)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ls = append(ls, v1.GuestListener{Proto: proto, IP: ip.String(), Port: port, Process: fields[9]})
+	}
+	return ls, scanner.Err()
+}
+
+// parseHexAddr parses addresses like 0100007F:1F90, the ip is stored as
+// 32bit words in host byte order.
+func parseHexAddr(s string) (net.IP, int, error) {
+	host, p, found := strings.Cut(s, ":")
+	if !found {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(p, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port %q: %v", s, err)
+	}
+	b, err := hex.DecodeString(host)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid ip %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	return net.IP(b), int(port), nil
+}
+
+// processNames replaces the socket inode in Process with the name of the
+// owning process, found by scanning /proc/*/fd.
+func processNames(inodes map[string]*v1.GuestListener) {
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		l, ok := inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]
+		if !ok {
+			continue
+		}
+		comm, err := os.ReadFile(path.Join(path.Dir(path.Dir(fd)), "comm"))
+		if err == nil {
+			l.Process = strings.TrimSpace(string(comm))
+		}
+	}
+	for _, l := range inodes {
+		if strings.Trim(l.Process, "0123456789") == "" {
+			l.Process = ""
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListening(t *testing.T) {
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0BB8 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 31337 1 0000000000000000 100 0 0 10 0
+   1: 0240A8C0:0016 0140A8C0:E2A4 01 00000000:00000000 02:0009C4CB 00000000     0        0 2 4 0000000000000000 20 4 31 10 -1
+`
+	ls, err := listening(strings.NewReader(tcp), "tcp", tcpListen)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	if len(ls) != 1 || ls[0].IP != "127.0.0.1" || ls[0].Port != 3000 || ls[0].Process != "31337" {
+		t.Fatalf("unexpected listeners: %+v", ls)
+	}
+
+	tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 4242 1 0000000000000000 100 0 0 10 0
+`
+	ls, err = listening(strings.NewReader(tcp6), "tcp", tcpListen)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	if len(ls) != 1 || ls[0].IP != "::" || ls[0].Port != 8080 {
+		t.Fatalf("unexpected listeners: %+v", ls)
+	}
+}
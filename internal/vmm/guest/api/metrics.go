@@ -0,0 +1,235 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/tool/server"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const sectorSize = 512
+
+// filesystems whose usage is reported, pseudo filesystems are skipped
+var realFs = map[string]bool{
+	"ext2": true, "ext3": true, "ext4": true, "xfs": true,
+	"btrfs": true, "vfat": true, "virtiofs": true,
+}
+
+// GetMetrics reports cpu, memory, load, disk and network counters of the
+// guest.
+func GetMetrics(r *http.Request, w http.ResponseWriter) int {
+	m := v1.GuestMetrics{
+		Timestamp: metav1.Now(),
+		CPUs:      runtime.NumCPU(),
+	}
+	err := readProc("/proc/stat", func(r io.Reader) error {
+		var err error
+		m.CPUBusy, m.CPUTotal, err = cpuJiffies(r)
+		return err
+	})
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	err = readProc("/proc/meminfo", func(r io.Reader) error {
+		var err error
+		m.MemTotal, m.MemAvailable, err = memInfo(r)
+		return err
+	})
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	err = readProc("/proc/loadavg", func(r io.Reader) error {
+		var err error
+		m.Load1, m.Load5, m.Load15, err = loadAvg(r)
+		return err
+	})
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	err = readProc("/proc/net/dev", func(r io.Reader) error {
+		var err error
+		m.Networks, err = netDev(r)
+		return err
+	})
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	err = readProc("/proc/diskstats", func(r io.Reader) error {
+		var err error
+		m.Disks, err = diskStats(r, isBlockDevice)
+		return err
+	})
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	err = readProc("/proc/mounts", func(r io.Reader) error {
+		m.Filesystems = fsUsage(r)
+		return nil
+	})
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	return server.HttpJson(w, m)
+}
+
+func readProc(name string, fn func(r io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
+
+// memInfo returns MemTotal and MemAvailable of /proc/meminfo in bytes.
+func memInfo(r io.Reader) (uint64, uint64, error) {
+	var (
+		total, avail uint64
+		scanner      = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse /proc/meminfo %s: %v", fields[0], err)
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = v * 1024
+		case "MemAvailable:":
+			avail = v * 1024
+		}
+	}
+	return total, avail, scanner.Err()
+}
+
+func loadAvg(r io.Reader) (float64, float64, float64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0, 0, 0, fmt.Errorf("unexpected /proc/loadavg: %q", data)
+	}
+	var load [3]float64
+	for i := range load {
+		load[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("parse /proc/loadavg: %v", err)
+		}
+	}
+	return load[0], load[1], load[2], nil
+}
+
+// netDev returns received and transmitted bytes per interface in the format
+// of /proc/net/dev, loopback is skipped.
+func netDev(r io.Reader) ([]v1.IOMetrics, error) {
+	var (
+		nets    []v1.IOMetrics
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		name, counters, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			// header
+			continue
+		}
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(counters)
+		if name == "lo" || len(fields) < 9 {
+			continue
+		}
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse /proc/net/dev %s: %v", name, err)
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse /proc/net/dev %s: %v", name, err)
+		}
+		nets = append(nets, v1.IOMetrics{Name: name, RxBytes: rx, TxBytes: tx})
+	}
+	return nets, scanner.Err()
+}
+
+// diskStats returns read and written bytes per block device in the format
+// of /proc/diskstats. Partitions and virtual devices are filtered by accept.
+func diskStats(r io.Reader, accept func(name string) bool) ([]v1.IOMetrics, error) {
+	var (
+		disks   []v1.IOMetrics
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || !accept(fields[2]) {
+			continue
+		}
+		read, err := strconv.ParseUint(fields[5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse /proc/diskstats %s: %v", fields[2], err)
+		}
+		written, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse /proc/diskstats %s: %v", fields[2], err)
+		}
+		disks = append(disks, v1.IOMetrics{
+			Name:    fields[2],
+			RxBytes: read * sectorSize,
+			TxBytes: written * sectorSize,
+		})
+	}
+	return disks, scanner.Err()
+}
+
+// isBlockDevice accepts whole disks listed in /sys/block, except loop and
+// ram devices.
+func isBlockDevice(name string) bool {
+	if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+		return false
+	}
+	_, err := os.Stat(path.Join("/sys/block", name))
+	return err == nil
+}
+
+func fsUsage(r io.Reader) []v1.FsMetrics {
+	var (
+		fss     []v1.FsMetrics
+		seen    = map[string]bool{}
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !realFs[fields[2]] || seen[fields[0]] {
+			continue
+		}
+		var st syscall.Statfs_t
+		err := syscall.Statfs(fields[1], &st)
+		if err != nil {
+			klog.V(5).Infof("statfs %s: %v", fields[1], err)
+			continue
+		}
+		seen[fields[0]] = true
+		bsize := uint64(st.Bsize)
+		fss = append(fss, v1.FsMetrics{
+			Mount: fields[1],
+			Total: st.Blocks * bsize,
+			Used:  (st.Blocks - st.Bfree) * bsize,
+		})
+	}
+	return fss
+}
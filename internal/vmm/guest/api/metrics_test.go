@@ -0,0 +1,47 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemInfo(t *testing.T) {
+	total, avail, err := memInfo(strings.NewReader(`MemTotal:        4020184 kB
+MemFree:          201232 kB
+MemAvailable:    2863304 kB
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4020184*1024 || avail != 2863304*1024 {
+		t.Fatalf("unexpected meminfo: total=%d, available=%d", total, avail)
+	}
+}
+
+func TestNetDev(t *testing.T) {
+	nets, err := netDev(strings.NewReader(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 5243904    4321    0    0    0     0          0         0   234567    1234    0    0    0     0       0          0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 1 || nets[0].Name != "eth0" || nets[0].RxBytes != 5243904 || nets[0].TxBytes != 234567 {
+		t.Fatalf("unexpected net dev: %+v", nets)
+	}
+}
+
+func TestDiskStats(t *testing.T) {
+	disks, err := diskStats(strings.NewReader(`
+ 252       0 vda 6012 1831 512402 1604 2417 3020 81752 3370 0 4356 5110 0 0 0 0
+ 252       1 vda1 5867 1831 504138 1571 2417 3020 81752 3370 0 4328 4941 0 0 0 0
+   7       0 loop0 51 0 2136 12 0 0 0 0 0 48 12 0 0 0 0
+`), func(name string) bool { return name == "vda" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disks) != 1 || disks[0].RxBytes != 512402*512 || disks[0].TxBytes != 81752*512 {
+		t.Fatalf("unexpected disk stats: %+v", disks)
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/tool/server"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// provisionDir is where boot.sh leaves the status of the provision steps
+	provisionDir = "/run/md-provision"
+	// logTailLines of the output of a step are reported
+	logTailLines = 20
+	logTailBytes = 4096
+)
+
+// GetProvision reports the provision steps of the current boot.
+func GetProvision(r *http.Request, w http.ResponseWriter) int {
+	status, err := provisionStatus(provisionDir)
+	if err != nil {
+		return server.HttpJson(w, err)
+	}
+	return server.HttpJson(w, status)
+}
+
+func provisionStatus(dir string) (*v1.ProvisionStatus, error) {
+	status := &v1.ProvisionStatus{}
+	_, err := os.Stat(filepath.Join(dir, "done"))
+	status.Done = err == nil
+
+	f, err := os.Open(filepath.Join(dir, "steps"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		mode, file, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		status.Steps = append(status.Steps, stepStatus(dir, mode, file))
+	}
+	return status, scanner.Err()
+}
+
+// stepStatus of file, which is named <index>-<name>.
+func stepStatus(dir, mode, file string) v1.ProvisionStepStatus {
+	_, name, _ := strings.Cut(file, "-")
+	step := v1.ProvisionStepStatus{Name: name, Mode: mode, Phase: v1.ProvisionPending}
+	if fi, err := os.Stat(filepath.Join(dir, file+".start")); err == nil {
+		step.Phase = v1.ProvisionRunning
+		step.StartedAt = &metav1.Time{Time: fi.ModTime()}
+	}
+	step.LogTail = tail(filepath.Join(dir, file+".log"))
+	exit := filepath.Join(dir, file+".exit")
+	data, err := os.ReadFile(exit)
+	if err != nil {
+		return step
+	}
+	step.ExitCode, err = strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		step.ExitCode = -1
+	}
+	step.Phase = v1.ProvisionSucceeded
+	if step.ExitCode != 0 {
+		step.Phase = v1.ProvisionFailed
+	}
+	if fi, err := os.Stat(exit); err == nil {
+		step.FinishedAt = &metav1.Time{Time: fi.ModTime()}
+	}
+	return step
+}
+
+// tail returns the last lines of file.
+func tail(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > logTailBytes {
+		_, _ = f.Seek(-logTailBytes, io.SeekEnd)
+	}
+	data, _ := io.ReadAll(f)
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	if len(lines) > logTailLines {
+		lines = lines[len(lines)-logTailLines:]
+	}
+	return string(bytes.Join(lines, []byte("\n")))
+}
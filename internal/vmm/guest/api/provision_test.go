@@ -0,0 +1,43 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+)
+
+func TestProvisionStatus(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("steps", "system 00-hosts\nsystem 02-tools\nuser 01-dotfiles\n")
+	write("00-hosts.start", "")
+	write("00-hosts.log", "ok\n")
+	write("00-hosts.exit", "0\n")
+	write("02-tools.start", "")
+	write("02-tools.log", "E: Unable to locate package nope\n")
+	write("02-tools.exit", "100\n")
+
+	status, err := provisionStatus(dir)
+	if err != nil {
+		t.Fatalf("status: %s", err)
+	}
+	if status.Done || len(status.Steps) != 3 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	hosts, tools, dotfiles := status.Steps[0], status.Steps[1], status.Steps[2]
+	if hosts.Name != "hosts" || hosts.Phase != v1.ProvisionSucceeded || hosts.FinishedAt == nil {
+		t.Fatalf("unexpected hosts step: %+v", hosts)
+	}
+	if tools.Phase != v1.ProvisionFailed || tools.ExitCode != 100 || tools.LogTail != "E: Unable to locate package nope" {
+		t.Fatalf("unexpected tools step: %+v", tools)
+	}
+	if dotfiles.Mode != v1.ProvisionUser || dotfiles.Phase != v1.ProvisionPending {
+		t.Fatalf("unexpected dotfiles step: %+v", dotfiles)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/aoxn/meridian/internal/tool/server"
 	"github.com/aoxn/meridian/internal/vmm/forward"
 	"github.com/aoxn/meridian/internal/vmm/guest/api"
+	"github.com/mdlayher/vsock"
 	"github.com/pkg/errors"
 	"io"
 	"k8s.io/klog/v2"
@@ -56,6 +57,18 @@ func RunDaemonAPI() error {
 	if err != nil {
 		return errors.Wrap(err, "add api server rule")
 	}
+
+	tunnel, err := vsock.Listen(forward.TunnelPort, &vsock.Config{})
+	if err != nil {
+		return errors.Wrap(err, "listen tunnel")
+	}
+	defer tunnel.Close()
+	go func() {
+		err := forward.ServeTunnel(tunnel, cancelCtx.Done())
+		if err != nil {
+			klog.Errorf("serve tunnel: %v", err)
+		}
+	}()
 	klog.Infof("waiting for incoming signal")
 	select {
 	case <-cancelCtx.Done():
@@ -107,10 +120,15 @@ func newRoute() map[string]map[string]server.HandlerFunc {
 			"/health":            health,
 			"/api/v1/guest/{id}": api.GetGI,
 			"/api/v1/guest":      api.GetGI,
+			"/api/v1/activity":   api.GetActivity,
+			"/api/v1/metrics":    api.GetMetrics,
+			"/api/v1/listeners":  api.GetListeners,
+			"/api/v1/provision":  api.GetProvision,
 		},
 		"PUT": {},
 		"POST": {
-			"/api/v1/guest": api.GetGI,
+			"/api/v1/guest":       api.GetGI,
+			"/api/v1/healthcheck": api.HealthCheck,
 		},
 		"DELETE": {
 			"/api/v1/guest/{id}": api.GetGI,
@@ -0,0 +1,251 @@
+package connectivity
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/client"
+	"github.com/aoxn/meridian/internal/vmm/forward"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	dialer "golang.org/x/net/proxy"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// AutoForward polls the sockets the guest listens on and keeps a host
+// forward through the guest tunnel for each of them accepted by the
+// AutoForward rules of vm. Forwards are removed when the socket closes.
+func (ha *Connectivity) AutoForward(ctx context.Context, vm *meta.Machine) {
+	af := vm.Spec.AutoForward
+	if af == nil || !af.Enabled {
+		return
+	}
+	vsock, err := ha.driver.Dialer(ctx)
+	if err != nil {
+		klog.Errorf("auto forward: get dialer: %v", err)
+		return
+	}
+	a := newAutoForwarder(af, ha.fwd, forward.NewTunnelDialer(vsock))
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		listeners, err := guestListeners(ctx, vm.GuestSock())
+		if err != nil {
+			klog.V(5).Infof("auto forward: %v", err)
+			return
+		}
+		a.sync(listeners, time.Now())
+	}, 2*time.Second)
+}
+
+type autoForwarder struct {
+	af     *v1.AutoForward
+	fwd    *forward.ForwardMgr
+	tunnel dialer.Dialer
+	active map[string]bool
+	// failed rules are retried with backoff while the guest listens
+	failed map[string]*retry
+}
+
+func newAutoForwarder(af *v1.AutoForward, fwd *forward.ForwardMgr, tunnel dialer.Dialer) *autoForwarder {
+	return &autoForwarder{
+		af:     af,
+		fwd:    fwd,
+		tunnel: tunnel,
+		active: map[string]bool{},
+		failed: map[string]*retry{},
+	}
+}
+
+// sync adds the forwards of the guest listeners and removes those of the
+// closed ones.
+func (a *autoForwarder) sync(listeners []v1.GuestListener, now time.Time) {
+	var (
+		fwds = a.fwd.List()
+		// forwards like the image cache are added at runtime, read them
+		// on each tick
+		declared, reverse = forwardedAddrs(fwds, a.active)
+		// a forward binds in background, a host port in use shows up as
+		// a failed forward on the next tick
+		states = lo.SliceToMap(fwds, func(fwd forward.Forwarder) (string, forward.Stats) {
+			return fwd.Rule(), fwd.Stats()
+		})
+		want = map[string]bool{}
+	)
+	fail := func(l *v1.GuestListener, rule string, err error) {
+		if a.failed[rule] == nil {
+			klog.Errorf("auto forward %s: %v", l, err)
+		} else {
+			klog.V(5).Infof("auto forward %s: %v", l, err)
+		}
+		a.failed[rule] = a.failed[rule].next(now)
+	}
+	for i := range listeners {
+		l := &listeners[i]
+		if (l.Proto == "udp" && !a.af.UDP) || !a.af.Forward(l) {
+			continue
+		}
+		// the guest end of a reverse forward leads back to the host
+		if l.Proto == "tcp" && lo.ContainsBy(reverse, func(addr string) bool { return sameListener(addr, l) }) {
+			continue
+		}
+		f := autoForwardOf(a.af, l)
+		if declared[f.SrcAddr.String()] {
+			continue
+		}
+		rule := f.Rule()
+		want[rule] = true
+		if a.active[rule] {
+			switch st := states[rule]; st.State {
+			case forward.StateFailed:
+				// stop the forwarder retrying on its own, it is added
+				// again with backoff
+				a.fwd.Remove(rule)
+				delete(a.active, rule)
+				fail(l, rule, errors.New(st.LastError))
+			case forward.StateListening:
+				delete(a.failed, rule)
+			}
+			continue
+		}
+		if !a.failed[rule].due(now) {
+			continue
+		}
+		err := a.fwd.AddBy(rule, a.tunnel)
+		if err != nil {
+			fail(l, rule, err)
+			continue
+		}
+		if a.failed[rule] == nil {
+			klog.Infof("auto forward %s: %s", l, rule)
+		}
+		a.active[rule] = true
+	}
+	for rule := range a.failed {
+		if !want[rule] {
+			delete(a.failed, rule)
+		}
+	}
+	for rule := range a.active {
+		if want[rule] {
+			continue
+		}
+		klog.Infof("auto forward removed, guest socket closed: %s", rule)
+		a.fwd.Remove(rule)
+		delete(a.active, rule)
+	}
+}
+
+// forwardedAddrs returns the host addresses bound by the forwards not in
+// auto, and the guest addresses of the reverse forwards.
+func forwardedAddrs(fwds []forward.Forwarder, auto map[string]bool) (map[string]bool, []string) {
+	var (
+		host    = map[string]bool{}
+		reverse []string
+	)
+	for _, fwd := range fwds {
+		if auto[fwd.Rule()] {
+			continue
+		}
+		network, address, ok := strings.Cut(fwd.BindAddr(), "@")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(network, v1.ReversePrefix) {
+			reverse = append(reverse, address)
+			continue
+		}
+		host[address] = true
+	}
+	return host, reverse
+}
+
+// sameListener reports whether the guest socket l listens on addr, an
+// unspecified ip on either side matches any ip of the same port.
+func sameListener(addr string, l *v1.GuestListener) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port != strconv.Itoa(l.Port) {
+		return false
+	}
+	unspecified := func(ip string) bool {
+		parsed := net.ParseIP(ip)
+		return ip == "" || (parsed != nil && parsed.IsUnspecified())
+	}
+	return host == l.IP || unspecified(host) || unspecified(l.IP)
+}
+
+// retry is the backoff of a rule which failed to forward, eg. the host port
+// is taken by another process.
+type retry struct {
+	at    time.Time
+	delay time.Duration
+}
+
+const maxRetryDelay = 2 * time.Minute
+
+// due reports whether the rule is tried on this tick, a rule never failed
+// always is.
+func (r *retry) due(now time.Time) bool {
+	return r == nil || !now.Before(r.at)
+}
+
+// next doubles the delay of r up to maxRetryDelay.
+func (r *retry) next(now time.Time) *retry {
+	delay := 4 * time.Second
+	if r != nil {
+		delay = min(2*r.delay, maxRetryDelay)
+	}
+	return &retry{at: now.Add(delay), delay: delay}
+}
+
+func autoForwardOf(af *v1.AutoForward, l *v1.GuestListener) v1.PortForward {
+	var (
+		port   = strconv.Itoa(l.Port)
+		hostIP = af.HostIP
+		dstIP  = l.IP
+	)
+	if hostIP == "" {
+		hostIP = "127.0.0.1"
+	}
+	if ip := net.ParseIP(dstIP); ip == nil || ip.IsUnspecified() {
+		dstIP = "127.0.0.1"
+	}
+	return v1.PortForward{
+		SrcProto: l.Proto,
+		SrcAddr:  intstr.FromString(net.JoinHostPort(hostIP, port)),
+		DstProto: l.Proto,
+		DstAddr:  intstr.FromString(net.JoinHostPort(dstIP, port)),
+	}
+}
+
+func guestListeners(ctx context.Context, sock string) ([]v1.GuestListener, error) {
+	ga, err := client.Client(sock)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var listeners []v1.GuestListener
+	return listeners, ga.List(ctx, "listeners", &listeners)
+}
+
+// NetworkProxy serves the SOCKS5 and HTTP proxy of vm, which dials through
+// the guest tunnel.
+func (ha *Connectivity) NetworkProxy(ctx context.Context, vm *meta.Machine) error {
+	np := vm.Spec.NetworkProxy
+	if np == nil || !np.Enabled {
+		return nil
+	}
+	vsock, err := ha.driver.Dialer(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get dialer")
+	}
+	ha.fwd.Add(forward.NewProxy(np.GetAddress(), forward.NewTunnelDialer(vsock)))
+	klog.Infof("network proxy of %s listen at %s", vm.Name, np.GetAddress())
+	return nil
+}
@@ -0,0 +1,108 @@
+package connectivity
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/aoxn/meridian/internal/vmm/forward"
+)
+
+type fakeForwarder struct {
+	forward.Forwarder
+	rule, bindAddr string
+}
+
+func (f *fakeForwarder) Rule() string     { return f.rule }
+func (f *fakeForwarder) BindAddr() string { return f.bindAddr }
+
+func TestForwardedAddrs(t *testing.T) {
+	auto := "tcp://127.0.0.1:8080->tcp://127.0.0.1:8080"
+	fwds := []forward.Forwarder{
+		&fakeForwarder{rule: "tcp://127.0.0.1:2222->tcp://127.0.0.1:22", bindAddr: "tcp@127.0.0.1:2222"},
+		&fakeForwarder{rule: "reverse+tcp://127.0.0.1:5050->tcp://127.0.0.1:5000", bindAddr: "reverse+tcp@127.0.0.1:5050"},
+		&fakeForwarder{rule: auto, bindAddr: "tcp@127.0.0.1:8080"},
+	}
+	host, reverse := forwardedAddrs(fwds, map[string]bool{auto: true})
+	if !host["127.0.0.1:2222"] || len(host) != 1 {
+		t.Fatalf("unexpected host addresses: %v", host)
+	}
+	if len(reverse) != 1 || reverse[0] != "127.0.0.1:5050" {
+		t.Fatalf("unexpected reverse addresses: %v", reverse)
+	}
+}
+
+func TestSameListener(t *testing.T) {
+	cases := []struct {
+		addr string
+		l    v1.GuestListener
+		want bool
+	}{
+		{"127.0.0.1:5050", v1.GuestListener{IP: "127.0.0.1", Port: 5050}, true},
+		{"127.0.0.1:5050", v1.GuestListener{IP: "0.0.0.0", Port: 5050}, true},
+		{"0.0.0.0:5050", v1.GuestListener{IP: "10.0.0.2", Port: 5050}, true},
+		{"127.0.0.1:5050", v1.GuestListener{IP: "10.0.0.2", Port: 5050}, false},
+		{"127.0.0.1:5050", v1.GuestListener{IP: "127.0.0.1", Port: 5051}, false},
+	}
+	for _, c := range cases {
+		if got := sameListener(c.addr, &c.l); got != c.want {
+			t.Errorf("sameListener(%s, %s:%d) = %v, want %v", c.addr, c.l.IP, c.l.Port, got, c.want)
+		}
+	}
+}
+
+func TestAutoForwardPortInUse(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := busy.Addr().(*net.TCPAddr).Port
+	var (
+		now       = time.Now()
+		fwd       = forward.NewForwardMgr()
+		a         = newAutoForwarder(&v1.AutoForward{Enabled: true}, fwd, nil)
+		listeners = []v1.GuestListener{{Proto: "tcp", IP: "0.0.0.0", Port: port}}
+		f         = autoForwardOf(a.af, &listeners[0])
+		rule      = f.Rule()
+	)
+	defer func() {
+		for _, f := range fwd.List() {
+			fwd.Remove(f.Rule())
+		}
+	}()
+	waitState := func(state string) {
+		for i := 0; i < 100; i++ {
+			for _, f := range fwd.List() {
+				if f.Rule() == rule && f.Stats().State == state {
+					return
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("forward %s never became %s", rule, state)
+	}
+
+	a.sync(listeners, now)
+	waitState(forward.StateFailed)
+	a.sync(listeners, now.Add(2*time.Second))
+	if a.active[rule] || a.failed[rule] == nil || len(fwd.List()) != 0 {
+		t.Fatalf("expect the failed forward removed and backed off: %v %v", a.active, a.failed)
+	}
+	a.sync(listeners, now.Add(3*time.Second))
+	if len(fwd.List()) != 0 {
+		t.Fatalf("expect no retry before the backoff")
+	}
+
+	_ = busy.Close()
+	a.sync(listeners, now.Add(7*time.Second))
+	waitState(forward.StateListening)
+	a.sync(listeners, now.Add(9*time.Second))
+	if !a.active[rule] || a.failed[rule] != nil {
+		t.Fatalf("expect the forward listening: %v %v", a.active, a.failed)
+	}
+	a.sync(nil, now.Add(11*time.Second))
+	if a.active[rule] || len(fwd.List()) != 0 {
+		t.Fatalf("expect the forward removed with the guest socket")
+	}
+}
@@ -43,7 +43,7 @@ func (ha *Connectivity) Forward(ctx context.Context, ports []v1.PortForward) err
 	}
 	for _, f := range ports {
 		var dialers []proxy.Dialer
-		if f.DstProto == "vsock" {
+		if f.DstProto == "vsock" || f.Reverse {
 			dialers = append(dialers, dialer)
 		}
 		err = ha.fwd.AddBy(f.Rule(), dialers...)
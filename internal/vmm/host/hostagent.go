@@ -23,6 +23,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,6 +34,7 @@ import (
 	"github.com/aoxn/meridian/internal/vmm/backend/wsl2"
 	"github.com/aoxn/meridian/internal/vmm/cidata"
 	"github.com/sethvargo/go-password/password"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type HostAgent struct {
@@ -91,7 +93,7 @@ func New(vmMeta *meta.Machine, signal chan os.Signal, opts ...Opt) (*HostAgent,
 		return vz.New(base)
 	}
 	driver := newBackend()
-	sshMgr := sshutil.NewSSHMgr("127.0.0.1", meta.Local.Config().Dir())
+	sshMgr := sshutil.NewSSHMgr("127.0.0.1", sshutil.KeyDir(meta.Local.Config().Dir(), vmMeta.Name))
 	var bootDisk cidata.BootDisk
 	switch strings.ToLower(string(vmMeta.Spec.OS)) {
 	case "darwin":
@@ -263,6 +265,11 @@ func (ha *HostAgent) startRoutinesAndWait(ctx context.Context, errCh chan error)
 		if err != nil {
 			klog.Errorf("failed to forward vm to host agent: %v", err)
 		}
+		go ha.connect.AutoForward(ctx, ha.vmMeta)
+		err = ha.connect.NetworkProxy(ctx, ha.vmMeta)
+		if err != nil {
+			klog.Errorf("failed to serve network proxy: %v", err)
+		}
 		stRunning := stBase
 		if haErr := ha.startHostAgentRoutines(ctx); haErr != nil {
 			stRunning.Degraded = true
@@ -372,6 +379,8 @@ func (ha *HostAgent) Serve(ctx context.Context) {
 				_, _ = w.Write([]byte(data))
 				return http.StatusOK
 			},
+			"/api/v1/activity": sandbox.Activity,
+			"/api/v1/forward":  sandbox.ListForward,
 		},
 		"POST": {
 			"/api/v1/forward/{name}": sandbox.Forward,
@@ -439,6 +448,61 @@ func (sbx *sandboxHandler) RemoveForward(r *http.Request, w http.ResponseWriter)
 	return server.HttpJson(w, spec)
 }
 
+// ListForward reports the state and traffic of every forward rule.
+func (sbx *sandboxHandler) ListForward(r *http.Request, w http.ResponseWriter) int {
+	var result []v1.ForwardStatus
+	for _, fwd := range sbx.host.connect.F().List() {
+		stats := fwd.Stats()
+		status := v1.ForwardStatus{
+			VM:          sbx.host.vmMeta.Name,
+			Rule:        fwd.Rule(),
+			BindAddr:    fwd.BindAddr(),
+			State:       stats.State,
+			Connections: stats.Connections,
+			BytesIn:     stats.BytesIn,
+			BytesOut:    stats.BytesOut,
+			LastError:   stats.LastError,
+		}
+		if !stats.LastActive.IsZero() {
+			status.LastActive = &metav1.Time{Time: stats.LastActive}
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Rule < result[j].Rule })
+	return server.HttpJson(w, result)
+}
+
+// Activity reports forwarded connections, the guest agent and docker probe
+// forwards used by meridian itself are not counted.
+func (sbx *sandboxHandler) Activity(r *http.Request, w http.ResponseWriter) int {
+	return server.HttpJson(w, forwardActivity(sbx.host.connect.F().List(), sbx.host.vmMeta))
+}
+
+// forwardActivity sums the connections of fwds but those of meridian itself.
+func forwardActivity(fwds []forward.Forwarder, vm *meta.Machine) v1.Activity {
+	var (
+		act   v1.Activity
+		last  time.Time
+		guest = fmt.Sprintf("unix@%s", vm.GuestSock())
+		probe = fmt.Sprintf("unix@%s", vm.DockerProbeSock())
+	)
+	for _, fwd := range fwds {
+		switch fwd.BindAddr() {
+		case guest, probe:
+			continue
+		}
+		stats := fwd.Stats()
+		act.Connections += stats.Connections
+		if stats.LastActive.After(last) {
+			last = stats.LastActive
+		}
+	}
+	if !last.IsZero() {
+		act.LastActive = &metav1.Time{Time: last}
+	}
+	return act
+}
+
 func (sbx *sandboxHandler) StopVm(r *http.Request, w http.ResponseWriter) int {
 	name := mux.Vars(r)["name"]
 	switch name {
@@ -1,7 +1,43 @@
 package hostagent
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/aoxn/meridian/internal/vmm/forward"
+	"github.com/aoxn/meridian/internal/vmm/meta"
+)
 
 func TestAgent(t *testing.T) {
 
 }
+
+type fakeForwarder struct {
+	forward.Forwarder
+	bindAddr string
+	stats    forward.Stats
+}
+
+func (f *fakeForwarder) BindAddr() string     { return f.bindAddr }
+func (f *fakeForwarder) Stats() forward.Stats { return f.stats }
+
+func TestForwardActivityDockerProbe(t *testing.T) {
+	vm := &meta.Machine{Name: "vm1", AbsDir: "/tmp/vm1"}
+	now := time.Now()
+	probe := &fakeForwarder{
+		bindAddr: "unix@" + vm.DockerProbeSock(),
+		stats:    forward.Stats{Connections: 1, LastActive: now},
+	}
+	act := forwardActivity([]forward.Forwarder{probe}, vm)
+	if act.LastActive != nil || act.Connections != 0 {
+		t.Fatalf("the docker probe should not count as activity: %+v", act)
+	}
+	docker := &fakeForwarder{
+		bindAddr: "unix@" + vm.DockerSock(),
+		stats:    forward.Stats{LastActive: now.Add(-time.Second)},
+	}
+	act = forwardActivity([]forward.Forwarder{probe, docker}, vm)
+	if act.LastActive == nil || !act.LastActive.Time.Equal(docker.stats.LastActive) {
+		t.Fatalf("expect last active of the docker client: %+v", act)
+	}
+}
@@ -0,0 +1,389 @@
+package imagecache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"k8s.io/klog/v2"
+)
+
+const maxManifestSize = 4 << 20
+
+var (
+	pathRe      = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs)/([^/]+)$`)
+	digestRe    = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	challengeRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// Cache is a pull-through cache of the registry v2 api for pulls only. The
+// upstream is the ns query parameter containerd adds to mirror requests,
+// docker.io without it as docker only mirrors docker hub. Pulls are
+// anonymous, a private image fails here and the client falls back to the
+// next mirror with its own credentials.
+type Cache struct {
+	store     *store
+	upstreams map[string]string
+	client    *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]token
+}
+
+type token struct {
+	value  string
+	expire time.Time
+}
+
+// New returns a cache storing the content under dir.
+func New(dir string, cfg *v1.ImageCache) (*Cache, error) {
+	s, err := newStore(dir, cfg.GetMaxSize())
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		store:     s,
+		upstreams: cfg.Upstreams,
+		tokens:    map[string]token{},
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: 30 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				IdleConnTimeout:       90 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// Listen serves the cache on the unix socket sock.
+func (c *Cache) Listen(sock string) (*http.Server, error) {
+	_ = os.Remove(sock)
+	lt, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listen %s", sock)
+	}
+	svr := &http.Server{Handler: c}
+	go func() {
+		err := svr.Serve(lt)
+		if err != nil && err != http.ErrServerClosed {
+			klog.Errorf("serve image cache: %v", err)
+		}
+	}()
+	return svr, nil
+}
+
+// Status lists the cached entries, the most recently used first.
+func (c *Cache) Status() *v1.CacheStatus {
+	status := &v1.CacheStatus{MaxSize: c.store.maxSize, Entries: c.store.list()}
+	for _, e := range status.Entries {
+		status.Size += e.Size
+	}
+	return status
+}
+
+// Prune removes the least recently used entries beyond maxSize.
+func (c *Cache) Prune(maxSize int64) ([]v1.CacheEntry, error) {
+	return c.store.prune(maxSize)
+}
+
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/v2/" || r.URL.Path == "/v2" {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+	m := pathRe.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	registry := r.URL.Query().Get("ns")
+	if registry == "" {
+		registry = v1.DockerHub
+	}
+	if strings.Contains(registry, "/") {
+		http.Error(w, "invalid ns", http.StatusBadRequest)
+		return
+	}
+	repo, kind, ref := m[1], m[2], m[3]
+	switch kind {
+	case "blobs":
+		c.serveBlob(w, r, registry, repo, ref)
+	default:
+		c.serveManifest(w, r, registry, repo, ref)
+	}
+}
+
+func (c *Cache) serveBlob(w http.ResponseWriter, r *http.Request, registry, repo, dgst string) {
+	if !digestRe.MatchString(dgst) {
+		http.NotFound(w, r)
+		return
+	}
+	if e, ok := c.store.get(dgst); ok {
+		c.serveFile(w, r, e)
+		return
+	}
+	resp, err := c.fetch(r.Context(), r.Method, registry, repo, "blobs", dgst, nil)
+	if err != nil {
+		klog.V(5).Infof("image cache: fetch %s/%s@%s: %v", registry, repo, dgst, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	copyHeaders(w, resp, "Content-Type", "Content-Length")
+	w.Header().Set("Docker-Content-Digest", dgst)
+	if resp.StatusCode != http.StatusOK || r.Method == http.MethodHead {
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, io.LimitReader(resp.Body, 4096))
+		return
+	}
+	bw, err := c.store.writer(v1.CacheEntry{Digest: dgst, Registry: registry, Repository: repo})
+	if err != nil {
+		klog.Warningf("image cache: ingest %s: %v", dgst, err)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, io.TeeReader(resp.Body, bw))
+	if err != nil {
+		bw.abort()
+		klog.V(5).Infof("image cache: copy %s: %v", dgst, err)
+		return
+	}
+	err = bw.commit()
+	if err != nil {
+		klog.Warningf("image cache: store %s: %v", dgst, err)
+	}
+}
+
+func (c *Cache) serveManifest(w http.ResponseWriter, r *http.Request, registry, repo, ref string) {
+	byDigest := digestRe.MatchString(ref)
+	tag := fmt.Sprintf("%s/%s:%s", registry, repo, ref)
+	if byDigest {
+		if e, ok := c.store.get(ref); ok {
+			c.serveFile(w, r, e)
+			return
+		}
+	}
+	resp, err := c.fetch(r.Context(), http.MethodGet, registry, repo, "manifests", ref, r.Header.Values("Accept"))
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("upstream: %s", resp.Status)
+		}
+		// serve the last known manifest of the tag while the upstream is down
+		if dgst, ok := c.store.tag(tag); ok && !byDigest {
+			if e, ok := c.store.get(dgst); ok {
+				klog.V(5).Infof("image cache: %s, serve cached %s", err, tag)
+				c.serveFile(w, r, e)
+				return
+			}
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		copyHeaders(w, resp, "Content-Type")
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, io.LimitReader(resp.Body, 4096))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil || len(body) > maxManifestSize {
+		http.Error(w, fmt.Sprintf("read manifest %s: too large or %v", tag, err), http.StatusBadGateway)
+		return
+	}
+	sum := sha256.Sum256(body)
+	dgst := "sha256:" + hex.EncodeToString(sum[:])
+	if byDigest && dgst != ref {
+		http.Error(w, fmt.Sprintf("manifest digest mismatch: %s", dgst), http.StatusBadGateway)
+		return
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	c.storeManifest(v1.CacheEntry{
+		Digest: dgst, Registry: registry, Repository: repo, MediaType: mediaType,
+	}, lo.Ternary(byDigest, "", tag), body)
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", dgst)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(body)
+}
+
+func (c *Cache) storeManifest(e v1.CacheEntry, tag string, body []byte) {
+	if _, ok := c.store.get(e.Digest); ok {
+		if tag != "" {
+			c.store.setTag(tag, e.Digest)
+		}
+		return
+	}
+	bw, err := c.store.writer(e)
+	if err != nil {
+		klog.Warningf("image cache: ingest %s: %v", e.Digest, err)
+		return
+	}
+	bw.tag = tag
+	_, err = io.Copy(bw, bytes.NewReader(body))
+	if err != nil {
+		bw.abort()
+		return
+	}
+	err = bw.commit()
+	if err != nil {
+		klog.Warningf("image cache: store %s: %v", e.Digest, err)
+	}
+}
+
+func (c *Cache) serveFile(w http.ResponseWriter, r *http.Request, e v1.CacheEntry) {
+	f, err := os.Open(c.store.path(e.Digest))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", lo.Ternary(e.MediaType == "", "application/octet-stream", e.MediaType))
+	w.Header().Set("Docker-Content-Digest", e.Digest)
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// endpoint of registry, docker hub is served by registry-1.docker.io.
+func (c *Cache) endpoint(registry string) string {
+	if u, ok := c.upstreams[registry]; ok {
+		return strings.TrimSuffix(u, "/")
+	}
+	if registry == v1.DockerHub {
+		return "https://registry-1.docker.io"
+	}
+	return "https://" + registry
+}
+
+// fetch requests the upstream, an anonymous bearer token is acquired when
+// the upstream asks for one.
+func (c *Cache) fetch(ctx context.Context, method, registry, repo, kind, ref string, accept []string) (*http.Response, error) {
+	target := fmt.Sprintf("%s/v2/%s/%s/%s", c.endpoint(registry), repo, kind, ref)
+	scope := fmt.Sprintf("repository:%s:pull", repo)
+	key := registry + " " + scope
+	do := func(tok string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		if tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+		return c.client.Do(req)
+	}
+	resp, err := do(c.cachedToken(key))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	tok, err := c.token(ctx, challenge, scope)
+	if err != nil {
+		return nil, errors.Wrapf(err, "authorize %s", registry)
+	}
+	c.mu.Lock()
+	c.tokens[key] = tok
+	c.mu.Unlock()
+	return do(tok.value)
+}
+
+func (c *Cache) cachedToken(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tok, ok := c.tokens[key]
+	if !ok || time.Now().After(tok.expire) {
+		return ""
+	}
+	return tok.value
+}
+
+// token requests an anonymous token from the realm of the bearer
+// challenge.
+func (c *Cache) token(ctx context.Context, challenge, scope string) (token, error) {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return token{}, fmt.Errorf("unsupported challenge %q", challenge)
+	}
+	q := url.Values{}
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", lo.Ternary(params["scope"] == "", scope, params["scope"]))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		return token{}, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return token{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return token{}, fmt.Errorf("token: %s", resp.Status)
+	}
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result)
+	if err != nil {
+		return token{}, errors.Wrap(err, "decode token")
+	}
+	if result.ExpiresIn <= 0 {
+		result.ExpiresIn = 60
+	}
+	return token{
+		value:  lo.Ternary(result.Token == "", result.AccessToken, result.Token),
+		expire: time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - 10*time.Second),
+	}, nil
+}
+
+func parseChallenge(h string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params := map[string]string{}
+	for _, m := range challengeRe.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	return scheme, params
+}
+
+func copyHeaders(w http.ResponseWriter, resp *http.Response, keys ...string) {
+	for _, k := range keys {
+		if v := resp.Header.Get(k); v != "" {
+			w.Header().Set(k, v)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package imagecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+)
+
+func digestOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestCache(t *testing.T) {
+	var (
+		blob     = strings.Repeat("layer", 100)
+		manifest = `{"schemaVersion":2,"layers":[{"digest":"` + digestOf(blob) + `"}]}`
+		hits     = map[string]int{}
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:library/busybox:pull" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token":"secret"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="http://`+r.Host+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/v2/library/busybox/manifests/latest":
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			_, _ = w.Write([]byte(manifest))
+		case "/v2/library/busybox/blobs/" + digestOf(blob):
+			_, _ = w.Write([]byte(blob))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	c, err := New(t.TempDir(), &v1.ImageCache{
+		MaxSize:   "1MiB",
+		Upstreams: map[string]string{v1.DockerHub: upstream.URL},
+	})
+	if err != nil {
+		t.Fatalf("new cache: %s", err)
+	}
+	cache := httptest.NewServer(c)
+	defer cache.Close()
+
+	get := func(path string) (int, string, http.Header) {
+		resp, err := http.Get(cache.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %s", path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data), resp.Header
+	}
+	for i := 0; i < 2; i++ {
+		code, body, header := get("/v2/library/busybox/manifests/latest?ns=docker.io")
+		if code != http.StatusOK || body != manifest ||
+			header.Get("Docker-Content-Digest") != digestOf(manifest) {
+			t.Fatalf("unexpected manifest: %d %s", code, body)
+		}
+		code, body, _ = get("/v2/library/busybox/blobs/" + digestOf(blob))
+		if code != http.StatusOK || body != blob {
+			t.Fatalf("unexpected blob: %d %s", code, body)
+		}
+	}
+	if hits["/v2/library/busybox/blobs/"+digestOf(blob)] != 1 {
+		t.Fatalf("blob should be pulled from upstream once, got %d", hits["/v2/library/busybox/blobs/"+digestOf(blob)])
+	}
+	if n := len(c.Status().Entries); n != 2 {
+		t.Fatalf("expect manifest and blob cached, got %d", n)
+	}
+
+	// the cached tag is served while the upstream is down
+	upstream.Close()
+	code, body, _ := get("/v2/library/busybox/manifests/latest")
+	if code != http.StatusOK || body != manifest {
+		t.Fatalf("unexpected cached manifest: %d %s", code, body)
+	}
+	code, _, _ = get("/v2/library/busybox/blobs/" + digestOf("missing"))
+	if code != http.StatusBadGateway {
+		t.Fatalf("expect bad gateway for an uncached blob, got %d", code)
+	}
+
+	removed, err := c.Prune(0)
+	if err != nil || len(removed) != 2 || c.Status().Size != 0 {
+		t.Fatalf("prune: %v, removed %d", err, len(removed))
+	}
+}
@@ -0,0 +1,245 @@
+package imagecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+const indexFile = "index.json"
+
+// index is persisted next to the blobs, Tags remember the manifest digest
+// of registry/repository:tag to serve it when the upstream is unreachable.
+type index struct {
+	Entries map[string]*v1.CacheEntry `json:"entries"`
+	Tags    map[string]string         `json:"tags"`
+}
+
+// store keeps blobs and manifests by digest under dir and evicts the least
+// recently used ones beyond maxSize.
+type store struct {
+	dir     string
+	maxSize int64
+
+	mu    sync.Mutex
+	index index
+}
+
+func newStore(dir string, maxSize int64) (*store, error) {
+	s := &store{
+		dir:     dir,
+		maxSize: maxSize,
+		index:   index{Entries: map[string]*v1.CacheEntry{}, Tags: map[string]string{}},
+	}
+	err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "make image cache dir %s", dir)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "read image cache index")
+	}
+	var idx index
+	err = json.Unmarshal(data, &idx)
+	if err != nil {
+		klog.Warningf("image cache index is broken, start over: %v", err)
+		return s, nil
+	}
+	for dgst, e := range idx.Entries {
+		if _, err := os.Stat(s.path(dgst)); err == nil {
+			s.index.Entries[dgst] = e
+		}
+	}
+	for tag, dgst := range idx.Tags {
+		if _, ok := s.index.Entries[dgst]; ok {
+			s.index.Tags[tag] = dgst
+		}
+	}
+	return s, nil
+}
+
+// path of the content of dgst, dgst is validated by the caller.
+func (s *store) path(dgst string) string {
+	return filepath.Join(s.dir, "blobs", "sha256", strings.TrimPrefix(dgst, "sha256:"))
+}
+
+// get returns the entry of dgst and marks it as used.
+func (s *store) get(dgst string) (v1.CacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.index.Entries[dgst]
+	if !ok {
+		return v1.CacheEntry{}, false
+	}
+	e.LastAccess = time.Now()
+	return *e, true
+}
+
+func (s *store) tag(ref string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dgst, ok := s.index.Tags[ref]
+	return dgst, ok
+}
+
+// writer stores the content of e once it is fully written and matches
+// e.Digest.
+func (s *store) writer(e v1.CacheEntry) (*blobWriter, error) {
+	f, err := os.CreateTemp(filepath.Join(s.dir, "blobs"), "ingest-")
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{store: s, entry: e, file: f, digester: sha256.New()}, nil
+}
+
+func (s *store) add(e v1.CacheEntry, tag string) {
+	s.mu.Lock()
+	e.LastAccess = time.Now()
+	s.index.Entries[e.Digest] = &e
+	if tag != "" {
+		s.index.Tags[tag] = e.Digest
+	}
+	s.mu.Unlock()
+
+	_, err := s.prune(s.maxSize)
+	if err != nil {
+		klog.Warningf("prune image cache: %v", err)
+	}
+}
+
+func (s *store) setTag(tag, dgst string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index.Tags[tag] == dgst {
+		return
+	}
+	s.index.Tags[tag] = dgst
+	s.saveLocked()
+}
+
+// prune removes the least recently used entries until the cache is no
+// larger than maxSize.
+func (s *store) prune(maxSize int64) ([]v1.CacheEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.entriesLocked()
+	var size int64
+	for _, e := range entries {
+		size += e.Size
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastAccess.Before(entries[j].LastAccess)
+	})
+	var removed []v1.CacheEntry
+	for _, e := range entries {
+		if size <= maxSize {
+			break
+		}
+		err := os.Remove(s.path(e.Digest))
+		if err != nil && !os.IsNotExist(err) {
+			return removed, errors.Wrapf(err, "remove %s", e.Digest)
+		}
+		delete(s.index.Entries, e.Digest)
+		size -= e.Size
+		removed = append(removed, e)
+	}
+	for tag, dgst := range s.index.Tags {
+		if _, ok := s.index.Entries[dgst]; !ok {
+			delete(s.index.Tags, tag)
+		}
+	}
+	s.saveLocked()
+	return removed, nil
+}
+
+func (s *store) list() []v1.CacheEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.entriesLocked()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastAccess.After(entries[j].LastAccess)
+	})
+	return entries
+}
+
+func (s *store) entriesLocked() []v1.CacheEntry {
+	var entries []v1.CacheEntry
+	for _, e := range s.index.Entries {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+func (s *store) saveLocked() {
+	data, err := json.Marshal(&s.index)
+	if err != nil {
+		klog.Warningf("marshal image cache index: %v", err)
+		return
+	}
+	tmp := filepath.Join(s.dir, indexFile+".tmp")
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(s.dir, indexFile))
+	}
+	if err != nil {
+		klog.Warningf("save image cache index: %v", err)
+	}
+}
+
+// blobWriter verifies the digest of the content while it is written.
+type blobWriter struct {
+	store    *store
+	entry    v1.CacheEntry
+	tag      string
+	file     *os.File
+	digester hash.Hash
+	size     int64
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	_, _ = w.digester.Write(p[:n])
+	return n, err
+}
+
+// commit stores the content when it matches the digest, it is discarded
+// otherwise.
+func (w *blobWriter) commit() error {
+	defer os.Remove(w.file.Name())
+	err := w.file.Close()
+	if err != nil {
+		return err
+	}
+	got := "sha256:" + hex.EncodeToString(w.digester.Sum(nil))
+	if got != w.entry.Digest {
+		return fmt.Errorf("digest mismatch: expect %s, got %s", w.entry.Digest, got)
+	}
+	err = os.Rename(w.file.Name(), w.store.path(w.entry.Digest))
+	if err != nil {
+		return err
+	}
+	w.entry.Size = w.size
+	w.store.add(w.entry, w.tag)
+	return nil
+}
+
+func (w *blobWriter) abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
@@ -3,6 +3,7 @@ package meta
 import (
 	"context"
 	"fmt"
+	v1 "github.com/aoxn/meridian/api/v1"
 	"github.com/opencontainers/go-digest"
 	"os"
 	"path"
@@ -20,6 +21,8 @@ type Backend interface {
 	Image() AbstractImage
 
 	Docker() AbstractDocker
+
+	Pool() AbstractPool
 }
 
 type Dir interface {
@@ -78,11 +81,23 @@ type AbstractK8S interface {
 	Remove(k *Kubernetes) error
 }
 
+type AbstractPool interface {
+	Dir
+	Get(key string) (*Pool, error)
+	List() ([]*Pool, error)
+	Create(p *Pool) error
+	Update(p *Pool) error
+	Remove(p *Pool) error
+}
+
 type Docker struct {
 	Name    string
 	Version string
+	Options v1.DockerOptions
 	VmName  string
 	State   string
+	// ServerVersion is reported by the docker daemon of the vm
+	ServerVersion string
 }
 
 type Task struct {
@@ -216,6 +231,17 @@ func (l *local) K8S() AbstractK8S {
 	return img
 }
 
+func (l *local) Pool() AbstractPool {
+	p := &pool{root: l.root}
+	_, err := os.Stat(p.Dir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			_ = os.MkdirAll(p.Dir(), 0755)
+		}
+	}
+	return p
+}
+
 func (l *local) Task() AbstractTask {
 	return &task{root: l.root}
 }
@@ -225,4 +251,5 @@ const (
 	machineJson = "machine.json"
 	imageJson   = "image.json"
 	dockerJson  = "docker.json"
+	poolJson    = "pool.json"
 )
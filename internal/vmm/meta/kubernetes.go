@@ -16,6 +16,101 @@ type Kubernetes struct {
 	Spec    v1.RequestSpec `yaml:"spec" json:"spec"`
 	State   string         `yaml:"state" json:"state"`
 	Message string         `yaml:"message" json:"message"`
+	// Masters and Workers are the desired number of nodes, VmName is the
+	// first master. Workers are vms created for the cluster.
+	Masters int `yaml:"masters,omitempty" json:"masters,omitempty"`
+	Workers int `yaml:"workers,omitempty" json:"workers,omitempty"`
+	// Nodes are the vms joined into the cluster, VmName included
+	Nodes []KubernetesNode `yaml:"nodes,omitempty" json:"nodes,omitempty"`
+	// VIP is the virtual ip in front of the apiservers of multiple masters,
+	// it is held by keepalived on one of them.
+	VIP string `yaml:"vip,omitempty" json:"vip,omitempty"`
+	// UpgradeTo is the version an in-place upgrade rolls the nodes to, it
+	// is kept after a failure to resume and cleared once all nodes run it.
+	UpgradeTo string `yaml:"upgradeTo,omitempty" json:"upgradeTo,omitempty"`
+}
+
+// KubernetesNode is the membership of a vm in the cluster.
+type KubernetesNode struct {
+	VmName  string      `yaml:"vmName" json:"vmName"`
+	Role    v1.NodeRole `yaml:"role" json:"role"`
+	State   string      `yaml:"state" json:"state"`
+	Message string      `yaml:"message,omitempty" json:"message,omitempty"`
+	// Version is the kubernetes version of the node, empty for the version
+	// of the cluster spec
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+}
+
+func (k *Kubernetes) Validate() error {
+	if k.Masters < 0 || k.Workers < 0 {
+		return fmt.Errorf("masters and workers must not be negative: %d, %d", k.Masters, k.Workers)
+	}
+	// etcd is stacked on the masters, an even number adds no fault tolerance
+	if k.Masters > 1 && k.Masters%2 == 0 {
+		return fmt.Errorf("masters must be an odd number for the etcd quorum, got %d", k.Masters)
+	}
+	return nil
+}
+
+// HighlyAvailable reports whether the control plane runs on multiple masters.
+func (k *Kubernetes) HighlyAvailable() bool {
+	return k.Masters > 1
+}
+
+// NodeVersion is the kubernetes version n runs.
+func (k *Kubernetes) NodeVersion(n KubernetesNode) string {
+	if n.Version != "" {
+		return n.Version
+	}
+	return k.Spec.Config.Kubernetes.Version
+}
+
+// GetNode returns the membership of vm, nil when it is not a node.
+func (k *Kubernetes) GetNode(vm string) *KubernetesNode {
+	for i := range k.Nodes {
+		if k.Nodes[i].VmName == vm {
+			return &k.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// SetNode adds or replaces the membership of n.VmName.
+func (k *Kubernetes) SetNode(n KubernetesNode) {
+	if cur := k.GetNode(n.VmName); cur != nil {
+		*cur = n
+		return
+	}
+	k.Nodes = append(k.Nodes, n)
+}
+
+func (k *Kubernetes) RemoveNode(vm string) {
+	for i := range k.Nodes {
+		if k.Nodes[i].VmName == vm {
+			k.Nodes = append(k.Nodes[:i], k.Nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// WorkerNodes are the workers ordered by join.
+func (k *Kubernetes) WorkerNodes() []KubernetesNode {
+	return k.nodesOf(v1.NodeRoleWorker)
+}
+
+// MasterNodes are the masters, the first one is VmName.
+func (k *Kubernetes) MasterNodes() []KubernetesNode {
+	return k.nodesOf(v1.NodeRoleMaster)
+}
+
+func (k *Kubernetes) nodesOf(role v1.NodeRole) []KubernetesNode {
+	var nodes []KubernetesNode
+	for _, n := range k.Nodes {
+		if n.Role == role {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
 }
 
 type kubernetes struct {
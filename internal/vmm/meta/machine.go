@@ -161,6 +161,36 @@ type Machine struct {
 	Message    string                 `json:"message,omitempty"`
 	Address    []string               `json:"address,omitempty"`
 	Stage      []Stage                `json:"stage,omitempty"`
+	// Stack is the name of the stack which manages this machine, empty
+	// when the machine was created imperatively.
+	Stack string `json:"stack,omitempty"`
+	// Pool is the name of the pool this machine belongs to, Claimed is
+	// set once a client takes it out of the pool.
+	Pool    string `json:"pool,omitempty"`
+	Claimed bool   `json:"claimed,omitempty"`
+	// Restarts counts automatic restarts since the vm was last running
+	// stably, a growing value indicates a crash loop.
+	Restarts int `json:"restarts,omitempty"`
+	// Events keeps the most recent maxEvents notable events, newest last.
+	Events []v1.Event `json:"events,omitempty"`
+	// Status carries the conditions of the machine, eg. Ready
+	Status v1.VirtualMachineStatus `json:"status,omitempty"`
+}
+
+const maxEvents = 20
+
+// AddEvent records an event of the machine, the caller is responsible
+// for persisting it.
+func (m *Machine) AddEvent(reason string, msg string) {
+	m.Events = append(m.Events, v1.Event{
+		Resource: m.Name,
+		Reason:   reason,
+		Message:  msg,
+		Time:     metav1.Now(),
+	})
+	if len(m.Events) > maxEvents {
+		m.Events = m.Events[len(m.Events)-maxEvents:]
+	}
 }
 
 type Stage struct {
@@ -214,6 +244,12 @@ func (m *Machine) DockerSock() string {
 	return path.Join(m.Dir(), "docker.sock")
 }
 
+// DockerProbeSock forwards docker as DockerSock does, it is used by the
+// health probe of meridiand and not counted as activity of the vm.
+func (m *Machine) DockerProbeSock() string {
+	return path.Join(m.Dir(), "docker-probe.sock")
+}
+
 func (m *Machine) SandboxSock() string {
 	return path.Join(m.Dir(), "sandbox.sock")
 }
@@ -0,0 +1,179 @@
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+)
+
+const (
+	// ReleaseDestroy destroys a released vm, the pool replenishes a new one.
+	ReleaseDestroy = "Destroy"
+	// ReleaseSnapshot would revert a released vm to the snapshot taken when
+	// it got ready. It is not supported, none of the vm drivers implements
+	// snapshots yet, and is rejected by Validate.
+	ReleaseSnapshot = "Snapshot"
+)
+
+// Pool keeps a number of booted vms ready to be claimed.
+type Pool struct {
+	Name string `yaml:"name" json:"name"`
+	// Profile is the absolute path of a vm config file, when set the vms
+	// are created with its content at the time, Spec otherwise
+	Profile  string `yaml:"profile,omitempty" json:"profile,omitempty"`
+	MinReady int    `yaml:"minReady" json:"minReady"`
+	Max      int    `yaml:"max" json:"max"`
+	// Release is what happens to a released vm, only ReleaseDestroy is
+	// supported
+	Release string                 `yaml:"release,omitempty" json:"release,omitempty"`
+	Spec    *v1.VirtualMachineSpec `yaml:"spec" json:"spec"`
+
+	// Status, filled by daemon on get
+	Ready   int `yaml:"ready,omitempty" json:"ready,omitempty"`
+	Claimed int `yaml:"claimed,omitempty" json:"claimed,omitempty"`
+	Total   int `yaml:"total,omitempty" json:"total,omitempty"`
+}
+
+// VMSpec is the spec of a new vm of the pool, read from Profile so that
+// edits of the profile apply to the vms created later.
+func (p *Pool) VMSpec() (*v1.VirtualMachineSpec, error) {
+	if p.Profile == "" {
+		return p.Spec.DeepCopy(), nil
+	}
+	data, err := os.ReadFile(p.Profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read profile of pool %s", p.Name)
+	}
+	var spec v1.VirtualMachineSpec
+	err = yaml.Unmarshal(data, &spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse profile %s", p.Profile)
+	}
+	return &spec, nil
+}
+
+func (p *Pool) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("pool name must be specified")
+	}
+	if p.Spec == nil {
+		return fmt.Errorf("pool %s: vm spec must be specified", p.Name)
+	}
+	if p.MinReady < 0 || p.Max <= 0 {
+		return fmt.Errorf("pool %s: min-ready must be >= 0 and max > 0", p.Name)
+	}
+	if p.MinReady > p.Max {
+		return fmt.Errorf("pool %s: min-ready %d exceeds max %d", p.Name, p.MinReady, p.Max)
+	}
+	switch p.Release {
+	case "", ReleaseDestroy:
+	case ReleaseSnapshot:
+		return fmt.Errorf("pool %s: release policy %s is not supported, the vm drivers have no snapshots", p.Name, p.Release)
+	default:
+		return fmt.Errorf("pool %s: unsupported release policy %s", p.Name, p.Release)
+	}
+	return nil
+}
+
+type pool struct {
+	root string
+}
+
+func (m *pool) Dir() string {
+	return m.rootLocation()
+}
+
+func (m *pool) rootLocation(name ...string) string {
+	return path.Join(m.root, "pool", path.Join(name...))
+}
+
+func (m *pool) Get(key string) (*Pool, error) {
+	pathName := m.rootLocation(key)
+	info, err := os.Stat(pathName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, errors.Wrapf(err, "NotFound: %s", key)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", pathName)
+	}
+	return m.load(path.Join(pathName, poolJson))
+}
+
+func (m *pool) List() ([]*Pool, error) {
+	var pools []*Pool
+	pathName := m.Dir()
+	info, err := os.Stat(pathName)
+	if err != nil {
+		return pools, err
+	}
+	if !info.IsDir() {
+		return pools, fmt.Errorf("%s is not a directory", pathName)
+	}
+	// walk directory
+	en, err := os.ReadDir(pathName)
+	if err != nil {
+		return pools, err
+	}
+	for _, dir := range en {
+		p, err := m.Get(dir.Name())
+		if err != nil {
+			continue
+		}
+		pools = append(pools, p)
+	}
+	return pools, nil
+}
+
+func (m *pool) Create(p *Pool) error {
+	pathName := m.rootLocation(p.Name)
+	_, err := os.Stat(pathName)
+	if err == nil {
+		return fmt.Errorf("%s already exists", pathName)
+	}
+	err = os.MkdirAll(pathName, 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(pathName, poolJson), data, 0644)
+}
+
+func (m *pool) Update(p *Pool) error {
+	pathName := m.rootLocation(p.Name)
+	_, err := os.Stat(pathName)
+	if err != nil {
+		return fmt.Errorf("%s not exists", pathName)
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(pathName, poolJson), data, 0644)
+}
+
+func (m *pool) Remove(p *Pool) error {
+	if p.Name == "" {
+		return fmt.Errorf("pool name is empty")
+	}
+	return os.RemoveAll(m.rootLocation(p.Name))
+}
+
+func (m *pool) load(uri string) (*Pool, error) {
+	data, err := os.ReadFile(uri)
+	if err != nil {
+		return nil, err
+	}
+	var p Pool
+	err = json.Unmarshal(data, &p)
+	return &p, err
+}
@@ -0,0 +1,57 @@
+package meta
+
+import (
+	v1 "github.com/aoxn/meridian/api/v1"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPoolStore(t *testing.T) {
+	bk, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("new backend: %s", err)
+	}
+	p := &Pool{Name: "agents", MinReady: 5, Max: 20, Spec: &v1.VirtualMachineSpec{CPUs: 2}}
+	err = p.Validate()
+	if err != nil {
+		t.Fatalf("validate: %s", err)
+	}
+	err = bk.Pool().Create(p)
+	if err != nil {
+		t.Fatalf("create pool: %s", err)
+	}
+	pools, err := bk.Pool().List()
+	if err != nil {
+		t.Fatalf("list pool: %s", err)
+	}
+	if len(pools) != 1 || pools[0].Spec.CPUs != 2 {
+		t.Fatalf("unexpected pools: %+v", pools)
+	}
+
+	p.MinReady = 21
+	if p.Validate() == nil {
+		t.Fatalf("expect min-ready > max rejected")
+	}
+	p.MinReady, p.Release = 5, ReleaseSnapshot
+	if p.Validate() == nil {
+		t.Fatalf("expect unsupported snapshot release rejected")
+	}
+}
+
+func TestPoolVMSpec(t *testing.T) {
+	p := &Pool{Name: "agents", Spec: &v1.VirtualMachineSpec{CPUs: 2}}
+	spec, err := p.VMSpec()
+	if err != nil || spec.CPUs != 2 {
+		t.Fatalf("expect the spec of pool without profile: %+v, %v", spec, err)
+	}
+	p.Profile = filepath.Join(t.TempDir(), "agent.yml")
+	err = os.WriteFile(p.Profile, []byte("cpus: 4\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err = p.VMSpec()
+	if err != nil || spec.CPUs != 4 {
+		t.Fatalf("expect the profile applied: %+v, %v", spec, err)
+	}
+}
@@ -0,0 +1,95 @@
+package sshutil
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/aoxn/meridian/api/v1"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// KeyDir returns the directory of the key pair of vm, which is its own key
+// once it was rotated on its own, or the key shared by all vms in cfgDir.
+func KeyDir(cfgDir, vm string) string {
+	dir := filepath.Join(cfgDir, v1.SSHKeysDir, vm)
+	if _, err := os.Stat(filepath.Join(dir, v1.UserPrivateKey)); err == nil {
+		return dir
+	}
+	return cfgDir
+}
+
+// GenerateKey creates an ed25519 key pair without passphrase in dir.
+func GenerateKey(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("could not create %q directory: %w", dir, err)
+	}
+	args := []string{
+		"-t", "ed25519",
+		"-q", "-N", "",
+		"-C", "meridian",
+		"-f", filepath.Join(dir, v1.UserPrivateKey),
+	}
+	// no passphrase, no user@host comment
+	keygenCmd := exec.Command("ssh-keygen", args...)
+	if out, err := keygenCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run %v: %q: %w", keygenCmd.Args, string(out), err)
+	}
+	return nil
+}
+
+// CopyKey copies the key pair in src to dst, replacing the one in dst.
+func CopyKey(src, dst string) error {
+	if err := os.MkdirAll(dst, 0o700); err != nil {
+		return err
+	}
+	for _, f := range []string{v1.UserPrivateKey, v1.UserPublicKey} {
+		data, err := os.ReadFile(filepath.Join(src, f))
+		if err != nil {
+			return errors.Wrapf(err, "read %s", f)
+		}
+		// write aside and rename, a broken key would lock us out
+		tmp := filepath.Join(dst, "."+f+".tmp")
+		err = os.WriteFile(tmp, data, 0o600)
+		if err != nil {
+			return errors.Wrapf(err, "write %s", f)
+		}
+		err = os.Rename(tmp, filepath.Join(dst, f))
+		if err != nil {
+			return errors.Wrapf(err, "replace %s", f)
+		}
+	}
+	return nil
+}
+
+// AuthorizedKeys returns the public keys allowed to log in with ssh, extra
+// keys come after the meridian ones.
+func (ssh *SSHMgr) AuthorizedKeys(extra []string) ([]string, error) {
+	pubs, err := ssh.LoadPubKey()
+	if err != nil {
+		return nil, err
+	}
+	keys := lo.Map(pubs, func(p PubKey, _ int) string { return p.Content })
+	for _, k := range extra {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return lo.Uniq(keys), nil
+}
+
+// PushAuthorizedKeys replaces ~/.ssh/authorized_keys of user in the vm with
+// keys, logging in with the current key of ssh.
+func (ssh *SSHMgr) PushAuthorizedKeys(ctx context.Context, user string, keys []string) error {
+	content := base64.StdEncoding.EncodeToString([]byte(strings.Join(keys, "\n") + "\n"))
+	cmd := fmt.Sprintf("set -e; umask 077; mkdir -p ~/.ssh; "+
+		"echo %s | base64 -d > ~/.ssh/authorized_keys.meridian; "+
+		"mv ~/.ssh/authorized_keys.meridian ~/.ssh/authorized_keys", content)
+	_, err := ssh.RunCommand(ctx, user, cmd)
+	return errors.Wrapf(err, "push authorized keys to %s", ssh.address)
+}
@@ -57,6 +57,11 @@ func (ssh *SSHMgr) SetAddr(addr string) {
 	ssh.address = addr
 }
 
+// KeyDir is the directory of the key pair used to log in.
+func (ssh *SSHMgr) KeyDir() string {
+	return ssh.cfgDir
+}
+
 func (ssh *SSHMgr) RunCommand(ctx context.Context, vmName, cmd string) (string, error) {
 	data, err := os.ReadFile(filepath.Join(ssh.cfgDir, v1.UserPrivateKey))
 	if err != nil {
@@ -138,6 +143,20 @@ func (ssh *SSHMgr) WriteSSHConfigFile(name, instDir string) error {
 	return os.WriteFile(fileName, b.Bytes(), 0o600)
 }
 
+// VMOpts returns the options to log in the vm at the address of ssh as user,
+// for the ssh command line and config files.
+func (ssh *SSHMgr) VMOpts(user string) ([]string, error) {
+	opts, err := ssh.CommonOpts(false)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts,
+		fmt.Sprintf("User=%s", user),
+		fmt.Sprintf("Hostname=%s", ssh.address),
+		fmt.Sprintf("Port=%d", ssh.port),
+	), nil
+}
+
 // LoadPubKey returns the public key from $MD_HOME/_config/user.pub.
 // The key will be created if it does not yet exist.
 //
@@ -151,19 +170,8 @@ func (ssh *SSHMgr) LoadPubKey() ([]PubKey, error) {
 		if !errors.Is(err, os.ErrNotExist) {
 			return nil, err
 		}
-		if err := os.MkdirAll(configDir, 0o700); err != nil {
-			return nil, fmt.Errorf("could not create %q directory: %w", configDir, err)
-		}
-		args := []string{
-			"-t", "ed25519",
-			"-q", "-N", "",
-			"-C", "meridian",
-			"-f", filepath.Join(configDir, v1.UserPrivateKey),
-		}
-		// no passphrase, no user@host comment
-		keygenCmd := exec.Command("ssh-keygen", args...)
-		if out, err := keygenCmd.CombinedOutput(); err != nil {
-			return nil, fmt.Errorf("failed to run %v: %q: %w", keygenCmd.Args, string(out), err)
+		if err := GenerateKey(configDir); err != nil {
+			return nil, err
 		}
 		klog.Infof("ssh public key generated for %q", ssh.address)
 	}